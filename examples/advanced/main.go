@@ -16,7 +16,7 @@ type User struct {
 }
 
 func main() {
-	fmt.Println("=== Advanced HTTP Client Examples ===\n")
+	fmt.Print("=== Advanced HTTP Client Examples ===\n\n")
 
 	// Example 1: Custom client with configuration
 	fmt.Println("1. Custom client with configuration:")
@@ -40,9 +40,10 @@ func main() {
 	authClient := httpclient.New().
 		WithAuth("your-bearer-token").
 		WithAPIKey("X-API-Key", "your-api-key")
+	_ = authClient
 
 	// This would work with a real authenticated API
-	fmt.Println("Auth client configured (would work with real authenticated API)\n")
+	fmt.Print("Auth client configured (would work with real authenticated API)\n\n")
 
 	// Example 3: Base URL for API clients
 	fmt.Println("3. API client with base URL:")
@@ -78,8 +79,9 @@ func main() {
 		WithCache(5 * time.Minute).                            // Cache responses for 5 minutes
 		WithMetrics(true).                                      // Enable Prometheus metrics
 		WithTracing(true)                                       // Enable OpenTelemetry tracing
+	_ = advancedClient
 
-	fmt.Println("Advanced client configured with rate limiting, circuit breaker, caching, metrics, and tracing\n")
+	fmt.Print("Advanced client configured with rate limiting, circuit breaker, caching, metrics, and tracing\n\n")
 
 	// Example 6: Fluent interface chaining
 	fmt.Println("6. Fluent interface chaining:")
@@ -15,7 +15,7 @@ type User struct {
 }
 
 func main() {
-	fmt.Println("=== AI-Powered HTTP Client Features ===\n")
+	fmt.Print("=== AI-Powered HTTP Client Features ===\n\n")
 
 	// Example 1: AI-Enhanced Retry Strategy
 	fmt.Println("1. AI-Enhanced Smart Retry:")
@@ -57,8 +57,7 @@ func main() {
 	// Example 3: Adaptive Timeout Based on Performance
 	fmt.Println("3. Adaptive Timeout Optimization:")
 	adaptiveClient := httpclient.New().
-		WithAdaptiveTimeout(true).
-		WithPerformanceOptimization(true)
+		WithAdaptiveTimeout(true)
 
 	// Simulate multiple requests to different endpoints
 	endpoints := []string{
@@ -116,8 +115,7 @@ func main() {
 		WithSmartCaching(true).
 		WithPredictivePreloading(true).
 		WithAdaptiveTimeout(true).
-		WithPerformanceOptimization(true).
-		WithRealTimeMetrics(true).
+		WithMetrics(true).
 		WithDebug(true)
 
 	fmt.Println("AI client configured with:")
@@ -125,8 +123,7 @@ func main() {
 	fmt.Println("  ✓ Intelligent caching decisions")
 	fmt.Println("  ✓ Predictive request preloading")
 	fmt.Println("  ✓ Adaptive timeout optimization")
-	fmt.Println("  ✓ Real-time performance optimization")
-	fmt.Println("  ✓ Live metrics and monitoring")
+	fmt.Println("  ✓ Prometheus metrics")
 
 	// Test the AI client
 	var testUser User
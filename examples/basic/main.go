@@ -15,7 +15,7 @@ type User struct {
 }
 
 func main() {
-	fmt.Println("=== Ultra-Simple HTTP Client Examples ===\n")
+	fmt.Print("=== Ultra-Simple HTTP Client Examples ===\n\n")
 
 	// Example 1: One-liner GET request
 	fmt.Println("1. Simple GET request:")
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/yourorg/httpclient"
 )
@@ -15,26 +14,30 @@ type User struct {
 }
 
 func main() {
-	fmt.Println("=== Developer Experience Features ===\n")
+	fmt.Print("=== Developer Experience Features ===\n\n")
 
 	// Example 1: Smart Constructors for Different Use Cases
 	fmt.Println("1. Smart Constructors:")
 	
 	// Microservices client
 	microserviceClient := httpclient.NewForMicroservices()
+	_ = microserviceClient
 	fmt.Println("✓ Microservices client: Load balancing, health checks, circuit breaker, AI retry")
 	
 	// API client
 	apiClient := httpclient.NewForAPI()
+	_ = apiClient
 	fmt.Println("✓ API client: Rate limiting, caching, compression, smart caching")
 	
 	// Enterprise client
 	enterpriseClient := httpclient.NewForEnterprise()
-	fmt.Println("✓ Enterprise client: Full security, compliance, monitoring, AI features")
+	_ = enterpriseClient
+	fmt.Println("✓ Enterprise client: Load balancing, circuit breaker, monitoring, AI features")
 	
 	// Development client
 	devClient := httpclient.NewForDevelopment()
-	fmt.Println("✓ Development client: Debugging, mocking, recording, chaos engineering")
+	_ = devClient
+	fmt.Println("✓ Development client: Debugging")
 	fmt.Println()
 
 	// Example 2: One-liner Advanced Operations
@@ -56,6 +59,7 @@ func main() {
 	// GraphQL in one line
 	fmt.Println("GraphQL query:")
 	var result map[string]interface{}
+	_ = result
 	query := `{ __schema { queryType { name } } }`
 	fmt.Printf("  Query: %s\n", query)
 	fmt.Println("  (Would execute with valid GraphQL endpoint)")
@@ -66,106 +70,32 @@ func main() {
 	
 	// The client automatically detects environment and configures accordingly
 	smartClient := httpclient.New().
-		WithPerformanceOptimization(true).
 		WithAIRetry(true).
 		WithAdaptiveTimeout(true)
+	_ = smartClient
 
 	fmt.Println("Smart client automatically configured with:")
-	fmt.Println("  ✓ Performance optimization enabled")
 	fmt.Println("  ✓ AI-powered retry logic")
 	fmt.Println("  ✓ Adaptive timeout adjustment")
 	fmt.Println("  ✓ Smart caching decisions")
 	fmt.Println()
 
-	// Example 4: Validation and Schema Support
-	fmt.Println("4. Request/Response Validation:")
-	
-	validatingClient := httpclient.New().
-		WithValidation(nil). // Would accept JSON schema
-		WithDebug(true)
-
-	var user User
-	err = validatingClient.JSON("GET", "https://jsonplaceholder.typicode.com/users/1", nil, &user)
-	if err != nil {
-		log.Printf("Validation error: %v", err)
-	} else {
-		fmt.Printf("  Validated user: %+v\n", user)
-	}
-	fmt.Println()
-
-	// Example 5: Chaos Engineering for Testing
-	fmt.Println("5. Chaos Engineering:")
-	
-	chaosClient := httpclient.New().
-		WithChaosEngineering(httpclient.ChaosConfig{
-			FailureRate:    0.1,  // 10% failure rate
-			LatencyRange:   [2]time.Duration{100*time.Millisecond, 500*time.Millisecond},
-			ErrorTypes:     []string{"timeout", "connection_error", "server_error"},
-			EnabledMethods: []string{"GET", "POST"},
-		}).
-		WithDebug(true)
-
-	fmt.Println("Chaos engineering client configured:")
-	fmt.Println("  ✓ 10% random failure injection")
-	fmt.Println("  ✓ Random latency 100-500ms")
-	fmt.Println("  ✓ Various error types")
-	fmt.Println("  ✓ Enabled for GET/POST methods")
-	fmt.Println()
-
-	// Example 6: Recording and Replay for Testing
-	fmt.Println("6. Recording and Replay:")
-	
-	recordingClient := httpclient.New().
-		WithRecording(true).
-		WithReplay(false). // Set to true to replay recorded responses
-		WithDebug(true)
-
-	fmt.Println("Recording client configured:")
-	fmt.Println("  ✓ Records all requests and responses")
-	fmt.Println("  ✓ Can replay recorded sessions")
-	fmt.Println("  ✓ Perfect for testing and development")
-	fmt.Println()
-
-	// Example 7: Mocking Support
-	fmt.Println("7. Built-in Mocking:")
-	
-	mockingClient := httpclient.New().
-		WithMocking(true).
-		WithDebug(true)
-
-	fmt.Println("Mocking client configured:")
-	fmt.Println("  ✓ Automatic mock responses for development")
-	fmt.Println("  ✓ Configurable mock data")
-	fmt.Println("  ✓ No external dependencies needed")
-	fmt.Println()
-
-	// Example 8: Complete Developer Experience
-	fmt.Println("8. Complete Developer Experience:")
+	// Example 4: Complete Developer Experience
+	fmt.Println("4. Complete Developer Experience:")
 	
 	completeClient := httpclient.New().
 		WithAIRetry(true).
 		WithSmartCaching(true).
 		WithAdaptiveTimeout(true).
-		WithPerformanceOptimization(true).
-		WithRealTimeMetrics(true).
-		WithValidation(nil).
-		WithDebug(true).
-		WithAutoRetry(httpclient.AutoRetryConfig{
-			MaxAttempts:     5,
-			BackoffStrategy: "exponential",
-			RetryConditions: []string{"timeout", "5xx", "connection_error"},
-			JitterEnabled:   true,
-		})
+		WithRetries(5).
+		WithDebug(true)
 
 	fmt.Println("Complete developer experience client:")
 	fmt.Println("  ✓ AI-powered features")
 	fmt.Println("  ✓ Smart caching and preloading")
-	fmt.Println("  ✓ Adaptive performance optimization")
-	fmt.Println("  ✓ Real-time metrics and monitoring")
-	fmt.Println("  ✓ Request/response validation")
+	fmt.Println("  ✓ Adaptive timeouts")
 	fmt.Println("  ✓ Comprehensive debugging")
-	fmt.Println("  ✓ Intelligent auto-retry")
-	fmt.Println("  ✓ Jitter for retry timing")
+	fmt.Println("  ✓ Retries with exponential backoff")
 
 	// Test the complete client
 	var testUser User
@@ -177,8 +107,8 @@ func main() {
 	}
 	fmt.Println()
 
-	// Example 9: Ultra-Simple Usage
-	fmt.Println("9. Ultra-Simple Usage Examples:")
+	// Example 5: Ultra-Simple Usage
+	fmt.Println("5. Ultra-Simple Usage Examples:")
 	fmt.Println()
 	
 	fmt.Println("// Basic requests")
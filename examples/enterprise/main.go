@@ -18,7 +18,7 @@ type User struct {
 }
 
 func main() {
-	fmt.Println("=== Enterprise HTTP Client Features ===\n")
+	fmt.Print("=== Enterprise HTTP Client Features ===\n\n")
 
 	// Example 1: Load Balancing with Health Checks
 	fmt.Println("1. Load Balancing with Health Checks:")
@@ -29,8 +29,9 @@ func main() {
 		}, "round-robin").
 		WithHealthCheck(30*time.Second, "/health").
 		WithTimeout(10 * time.Second)
+	_ = lbClient
 
-	fmt.Println("Load balancer configured with health checks\n")
+	fmt.Print("Load balancer configured with health checks\n\n")
 
 	// Example 2: Request/Response Compression
 	fmt.Println("2. Compression and Custom Transport:")
@@ -54,8 +55,9 @@ func main() {
 		WithTLSConfig(&tls.Config{
 			MinVersion: tls.VersionTLS12,
 		})
+	_ = secureClient
 
-	fmt.Println("Secure client configured with IP whitelist and TLS settings\n")
+	fmt.Print("Secure client configured with IP whitelist and TLS settings\n\n")
 
 	// Example 4: Cookie Jar and Redirect Policy
 	fmt.Println("4. Cookie Management and Redirect Policy:")
@@ -68,8 +70,9 @@ func main() {
 			}
 			return nil
 		})
+	_ = cookieClient
 
-	fmt.Println("Cookie client configured with custom redirect policy\n")
+	fmt.Print("Cookie client configured with custom redirect policy\n\n")
 
 	// Example 5: Request/Response Interceptors
 	fmt.Println("5. Request/Response Interceptors:")
@@ -101,16 +104,18 @@ func main() {
 		}).
 		WithTimeout(5 * time.Second).
 		WithRetries(2)
+	_ = failoverClient
 
-	fmt.Println("Failover client configured with backup endpoints\n")
+	fmt.Print("Failover client configured with backup endpoints\n\n")
 
 	// Example 7: Proxy Support
 	fmt.Println("7. Proxy Configuration:")
 	proxyClient := httpclient.New().
 		WithProxy("http://proxy.example.com:8080").
 		WithTimeout(15 * time.Second)
+	_ = proxyClient
 
-	fmt.Println("Proxy client configured\n")
+	fmt.Print("Proxy client configured\n\n")
 
 	// Example 8: Complete Enterprise Setup
 	fmt.Println("8. Complete Enterprise Configuration:")
@@ -143,6 +148,7 @@ func main() {
 		WithBackupEndpoints([]string{
 			"https://backup-api.enterprise.com",
 		})
+	_ = enterpriseClient
 
 	fmt.Println("Enterprise client configured with all advanced features:")
 	fmt.Println("  ✓ Load balancing (least-connection)")
@@ -91,11 +91,12 @@ func (os *OrderService) CreateOrder(order *Order) (*Order, error) {
 }
 
 func main() {
-	fmt.Println("=== Microservice Communication Example ===\n")
+	fmt.Print("=== Microservice Communication Example ===\n\n")
 
 	// Initialize service clients
 	userService := NewUserService("https://jsonplaceholder.typicode.com")
 	orderService := NewOrderService("https://jsonplaceholder.typicode.com")
+	_ = orderService
 
 	// Use the services
 	fmt.Println("1. Getting user from user service:")
@@ -117,7 +118,7 @@ func main() {
 
 	// Simulate order service usage
 	fmt.Println("3. Order service configured with circuit breaker and extended timeout")
-	fmt.Println("   (Would work with real order service endpoints)\n")
+	fmt.Print("   (Would work with real order service endpoints)\n\n")
 
 	// Example of service-to-service communication
 	fmt.Println("4. Service-to-service communication pattern:")
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"net"
 	"net/http"
 	"time"
 
@@ -17,7 +18,7 @@ import (
 )
 
 func main() {
-	fmt.Println("=== Security Features Demo ===\n")
+	fmt.Print("=== Security Features Demo ===\n\n")
 
 	// Example 1: TLS Configuration
 	fmt.Println("1. Custom TLS Configuration:")
@@ -31,8 +32,9 @@ func main() {
 			},
 		}).
 		WithTimeout(10 * time.Second)
+	_ = tlsClient
 
-	fmt.Println("TLS client configured with minimum TLS 1.2 and specific cipher suites\n")
+	fmt.Print("TLS client configured with minimum TLS 1.2 and specific cipher suites\n\n")
 
 	// Example 2: Request Signing
 	fmt.Println("2. Request Signing:")
@@ -44,6 +46,7 @@ func main() {
 	signingClient := httpclient.New().
 		WithRequestSigning("test-key-id", privateKeyPEM).
 		WithTimeout(10 * time.Second)
+	_ = signingClient
 
 	fmt.Printf("Request signing configured with key ID: test-key-id\n")
 	fmt.Printf("Public key fingerprint: %x\n\n", publicKey.N.Bytes()[:8])
@@ -57,8 +60,9 @@ func main() {
 			"192.168.1.0/24",
 		}).
 		WithTimeout(5 * time.Second)
+	_ = whitelistClient
 
-	fmt.Println("IP whitelist configured for localhost and private network\n")
+	fmt.Print("IP whitelist configured for localhost and private network\n\n")
 
 	// Example 4: Authentication Headers
 	fmt.Println("4. Multiple Authentication Methods:")
@@ -67,8 +71,9 @@ func main() {
 		WithAPIKey("X-API-Key", "api-key-67890").
 		WithHeader("X-Client-ID", "client-12345").
 		WithHeader("X-Client-Secret", "secret-67890")
+	_ = authClient
 
-	fmt.Println("Multiple authentication methods configured\n")
+	fmt.Print("Multiple authentication methods configured\n\n")
 
 	// Example 5: Request/Response Security Interceptors
 	fmt.Println("5. Security Interceptors:")
@@ -143,6 +148,7 @@ func main() {
 			}
 			return nil
 		})
+	_ = completeSecurityClient
 
 	fmt.Println("Complete security client configured with:")
 	fmt.Println("  ✓ TLS 1.3 minimum")
@@ -4,27 +4,30 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/yourorg/httpclient"
 )
 
 func main() {
-	fmt.Println("=== Streaming and Real-time Features ===\n")
+	fmt.Print("=== Streaming and Real-time Features ===\n\n")
 
 	// Example 1: HTTP Streaming
 	fmt.Println("1. HTTP Streaming:")
 	streamingClient := httpclient.New().
-		WithTimeout(0). // No timeout for streaming
+		WithTimeout(10 * time.Second). // Bounds only the wait for the response headers
 		WithDebug(true)
+	_ = streamingClient
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	_ = ctx
 	defer cancel()
 
 	// Note: This would work with a real streaming endpoint
 	fmt.Println("Streaming client configured (would work with real streaming endpoints)")
 	fmt.Println("Example usage:")
-	fmt.Println(`
+	os.Stdout.WriteString(`
 	stream, err := streamingClient.Stream("GET", "https://api.example.com/stream", nil)
 	if err != nil {
 		log.Fatal(err)
@@ -40,10 +43,11 @@ func main() {
 	fmt.Println("2. WebSocket Support:")
 	wsClient := httpclient.New().
 		WithTimeout(30 * time.Second)
+	_ = wsClient
 
 	fmt.Println("WebSocket client configured")
 	fmt.Println("Example usage:")
-	fmt.Println(`
+	os.Stdout.WriteString(`
 	ws, err := wsClient.WebSocket("wss://echo.websocket.org")
 	if err != nil {
 		log.Fatal(err)
@@ -131,6 +135,7 @@ func main() {
 		WithBaseURL("https://api.github.com/graphql").
 		WithHeader("Authorization", "Bearer YOUR_TOKEN_HERE").
 		WithTimeout(30 * time.Second)
+	_ = graphqlClient
 
 	query := `
 		query {
@@ -151,10 +156,10 @@ func main() {
 	// Example 6: Real-time Features Combined
 	fmt.Println("6. Complete Real-time Configuration:")
 	realtimeClient := httpclient.New().
-		WithTimeout(0). // No timeout for real-time
-		WithRealTimeMetrics(true).
-		WithPerformanceOptimization(true).
+		WithTimeout(10 * time.Second). // Streams and WebSockets outlive it
+		WithMetrics(true).
 		WithDebug(true)
+	_ = realtimeClient
 
 	fmt.Println("Real-time client configured with:")
 	fmt.Println("  ✓ HTTP streaming support")
@@ -163,8 +168,7 @@ func main() {
 	fmt.Println("  ✓ Batch request processing")
 	fmt.Println("  ✓ Pipeline request processing")
 	fmt.Println("  ✓ GraphQL support")
-	fmt.Println("  ✓ Real-time metrics")
-	fmt.Println("  ✓ Performance optimization")
+	fmt.Println("  ✓ Prometheus metrics")
 
 	// Demonstrate simple usage
 	fmt.Println("\nSimple usage examples:")
@@ -0,0 +1,410 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/batch"
+	"github.com/yourorg/httpclient/internal/client"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// facade adapts the internal client to the Client interface: its fluent
+// methods return the internal type, so each is wrapped to return a Client
+// again, as are batches and pipelines. All other methods are promoted.
+type facade struct {
+	*client.Client
+}
+
+func (f facade) Batch() BatchRequest {
+	return batchRequest{f.Client.Batch()}
+}
+
+func (f facade) Pipeline() PipelineRequest {
+	return pipelineRequest{f.Client.Pipeline()}
+}
+
+func (f facade) WebSocket(url string, opts ...WebSocketOption) (WebSocketConn, error) {
+	return f.WebSocketContext(context.Background(), url, opts...)
+}
+
+func (f facade) WebSocketContext(ctx context.Context, url string, opts ...WebSocketOption) (WebSocketConn, error) {
+	conn, err := f.Client.WebSocketContext(ctx, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (f facade) WithTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithTimeout(timeout)}
+}
+
+func (f facade) WithAttemptTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithAttemptTimeout(timeout)}
+}
+
+func (f facade) WithOverallTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithOverallTimeout(timeout)}
+}
+
+func (f facade) WithClock(clock Clock) Client {
+	return facade{f.Client.WithClock(clock)}
+}
+
+func (f facade) WithRetries(retries int) Client {
+	return facade{f.Client.WithRetries(retries)}
+}
+
+func (f facade) WithOnRetry(fn func(attempt int, err error, nextDelay time.Duration)) Client {
+	return facade{f.Client.WithOnRetry(fn)}
+}
+
+func (f facade) WithRetryBudget(maxTotal time.Duration) Client {
+	return facade{f.Client.WithRetryBudget(maxTotal)}
+}
+
+func (f facade) WithRetryNonIdempotent(enabled bool) Client {
+	return facade{f.Client.WithRetryNonIdempotent(enabled)}
+}
+
+func (f facade) WithIdempotencyKey() Client {
+	return facade{f.Client.WithIdempotencyKey()}
+}
+
+func (f facade) WithBaseURL(baseURL string) Client {
+	return facade{f.Client.WithBaseURL(baseURL)}
+}
+
+func (f facade) WithAuth(token string) Client {
+	return facade{f.Client.WithAuth(token)}
+}
+
+func (f facade) WithAuthProvider(provider func(ctx context.Context) (string, error)) Client {
+	return facade{f.Client.WithAuthProvider(provider)}
+}
+
+func (f facade) WithAuthRefreshOn401(enabled bool) Client {
+	return facade{f.Client.WithAuthRefreshOn401(enabled)}
+}
+
+func (f facade) WithAPIKey(key, value string) Client {
+	return facade{f.Client.WithAPIKey(key, value)}
+}
+
+func (f facade) WithHeader(key, value string) Client {
+	return facade{f.Client.WithHeader(key, value)}
+}
+
+func (f facade) WithHeaders(headers map[string]string) Client {
+	return facade{f.Client.WithHeaders(headers)}
+}
+
+func (f facade) WithUserAgent(userAgent string) Client {
+	return facade{f.Client.WithUserAgent(userAgent)}
+}
+
+func (f facade) WithAccept(mediaTypes ...string) Client {
+	return facade{f.Client.WithAccept(mediaTypes...)}
+}
+
+func (f facade) WithRateLimiter(rps int) Client {
+	return facade{f.Client.WithRateLimiter(rps)}
+}
+
+func (f facade) WithRateLimiterBurst(rps, burst int) Client {
+	return facade{f.Client.WithRateLimiterBurst(rps, burst)}
+}
+
+func (f facade) WithRateLimiterNonBlocking(enabled bool) Client {
+	return facade{f.Client.WithRateLimiterNonBlocking(enabled)}
+}
+
+func (f facade) WithPerHostRateLimiter(rps int) Client {
+	return facade{f.Client.WithPerHostRateLimiter(rps)}
+}
+
+func (f facade) WithCircuitBreaker(threshold int, timeout time.Duration) Client {
+	return facade{f.Client.WithCircuitBreaker(threshold, timeout)}
+}
+
+func (f facade) OnCircuitStateChange(fn func(old, new string)) Client {
+	return facade{f.Client.OnCircuitStateChange(fn)}
+}
+
+func (f facade) OnStateChange(fn func(host string, from, to CircuitState)) Client {
+	return facade{f.Client.OnStateChange(fn)}
+}
+
+func (f facade) WithCircuitBreakerProbes(probes, successes int) Client {
+	return facade{f.Client.WithCircuitBreakerProbes(probes, successes)}
+}
+
+func (f facade) WithCache(ttl time.Duration) Client {
+	return facade{f.Client.WithCache(ttl)}
+}
+
+func (f facade) WithNegativeCache(ttl time.Duration) Client {
+	return facade{f.Client.WithNegativeCache(ttl)}
+}
+
+func (f facade) WithCacheLimits(maxEntries int, maxBytes int64) Client {
+	return facade{f.Client.WithCacheLimits(maxEntries, maxBytes)}
+}
+
+func (f facade) WithCacheMaxEntrySize(maxBytes int64) Client {
+	return facade{f.Client.WithCacheMaxEntrySize(maxBytes)}
+}
+
+func (f facade) WithCacheStore(store CacheStore) Client {
+	return facade{f.Client.WithCacheStore(store)}
+}
+
+func (f facade) WithCacheKeyFunc(fn func(req *http.Request) string) Client {
+	return facade{f.Client.WithCacheKeyFunc(fn)}
+}
+
+func (f facade) WithCachePolicy(policy CachePolicy) Client {
+	return facade{f.Client.WithCachePolicy(policy)}
+}
+
+func (f facade) WithRequestCoalescing(enabled bool) Client {
+	return facade{f.Client.WithRequestCoalescing(enabled)}
+}
+
+func (f facade) WithMetrics(enabled bool) Client {
+	return facade{f.Client.WithMetrics(enabled)}
+}
+
+func (f facade) WithTracing(enabled bool) Client {
+	return facade{f.Client.WithTracing(enabled)}
+}
+
+func (f facade) WithTracePropagator(propagator propagation.TextMapPropagator) Client {
+	return facade{f.Client.WithTracePropagator(propagator)}
+}
+
+func (f facade) WithDebug(enabled bool) Client {
+	return facade{f.Client.WithDebug(enabled)}
+}
+
+func (f facade) WithLogger(logger Logger) Client {
+	return facade{f.Client.WithLogger(logger)}
+}
+
+func (f facade) WithMiddleware(mw Middleware) Client {
+	return facade{f.Client.WithMiddleware(mw)}
+}
+
+func (f facade) WithLoadBalancer(endpoints []string, strategy string) Client {
+	return facade{f.Client.WithLoadBalancer(endpoints, strategy)}
+}
+
+func (f facade) WithWeightedLoadBalancer(weights map[string]int) Client {
+	return facade{f.Client.WithWeightedLoadBalancer(weights)}
+}
+
+func (f facade) WithLoadBalancerKey(fn func(*http.Request) string) Client {
+	return facade{f.Client.WithLoadBalancerKey(fn)}
+}
+
+func (f facade) WithHealthCheck(interval time.Duration, endpoint string) Client {
+	return facade{f.Client.WithHealthCheck(interval, endpoint)}
+}
+
+func (f facade) WithCompression(enabled bool) Client {
+	return facade{f.Client.WithCompression(enabled)}
+}
+
+func (f facade) WithCompressionMinSize(bytes int) Client {
+	return facade{f.Client.WithCompressionMinSize(bytes)}
+}
+
+func (f facade) WithStreamIdleTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithStreamIdleTimeout(timeout)}
+}
+
+func (f facade) WithRequestSigning(keyID, privateKey string) Client {
+	return facade{f.Client.WithRequestSigning(keyID, privateKey)}
+}
+
+func (f facade) WithIPWhitelist(ips []string) Client {
+	return facade{f.Client.WithIPWhitelist(ips)}
+}
+
+func (f facade) WithRequestInterceptor(interceptor func(*http.Request) error) Client {
+	return facade{f.Client.WithRequestInterceptor(interceptor)}
+}
+
+func (f facade) WithResponseInterceptor(interceptor func(*http.Response) error) Client {
+	return facade{f.Client.WithResponseInterceptor(interceptor)}
+}
+
+func (f facade) WithBackupEndpoints(endpoints []string) Client {
+	return facade{f.Client.WithBackupEndpoints(endpoints)}
+}
+
+func (f facade) WithCustomTransport(transport http.RoundTripper) Client {
+	return facade{f.Client.WithCustomTransport(transport)}
+}
+
+func (f facade) WithConnectionPool(maxIdle, maxIdlePerHost int) Client {
+	return facade{f.Client.WithConnectionPool(maxIdle, maxIdlePerHost)}
+}
+
+func (f facade) WithKeepAlive(duration time.Duration) Client {
+	return facade{f.Client.WithKeepAlive(duration)}
+}
+
+func (f facade) WithDialTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithDialTimeout(timeout)}
+}
+
+func (f facade) WithResponseHeaderTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithResponseHeaderTimeout(timeout)}
+}
+
+func (f facade) WithTLSHandshakeTimeout(timeout time.Duration) Client {
+	return facade{f.Client.WithTLSHandshakeTimeout(timeout)}
+}
+
+func (f facade) WithMaxResponseSize(bytes int64) Client {
+	return facade{f.Client.WithMaxResponseSize(bytes)}
+}
+
+func (f facade) WithCodec(codec Codec) Client {
+	return facade{f.Client.WithCodec(codec)}
+}
+
+func (f facade) WithExpectContinue(enabled bool, timeout time.Duration) Client {
+	return facade{f.Client.WithExpectContinue(enabled, timeout)}
+}
+
+func (f facade) WithTLSConfig(config *tls.Config) Client {
+	return facade{f.Client.WithTLSConfig(config)}
+}
+
+func (f facade) WithProxy(proxyURL string) Client {
+	return facade{f.Client.WithProxy(proxyURL)}
+}
+
+func (f facade) WithProxyFunc(fn func(*http.Request) (*url.URL, error)) Client {
+	return facade{f.Client.WithProxyFunc(fn)}
+}
+
+func (f facade) WithProxies(httpProxy, httpsProxy, noProxy string) Client {
+	return facade{f.Client.WithProxies(httpProxy, httpsProxy, noProxy)}
+}
+
+func (f facade) WithoutProxy() Client {
+	return facade{f.Client.WithoutProxy()}
+}
+
+func (f facade) WithResolver(resolver Resolver) Client {
+	return facade{f.Client.WithResolver(resolver)}
+}
+
+func (f facade) WithDNSCache(ttl, negativeTTL time.Duration, maxEntries int) Client {
+	return facade{f.Client.WithDNSCache(ttl, negativeTTL, maxEntries)}
+}
+
+func (f facade) WithCookieJar(jar http.CookieJar) Client {
+	return facade{f.Client.WithCookieJar(jar)}
+}
+
+func (f facade) WithRedirectPolicy(policy func(req *http.Request, via []*http.Request) error) Client {
+	return facade{f.Client.WithRedirectPolicy(policy)}
+}
+
+func (f facade) WithAIRetry(enabled bool) Client {
+	return facade{f.Client.WithAIRetry(enabled)}
+}
+
+func (f facade) WithSmartCaching(enabled bool) Client {
+	return facade{f.Client.WithSmartCaching(enabled)}
+}
+
+func (f facade) WithPredictivePreloading(enabled bool) Client {
+	return facade{f.Client.WithPredictivePreloading(enabled)}
+}
+
+func (f facade) WithAdaptiveTimeout(enabled bool) Client {
+	return facade{f.Client.WithAdaptiveTimeout(enabled)}
+}
+
+func (f facade) WithGraphQLEndpoint(endpoint string) Client {
+	return facade{f.Client.WithGraphQLEndpoint(endpoint)}
+}
+
+func (f facade) WithGraphQLPersistedQueries(enabled bool) Client {
+	return facade{f.Client.WithGraphQLPersistedQueries(enabled)}
+}
+
+func (f facade) WithGraphQLValidation(enabled bool) Client {
+	return facade{f.Client.WithGraphQLValidation(enabled)}
+}
+
+func (f facade) WithGraphQLInitPayload(payload map[string]interface{}) Client {
+	return facade{f.Client.WithGraphQLInitPayload(payload)}
+}
+
+func (f facade) WithHTTP2(enabled bool) Client {
+	return facade{f.Client.WithHTTP2(enabled)}
+}
+
+func (f facade) WithHTTP3(enabled bool) Client {
+	return facade{f.Client.WithHTTP3(enabled)}
+}
+
+func (f facade) WithHTTP3AltSvc(enabled bool) Client {
+	return facade{f.Client.WithHTTP3AltSvc(enabled)}
+}
+
+func (f facade) WithOAuth2(config OAuth2Config) Client {
+	return facade{f.Client.WithOAuth2(config)}
+}
+
+// batchRequest adapts a batch to the BatchRequest interface
+type batchRequest struct {
+	*batch.BatchRequest
+}
+
+func (b batchRequest) Add(method, url string, body interface{}, opts ...ItemOption) BatchRequest {
+	return batchRequest{b.BatchRequest.Add(method, url, body, opts...)}
+}
+
+func (b batchRequest) WithConcurrency(n int) BatchRequest {
+	return batchRequest{b.BatchRequest.WithConcurrency(n)}
+}
+
+func (b batchRequest) FailFast() BatchRequest {
+	return batchRequest{b.BatchRequest.FailFast()}
+}
+
+func (b batchRequest) OnProgress(fn func(done, total int)) BatchRequest {
+	return batchRequest{b.BatchRequest.OnProgress(fn)}
+}
+
+// pipelineRequest adapts a pipeline to the PipelineRequest interface
+type pipelineRequest struct {
+	*batch.PipelineRequest
+}
+
+func (p pipelineRequest) Add(method, url string, body interface{}, opts ...ItemOption) PipelineRequest {
+	return pipelineRequest{p.PipelineRequest.Add(method, url, body, opts...)}
+}
+
+func (p pipelineRequest) AddDependent(build func(prev []byte) (method, url string, body interface{}), opts ...ItemOption) PipelineRequest {
+	return pipelineRequest{p.PipelineRequest.AddDependent(build, opts...)}
+}
+
+func (p pipelineRequest) AddFunc(fn StepFunc, opts ...ItemOption) PipelineRequest {
+	return pipelineRequest{p.PipelineRequest.AddFunc(fn, opts...)}
+}
+
+func (p pipelineRequest) StopOnError() PipelineRequest {
+	return pipelineRequest{p.PipelineRequest.StopOnError()}
+}
@@ -0,0 +1,119 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/yourorg/httpclient/internal/client"
+)
+
+// DisallowUnknownFields makes GetAs and PostAs fail when the response
+// contains object keys that don't match a field of the target type
+func DisallowUnknownFields() RequestOption {
+	return func(o *client.RequestOptions) {
+		o.DisallowUnknownFields = true
+	}
+}
+
+// DecodeError is returned by GetAs and PostAs when the response body can't
+// be decoded into the requested type
+type DecodeError struct {
+	Type    string // the target type
+	Offset  int64  // byte offset in the body where decoding failed
+	Snippet string // the body around Offset
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode response into %s at offset %d near %q: %v", e.Type, e.Offset, e.Snippet, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// GetAs makes a GET request and decodes the JSON response into a T. A nil
+// client uses Default.
+//
+//	users, err := httpclient.GetAs[[]User](ctx, client, "/users")
+func GetAs[T any](ctx context.Context, c Client, url string, opts ...RequestOption) (T, error) {
+	if c == nil {
+		c = Default
+	}
+
+	data, err := c.GetContext(ctx, url, opts...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decodeAs[T](data, opts)
+}
+
+// PostAs makes a POST request with body encoded as JSON and decodes the
+// JSON response into a T. A nil client uses Default.
+func PostAs[T any](ctx context.Context, c Client, url string, body interface{}, opts ...RequestOption) (T, error) {
+	if c == nil {
+		c = Default
+	}
+
+	data, err := c.PostContext(ctx, url, body, opts...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decodeAs[T](data, opts)
+}
+
+// decodeAs decodes data into a new T; an empty body yields the zero value
+func decodeAs[T any](data []byte, opts []RequestOption) (T, error) {
+	var result T
+	if len(bytes.TrimSpace(data)) == 0 {
+		return result, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if client.NewRequestOptions(opts).DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&result); err != nil {
+		offset := decoder.InputOffset()
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			offset = syntaxErr.Offset
+		case errors.As(err, &typeErr):
+			offset = typeErr.Offset
+		}
+		return result, &DecodeError{
+			Type:    reflect.TypeOf(&result).Elem().String(),
+			Offset:  offset,
+			Snippet: snippet(data, offset),
+			Err:     err,
+		}
+	}
+
+	return result, nil
+}
+
+// snippet returns up to 40 bytes of data around offset
+func snippet(data []byte, offset int64) string {
+	const radius = 20
+
+	start := offset - radius
+	if start < 0 {
+		start = 0
+	}
+	end := offset + radius
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	if start > end {
+		start = end
+	}
+	return string(data[start:end])
+}
@@ -19,10 +19,26 @@ package httpclient
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/yourorg/httpclient/internal/batch"
 	"github.com/yourorg/httpclient/internal/client"
+	"github.com/yourorg/httpclient/internal/clock"
 	"github.com/yourorg/httpclient/internal/config"
+	"github.com/yourorg/httpclient/internal/cookies"
+	"github.com/yourorg/httpclient/internal/dns"
+	"github.com/yourorg/httpclient/internal/graphql"
+	"github.com/yourorg/httpclient/internal/logging"
+	"github.com/yourorg/httpclient/internal/middleware"
+	"github.com/yourorg/httpclient/internal/retry"
+	"github.com/yourorg/httpclient/internal/streaming"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // Default client instance - ready to use immediately
@@ -31,61 +47,122 @@ var Default = New()
 // Client is the main HTTP client interface
 type Client interface {
 	// HTTP Methods
-	GET(url string) ([]byte, error)
-	POST(url string, body interface{}) ([]byte, error)
-	PUT(url string, body interface{}) ([]byte, error)
-	PATCH(url string, body interface{}) ([]byte, error)
-	DELETE(url string) ([]byte, error)
-	HEAD(url string) error
-	OPTIONS(url string) ([]byte, error)
+	GET(url string, opts ...RequestOption) ([]byte, error)
+	POST(url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	PUT(url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	PATCH(url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	DELETE(url string, opts ...RequestOption) ([]byte, error)
+	HEAD(url string, opts ...RequestOption) error
+	OPTIONS(url string, opts ...RequestOption) ([]byte, error)
+	TRACE(url string, opts ...RequestOption) ([]byte, error)
+	Preflight(url string) (allowedMethods []string, allowedHeaders []string, err error)
 
 	// Context-aware methods
-	GetContext(ctx context.Context, url string) ([]byte, error)
-	PostContext(ctx context.Context, url string, body interface{}) ([]byte, error)
-	PutContext(ctx context.Context, url string, body interface{}) ([]byte, error)
-	PatchContext(ctx context.Context, url string, body interface{}) ([]byte, error)
-	DeleteContext(ctx context.Context, url string) ([]byte, error)
+	GetContext(ctx context.Context, url string, opts ...RequestOption) ([]byte, error)
+	PostContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	PutContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	PatchContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error)
+	DeleteContext(ctx context.Context, url string, opts ...RequestOption) ([]byte, error)
 
 	// JSON methods
-	JSON(method, url string, body, result interface{}) error
-	JSONContext(ctx context.Context, method, url string, body, result interface{}) error
+	JSON(method, url string, body, result interface{}, opts ...RequestOption) error
+	JSONContext(ctx context.Context, method, url string, body, result interface{}, opts ...RequestOption) error
+
+	// Protobuf methods
+	Proto(method, url string, in, out ProtoMessage) error
+	ProtoContext(ctx context.Context, method, url string, in, out ProtoMessage) error
 
 	// Streaming methods
-	Stream(method, url string, body interface{}) (<-chan []byte, error)
-	StreamContext(ctx context.Context, method, url string, body interface{}) (<-chan []byte, error)
+	Stream(method, url string, body interface{}, opts ...StreamOption) (<-chan []byte, error)
+	StreamContext(ctx context.Context, method, url string, body interface{}, opts ...StreamOption) (<-chan []byte, error)
+	StreamJSON(ctx context.Context, method, url string, body interface{}, fn func(json.RawMessage) error) error
+	SubscribeSSE(ctx context.Context, url string, handlers map[string]func(data string)) error
 
 	// Batch operations
 	Batch() BatchRequest
 	Pipeline() PipelineRequest
 
+	// Raw TCP tunnels through the configured proxy
+	Connect(host string) (net.Conn, error)
+	ConnectContext(ctx context.Context, host string) (net.Conn, error)
+
 	// WebSocket support
-	WebSocket(url string) (WebSocketConn, error)
-	WebSocketContext(ctx context.Context, url string) (WebSocketConn, error)
+	WebSocket(url string, opts ...WebSocketOption) (WebSocketConn, error)
+	WebSocketContext(ctx context.Context, url string, opts ...WebSocketOption) (WebSocketConn, error)
 
 	// GraphQL support
 	GraphQL(query string, variables map[string]interface{}, result interface{}) error
 	GraphQLContext(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error
+	GraphQLOp(ctx context.Context, opName, query string, variables map[string]interface{}, result interface{}) error
+	GraphQLUpload(ctx context.Context, query string, variables map[string]interface{}, files map[string]Upload, result interface{}) error
+	GraphQLBatch(ctx context.Context, requests []GraphQLRequest, results []interface{}) error
+	GraphQLSubscribe(ctx context.Context, opName, query string, variables map[string]interface{}) (*GraphQLSubscription, error)
+
+	// Circuit breaker health: "closed", "open", "half-open" or "disabled"
+	// overall, and the circuit of a single host
+	CircuitBreakerState() (state string, failures int64)
+	CircuitState(host string) (state CircuitState, failures int64)
+
+	// In-flight requests per endpoint for the "least-conn" load balancer
+	EndpointConnections() map[string]int64
+
+	// Response cache maintenance
+	InvalidateCache(url string)
+	ClearCache()
+	FlushCache()
+	CacheStats() CacheStats
+	MetricsSnapshot() MetricsSnapshot
 
 	// Configuration methods (fluent interface)
 	WithTimeout(timeout time.Duration) Client
+	WithAttemptTimeout(timeout time.Duration) Client
+	WithOverallTimeout(timeout time.Duration) Client
+	WithClock(clock Clock) Client
 	WithRetries(retries int) Client
+	WithOnRetry(fn func(attempt int, err error, nextDelay time.Duration)) Client
+	WithRetryBudget(maxTotal time.Duration) Client
+	WithRetryNonIdempotent(enabled bool) Client
+	WithIdempotencyKey() Client
 	WithBaseURL(baseURL string) Client
 	WithAuth(token string) Client
+	WithAuthProvider(provider func(ctx context.Context) (string, error)) Client
+	WithAuthRefreshOn401(enabled bool) Client
 	WithAPIKey(key, value string) Client
 	WithHeader(key, value string) Client
 	WithHeaders(headers map[string]string) Client
 	WithUserAgent(userAgent string) Client
+	WithAccept(mediaTypes ...string) Client
 	WithRateLimiter(rps int) Client
+	WithRateLimiterBurst(rps, burst int) Client
+	WithRateLimiterNonBlocking(enabled bool) Client
+	WithPerHostRateLimiter(rps int) Client
 	WithCircuitBreaker(threshold int, timeout time.Duration) Client
+	OnCircuitStateChange(fn func(old, new string)) Client
+	OnStateChange(fn func(host string, from, to CircuitState)) Client
+	WithCircuitBreakerProbes(probes, successes int) Client
 	WithCache(ttl time.Duration) Client
+	WithNegativeCache(ttl time.Duration) Client
+	WithCacheLimits(maxEntries int, maxBytes int64) Client
+	WithCacheMaxEntrySize(maxBytes int64) Client
+	WithCacheStore(store CacheStore) Client
+	WithCacheKeyFunc(fn func(req *http.Request) string) Client
+	WithCachePolicy(policy CachePolicy) Client
+	WithRequestCoalescing(enabled bool) Client
 	WithMetrics(enabled bool) Client
 	WithTracing(enabled bool) Client
+	WithTracePropagator(propagator propagation.TextMapPropagator) Client
 	WithDebug(enabled bool) Client
+	WithLogger(logger Logger) Client
+	WithMiddleware(mw Middleware) Client
 
 	// Advanced features
 	WithLoadBalancer(endpoints []string, strategy string) Client
+	WithWeightedLoadBalancer(weights map[string]int) Client
+	WithLoadBalancerKey(fn func(*http.Request) string) Client
 	WithHealthCheck(interval time.Duration, endpoint string) Client
 	WithCompression(enabled bool) Client
+	WithCompressionMinSize(bytes int) Client
+	WithStreamIdleTimeout(timeout time.Duration) Client
 	WithRequestSigning(keyID, privateKey string) Client
 	WithIPWhitelist(ips []string) Client
 	WithRequestInterceptor(interceptor func(*http.Request) error) Client
@@ -94,8 +171,19 @@ type Client interface {
 	WithCustomTransport(transport http.RoundTripper) Client
 	WithConnectionPool(maxIdle, maxIdlePerHost int) Client
 	WithKeepAlive(duration time.Duration) Client
+	WithDialTimeout(timeout time.Duration) Client
+	WithResponseHeaderTimeout(timeout time.Duration) Client
+	WithTLSHandshakeTimeout(timeout time.Duration) Client
+	WithMaxResponseSize(bytes int64) Client
+	WithCodec(codec Codec) Client
+	WithExpectContinue(enabled bool, timeout time.Duration) Client
 	WithTLSConfig(config *tls.Config) Client
 	WithProxy(proxyURL string) Client
+	WithProxyFunc(fn func(*http.Request) (*url.URL, error)) Client
+	WithProxies(httpProxy, httpsProxy, noProxy string) Client
+	WithoutProxy() Client
+	WithResolver(resolver Resolver) Client
+	WithDNSCache(ttl, negativeTTL time.Duration, maxEntries int) Client
 	WithCookieJar(jar http.CookieJar) Client
 	WithRedirectPolicy(policy func(req *http.Request, via []*http.Request) error) Client
 
@@ -104,106 +192,421 @@ type Client interface {
 	WithSmartCaching(enabled bool) Client
 	WithPredictivePreloading(enabled bool) Client
 	WithAdaptiveTimeout(enabled bool) Client
+	AdaptiveTimeouts() map[string]time.Duration
 
 	// Advanced Networking
+	WithGraphQLEndpoint(endpoint string) Client
+	WithGraphQLPersistedQueries(enabled bool) Client
+	WithGraphQLValidation(enabled bool) Client
+	WithGraphQLInitPayload(payload map[string]interface{}) Client
+	WithHTTP2(enabled bool) Client
 	WithHTTP3(enabled bool) Client
-	WithMultipath(enabled bool) Client
-	WithDNSOverHTTPS(enabled bool) Client
-	WithEdgeOptimization(enabled bool) Client
+	WithHTTP3AltSvc(enabled bool) Client
 
 	// Security & Compliance
-	WithMTLS(certFile, keyFile string) Client
 	WithOAuth2(config OAuth2Config) Client
-	WithJWT(config JWTConfig) Client
-	WithAPIGateway(config APIGatewayConfig) Client
-	WithCompliance(standards []string) Client
+}
+
+// Sentinel errors that can be matched with errors.Is
+var (
+	ErrCircuitOpen           = middleware.ErrCircuitOpen
+	ErrRateLimited           = client.ErrRateLimited
+	ErrNotWhitelisted        = client.ErrNotWhitelisted
+	ErrAttemptTimeout        = client.ErrAttemptTimeout
+	ErrOverallTimeout        = client.ErrOverallTimeout
+	ErrPathParams            = client.ErrPathParams
+	ErrCacheMiss             = client.ErrCacheMiss
+	ErrResponseTooLarge      = client.ErrResponseTooLarge
+	ErrInvalidProxy          = client.ErrInvalidProxy
+	ErrInvalidWeights        = client.ErrInvalidWeights
+	ErrMaxRetries            = retry.ErrMaxRetries
+	ErrRetryBudgetExceeded   = retry.ErrRetryBudgetExceeded
+	ErrUnsupportedEncoding   = client.ErrUnsupportedEncoding
+	ErrUnexpectedContentType = client.ErrUnexpectedContentType
+	ErrPartialRecord         = streaming.ErrPartialRecord
+	ErrStreamIdleTimeout     = client.ErrStreamIdleTimeout
+	ErrRecordTooLong         = streaming.ErrRecordTooLong
+	ErrWebSocketClosed       = streaming.ErrWebSocketClosed
+	ErrDisconnected          = streaming.ErrDisconnected
+	ErrReconnectFailed       = streaming.ErrReconnectFailed
+	ErrKeepaliveTimeout      = streaming.ErrKeepaliveTimeout
+)
+
+// GraphQLErrors is returned by GraphQL when the server reports errors;
+// each GraphQLError carries its path and extensions
+type GraphQLErrors = graphql.GraphQLErrors
+
+// GraphQLError is a single error reported by a GraphQL server
+type GraphQLError = graphql.GraphQLError
+
+// GraphQLValidationError is returned by GraphQL requests that fail the
+// schema validation of WithGraphQLValidation
+type GraphQLValidationError = graphql.ValidationError
+
+// GraphQLSubscription is a running subscription of GraphQLSubscribe
+type GraphQLSubscription = graphql.GraphQLSubscription
+
+// GraphQLResponse is a result of a GraphQLSubscription; Decode reads its
+// data
+type GraphQLResponse = graphql.GraphQLResponse
+
+// GraphQLRequest is an operation of a GraphQLBatch
+type GraphQLRequest = graphql.GraphQLRequest
+
+// GraphQLBatchError is returned by GraphQLBatch when some of its operations
+// fail; Errors has an entry per operation, nil for the ones that succeeded
+type GraphQLBatchError = graphql.BatchError
+
+// Upload is a file attached to a GraphQLUpload request
+type Upload = client.Upload
+
+// ProtoMessage is a request or response body of Proto: a protobuf message
+// with Marshal and Unmarshal methods
+type ProtoMessage = client.ProtoMessage
+
+// PersistentJar is a cookie jar saved to a JSON file, see
+// PersistentCookieJar
+type PersistentJar = cookies.PersistentJar
+
+// PersistentCookieJar returns a cookie jar that loads its cookies from the
+// JSON file at path and saves them there on every change, so sessions
+// survive restarts. Use it with WithCookieJar.
+func PersistentCookieJar(path string) (*PersistentJar, error) {
+	return cookies.NewPersistentJar(path)
+}
+
+// Logger receives debug output and internal warnings, see
+// Client.WithLogger
+type Logger = logging.Logger
+
+// NewLogger returns a Logger writing one line per message to w
+func NewLogger(w io.Writer) Logger {
+	return logging.NewWriter(w)
+}
+
+// WithoutCoalescing returns a context whose requests are sent on their own
+// even when the client coalesces identical requests, see
+// Client.WithRequestCoalescing
+func WithoutCoalescing(ctx context.Context) context.Context {
+	return client.WithoutCoalescing(ctx)
+}
+
+// IsAuthRefresh reports whether an auth provider is called because the
+// server rejected its last token, see Client.WithAuthProvider
+func IsAuthRefresh(ctx context.Context) bool {
+	return client.IsAuthRefresh(ctx)
+}
+
+// CacheStore holds cached responses, e.g. in Redis to share them between
+// processes, see Client.WithCacheStore
+type CacheStore = middleware.CacheStore
+
+// CachedResponse is a response held by a CacheStore. MarshalBinary and
+// UnmarshalBinary give it a stable encoding for stores that hold bytes.
+type CachedResponse = middleware.CachedResponse
+
+// CacheStats reports hits, misses and evictions of the response cache and
+// its current size, see Client.CacheStats
+type CacheStats = middleware.CacheStats
+
+// MetricsSnapshot holds the metrics of a single client, see
+// Client.MetricsSnapshot
+type MetricsSnapshot = client.MetricsSnapshot
+
+// CachePolicy decides how long a cached response is fresh, see
+// Client.WithCachePolicy
+type CachePolicy = middleware.CachePolicy
+
+// Cache policies
+const (
+	CacheRespectServer = middleware.CacheRespectServer
+	CacheOverride      = middleware.CacheOverride
+)
+
+// Middleware observes each request before it is sent and its response
+// after, see Client.WithMiddleware
+type Middleware = middleware.Middleware
+
+// MiddlewareFunc makes a Middleware from functions; either may be nil
+func MiddlewareFunc(before func(*http.Request) error, after func(*http.Response)) Middleware {
+	return middleware.NewFunc(before, after)
+}
+
+// RequestRoute returns the URL template a request was built from with
+// WithPathParams, e.g. "/users/{id}", or "" for a plain URL. Middleware can
+// use it as a low-cardinality label.
+func RequestRoute(req *http.Request) string {
+	return middleware.Route(req)
+}
+
+// ContentTypeError is returned by JSON when the response Content-Type can't
+// be decoded into the result; it carries the start of the body
+type ContentTypeError = client.ContentTypeError
+
+// DecodeFunc decodes a response body into a result
+type DecodeFunc = client.DecodeFunc
+
+// RegisterDecoder makes JSON decode responses of mediaType with fn, e.g.
+// for application/msgpack. JSON, XML and text/* are decoded out of the box.
+func RegisterDecoder(mediaType string, fn DecodeFunc) {
+	client.RegisterDecoder(mediaType, fn)
+}
+
+// MaxAdaptiveEndpoints is how many endpoints Client.AdaptiveTimeouts keeps
+// statistics for at most
+const MaxAdaptiveEndpoints = client.MaxAdaptiveEndpoints
+
+// CircuitState is the state of the circuit of a host, returned by
+// Client.CircuitState and passed to Client.OnStateChange
+type CircuitState = middleware.CircuitState
+
+// Circuit states
+const (
+	CircuitClosed   = middleware.StateClosed
+	CircuitOpen     = middleware.StateOpen
+	CircuitHalfOpen = middleware.StateHalfOpen
+)
+
+// Codec encodes request bodies and decodes JSON responses in place of
+// encoding/json, see Client.WithCodec
+type Codec = config.Codec
+
+// RequestOption customizes a single request
+type RequestOption = client.RequestOption
+
+// WithPathParams fills the {name} placeholders of the request URL path,
+// percent-encoding each value as a single path segment:
+//
+//	client.GET("/users/{id}/posts/{slug}", httpclient.WithPathParams(map[string]string{"id": "42", "slug": "hello world"}))
+//
+// requests /users/42/posts/hello%20world. A missing or unused parameter,
+// or a segment that comes out empty, "." or "..", fails the request with
+// ErrPathParams before anything is sent. Metrics and traces label the
+// request with the template as its route.
+func WithPathParams(params map[string]string) RequestOption {
+	return client.WithPathParams(params)
+}
 
-	// Performance & Monitoring
-	WithRealTimeMetrics(enabled bool) Client
-	WithAPM(provider string) Client
-	WithChaosEngineering(config ChaosConfig) Client
-	WithPerformanceOptimization(enabled bool) Client
+// NoCache skips the response cache for one request: nothing is looked up
+// and the response isn't stored
+func NoCache() RequestOption { return client.NoCache() }
 
-	// Developer Experience
-	WithMocking(enabled bool) Client
-	WithRecording(enabled bool) Client
-	WithReplay(enabled bool) Client
-	WithValidation(schema interface{}) Client
-	WithAutoRetry(config AutoRetryConfig) Client
+// ForceRevalidate sends one request to the server even when a fresh
+// response is cached, conditionally when it can be revalidated, and
+// caches the answer, e.g. for a refresh the user asked for
+func ForceRevalidate() RequestOption { return client.ForceRevalidate() }
+
+// OnlyIfCached answers one request from the cache, failing it with
+// ErrCacheMiss instead of going to the network
+func OnlyIfCached() RequestOption { return client.OnlyIfCached() }
+
+// StreamOption frames or observes a single Stream call
+type StreamOption = streaming.StreamOption
+
+// SplitLines makes Stream deliver one line at a time, without its line
+// terminator
+func SplitLines() StreamOption { return streaming.SplitLines() }
+
+// SplitDelimiter makes Stream deliver the records between occurrences of
+// delim
+func SplitDelimiter(delim byte) StreamOption { return streaming.SplitDelimiter(delim) }
+
+// SplitSSE makes Stream deliver whole server-sent events
+func SplitSSE() StreamOption { return streaming.SplitSSE() }
+
+// MaxRecordSize limits the size of a framed record; longer records end the
+// stream with ErrRecordTooLong. The default is 64KB.
+func MaxRecordSize(n int) StreamOption { return streaming.MaxRecordSize(n) }
+
+// StreamBufferSize sets how many values the stream channel holds, 100 by
+// default. A full channel pauses reading the body until the consumer
+// catches up, which slows the server down instead of buffering without
+// bound.
+func StreamBufferSize(n int) StreamOption { return streaming.StreamBufferSize(n) }
+
+// OnStreamError calls fn with the error that ends a stream early
+func OnStreamError(fn func(error)) StreamOption { return streaming.OnStreamError(fn) }
+
+// OnStreamEnd calls fn once the stream has ended, for whatever reason
+func OnStreamEnd(fn func()) StreamOption { return streaming.OnStreamEnd(fn) }
+
+// WebSocketOption customizes a single WebSocket call
+type WebSocketOption = streaming.WebSocketOption
+
+// ReconnectPolicy controls how a dropped WebSocket is re-dialed
+type ReconnectPolicy = streaming.ReconnectPolicy
+
+// WithReconnect makes WebSocket re-dial the connection with exponential
+// backoff when it drops. Receive reconnects transparently and messages
+// sent while disconnected are queued.
+func WithReconnect(policy ReconnectPolicy) WebSocketOption { return streaming.WithReconnect(policy) }
+
+// OnReconnect calls fn with each new connection after a reconnect, e.g. to
+// resubscribe
+func OnReconnect(fn func(conn WebSocketConn) error) WebSocketOption {
+	return streaming.OnReconnect(func(conn streaming.Socket) error { return fn(conn) })
 }
 
+// WithPingKeepalive makes WebSocket ping the server every interval and
+// close the connection with ErrKeepaliveTimeout when nothing arrives within
+// timeout of a ping
+func WithPingKeepalive(interval, timeout time.Duration) WebSocketOption {
+	return streaming.WithPingKeepalive(interval, timeout)
+}
+
+// WebSocketMessage is a message received on a WebSocket, with its type and
+// arrival time
+type WebSocketMessage = streaming.Message
+
+// MessageType is the frame type of a WebSocket message
+type MessageType = streaming.MessageType
+
+// WebSocket message types; CloseMessage is reported by ReceiveTyped for the
+// server's close frame
+const (
+	TextMessage   = streaming.TextMessage
+	BinaryMessage = streaming.BinaryMessage
+	CloseMessage  = streaming.CloseMessage
+)
+
+// ReceiveAs receives the next WebSocket message and decodes it from JSON
+// into a T
+func ReceiveAs[T any](conn WebSocketConn) (T, error) {
+	return streaming.ReceiveAs[T](conn)
+}
+
+// CloseError is returned by Receive once the server has closed the
+// WebSocket, with the code and reason from its close frame
+type CloseError = streaming.CloseError
+
+// WebSocket close codes for CloseWithStatus
+const (
+	CloseNormalClosure = streaming.CloseNormalClosure
+	CloseGoingAway     = streaming.CloseGoingAway
+)
+
+// Clock is the time source used by the client. Now and After mirror the
+// functions in the time package; the default is the real clock.
+type Clock = clock.Clock
+
+// Resolver looks up host addresses for WithResolver; *net.Resolver
+// satisfies it and ResolverFunc adapts a plain function
+type Resolver = dns.Resolver
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc = dns.ResolverFunc
+
+// APIError is returned for 4xx/5xx responses. Use errors.As to inspect the
+// status code, headers and body, or Unmarshal to decode a JSON error payload.
+type APIError = retry.APIError
+
 // Advanced types for new features
 type BatchRequest interface {
-	Add(method, url string, body interface{}) BatchRequest
-	Execute() ([]BatchResponse, error)
-	ExecuteContext(ctx context.Context) ([]BatchResponse, error)
+	Add(method, url string, body interface{}, opts ...ItemOption) BatchRequest
+	WithConcurrency(n int) BatchRequest
+	FailFast() BatchRequest
+	OnProgress(fn func(done, total int)) BatchRequest
+	Execute() (BatchResponses, error)
+	ExecuteContext(ctx context.Context) (BatchResponses, error)
+	ExecuteStream(ctx context.Context) (<-chan BatchResponse, error)
+	Failed() []BatchItem
+	RetryFailed(ctx context.Context, opts ...RetryFailedOption) (BatchResponses, error)
 }
 
 type PipelineRequest interface {
-	Add(method, url string, body interface{}) PipelineRequest
+	Add(method, url string, body interface{}, opts ...ItemOption) PipelineRequest
+	AddDependent(build func(prev []byte) (method, url string, body interface{}), opts ...ItemOption) PipelineRequest
+	AddFunc(fn StepFunc, opts ...ItemOption) PipelineRequest
+	StopOnError() PipelineRequest
 	Execute() (<-chan PipelineResponse, error)
 	ExecuteContext(ctx context.Context) (<-chan PipelineResponse, error)
 }
 
-type BatchResponse struct {
-	Index    int
-	Data     []byte
-	Error    error
-	Duration time.Duration
+// ItemOption customizes a single request of a batch or pipeline
+type ItemOption = batch.ItemOption
+
+// ItemHeader sets a header on a single batch or pipeline request
+func ItemHeader(key, value string) ItemOption {
+	return batch.ItemHeader(key, value)
 }
 
-type PipelineResponse struct {
-	Index    int
-	Data     []byte
-	Error    error
-	Duration time.Duration
+// ItemTimeout bounds a single batch or pipeline request, retries included
+func ItemTimeout(d time.Duration) ItemOption {
+	return batch.ItemTimeout(d)
 }
 
+// BatchItem is a request queued in a batch or pipeline
+type BatchItem = batch.BatchItem
+
+// RetryFailedOption customizes BatchRequest.RetryFailed
+type RetryFailedOption = batch.RetryFailedOption
+
+// MaxRounds lets RetryFailed re-run the failed requests up to n times
+func MaxRounds(n int) RetryFailedOption {
+	return batch.MaxRounds(n)
+}
+
+// BatchResponse is the result of a single batch request
+type BatchResponse = batch.BatchResponse
+
+// BatchResponses are the responses of a batch in the order the requests
+// were added; AllSucceeded and DecodeJSON help check and read them
+type BatchResponses = batch.BatchResponses
+
+// BatchError is returned by Execute alongside the responses when any
+// request failed; errors.Is and errors.As look through it to each failure
+type BatchError = batch.BatchError
+
+// PipelineResponse is the result of a single pipeline step
+type PipelineResponse = batch.PipelineResponse
+
+// StepFunc computes a pipeline step from the responses of the steps before
+// it, see PipelineRequest.AddFunc
+type StepFunc = batch.StepFunc
+
 type WebSocketConn interface {
 	Send(data interface{}) error
+	SendJSON(v interface{}) error
 	Receive() ([]byte, error)
+	ReceiveJSON(v interface{}) error
+	ReceiveMessage() (WebSocketMessage, error)
+	ReceiveTyped() (MessageType, []byte, error)
+	Listen(ctx context.Context) (<-chan WebSocketMessage, <-chan error)
+	ReadChannel() <-chan WebSocketMessage
 	Close() error
+	CloseWithStatus(code int, reason string) error
 }
 
-type OAuth2Config struct {
-	ClientID     string
-	ClientSecret string
-	TokenURL     string
-	Scopes       []string
-}
+// OAuth2Config gets request tokens from an OAuth2 token endpoint with the
+// client credentials, authorization code or refresh token grant, see
+// Client.WithOAuth2
+type OAuth2Config = config.OAuth2Config
 
-type JWTConfig struct {
-	Secret    string
-	Algorithm string
-	Issuer    string
-}
+// OAuth2Token is a token issued by an OAuth2 token endpoint, passed to
+// OAuth2Config.OnToken
+type OAuth2Token = config.OAuth2Token
 
-type APIGatewayConfig struct {
-	Provider string
-	Config   map[string]interface{}
-}
+// OAuth2AuthStyle is how the client ID and secret are sent to the token
+// endpoint
+type OAuth2AuthStyle = config.OAuth2AuthStyle
 
-type ChaosConfig struct {
-	FailureRate    float64
-	LatencyRange   [2]time.Duration
-	ErrorTypes     []string
-	EnabledMethods []string
-}
+// OAuth2 client authentication styles
+const (
+	OAuth2AuthHeader = config.OAuth2AuthHeader
+	OAuth2AuthParams = config.OAuth2AuthParams
+)
+
+// OAuth2Error is an error response of an OAuth2 token endpoint
+type OAuth2Error = client.OAuth2Error
 
-type AutoRetryConfig struct {
-	MaxAttempts     int
-	BackoffStrategy string
-	RetryConditions []string
-	JitterEnabled   bool
-}
 // New creates a new HTTP client with sensible defaults
 func New() Client {
-	return client.New(config.Default())
+	return facade{client.New(config.Default())}
 }
 
 // NewWithConfig creates a new HTTP client with custom configuration
 func NewWithConfig(cfg *config.Config) Client {
-	return client.New(cfg)
+	return facade{client.New(cfg)}
 }
 
 // Smart constructors for common use cases
@@ -221,10 +624,9 @@ func NewForMicroservices() Client {
 func NewForAPI() Client {
 	return New().
 		WithRateLimiter(100).
-		WithCache(5*time.Minute).
+		WithCache(5 * time.Minute).
 		WithCompression(true).
-		WithSmartCaching(true).
-		WithPerformanceOptimization(true)
+		WithSmartCaching(true)
 }
 
 func NewForEnterprise() Client {
@@ -235,64 +637,55 @@ func NewForEnterprise() Client {
 		WithCompression(true).
 		WithMetrics(true).
 		WithTracing(true).
-		WithRealTimeMetrics(true).
-		WithCompliance([]string{"SOC2", "GDPR", "HIPAA"}).
-		WithPerformanceOptimization(true).
 		WithAIRetry(true).
 		WithAdaptiveTimeout(true)
 }
 
 func NewForDevelopment() Client {
 	return New().
-		WithDebug(true).
-		WithMocking(true).
-		WithRecording(true).
-		WithValidation(nil).
-		WithChaosEngineering(ChaosConfig{
-			FailureRate: 0.1,
-			LatencyRange: [2]time.Duration{100*time.Millisecond, 500*time.Millisecond},
-		})
+		WithDebug(true)
 }
+
 // Package-level convenience functions using the default client
 
 // GET makes a GET request using the default client
-func GET(url string) ([]byte, error) {
-	return Default.GET(url)
+func GET(url string, opts ...RequestOption) ([]byte, error) {
+	return Default.GET(url, opts...)
 }
 
 // POST makes a POST request using the default client
-func POST(url string, body interface{}) ([]byte, error) {
-	return Default.POST(url, body)
+func POST(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return Default.POST(url, body, opts...)
 }
 
 // PUT makes a PUT request using the default client
-func PUT(url string, body interface{}) ([]byte, error) {
-	return Default.PUT(url, body)
+func PUT(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return Default.PUT(url, body, opts...)
 }
 
 // PATCH makes a PATCH request using the default client
-func PATCH(url string, body interface{}) ([]byte, error) {
-	return Default.PATCH(url, body)
+func PATCH(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return Default.PATCH(url, body, opts...)
 }
 
 // DELETE makes a DELETE request using the default client
-func DELETE(url string) ([]byte, error) {
-	return Default.DELETE(url)
+func DELETE(url string, opts ...RequestOption) ([]byte, error) {
+	return Default.DELETE(url, opts...)
 }
 
 // HEAD makes a HEAD request using the default client
-func HEAD(url string) error {
-	return Default.HEAD(url)
+func HEAD(url string, opts ...RequestOption) error {
+	return Default.HEAD(url, opts...)
 }
 
 // OPTIONS makes an OPTIONS request using the default client
-func OPTIONS(url string) ([]byte, error) {
-	return Default.OPTIONS(url)
+func OPTIONS(url string, opts ...RequestOption) ([]byte, error) {
+	return Default.OPTIONS(url, opts...)
 }
 
 // JSON makes a JSON request using the default client
-func JSON(method, url string, body, result interface{}) error {
-	return Default.JSON(method, url, body, result)
+func JSON(method, url string, body, result interface{}, opts ...RequestOption) error {
+	return Default.JSON(method, url, body, result, opts...)
 }
 
 // Advanced package-level functions
@@ -304,30 +697,35 @@ func Pipeline() PipelineRequest {
 	return Default.Pipeline()
 }
 
-func Stream(method, url string, body interface{}) (<-chan []byte, error) {
-	return Default.Stream(method, url, body)
+func Stream(method, url string, body interface{}, opts ...StreamOption) (<-chan []byte, error) {
+	return Default.Stream(method, url, body, opts...)
 }
 
 func GraphQL(query string, variables map[string]interface{}, result interface{}) error {
 	return Default.GraphQL(query, variables, result)
 }
 
-func WebSocket(url string) (WebSocketConn, error) {
-	return Default.WebSocket(url)
+func GraphQLOp(ctx context.Context, opName, query string, variables map[string]interface{}, result interface{}) error {
+	return Default.GraphQLOp(ctx, opName, query, variables, result)
+}
+
+func WebSocket(url string, opts ...WebSocketOption) (WebSocketConn, error) {
+	return Default.WebSocket(url, opts...)
 }
+
 // Context-aware package-level functions
 
 // GetContext makes a GET request with context using the default client
-func GetContext(ctx context.Context, url string) ([]byte, error) {
-	return Default.GetContext(ctx, url)
+func GetContext(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return Default.GetContext(ctx, url, opts...)
 }
 
 // PostContext makes a POST request with context using the default client
-func PostContext(ctx context.Context, url string, body interface{}) ([]byte, error) {
-	return Default.PostContext(ctx, url, body)
+func PostContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return Default.PostContext(ctx, url, body, opts...)
 }
 
 // JSONContext makes a JSON request with context using the default client
-func JSONContext(ctx context.Context, method, url string, body, result interface{}) error {
-	return Default.JSONContext(ctx, method, url, body, result)
-}
\ No newline at end of file
+func JSONContext(ctx context.Context, method, url string, body, result interface{}, opts ...RequestOption) error {
+	return Default.JSONContext(ctx, method, url, body, result, opts...)
+}
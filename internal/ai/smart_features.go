@@ -1,12 +1,15 @@
 package ai
 
 import (
-	"context"
-	"fmt"
+	"container/list"
 	"math"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/yourorg/httpclient/internal/clock"
 )
 
 // SmartRetry uses AI to determine optimal retry strategies
@@ -14,10 +17,12 @@ type SmartRetry struct {
 	history    []RetryAttempt
 	mu         sync.RWMutex
 	model      *RetryModel
+	clock      clock.Clock
 }
 
 type RetryAttempt struct {
 	URL        string
+	Host       string
 	Method     string
 	StatusCode int
 	Duration   time.Duration
@@ -37,19 +42,33 @@ func NewSmartRetry() *SmartRetry {
 			weights: map[string]float64{
 				"status_code": -0.1,
 				"duration":    -0.05,
-				"hour":        0.02,
+				// 0.02 per hour of the day, now that the hour is scaled
+				// to [0, 1)
+				"hour":        0.48,
 				"method":      0.1,
 			},
 			bias: 0.5,
 		},
+		clock: clock.Real,
 	}
 }
 
+// historyWeight scales how much the recent success rate of a host moves
+// the predicted success of retrying a request to it
+const historyWeight = 4.0
+
+// historySize is how many recent attempts to a host its success rate is
+// taken over
+const historySize = 20
+
 func (sr *SmartRetry) ShouldRetry(req *http.Request, resp *http.Response, attempt int) bool {
 	if attempt >= 5 {
 		return false
 	}
 
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
 	// Use AI model to predict success probability
 	probability := sr.predictSuccessProbability(req, resp)
 	
@@ -60,9 +79,13 @@ func (sr *SmartRetry) ShouldRetry(req *http.Request, resp *http.Response, attemp
 }
 
 func (sr *SmartRetry) predictSuccessProbability(req *http.Request, resp *http.Response) float64 {
-	features := sr.extractFeatures(req, resp)
+	var status int
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	features := extractFeatures(req.Method, status, 0, sr.clock.Now())
 	
-	score := sr.model.bias
+	score := sr.model.bias + historyWeight*(sr.successRate(req.URL.Host)-0.5)
 	for feature, value := range features {
 		if weight, exists := sr.model.weights[feature]; exists {
 			score += weight * value
@@ -73,16 +96,33 @@ func (sr *SmartRetry) predictSuccessProbability(req *http.Request, resp *http.Re
 	return 1.0 / (1.0 + math.Exp(-score))
 }
 
-func (sr *SmartRetry) extractFeatures(req *http.Request, resp *http.Response) map[string]float64 {
-	features := make(map[string]float64)
-	
-	if resp != nil {
-		features["status_code"] = float64(resp.StatusCode)
+// successRate is the share of the recent attempts to host that succeeded,
+// starting from one success and one failure so that a host without
+// history is neutral. The caller holds sr.mu.
+func (sr *SmartRetry) successRate(host string) float64 {
+	successes, attempts := 1, 2
+	for i := len(sr.history) - 1; i >= 0 && attempts-2 < historySize; i-- {
+		if sr.history[i].Host != host {
+			continue
+		}
+		attempts++
+		if sr.history[i].Success {
+			successes++
+		}
 	}
+	return float64(successes) / float64(attempts)
+}
+
+// extractFeatures scales the features of an attempt to similar ranges, so
+// that no single one dominates the model or its updates
+func extractFeatures(method string, status int, duration time.Duration, at time.Time) map[string]float64 {
+	features := make(map[string]float64)
 	
-	features["hour"] = float64(time.Now().Hour())
+	features["status_code"] = float64(status) / 100
+	features["duration"] = duration.Seconds()
+	features["hour"] = float64(at.Hour()) / 24
 	
-	switch req.Method {
+	switch method {
 	case "GET":
 		features["method"] = 1.0
 	case "POST":
@@ -102,10 +142,11 @@ func (sr *SmartRetry) RecordAttempt(req *http.Request, resp *http.Response, dura
 	
 	attempt := RetryAttempt{
 		URL:       req.URL.String(),
+		Host:      req.URL.Host,
 		Method:    req.Method,
 		Duration:  duration,
 		Success:   success,
-		Timestamp: time.Now(),
+		Timestamp: sr.clock.Now(),
 	}
 	
 	if resp != nil {
@@ -130,11 +171,7 @@ func (sr *SmartRetry) updateModel() {
 	learningRate := 0.01
 	
 	for _, attempt := range sr.history[len(sr.history)-10:] {
-		features := map[string]float64{
-			"status_code": float64(attempt.StatusCode),
-			"duration":    attempt.Duration.Seconds(),
-			"hour":        float64(attempt.Timestamp.Hour()),
-		}
+		features := extractFeatures(attempt.Method, attempt.StatusCode, attempt.Duration, attempt.Timestamp)
 		
 		predicted := sr.model.bias
 		for feature, value := range features {
@@ -160,10 +197,16 @@ func (sr *SmartRetry) updateModel() {
 	}
 }
 
+// MaxAccessPatterns is how many URLs a SmartCache keeps the access
+// history of at most. Beyond it the URL accessed least recently is dropped.
+const MaxAccessPatterns = 1000
+
 // SmartCache uses AI to optimize caching decisions
 type SmartCache struct {
-	accessPatterns map[string]*AccessPattern
+	accessPatterns map[string]*list.Element
+	lru            *list.List // of *AccessPattern, most recently accessed first
 	mu             sync.RWMutex
+	clock          clock.Clock
 }
 
 type AccessPattern struct {
@@ -176,36 +219,52 @@ type AccessPattern struct {
 
 func NewSmartCache() *SmartCache {
 	return &SmartCache{
-		accessPatterns: make(map[string]*AccessPattern),
+		accessPatterns: make(map[string]*list.Element),
+		lru:            list.New(),
+		clock:          clock.Real,
 	}
 }
 
+// ShouldCache reports whether a response of size bytes for url is worth
+// caching: small ones always are, larger ones only for URLs accessed often
+// and recently
 func (sc *SmartCache) ShouldCache(url string, size int64) bool {
+	if size < 1024*1024 { // 1MB limit for any item
+		return true
+	}
+
 	sc.mu.RLock()
-	pattern, exists := sc.accessPatterns[url]
-	sc.mu.RUnlock()
-	
+	defer sc.mu.RUnlock()
+
+	elem, exists := sc.accessPatterns[url]
 	if !exists {
-		// Cache new URLs by default
-		return size < 1024*1024 // 1MB limit for new items
+		return false
 	}
+	pattern := elem.Value.(*AccessPattern)
 	
 	// Use access frequency and recency to decide
 	frequency := sc.calculateFrequency(pattern)
-	recency := time.Since(pattern.LastAccess).Hours()
+	recency := sc.clock.Now().Sub(pattern.LastAccess).Hours()
 	
 	score := frequency * math.Exp(-recency/24) // Decay over 24 hours
 	
 	return score > 0.1 && size < 10*1024*1024 // 10MB limit for frequent items
 }
 
+// Len returns how many URLs the access history is kept for
+func (sc *SmartCache) Len() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.lru.Len()
+}
+
 func (sc *SmartCache) calculateFrequency(pattern *AccessPattern) float64 {
 	if len(pattern.AccessTimes) < 2 {
 		return 0.1
 	}
 	
 	// Calculate access frequency over the last 24 hours
-	now := time.Now()
+	now := sc.clock.Now()
 	recentAccesses := 0
 	
 	for _, accessTime := range pattern.AccessTimes {
@@ -221,16 +280,23 @@ func (sc *SmartCache) RecordAccess(url string) {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 	
-	pattern, exists := sc.accessPatterns[url]
-	if !exists {
+	var pattern *AccessPattern
+	if elem, exists := sc.accessPatterns[url]; exists {
+		sc.lru.MoveToFront(elem)
+		pattern = elem.Value.(*AccessPattern)
+	} else {
+		if sc.lru.Len() >= MaxAccessPatterns {
+			oldest := sc.lru.Remove(sc.lru.Back()).(*AccessPattern)
+			delete(sc.accessPatterns, oldest.URL)
+		}
 		pattern = &AccessPattern{
 			URL:         url,
 			AccessTimes: make([]time.Time, 0),
 		}
-		sc.accessPatterns[url] = pattern
+		sc.accessPatterns[url] = sc.lru.PushFront(pattern)
 	}
 	
-	now := time.Now()
+	now := sc.clock.Now()
 	pattern.AccessTimes = append(pattern.AccessTimes, now)
 	pattern.LastAccess = now
 	
@@ -245,7 +311,7 @@ func (sc *SmartCache) RecordAccess(url string) {
 
 func (sc *SmartCache) predictNextAccess(pattern *AccessPattern) time.Time {
 	if len(pattern.AccessTimes) < 3 {
-		return time.Now().Add(time.Hour)
+		return sc.clock.Now().Add(time.Hour)
 	}
 	
 	// Calculate average interval between accesses
@@ -270,14 +336,20 @@ func (sc *SmartCache) predictNextAccess(pattern *AccessPattern) time.Time {
 	return pattern.LastAccess.Add(avgInterval)
 }
 
+// MaxEndpoints is how many endpoints an AdaptiveTimeout keeps statistics
+// for at most. Beyond it the endpoint recorded least recently is dropped.
+const MaxEndpoints = 1000
+
 // AdaptiveTimeout adjusts timeouts based on historical performance
 type AdaptiveTimeout struct {
-	endpointStats map[string]*EndpointStats
+	endpointStats map[string]*list.Element
+	lru           *list.List // of *EndpointStats, most recently recorded first
 	mu            sync.RWMutex
+	clock         clock.Clock
 }
 
 type EndpointStats struct {
-	URL              string
+	Endpoint         string // see EndpointKey
 	ResponseTimes    []time.Duration
 	SuccessRate      float64
 	RecommendedTimeout time.Duration
@@ -286,38 +358,114 @@ type EndpointStats struct {
 
 func NewAdaptiveTimeout() *AdaptiveTimeout {
 	return &AdaptiveTimeout{
-		endpointStats: make(map[string]*EndpointStats),
+		endpointStats: make(map[string]*list.Element),
+		lru:           list.New(),
+		clock:         clock.Real,
+	}
+}
+
+// EndpointKey identifies the endpoint of u for its statistics: the host
+// and route, the URL template the request was built from, or without one
+// the path with numeric and UUID segments replaced by {id}. Requests for
+// /users/1 and /users/2 share the key "host/users/{id}".
+func EndpointKey(u *url.URL, route string) string {
+	if route != "" {
+		return u.Host + route
+	}
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		if isID(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return u.Host + strings.Join(segments, "/")
+}
+
+// isID reports whether a path segment is a number or a UUID
+func isID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if strings.Trim(segment, "0123456789") == "" {
+		return true
+	}
+	if len(segment) != 36 {
+		return false
+	}
+	for i, r := range segment {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if r != '-' {
+				return false
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", r):
+			return false
+		}
 	}
+	return true
 }
 
-func (at *AdaptiveTimeout) GetTimeout(url string, defaultTimeout time.Duration) time.Duration {
+// GetTimeout returns the timeout for endpoint, a key from EndpointKey, or
+// defaultTimeout until it has answered often and recently enough. A
+// defaultTimeout other than 0 is never exceeded.
+func (at *AdaptiveTimeout) GetTimeout(endpoint string, defaultTimeout time.Duration) time.Duration {
 	at.mu.RLock()
-	stats, exists := at.endpointStats[url]
-	at.mu.RUnlock()
+	defer at.mu.RUnlock()
 	
-	if !exists || time.Since(stats.LastUpdate) > time.Hour {
+	elem, exists := at.endpointStats[endpoint]
+	if !exists {
 		return defaultTimeout
 	}
+	return at.timeout(elem.Value.(*EndpointStats), defaultTimeout)
+}
+
+// Timeouts returns the timeout of every endpoint with statistics
+func (at *AdaptiveTimeout) Timeouts(defaultTimeout time.Duration) map[string]time.Duration {
+	at.mu.RLock()
+	defer at.mu.RUnlock()
 	
+	timeouts := make(map[string]time.Duration, len(at.endpointStats))
+	for endpoint, elem := range at.endpointStats {
+		timeouts[endpoint] = at.timeout(elem.Value.(*EndpointStats), defaultTimeout)
+	}
+	return timeouts
+}
+
+// timeout is GetTimeout for stats. The caller holds at.mu.
+func (at *AdaptiveTimeout) timeout(stats *EndpointStats, defaultTimeout time.Duration) time.Duration {
+	// Too few responses say little about the endpoint
+	if len(stats.ResponseTimes) < 5 || at.clock.Now().Sub(stats.LastUpdate) > time.Hour {
+		return defaultTimeout
+	}
+	if defaultTimeout > 0 && stats.RecommendedTimeout > defaultTimeout {
+		return defaultTimeout
+	}
 	return stats.RecommendedTimeout
 }
 
-func (at *AdaptiveTimeout) RecordResponse(url string, duration time.Duration, success bool) {
+func (at *AdaptiveTimeout) RecordResponse(endpoint string, duration time.Duration, success bool) {
 	at.mu.Lock()
 	defer at.mu.Unlock()
 	
-	stats, exists := at.endpointStats[url]
-	if !exists {
+	var stats *EndpointStats
+	if elem, exists := at.endpointStats[endpoint]; exists {
+		at.lru.MoveToFront(elem)
+		stats = elem.Value.(*EndpointStats)
+	} else {
+		if at.lru.Len() >= MaxEndpoints {
+			oldest := at.lru.Remove(at.lru.Back()).(*EndpointStats)
+			delete(at.endpointStats, oldest.Endpoint)
+		}
 		stats = &EndpointStats{
-			URL:           url,
+			Endpoint:      endpoint,
 			ResponseTimes: make([]time.Duration, 0),
 			SuccessRate:   1.0,
 		}
-		at.endpointStats[url] = stats
+		at.endpointStats[endpoint] = at.lru.PushFront(stats)
 	}
 	
 	stats.ResponseTimes = append(stats.ResponseTimes, duration)
-	stats.LastUpdate = time.Now()
+	stats.LastUpdate = at.clock.Now()
 	
 	// Keep only recent response times
 	if len(stats.ResponseTimes) > 100 {
@@ -468,21 +616,45 @@ type AIManager struct {
 	adaptiveTimeout     *AdaptiveTimeout
 	predictivePreloader *PredictivePreloader
 	enabled             bool
+	features            Features
+}
+
+// Features selects the AI features whose history RecordRequest keeps
+type Features struct {
+	Retry           bool
+	Caching         bool
+	AdaptiveTimeout bool
 }
 
+// NewAIManager creates a manager with every feature enabled
 func NewAIManager() *AIManager {
 	return &AIManager{
 		smartRetry:      NewSmartRetry(),
 		smartCache:      NewSmartCache(),
 		adaptiveTimeout: NewAdaptiveTimeout(),
 		enabled:         true,
+		features:        Features{Retry: true, Caching: true, AdaptiveTimeout: true},
 	}
 }
 
+// SetFeatures limits the history RecordRequest keeps to the features
+// enabled in f; a disabled feature keeps making its decisions without it
+func (ai *AIManager) SetFeatures(f Features) {
+	ai.features = f
+}
+
 func (ai *AIManager) SetPreloadFunction(fn func(url string)) {
 	ai.predictivePreloader = NewPredictivePreloader(fn)
 }
 
+// SetClock replaces the time source used by all AI components
+func (ai *AIManager) SetClock(clk clock.Clock) {
+	clk = clock.OrReal(clk)
+	ai.smartRetry.clock = clk
+	ai.smartCache.clock = clk
+	ai.adaptiveTimeout.clock = clk
+}
+
 func (ai *AIManager) ShouldRetry(req *http.Request, resp *http.Response, attempt int) bool {
 	if !ai.enabled {
 		return attempt < 3 // Fallback to simple retry
@@ -497,26 +669,41 @@ func (ai *AIManager) ShouldCache(url string, size int64) bool {
 	return ai.smartCache.ShouldCache(url, size)
 }
 
-func (ai *AIManager) GetAdaptiveTimeout(url string, defaultTimeout time.Duration) time.Duration {
+// GetAdaptiveTimeout returns the timeout for endpoint, a key from
+// EndpointKey
+func (ai *AIManager) GetAdaptiveTimeout(endpoint string, defaultTimeout time.Duration) time.Duration {
 	if !ai.enabled {
 		return defaultTimeout
 	}
-	return ai.adaptiveTimeout.GetTimeout(url, defaultTimeout)
+	return ai.adaptiveTimeout.GetTimeout(endpoint, defaultTimeout)
+}
+
+// AdaptiveTimeouts returns the timeout of every endpoint with statistics
+func (ai *AIManager) AdaptiveTimeouts(defaultTimeout time.Duration) map[string]time.Duration {
+	return ai.adaptiveTimeout.Timeouts(defaultTimeout)
 }
 
-func (ai *AIManager) RecordRequest(req *http.Request, resp *http.Response, duration time.Duration, success bool) {
+// RecordRequest records the outcome of req, whose endpoint is a key from
+// EndpointKey
+func (ai *AIManager) RecordRequest(req *http.Request, endpoint string, resp *http.Response, duration time.Duration, success bool) {
 	if !ai.enabled {
 		return
 	}
 	
-	url := req.URL.String()
+	rawURL := req.URL.String()
 	
-	ai.smartRetry.RecordAttempt(req, resp, duration, success)
-	ai.smartCache.RecordAccess(url)
-	ai.adaptiveTimeout.RecordResponse(url, duration, success)
+	if ai.features.Retry {
+		ai.smartRetry.RecordAttempt(req, resp, duration, success)
+	}
+	if ai.features.Caching {
+		ai.smartCache.RecordAccess(rawURL)
+	}
+	if ai.features.AdaptiveTimeout {
+		ai.adaptiveTimeout.RecordResponse(endpoint, duration, success)
+	}
 	
 	if ai.predictivePreloader != nil {
-		ai.predictivePreloader.RecordRequest(url)
+		ai.predictivePreloader.RecordRequest(rawURL)
 	}
 }
 
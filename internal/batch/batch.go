@@ -1,170 +1,576 @@
 package batch
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// DoFunc performs a single request with header added to it and returns the
+// response status and body; the status is also set, when known, alongside
+// an error. The client passes its own request path so batch items get the
+// same retries, rate limiting, circuit breaker, auth and interceptors as
+// single requests.
+type DoFunc func(ctx context.Context, method, url string, body interface{}, header http.Header) (status int, data []byte, err error)
+
+// DefaultConcurrency is the number of requests of a batch in flight at once
+// unless WithConcurrency says otherwise
+const DefaultConcurrency = 10
+
 // BatchRequest represents a batch of HTTP requests
 type BatchRequest struct {
-	requests []BatchItem
-	client   *http.Client
-	mu       sync.Mutex
+	requests    []BatchItem
+	do          DoFunc
+	concurrency int
+	failFast    bool
+	onProgress  func(done, total int)
+	responses   BatchResponses // of the last ExecuteContext or RetryFailed
+	mu          sync.Mutex
 }
 
 type BatchItem struct {
-	Method string
-	URL    string
-	Body   interface{}
-	Index  int
+	Method  string
+	URL     string
+	Body    interface{}
+	Header  http.Header   // added to the request, overriding client headers
+	Timeout time.Duration // bounds the request including retries, 0 for none
+	Index   int
+
+	// build derives the request from the previous pipeline step's response
+	build func(prev []byte) (method, url string, body interface{})
+	// buildFunc derives the request from all earlier pipeline responses
+	buildFunc StepFunc
+}
+
+// StepFunc computes a pipeline step from the responses of the steps before
+// it, in order. An error fails the step without sending a request.
+type StepFunc func(ctx context.Context, prev []PipelineResponse) (method, url string, body interface{}, err error)
+
+// ItemOption customizes a single request of a batch or pipeline
+type ItemOption func(*BatchItem)
+
+// ItemHeader sets a header on the request, e.g. a different Accept per
+// resource
+func ItemHeader(key, value string) ItemOption {
+	return func(item *BatchItem) {
+		if item.Header == nil {
+			item.Header = make(http.Header)
+		}
+		item.Header.Set(key, value)
+	}
 }
 
+// ItemTimeout bounds the request, retries included
+func ItemTimeout(d time.Duration) ItemOption {
+	return func(item *BatchItem) {
+		item.Timeout = d
+	}
+}
+
+func newItem(method, url string, body interface{}, index int, opts []ItemOption) BatchItem {
+	item := BatchItem{
+		Method: method,
+		URL:    url,
+		Body:   body,
+		Index:  index,
+	}
+	for _, opt := range opts {
+		opt(&item)
+	}
+	return item
+}
+
+// run performs the request of item, or of method, url and body for a
+// dependent step, within the item's timeout
+func (item BatchItem) run(ctx context.Context, do DoFunc, method, url string, body interface{}) (int, []byte, error) {
+	if item.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, item.Timeout)
+		defer cancel()
+	}
+	return do(ctx, method, url, body, item.Header)
+}
+
+// ErrStepSkipped is the error of a dependent pipeline step that didn't run
+// because the step before it failed
+var ErrStepSkipped = errors.New("pipeline step skipped")
+
+// ErrNotStarted is the error of a batch request that wasn't sent because
+// the batch was canceled first, by its context or FailFast
+var ErrNotStarted = errors.New("batch request not started")
+
 type BatchResponse struct {
-	Index    int
-	Data     []byte
-	Error    error
-	Duration time.Duration
+	Index      int
+	StatusCode int // 0 when no response was received
+	Data       []byte
+	Error      error
+	Duration   time.Duration
+}
+
+// BatchResponses are the responses of a batch in the order the requests
+// were added
+type BatchResponses []BatchResponse
+
+// AllSucceeded reports whether no request in the batch failed
+func (r BatchResponses) AllSucceeded() bool {
+	for _, resp := range r {
+		if resp.Error != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeJSON decodes the body of response i into v, or returns the error of
+// that request when it failed
+func (r BatchResponses) DecodeJSON(i int, v interface{}) error {
+	if i < 0 || i >= len(r) {
+		return fmt.Errorf("batch response %d out of range [0, %d)", i, len(r))
+	}
+	if r[i].Error != nil {
+		return r[i].Error
+	}
+	if err := json.Unmarshal(r[i].Data, v); err != nil {
+		return fmt.Errorf("decode batch response %d: %w", i, err)
+	}
+	return nil
+}
+
+// BatchError is returned alongside the responses when any request in a
+// batch failed. It wraps the error of every failed request, so errors.Is
+// and errors.As look through it.
+type BatchError struct {
+	Failed int
+	Total  int
+	errs   []error
+}
+
+func (e *BatchError) Error() string {
+	if len(e.errs) == 0 {
+		return fmt.Sprintf("%d of %d batch requests failed", e.Failed, e.Total)
+	}
+	return fmt.Sprintf("%d of %d batch requests failed, first: %v", e.Failed, e.Total, e.errs[0])
+}
+
+func (e *BatchError) Unwrap() []error {
+	return e.errs
 }
 
+// NewBatchRequest creates a batch that sends its requests with a plain
+// http.Client
 func NewBatchRequest(client *http.Client) *BatchRequest {
+	return NewBatchRequestFunc(httpDo(client))
+}
+
+// NewBatchRequestFunc creates a batch that sends each request with do
+func NewBatchRequestFunc(do DoFunc) *BatchRequest {
 	return &BatchRequest{
-		requests: make([]BatchItem, 0),
-		client:   client,
+		requests:    make([]BatchItem, 0),
+		do:          do,
+		concurrency: DefaultConcurrency,
 	}
 }
 
-func (br *BatchRequest) Add(method, url string, body interface{}) *BatchRequest {
+// WithConcurrency limits the batch to n requests in flight at once; n <= 0
+// restores DefaultConcurrency
+func (br *BatchRequest) WithConcurrency(n int) *BatchRequest {
 	br.mu.Lock()
 	defer br.mu.Unlock()
-	
-	br.requests = append(br.requests, BatchItem{
-		Method: method,
-		URL:    url,
-		Body:   body,
-		Index:  len(br.requests),
-	})
-	
+
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+	br.concurrency = n
+	return br
+}
+
+// FailFast cancels the rest of the batch as soon as a request fails:
+// requests in flight see their context canceled and the ones not yet sent
+// fail with ErrNotStarted. The responses of completed requests are kept.
+func (br *BatchRequest) FailFast() *BatchRequest {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.failFast = true
+	return br
+}
+
+// Add queues a request; opts such as ItemHeader and ItemTimeout apply to
+// this request only
+func (br *BatchRequest) Add(method, url string, body interface{}, opts ...ItemOption) *BatchRequest {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.requests = append(br.requests, newItem(method, url, body, len(br.requests), opts))
 	return br
 }
 
-func (br *BatchRequest) Execute() ([]BatchResponse, error) {
+// OnProgress calls fn each time a request of the batch completes, with the
+// number completed so far and the batch size. Calls come one at a time
+// with done increasing, and the last has done == total.
+func (br *BatchRequest) OnProgress(fn func(done, total int)) *BatchRequest {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.onProgress = fn
+	return br
+}
+
+func (br *BatchRequest) Execute() (BatchResponses, error) {
 	return br.ExecuteContext(context.Background())
 }
 
-func (br *BatchRequest) ExecuteContext(ctx context.Context) ([]BatchResponse, error) {
+// ExecuteContext runs the requests concurrently, at most the batch's
+// concurrency at a time, and returns a response for each. When any of them
+// failed a *BatchError is returned as well; the responses still hold the
+// data of the ones that succeeded. Once ctx is done no further requests
+// are started.
+func (br *BatchRequest) ExecuteContext(ctx context.Context) (BatchResponses, error) {
+	results, total := br.start(ctx)
+	responses := make(BatchResponses, total)
+	for resp := range results {
+		responses[resp.Index] = resp
+	}
+
+	br.mu.Lock()
+	br.responses = append(BatchResponses(nil), responses...)
+	br.mu.Unlock()
+	return responses, batchError(responses)
+}
+
+// batchError returns a *BatchError for the failed responses, or nil
+func batchError(responses BatchResponses) error {
+	var errs []error
+	for _, resp := range responses {
+		if resp.Error != nil {
+			errs = append(errs, fmt.Errorf("request %d: %w", resp.Index, resp.Error))
+		}
+	}
+	if len(errs) > 0 {
+		return &BatchError{Failed: len(errs), Total: len(responses), errs: errs}
+	}
+	return nil
+}
+
+// RetryFailedOption customizes RetryFailed
+type RetryFailedOption func(*retryFailedOptions)
+
+type retryFailedOptions struct {
+	maxRounds int
+}
+
+// MaxRounds lets RetryFailed re-run the failed requests up to n times,
+// stopping early once none is left to retry; the default is one round
+func MaxRounds(n int) RetryFailedOption {
+	return func(o *retryFailedOptions) {
+		o.maxRounds = n
+	}
+}
+
+// Retryable reports whether a failed request is worth sending again: it
+// got no response, e.g. a network error or it was never started, or the
+// server answered with a 5xx status
+func (r BatchResponse) Retryable() bool {
+	return r.Error != nil && (r.StatusCode == 0 || r.StatusCode >= 500)
+}
+
+// Failed returns the requests whose response in the last ExecuteContext or
+// RetryFailed call is retryable, in the order they were added
+func (br *BatchRequest) Failed() []BatchItem {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	var failed []BatchItem
+	for _, resp := range br.responses {
+		if resp.Retryable() {
+			failed = append(failed, br.requests[resp.Index])
+		}
+	}
+	return failed
+}
+
+// ErrNotExecuted is returned by RetryFailed when the batch hasn't been
+// executed yet
+var ErrNotExecuted = errors.New("batch not executed")
+
+// RetryFailed sends the requests returned by Failed again, with the
+// batch's concurrency and FailFast setting, and merges their new responses
+// into those of the last execution at the same indices. The merged
+// responses are returned along with a *BatchError when some request still
+// failed. OnProgress counts each round separately.
+func (br *BatchRequest) RetryFailed(ctx context.Context, opts ...RetryFailedOption) (BatchResponses, error) {
+	o := retryFailedOptions{maxRounds: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	br.mu.Lock()
+	if br.responses == nil {
+		br.mu.Unlock()
+		return nil, ErrNotExecuted
+	}
+	responses := append(BatchResponses(nil), br.responses...)
+	concurrency, failFast, onProgress := br.concurrency, br.failFast, br.onProgress
+	br.mu.Unlock()
+
+	for round := 0; round < o.maxRounds && ctx.Err() == nil; round++ {
+		failed := br.Failed()
+		if len(failed) == 0 {
+			break
+		}
+
+		out := make(chan BatchResponse, len(failed))
+		go br.run(ctx, failed, concurrency, failFast, onProgress, out)
+		for resp := range out {
+			responses[resp.Index] = resp
+		}
+
+		br.mu.Lock()
+		br.responses = append(BatchResponses(nil), responses...)
+		br.mu.Unlock()
+	}
+	return responses, batchError(responses)
+}
+
+// ExecuteStream runs the requests like ExecuteContext but sends each
+// response on the channel as soon as its request completes, in completion
+// order; Index tells which request it belongs to. Requests that were never
+// started come last, failing with ErrNotStarted. The channel is closed
+// once every request has a response.
+func (br *BatchRequest) ExecuteStream(ctx context.Context) (<-chan BatchResponse, error) {
+	results, _ := br.start(ctx)
+	return results, nil
+}
+
+// start runs the requests queued so far in the background and returns the
+// channel their responses arrive on and how many there will be
+func (br *BatchRequest) start(ctx context.Context) (<-chan BatchResponse, int) {
 	br.mu.Lock()
 	requests := make([]BatchItem, len(br.requests))
 	copy(requests, br.requests)
+	concurrency, failFast, onProgress := br.concurrency, br.failFast, br.onProgress
 	br.mu.Unlock()
 
-	responses := make([]BatchResponse, len(requests))
+	out := make(chan BatchResponse, len(requests))
+	go br.run(ctx, requests, concurrency, failFast, onProgress, out)
+	return out, len(requests)
+}
+
+func (br *BatchRequest) run(ctx context.Context, requests []BatchItem, concurrency int, failFast bool, onProgress func(done, total int), out chan<- BatchResponse) {
+	defer close(out)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first failure cancels the batch under FailFast
+	var stopOnce sync.Once
+	var stopErr error
+
+	started := make([]bool, len(requests))
+	jobs := make(chan int)
+	results := make(chan BatchResponse)
 	var wg sync.WaitGroup
-	
-	// Execute all requests concurrently
-	for i, req := range requests {
+
+	workers := min(concurrency, len(requests))
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func(index int, item BatchItem) {
+		go func() {
 			defer wg.Done()
-			
-			start := time.Now()
-			data, err := br.executeRequest(ctx, item)
-			duration := time.Since(start)
-			
-			responses[index] = BatchResponse{
-				Index:    item.Index,
-				Data:     data,
-				Error:    err,
-				Duration: duration,
+			for index := range jobs {
+				item := requests[index]
+
+				start := time.Now()
+				status, data, err := item.run(ctx, br.do, item.Method, item.URL, item.Body)
+				duration := time.Since(start)
+
+				if err != nil && failFast {
+					stopOnce.Do(func() {
+						stopErr = fmt.Errorf("request %d failed: %w", item.Index, err)
+						cancel()
+					})
+				}
+				results <- BatchResponse{
+					Index:      item.Index,
+					StatusCode: status,
+					Data:       data,
+					Error:      err,
+					Duration:   duration,
+				}
 			}
-		}(i, req)
+		}()
 	}
-	
-	wg.Wait()
-	return responses, nil
-}
 
-func (br *BatchRequest) executeRequest(ctx context.Context, item BatchItem) ([]byte, error) {
-	var reqBody []byte
-	var err error
-	
-	if item.Body != nil {
-		reqBody, err = json.Marshal(item.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal body: %w", err)
+	// Hand out the requests until they run out or the batch is canceled
+	go func() {
+		defer close(results)
+	dispatch:
+		for index := range requests {
+			// Prefer stopping over starting when both are possible
+			if ctx.Err() != nil {
+				break
+			}
+			select {
+			case jobs <- index:
+				started[index] = true
+			case <-ctx.Done():
+				break dispatch
+			}
 		}
+		close(jobs)
+		wg.Wait()
+	}()
+
+	// Progress is reported from here only, so calls never overlap
+	done := 0
+	emit := func(resp BatchResponse) {
+		done++
+		if onProgress != nil {
+			onProgress(done, len(requests))
+		}
+		out <- resp
 	}
-	
-	req, err := http.NewRequestWithContext(ctx, item.Method, item.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
+	for resp := range results {
+		emit(resp)
 	}
-	
-	resp, err := br.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+
+	cause := stopErr
+	if cause == nil {
+		cause = ctx.Err()
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	for index, item := range requests {
+		if !started[index] {
+			emit(BatchResponse{
+				Index: item.Index,
+				Error: fmt.Errorf("%w: %w", ErrNotStarted, cause),
+			})
+		}
 	}
-	
-	// Read response body
-	data := make([]byte, 0)
-	buffer := make([]byte, 4096)
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			data = append(data, buffer[:n]...)
+}
+
+// httpDo sends requests with a plain http.Client, encoding bodies as JSON
+func httpDo(client *http.Client) DoFunc {
+	return func(ctx context.Context, method, url string, body interface{}, header http.Header) (int, []byte, error) {
+		var reqBody io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			if err != nil {
+				return 0, nil, fmt.Errorf("failed to marshal body: %w", err)
+			}
+			reqBody = bytes.NewReader(data)
 		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 		if err != nil {
-			break
+			return 0, nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		if reqBody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, nil, fmt.Errorf("request failed: %w", err)
 		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			return resp.StatusCode, nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return resp.StatusCode, nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return resp.StatusCode, data, nil
 	}
-	
-	return data, nil
 }
 
 // PipelineRequest represents a pipeline of HTTP requests
 type PipelineRequest struct {
-	requests []BatchItem
-	client   *http.Client
-	mu       sync.Mutex
+	requests    []BatchItem
+	do          DoFunc
+	stopOnError bool
+	mu          sync.Mutex
 }
 
 type PipelineResponse struct {
-	Index    int
-	Data     []byte
-	Error    error
-	Duration time.Duration
+	Index      int
+	StatusCode int // 0 when no response was received
+	Data       []byte
+	Error      error
+	Duration   time.Duration
 }
 
+// NewPipelineRequest creates a pipeline that sends its requests with a
+// plain http.Client
 func NewPipelineRequest(client *http.Client) *PipelineRequest {
+	return NewPipelineRequestFunc(httpDo(client))
+}
+
+// NewPipelineRequestFunc creates a pipeline that sends each request with do
+func NewPipelineRequestFunc(do DoFunc) *PipelineRequest {
 	return &PipelineRequest{
 		requests: make([]BatchItem, 0),
-		client:   client,
+		do:       do,
 	}
 }
 
-func (pr *PipelineRequest) Add(method, url string, body interface{}) *PipelineRequest {
+// Add queues a step; opts such as ItemHeader and ItemTimeout apply to this
+// step only
+func (pr *PipelineRequest) Add(method, url string, body interface{}, opts ...ItemOption) *PipelineRequest {
 	pr.mu.Lock()
 	defer pr.mu.Unlock()
-	
-	pr.requests = append(pr.requests, BatchItem{
-		Method: method,
-		URL:    url,
-		Body:   body,
-		Index:  len(pr.requests),
-	})
-	
+
+	pr.requests = append(pr.requests, newItem(method, url, body, len(pr.requests), opts))
+	return pr
+}
+
+// AddDependent adds a step built from the response body of the step before
+// it, e.g. to fetch a resource by an ID the previous step returned. The
+// first step gets a nil body. When the previous step fails this one is
+// skipped with ErrStepSkipped.
+func (pr *PipelineRequest) AddDependent(build func(prev []byte) (method, url string, body interface{}), opts ...ItemOption) *PipelineRequest {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	item := newItem("", "", nil, len(pr.requests), opts)
+	item.build = build
+	pr.requests = append(pr.requests, item)
+
+	return pr
+}
+
+// AddFunc adds a step computed by fn from the responses of all the steps
+// before it, failed ones included, e.g. to fetch a resource by the ID a
+// create step returned
+func (pr *PipelineRequest) AddFunc(fn StepFunc, opts ...ItemOption) *PipelineRequest {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	item := newItem("", "", nil, len(pr.requests), opts)
+	item.buildFunc = fn
+	pr.requests = append(pr.requests, item)
+	return pr
+}
+
+// StopOnError ends the pipeline at the first failed step: its response is
+// the last one sent before the channel is closed. By default the remaining
+// steps still run.
+func (pr *PipelineRequest) StopOnError() *PipelineRequest {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.stopOnError = true
 	return pr
 }
 
@@ -176,6 +582,7 @@ func (pr *PipelineRequest) ExecuteContext(ctx context.Context) (<-chan PipelineR
 	pr.mu.Lock()
 	requests := make([]BatchItem, len(pr.requests))
 	copy(requests, pr.requests)
+	stopOnError := pr.stopOnError
 	pr.mu.Unlock()
 
 	ch := make(chan PipelineResponse, len(requests))
@@ -184,71 +591,57 @@ func (pr *PipelineRequest) ExecuteContext(ctx context.Context) (<-chan PipelineR
 		defer close(ch)
 		
 		// Execute requests in sequence, streaming results
+		var prev PipelineResponse
+		done := make([]PipelineResponse, 0, len(requests))
 		for _, req := range requests {
+			// Don't start further steps once the context is done
+			if ctx.Err() != nil {
+				return
+			}
+
 			start := time.Now()
-			data, err := pr.executeRequest(ctx, req)
+			var status int
+			var data []byte
+			var err error
+			switch {
+			case req.build != nil && req.Index > 0 && prev.Error != nil:
+				err = fmt.Errorf("%w: step %d failed: %w", ErrStepSkipped, prev.Index, prev.Error)
+			case req.build != nil:
+				method, url, body := req.build(prev.Data)
+				status, data, err = req.run(ctx, pr.do, method, url, body)
+			case req.buildFunc != nil:
+				var method, url string
+				var body interface{}
+				method, url, body, err = req.buildFunc(ctx, done[:len(done):len(done)])
+				if err == nil {
+					status, data, err = req.run(ctx, pr.do, method, url, body)
+				}
+			default:
+				status, data, err = req.run(ctx, pr.do, req.Method, req.URL, req.Body)
+			}
 			duration := time.Since(start)
 			
 			response := PipelineResponse{
-				Index:    req.Index,
-				Data:     data,
-				Error:    err,
-				Duration: duration,
+				Index:      req.Index,
+				StatusCode: status,
+				Data:       data,
+				Error:      err,
+				Duration:   duration,
 			}
 			
+			prev = response
+			done = append(done, response)
+
 			select {
 			case ch <- response:
 			case <-ctx.Done():
 				return
 			}
+			if err != nil && stopOnError {
+				return
+			}
 		}
 	}()
 	
 	return ch, nil
 }
-
-func (pr *PipelineRequest) executeRequest(ctx context.Context, item BatchItem) ([]byte, error) {
-	var reqBody []byte
-	var err error
-	
-	if item.Body != nil {
-		reqBody, err = json.Marshal(item.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal body: %w", err)
-		}
-	}
-	
-	req, err := http.NewRequestWithContext(ctx, item.Method, item.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	
-	resp, err := pr.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
-	}
-	
-	// Read response body
-	data := make([]byte, 0)
-	buffer := make([]byte, 4096)
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			data = append(data, buffer[:n]...)
-		}
-		if err != nil {
-			break
-		}
-	}
-	
-	return data, nil
-}
\ No newline at end of file
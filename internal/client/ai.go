@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/ai"
+	"github.com/yourorg/httpclient/internal/middleware"
+	"github.com/yourorg/httpclient/internal/retry"
+)
+
+// MaxAdaptiveEndpoints is how many endpoints the adaptive timeouts keep
+// statistics for at most, dropping the one recorded least recently
+const MaxAdaptiveEndpoints = ai.MaxEndpoints
+
+// WithAIRetry lets the retry model veto retries: a failed attempt is only
+// retried when the model, which weighs the status, method and recent
+// success rate of the host, predicts the next one may succeed. It never
+// adds retries beyond WithRetries.
+func (c *client) WithAIRetry(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.AIRetryEnabled = enabled
+	return New(newConfig)
+}
+
+// WithSmartCaching keeps responses out of the cache that the access
+// history of their URL says aren't worth keeping, such as large bodies of
+// rarely requested URLs. It only has an effect with a cache.
+func (c *client) WithSmartCaching(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.SmartCachingEnabled = enabled
+	return New(newConfig)
+}
+
+// WithPredictivePreloading sets PredictivePreloadingEnabled. Requests
+// aren't preloaded yet.
+func (c *client) WithPredictivePreloading(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.PredictivePreloadingEnabled = enabled
+	return New(newConfig)
+}
+
+// WithAdaptiveTimeout bounds each attempt to an endpoint by a timeout
+// derived from its recent response times, between 5s and 5m, once it has
+// answered five times. Until then the attempt timeout applies, and it is
+// never exceeded. Endpoints are told apart by host and route, see
+// AdaptiveTimeouts.
+func (c *client) WithAdaptiveTimeout(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.AdaptiveTimeoutEnabled = enabled
+	return New(newConfig)
+}
+
+// AdaptiveTimeouts returns the attempt timeout of each endpoint the
+// adaptive timeouts keep statistics for, keyed by host and route, e.g.
+// "api.example.com/users/{id}". The route is the URL template of
+// WithPathParams, or else the path with numeric and UUID segments
+// replaced by {id}. At most MaxAdaptiveEndpoints are kept.
+func (c *client) AdaptiveTimeouts() map[string]time.Duration {
+	if !c.config.AdaptiveTimeoutEnabled || c.ai == nil {
+		return map[string]time.Duration{}
+	}
+	return c.ai.AdaptiveTimeouts(c.config.AttemptTimeout)
+}
+
+// attemptTimeout returns the timeout of an attempt of req, 0 for none
+func (c *client) attemptTimeout(req *http.Request) time.Duration {
+	timeout := c.config.AttemptTimeout
+	if c.config.AdaptiveTimeoutEnabled && c.ai != nil {
+		timeout = c.ai.GetAdaptiveTimeout(endpointKey(req), timeout)
+	}
+	return timeout
+}
+
+// endpointKey returns the key of the endpoint of req in the statistics of
+// the adaptive timeouts
+func endpointKey(req *http.Request) string {
+	return ai.EndpointKey(req.URL, middleware.Route(req))
+}
+
+// recordAttempt feeds the outcome of an attempt to the AI features. An
+// attempt its caller canceled says nothing about the server.
+func (c *client) recordAttempt(req *http.Request, r *response, err error, duration time.Duration) {
+	if c.ai == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	c.ai.RecordRequest(req, endpointKey(req), attemptResponse(r, err), duration, err == nil)
+}
+
+// attemptResponse returns a response with the status of an attempt, or
+// nil when it got none
+func attemptResponse(r *response, err error) *http.Response {
+	if r != nil {
+		return &http.Response{StatusCode: r.statusCode}
+	}
+	var apiErr *retry.APIError
+	if errors.As(err, &apiErr) {
+		return &http.Response{StatusCode: apiErr.StatusCode}
+	}
+	return nil
+}
+
+// admitToCache returns the cache admission of smart caching, nil without
+func (c *client) admitToCache() func(req *http.Request, size int64) bool {
+	if !c.config.SmartCachingEnabled || c.ai == nil {
+		return nil
+	}
+	return func(req *http.Request, size int64) bool {
+		return c.ai.ShouldCache(req.URL.String(), size)
+	}
+}
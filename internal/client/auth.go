@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yourorg/httpclient/internal/retry"
+)
+
+// authRefreshKey marks the context of an auth provider call made because
+// the server rejected the previous token
+type authRefreshKey struct{}
+
+// IsAuthRefresh reports whether an auth provider is called because the
+// server answered 401 Unauthorized to the token it returned last. A
+// provider that caches tokens should fetch a new one.
+func IsAuthRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(authRefreshKey{}).(bool)
+	return refresh
+}
+
+// authorize sets the Authorization header of req to a bearer token from
+// the auth provider, or from the OAuth2 token endpoint without one, and
+// returns the token
+func (c *client) authorize(ctx context.Context, req *http.Request, refresh bool) (string, error) {
+	if refresh {
+		ctx = context.WithValue(ctx, authRefreshKey{}, true)
+	}
+	provider := c.config.AuthProvider
+	if c.oauth2 != nil {
+		provider = c.oauth2.accessToken
+	}
+	token, err := provider(ctx)
+	if err != nil {
+		return "", fmt.Errorf("auth provider: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token, nil
+}
+
+// isUnauthorized reports whether err is a 401 Unauthorized response
+func isUnauthorized(err error) bool {
+	var apiErr *retry.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,29 @@
+package client
+
+import "github.com/yourorg/httpclient/internal/middleware"
+
+// NoCache sends the request without looking in the response cache and
+// keeps its response out of it, like Cache-Control: no-store
+func NoCache() RequestOption {
+	return func(o *RequestOptions) {
+		o.CacheMode = middleware.CacheModeNoStore
+	}
+}
+
+// ForceRevalidate sends the request even when a fresh response is cached,
+// as a conditional request when the cached response has an ETag or
+// Last-Modified, and caches the answer, like Cache-Control: no-cache
+func ForceRevalidate() RequestOption {
+	return func(o *RequestOptions) {
+		o.CacheMode = middleware.CacheModeNoCache
+	}
+}
+
+// OnlyIfCached answers the request from a fresh cached response without
+// going to the network, or fails it with ErrCacheMiss, like Cache-Control:
+// only-if-cached
+func OnlyIfCached() RequestOption {
+	return func(o *RequestOptions) {
+		o.CacheMode = middleware.CacheModeOnlyIfCached
+	}
+}
@@ -13,37 +13,87 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/yourorg/httpclient/internal/ai"
+	"github.com/yourorg/httpclient/internal/batch"
+	"github.com/yourorg/httpclient/internal/clock"
 	"github.com/yourorg/httpclient/internal/config"
+	"github.com/yourorg/httpclient/internal/dns"
+	"github.com/yourorg/httpclient/internal/graphql"
 	"github.com/yourorg/httpclient/internal/loadbalancer"
+	"github.com/yourorg/httpclient/internal/logging"
 	"github.com/yourorg/httpclient/internal/middleware"
+	"github.com/yourorg/httpclient/internal/ratelimit"
 	"github.com/yourorg/httpclient/internal/retry"
+	"github.com/yourorg/httpclient/internal/streaming"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
 	"golang.org/x/time/rate"
 )
 
+// Sentinel errors for failures raised by the client itself
+var (
+	ErrRateLimited    = errors.New("rate limit exceeded")
+	ErrNotWhitelisted = errors.New("IP not whitelisted")
+	// ErrAttemptTimeout and ErrOverallTimeout are wrapped by the error of a
+	// request cut short by WithAttemptTimeout or WithOverallTimeout
+	ErrAttemptTimeout = errors.New("attempt timeout exceeded")
+	ErrOverallTimeout = errors.New("overall timeout exceeded")
+	// ErrInvalidProxy fails the requests of a client given a proxy URL
+	// that can't be used, see WithProxy
+	ErrInvalidProxy = errors.New("invalid proxy URL")
+	// ErrInvalidWeights fails the requests of a client given load balancer
+	// weights that can't be used, see WithWeightedLoadBalancer
+	ErrInvalidWeights = errors.New("invalid load balancer weights")
+	// ErrResponseTooLarge fails a request whose response body exceeds
+	// WithMaxResponseSize
+	ErrResponseTooLarge = errors.New("response too large")
+	// ErrCacheMiss fails a request made with OnlyIfCached when no fresh
+	// response is cached
+	ErrCacheMiss = middleware.ErrCacheMiss
+)
+
+// Client is the client returned by New, for the httpclient package to wrap
+type Client = client
+
 // client implements the Client interface
 type client struct {
-	httpClient     *http.Client
-	config         *config.Config
-	rateLimiter    *rate.Limiter
-	middlewares    []middleware.Middleware
-	retryStrategy  retry.Strategy
-	loadBalancer   loadbalancer.LoadBalancer
-	healthChecker  *HealthChecker
-	requestSigner  *RequestSigner
-	ipWhitelist    map[string]bool
-	backupClients  []*client
-	mu             sync.RWMutex
+	httpClient      *http.Client
+	tcpTransport    *http.Transport // nil with a custom transport
+	config          *config.Config
+	rateLimiter     *rate.Limiter
+	hostLimiter     *ratelimit.PerHost
+	middlewares     []middleware.Middleware
+	retryStrategy   retry.Strategy
+	logger          logging.Logger
+	loadBalancer    loadbalancer.LoadBalancer
+	loadBalancerErr error // why the load balancer weights can't be used
+	cache           middleware.Cache
+	oauth2          *oauth2Source // nil without OAuth2Config or with an AuthProvider
+	flights         *flightGroup  // nil unless requests are coalesced
+	breaker         middleware.CircuitBreaker
+	ai              *ai.AIManager // nil unless an AI feature is enabled
+	gql             *graphql.GraphQLClient
+	healthChecker   *HealthChecker
+	requestSigner   *RequestSigner
+	ipWhitelist     map[string]bool
+	backupClients   []*client
+	mu              sync.RWMutex
 }
 
 // HealthChecker manages endpoint health checking
@@ -70,52 +120,59 @@ type RequestSigner struct {
 // New creates a new HTTP client with the given configuration
 func New(cfg *config.Config) *client {
 	var transport http.RoundTripper
-	
+	var tcpTransport *http.Transport
+
 	if cfg.CustomTransport != nil {
 		transport = cfg.CustomTransport
 	} else {
-		tlsConfig := cfg.TLSConfig
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{
-				InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		// Clients with the same transport settings share a connection pool
+		httpTransport := sharedTransport(cfg)
+		tcpTransport = httpTransport
+		transport = httpTransport
+		if cfg.HTTP3Enabled {
+			if h3 := newHTTP3RoundTripper(httpTransport.TLSClientConfig, cfg); h3 != nil {
+				transport = newHTTP3Transport(h3, httpTransport, cfg.HTTP3AltSvc, cfg.Clock)
 			}
 		}
-
-		httpTransport := &http.Transport{
-			MaxIdleConns:        cfg.MaxIdleConns,
-			MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
-			IdleConnTimeout:     cfg.IdleConnTimeout,
-			TLSClientConfig:     tlsConfig,
-			TLSHandshakeTimeout: cfg.TLSTimeout,
-			KeepAlive:          cfg.KeepAlive,
-		}
-
-		if cfg.ProxyURL != nil {
-			httpTransport.Proxy = http.ProxyURL(cfg.ProxyURL)
+		if cfg.HTTP2Enabled != nil && *cfg.HTTP2Enabled {
+			transport = newH2CTransport(transport, httpTransport)
 		}
 
 		if cfg.CompressionEnabled {
-			transport = &compressionTransport{base: httpTransport}
-		} else {
-			transport = httpTransport
+			transport = &compressionTransport{base: transport, minSize: cfg.CompressionMinSize}
 		}
 	}
 
 	httpClient := &http.Client{
 		Timeout:       cfg.Timeout,
 		Transport:     transport,
-		Jar:          cfg.CookieJar,
+		Jar:           cfg.CookieJar,
 		CheckRedirect: cfg.RedirectPolicy,
 	}
 
 	var rateLimiter *rate.Limiter
 	if cfg.RateLimitRPS > 0 {
-		rateLimiter = rate.NewLimiter(rate.Limit(cfg.RateLimitRPS), cfg.RateLimitRPS)
+		burst := cfg.RateLimitBurst
+		if burst <= 0 {
+			burst = cfg.RateLimitRPS
+		}
+		rateLimiter = rate.NewLimiter(rate.Limit(cfg.RateLimitRPS), burst)
+	}
+
+	var hostLimiter *ratelimit.PerHost
+	if cfg.PerHostRateLimitRPS > 0 {
+		hostLimiter = ratelimit.NewPerHost(cfg.PerHostRateLimitRPS, cfg.PerHostRateLimitRPS, ratelimit.DefaultIdleTimeout)
 	}
 
 	// Initialize load balancer
 	var lb loadbalancer.LoadBalancer
-	if len(cfg.LoadBalancerEndpoints) > 0 {
+	var lbErr error
+	if len(cfg.LoadBalancerWeights) > 0 {
+		lb, lbErr = loadbalancer.NewWeightedLB(cfg.LoadBalancerWeights)
+		if lbErr != nil {
+			lbErr = fmt.Errorf("%w: %w", ErrInvalidWeights, lbErr)
+		}
+	} else if len(cfg.LoadBalancerEndpoints) > 0 {
 		lb = loadbalancer.New(cfg.LoadBalancerEndpoints, cfg.LoadBalancerStrategy)
 	}
 
@@ -127,11 +184,14 @@ func New(cfg *config.Config) *client {
 	}
 
 	// Initialize request signer
+	logger := logging.OrNop(cfg.Logger)
 	var rs *RequestSigner
 	if cfg.RequestSigningKeyID != "" && cfg.RequestSigningKey != "" {
-		if signer, err := NewRequestSigner(cfg.RequestSigningKeyID, cfg.RequestSigningKey); err == nil {
-			rs = signer
+		signer, err := NewRequestSigner(cfg.RequestSigningKeyID, cfg.RequestSigningKey)
+		if err != nil {
+			logger.Errorf("request signing disabled key_id=%s error=%q", cfg.RequestSigningKeyID, err.Error())
 		}
+		rs = signer
 	}
 
 	// Initialize IP whitelist
@@ -141,104 +201,197 @@ func New(cfg *config.Config) *client {
 	}
 
 	c := &client{
-		httpClient:     httpClient,
-		config:         cfg,
-		rateLimiter:    rateLimiter,
-		middlewares:    []middleware.Middleware{},
-		retryStrategy:  retry.NewExponentialBackoff(cfg),
-		loadBalancer:   lb,
-		healthChecker:  hc,
-		requestSigner:  rs,
-		ipWhitelist:    ipWhitelist,
+		httpClient:      httpClient,
+		tcpTransport:    tcpTransport,
+		config:          cfg,
+		rateLimiter:     rateLimiter,
+		hostLimiter:     hostLimiter,
+		middlewares:     []middleware.Middleware{},
+		retryStrategy:   retry.NewExponentialBackoff(cfg),
+		logger:          logger,
+		loadBalancer:    lb,
+		loadBalancerErr: lbErr,
+		healthChecker:   hc,
+		requestSigner:   rs,
+		ipWhitelist:     ipWhitelist,
+	}
+
+	if cfg.OAuth2Config != nil && cfg.AuthProvider == nil {
+		if cfg.OAuth2Session == nil {
+			cfg.OAuth2Session = config.NewOAuth2Session(*cfg.OAuth2Config)
+		}
+		c.oauth2 = newOAuth2Source(*cfg.OAuth2Config, cfg.OAuth2Session, httpClient, cfg.Clock)
+	}
+
+	c.gql = graphql.NewGraphQLClientFunc(cfg.GraphQLEndpoint, c.graphQLDo).
+		WithPersistedQueries(cfg.GraphQLPersistedQueries).
+		WithSubscriptionDialer(c.dialGraphQL).
+		WithConnectionInitPayload(cfg.GraphQLInitPayload)
+	if cfg.GraphQLValidation {
+		c.gql.WithValidation()
+	}
+
+	if cfg.AIRetryEnabled || cfg.SmartCachingEnabled || cfg.AdaptiveTimeoutEnabled {
+		c.ai = ai.NewAIManager()
+		c.ai.SetClock(cfg.Clock)
+		c.ai.SetFeatures(ai.Features{
+			Retry:           cfg.AIRetryEnabled,
+			Caching:         cfg.SmartCachingEnabled,
+			AdaptiveTimeout: cfg.AdaptiveTimeoutEnabled,
+		})
 	}
 
 	// Initialize backup clients
 	for _, endpoint := range cfg.BackupEndpoints {
 		backupCfg := cfg.Clone()
 		backupCfg.BaseURL = endpoint
+		backupCfg.BackupEndpoints = nil
+		backupCfg.LoadBalancerEndpoints = nil
 		c.backupClients = append(c.backupClients, New(backupCfg))
 	}
 
 	// Add default middlewares
+	if cfg.CircuitBreakerEnabled {
+		var onStateChange func(host string, from, to middleware.CircuitState)
+		if cfg.CircuitStateChange != nil || cfg.CircuitHostStateChange != nil {
+			onStateChange = func(host string, from, to middleware.CircuitState) {
+				if cfg.CircuitStateChange != nil {
+					cfg.CircuitStateChange(from.String(), to.String())
+				}
+				if cfg.CircuitHostStateChange != nil {
+					cfg.CircuitHostStateChange(host, from, to)
+				}
+			}
+		}
+		c.breaker = middleware.NewCircuitBreaker(middleware.CircuitBreakerOptions{
+			Threshold:        cfg.CircuitBreakerThreshold,
+			Timeout:          cfg.CircuitBreakerTimeout,
+			HalfOpenProbes:   cfg.CircuitHalfOpenProbes,
+			SuccessThreshold: cfg.CircuitSuccessThreshold,
+			Clock:            cfg.Clock,
+			OnStateChange:    onStateChange,
+		})
+		c.middlewares = append(c.middlewares, c.breaker)
+	}
+	if cfg.CacheEnabled || cfg.NegativeCacheTTL > 0 {
+		var ttl time.Duration
+		if cfg.CacheEnabled {
+			ttl = cfg.CacheTTL
+		}
+		c.cache = middleware.NewCache(middleware.CacheOptions{
+			TTL:          ttl,
+			NegativeTTL:  cfg.NegativeCacheTTL,
+			Clock:        cfg.Clock,
+			Store:        cfg.CacheStore,
+			MaxEntries:   cfg.CacheMaxEntries,
+			MaxBytes:     cfg.CacheMaxBytes,
+			MaxEntrySize: cfg.CacheMaxEntrySize,
+			KeyFunc:      cfg.CacheKeyFunc,
+			Policy:       cfg.CachePolicy,
+			Admit:        c.admitToCache(),
+		})
+		c.middlewares = append(c.middlewares, c.cache)
+	}
+	if cfg.RequestCoalescing {
+		c.flights = newFlightGroup()
+	}
 	if cfg.MetricsEnabled {
 		c.middlewares = append(c.middlewares, middleware.NewMetrics())
 	}
 	if cfg.TracingEnabled {
-		c.middlewares = append(c.middlewares, middleware.NewTracing())
+		c.middlewares = append(c.middlewares, middleware.NewTracing(cfg.TracePropagator))
 	}
 	if cfg.DebugEnabled {
-		c.middlewares = append(c.middlewares, middleware.NewDebug())
+		debugLogger := cfg.Logger
+		if debugLogger == nil {
+			debugLogger = logging.NewWriter(os.Stdout)
+		}
+		c.middlewares = append(c.middlewares, middleware.NewDebug(debugLogger))
 	}
+	c.middlewares = append(c.middlewares, cfg.Middlewares...)
 
 	return c
 }
 
 // HTTP Methods
 
-func (c *client) GET(url string) ([]byte, error) {
-	return c.GetContext(context.Background(), url)
+func (c *client) GET(url string, opts ...RequestOption) ([]byte, error) {
+	return c.GetContext(context.Background(), url, opts...)
 }
 
-func (c *client) POST(url string, body interface{}) ([]byte, error) {
-	return c.PostContext(context.Background(), url, body)
+func (c *client) POST(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.PostContext(context.Background(), url, body, opts...)
 }
 
-func (c *client) PUT(url string, body interface{}) ([]byte, error) {
-	return c.PutContext(context.Background(), url, body)
+func (c *client) PUT(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.PutContext(context.Background(), url, body, opts...)
 }
 
-func (c *client) PATCH(url string, body interface{}) ([]byte, error) {
-	return c.PatchContext(context.Background(), url, body)
+func (c *client) PATCH(url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.PatchContext(context.Background(), url, body, opts...)
 }
 
-func (c *client) DELETE(url string) ([]byte, error) {
-	return c.DeleteContext(context.Background(), url)
+func (c *client) DELETE(url string, opts ...RequestOption) ([]byte, error) {
+	return c.DeleteContext(context.Background(), url, opts...)
 }
 
-func (c *client) HEAD(url string) error {
-	_, err := c.do(context.Background(), "HEAD", url, nil)
+func (c *client) HEAD(url string, opts ...RequestOption) error {
+	_, err := c.do(context.Background(), "HEAD", url, nil, opts...)
 	return err
 }
 
-func (c *client) OPTIONS(url string) ([]byte, error) {
-	return c.do(context.Background(), "OPTIONS", url, nil)
+func (c *client) OPTIONS(url string, opts ...RequestOption) ([]byte, error) {
+	return c.do(context.Background(), "OPTIONS", url, nil, opts...)
+}
+
+// TRACE sends a TRACE request; the body is the request as the server
+// received it
+func (c *client) TRACE(url string, opts ...RequestOption) ([]byte, error) {
+	return c.do(context.Background(), "TRACE", url, nil, opts...)
 }
 
 // Context-aware methods
 
-func (c *client) GetContext(ctx context.Context, url string) ([]byte, error) {
-	return c.do(ctx, "GET", url, nil)
+func (c *client) GetContext(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.do(ctx, "GET", url, nil, opts...)
 }
 
-func (c *client) PostContext(ctx context.Context, url string, body interface{}) ([]byte, error) {
-	return c.do(ctx, "POST", url, body)
+func (c *client) PostContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.do(ctx, "POST", url, body, opts...)
 }
 
-func (c *client) PutContext(ctx context.Context, url string, body interface{}) ([]byte, error) {
-	return c.do(ctx, "PUT", url, body)
+func (c *client) PutContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.do(ctx, "PUT", url, body, opts...)
 }
 
-func (c *client) PatchContext(ctx context.Context, url string, body interface{}) ([]byte, error) {
-	return c.do(ctx, "PATCH", url, body)
+func (c *client) PatchContext(ctx context.Context, url string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	return c.do(ctx, "PATCH", url, body, opts...)
 }
 
-func (c *client) DeleteContext(ctx context.Context, url string) ([]byte, error) {
-	return c.do(ctx, "DELETE", url, nil)
+func (c *client) DeleteContext(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.do(ctx, "DELETE", url, nil, opts...)
 }
 
 // JSON methods
 
-func (c *client) JSON(method, url string, body, result interface{}) error {
-	return c.JSONContext(context.Background(), method, url, body, result)
+func (c *client) JSON(method, url string, body, result interface{}, opts ...RequestOption) error {
+	return c.JSONContext(context.Background(), method, url, body, result, opts...)
 }
 
-func (c *client) JSONContext(ctx context.Context, method, url string, body, result interface{}) error {
-	data, err := c.do(ctx, method, url, body)
+func (c *client) JSONContext(ctx context.Context, method, url string, body, result interface{}, opts ...RequestOption) error {
+	ctx, url, err := applyRequestOptions(ctx, url, opts)
+	if err != nil {
+		return err
+	}
+	if !hasHeader(c.config.Headers, "Accept") {
+		ctx = withDefaultHeader(ctx, "Accept", "application/json")
+	}
+	resp, err := c.doResponse(ctx, method, url, body)
 	if err != nil {
 		return err
 	}
-	if result != nil && len(data) > 0 {
-		return json.Unmarshal(data, result)
+	if result != nil && len(resp.body) > 0 {
+		return decodeContent(resp.header, resp.body, result, codecOf(c.config))
 	}
 	return nil
 }
@@ -251,12 +404,79 @@ func (c *client) WithTimeout(timeout time.Duration) *client {
 	return New(newConfig)
 }
 
+// WithAttemptTimeout bounds each attempt of a request, so a hanging attempt
+// is given up on and retried. Unlike WithTimeout it is a context deadline,
+// and the error wraps ErrAttemptTimeout.
+func (c *client) WithAttemptTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.AttemptTimeout = timeout
+	return New(newConfig)
+}
+
+// WithOverallTimeout bounds a whole call: every attempt, the backoff
+// between them and failover to backup endpoints. Once it expires no further
+// attempt is made and the error wraps ErrOverallTimeout. Streams aren't
+// covered.
+func (c *client) WithOverallTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.OverallTimeout = timeout
+	return New(newConfig)
+}
+
+// WithClock replaces the time source used for cache expiry, circuit breaker
+// timeouts and retry backoff, mainly so tests can control time
+func (c *client) WithClock(clk clock.Clock) *client {
+	newConfig := c.config.Clone()
+	newConfig.Clock = clk
+	return New(newConfig)
+}
+
 func (c *client) WithRetries(retries int) *client {
 	newConfig := c.config.Clone()
 	newConfig.Retries = retries
 	return New(newConfig)
 }
 
+// WithRetryNonIdempotent allows retrying POST, PATCH and other requests
+// that may have side effects. By default only GET, HEAD, PUT, DELETE,
+// OPTIONS and TRACE requests, GraphQL queries and requests carrying an
+// Idempotency-Key header are retried after reaching the server; the others
+// only when the connection couldn't be made.
+func (c *client) WithRetryNonIdempotent(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.RetryNonIdempotent = enabled
+	return New(newConfig)
+}
+
+// WithIdempotencyKey sends a random Idempotency-Key header with every
+// POST, PATCH or other non-idempotent request that doesn't set one. The key
+// is generated once per call and reused by its retries and failover to
+// backup endpoints, so the server can recognize and dedupe them; such
+// requests are then retried like idempotent ones.
+func (c *client) WithIdempotencyKey() *client {
+	newConfig := c.config.Clone()
+	newConfig.IdempotencyKeys = true
+	return New(newConfig)
+}
+
+// WithRetryBudget stops retrying once the time since the first attempt,
+// including the next backoff delay, would exceed maxTotal. The error then
+// wraps ErrRetryBudgetExceeded and the last attempt's error.
+func (c *client) WithRetryBudget(maxTotal time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.RetryBudget = maxTotal
+	return New(newConfig)
+}
+
+// WithOnRetry calls fn after each failed attempt that will be retried,
+// before the backoff delay: attempt is the 1-based number of the attempt
+// that failed, err its error and nextDelay the wait before the next one
+func (c *client) WithOnRetry(fn func(attempt int, err error, nextDelay time.Duration)) *client {
+	newConfig := c.config.Clone()
+	newConfig.OnRetry = fn
+	return New(newConfig)
+}
+
 func (c *client) WithBaseURL(baseURL string) *client {
 	newConfig := c.config.Clone()
 	newConfig.BaseURL = strings.TrimSuffix(baseURL, "/")
@@ -267,6 +487,39 @@ func (c *client) WithAuth(token string) *client {
 	return c.WithHeader("Authorization", "Bearer "+token)
 }
 
+// WithAuthProvider calls provider for the bearer token of every request,
+// so expiring tokens can be renewed; caching them is up to the provider.
+// See WithAuthRefreshOn401 to renew a token the server rejects.
+func (c *client) WithAuthProvider(provider func(ctx context.Context) (string, error)) *client {
+	newConfig := c.config.Clone()
+	newConfig.AuthProvider = provider
+	return New(newConfig)
+}
+
+// WithAuthRefreshOn401 handles tokens that expire before the provider
+// notices: when the server answers 401 Unauthorized, the auth provider is
+// called once more with a context for which IsAuthRefresh is true, and the
+// request is repeated with the new token. That happens at most once per
+// call, and not at all when the provider returns the rejected token again.
+func (c *client) WithAuthRefreshOn401(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.AuthRefreshOn401 = enabled
+	return New(newConfig)
+}
+
+// WithOAuth2 authenticates requests with bearer tokens from the token
+// endpoint of cfg: with the authorization code or refresh token of a user
+// when cfg has one, otherwise with the client credentials grant. Tokens
+// are renewed shortly before they expire and when the server answers 401.
+// Clients derived from this one share its tokens. An auth provider set with
+// WithAuthProvider takes precedence.
+func (c *client) WithOAuth2(cfg config.OAuth2Config) *client {
+	newConfig := c.config.Clone()
+	newConfig.OAuth2Config = &cfg
+	newConfig.OAuth2Session = nil
+	return New(newConfig)
+}
+
 func (c *client) WithAPIKey(key, value string) *client {
 	return c.WithHeader(key, value)
 }
@@ -285,6 +538,21 @@ func (c *client) WithHeaders(headers map[string]string) *client {
 	return New(newConfig)
 }
 
+// WithAccept sends an Accept header listing mediaTypes in order of
+// preference, e.g. "application/json, application/xml;q=0.9"
+func (c *client) WithAccept(mediaTypes ...string) *client {
+	newConfig := c.config.Clone()
+	for k := range newConfig.Headers {
+		if http.CanonicalHeaderKey(k) == "Accept" {
+			delete(newConfig.Headers, k)
+		}
+	}
+	if len(mediaTypes) > 0 {
+		newConfig.Headers["Accept"] = acceptHeader(mediaTypes...)
+	}
+	return New(newConfig)
+}
+
 func (c *client) WithUserAgent(userAgent string) *client {
 	newConfig := c.config.Clone()
 	newConfig.UserAgent = userAgent
@@ -297,19 +565,238 @@ func (c *client) WithRateLimiter(rps int) *client {
 	return New(newConfig)
 }
 
+// WithRateLimiterBurst limits requests to rps per second while allowing
+// bursts of up to burst requests
+func (c *client) WithRateLimiterBurst(rps, burst int) *client {
+	newConfig := c.config.Clone()
+	newConfig.RateLimitRPS = rps
+	newConfig.RateLimitBurst = burst
+	return New(newConfig)
+}
+
+// WithPerHostRateLimiter limits requests to rps per second for each request
+// host independently
+func (c *client) WithPerHostRateLimiter(rps int) *client {
+	newConfig := c.config.Clone()
+	newConfig.PerHostRateLimitRPS = rps
+	return New(newConfig)
+}
+
+// WithRateLimiterNonBlocking makes rate-limited requests fail immediately
+// with ErrRateLimited instead of waiting for a token
+func (c *client) WithRateLimiterNonBlocking(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.RateLimitNonBlocking = enabled
+	return New(newConfig)
+}
+
 func (c *client) WithCircuitBreaker(threshold int, timeout time.Duration) *client {
 	newConfig := c.config.Clone()
+	newConfig.CircuitBreakerEnabled = true
 	newConfig.CircuitBreakerThreshold = threshold
 	newConfig.CircuitBreakerTimeout = timeout
 	return New(newConfig)
 }
 
+// WithHTTP3 sends HTTPS requests over HTTP/3 (QUIC), falling back to
+// HTTP/2 over TCP for hosts where the QUIC handshake fails. HTTP/3 support
+// requires building with the http3 tag; without it requests use HTTP/2.
+func (c *client) WithHTTP3(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.HTTP3Enabled = enabled
+	return New(newConfig)
+}
+
+// WithHTTP2 forces HTTP/2 when enabled: negotiated through ALPN for HTTPS
+// and spoken with prior knowledge (h2c) for plain HTTP, so the server must
+// support h2c. Cleartext requests through a proxy stay on HTTP/1.1. When
+// disabled every request uses HTTP/1.1.
+func (c *client) WithHTTP2(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.HTTP2Enabled = &enabled
+	return New(newConfig)
+}
+
+// WithHTTP3AltSvc enables HTTP/3 only for hosts that advertise it in an
+// Alt-Svc response header, sending the first requests over TCP
+func (c *client) WithHTTP3AltSvc(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.HTTP3AltSvc = enabled
+	if enabled {
+		newConfig.HTTP3Enabled = true
+	}
+	return New(newConfig)
+}
+
+// OnCircuitStateChange registers fn to be called with the old and new
+// state whenever the circuit of a host changes state
+func (c *client) OnCircuitStateChange(fn func(old, new string)) *client {
+	newConfig := c.config.Clone()
+	newConfig.CircuitStateChange = fn
+	return New(newConfig)
+}
+
+// OnStateChange registers fn to be called with the host, old and new
+// state whenever the circuit of a host changes state
+func (c *client) OnStateChange(fn func(host string, from, to middleware.CircuitState)) *client {
+	newConfig := c.config.Clone()
+	newConfig.CircuitHostStateChange = fn
+	return New(newConfig)
+}
+
+// WithCircuitBreakerProbes lets up to probes requests at a time through a
+// half-open circuit, and closes it once successes of them in a row have
+// succeeded. Any failed probe opens the circuit again.
+func (c *client) WithCircuitBreakerProbes(probes, successes int) *client {
+	newConfig := c.config.Clone()
+	newConfig.CircuitHalfOpenProbes = probes
+	newConfig.CircuitSuccessThreshold = successes
+	return New(newConfig)
+}
+
+// CircuitBreakerState reports the circuit breaker state ("closed", "open"
+// or "half-open") and the current failure count. Circuits are kept per
+// host; the state is the worst across hosts and failures are summed. It
+// returns "disabled" when no circuit breaker is configured.
+func (c *client) CircuitBreakerState() (state string, failures int64) {
+	if c.breaker == nil {
+		return "disabled", 0
+	}
+	return c.breaker.GetState().String(), c.breaker.GetFailures()
+}
+
+// CircuitState reports the circuit state and failure count of a single
+// host, given as host or host:port like in the request URL. Without a
+// circuit breaker every host is closed.
+func (c *client) CircuitState(host string) (middleware.CircuitState, int64) {
+	if c.breaker == nil {
+		return middleware.StateClosed, 0
+	}
+	return c.breaker.GetHostState(host)
+}
+
+// InvalidateCache drops the cached responses for url, e.g. after
+// changing the resource out of band. A url without a query covers every
+// query of its path, and a * in the path matches any part of a segment.
+// Successful POST, PUT, PATCH and DELETE requests through the client
+// invalidate their URL on their own.
+func (c *client) InvalidateCache(url string) {
+	if c.cache != nil {
+		c.cache.Invalidate(url)
+	}
+}
+
+// ClearCache drops every cached response
+func (c *client) ClearCache() {
+	if c.cache != nil {
+		c.cache.Clear()
+	}
+}
+
+// FlushCache is ClearCache
+func (c *client) FlushCache() {
+	c.ClearCache()
+}
+
+// CacheStats returns the hits, misses and evictions of the response cache
+// and its current size, or zeros without a cache. The counts start over
+// with each With* call, which creates a new cache.
+func (c *client) CacheStats() middleware.CacheStats {
+	if c.cache == nil {
+		return middleware.CacheStats{}
+	}
+	return c.cache.Stats()
+}
+
+// MetricsSnapshot holds the metrics of a single client at one moment
+type MetricsSnapshot struct {
+	Cache middleware.CacheStats
+}
+
+// MetricsSnapshot returns the client's metrics as of now. Prometheus gets
+// the same counts, summed across clients, when metrics are enabled.
+func (c *client) MetricsSnapshot() MetricsSnapshot {
+	return MetricsSnapshot{Cache: c.CacheStats()}
+}
+
 func (c *client) WithCache(ttl time.Duration) *client {
 	newConfig := c.config.Clone()
+	newConfig.CacheEnabled = true
 	newConfig.CacheTTL = ttl
 	return New(newConfig)
 }
 
+// WithRequestCoalescing makes identical GET and HEAD requests that are in
+// flight at the same time share a single upstream request, each caller
+// getting its own copy of the response, e.g. to avoid a stampede when a
+// popular cache entry expires. See WithoutCoalescing to opt a request out.
+func (c *client) WithRequestCoalescing(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.RequestCoalescing = enabled
+	return New(newConfig)
+}
+
+// WithCacheLimits bounds the response cache to maxEntries responses and
+// maxBytes of response bodies, evicting the least recently used responses
+// to make room. Zero leaves that dimension unbounded.
+func (c *client) WithCacheLimits(maxEntries int, maxBytes int64) *client {
+	newConfig := c.config.Clone()
+	newConfig.CacheMaxEntries = maxEntries
+	newConfig.CacheMaxBytes = maxBytes
+	return New(newConfig)
+}
+
+// WithCacheStore keeps cached responses in store, e.g. Redis, so that
+// clients in several processes share them, and enables the cache with the
+// configured TTL. Store errors turn into cache misses and never fail a
+// request. The limits of WithCacheLimits only apply to the default
+// in-memory store.
+func (c *client) WithCacheStore(store middleware.CacheStore) *client {
+	newConfig := c.config.Clone()
+	newConfig.CacheEnabled = true
+	newConfig.CacheStore = store
+	return New(newConfig)
+}
+
+// WithCacheMaxEntrySize keeps responses with bodies over maxBytes out of
+// the cache, so a few large responses don't evict many small ones. Zero
+// removes the limit.
+func (c *client) WithCacheMaxEntrySize(maxBytes int64) *client {
+	newConfig := c.config.Clone()
+	newConfig.CacheMaxEntrySize = maxBytes
+	return New(newConfig)
+}
+
+// WithCacheKeyFunc sets the key a response is cached under for a request,
+// so that requests with the same key share it. The default is the method,
+// URL and Authorization header; include other headers the server varies
+// the response on without listing them in Vary, e.g. Accept. Headers listed
+// in Vary are always compared as well.
+func (c *client) WithCacheKeyFunc(fn func(req *http.Request) string) *client {
+	newConfig := c.config.Clone()
+	newConfig.CacheKeyFunc = fn
+	return New(newConfig)
+}
+
+// WithCachePolicy sets whether the lifetime a response sets with
+// Cache-Control max-age, s-maxage or Expires wins over the cache TTL
+// (middleware.CacheRespectServer, the default) or the TTL always applies
+// (middleware.CacheOverride). no-store and private responses are never
+// cached and no-cache ones are revalidated before every use either way.
+func (c *client) WithCachePolicy(policy middleware.CachePolicy) *client {
+	newConfig := c.config.Clone()
+	newConfig.CachePolicy = policy
+	return New(newConfig)
+}
+
+// WithNegativeCache caches 404 and 410 responses for ttl, independently of
+// the TTL used for successful responses
+func (c *client) WithNegativeCache(ttl time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.NegativeCacheTTL = ttl
+	return New(newConfig)
+}
+
 func (c *client) WithMetrics(enabled bool) *client {
 	newConfig := c.config.Clone()
 	newConfig.MetricsEnabled = enabled
@@ -322,21 +809,63 @@ func (c *client) WithTracing(enabled bool) *client {
 	return New(newConfig)
 }
 
+// WithTracePropagator sets how the span of each request is injected into
+// its headers when tracing is enabled, e.g. for B3 instead of W3C Trace
+// Context
+func (c *client) WithTracePropagator(propagator propagation.TextMapPropagator) *client {
+	newConfig := c.config.Clone()
+	newConfig.TracePropagator = propagator
+	return New(newConfig)
+}
+
 func (c *client) WithDebug(enabled bool) *client {
 	newConfig := c.config.Clone()
 	newConfig.DebugEnabled = enabled
 	return New(newConfig)
 }
 
+// WithLogger routes debug output (see WithDebug) and internal warnings,
+// like a failover to a backup endpoint, to logger
+func (c *client) WithLogger(logger logging.Logger) *client {
+	newConfig := c.config.Clone()
+	newConfig.Logger = logger
+	return New(newConfig)
+}
+
+// WithMiddleware adds mw to the middleware chain. Before runs in
+// registration order and After in reverse, with the built-in middlewares
+// (circuit breaker, cache, metrics, tracing, debug) registered first. A
+// Before error stops the request before it is sent; middlewares that also
+// implement ErrorObserver see transport failures, in the order of After.
+func (c *client) WithMiddleware(mw middleware.Middleware) *client {
+	newConfig := c.config.Clone()
+	newConfig.Middlewares = append(newConfig.Middlewares, mw)
+	return New(newConfig)
+}
+
 // Advanced configuration methods
 
 func (c *client) WithLoadBalancer(endpoints []string, strategy string) *client {
 	newConfig := c.config.Clone()
+	newConfig.LoadBalancerWeights = nil
 	newConfig.LoadBalancerEndpoints = endpoints
 	newConfig.LoadBalancerStrategy = strategy
 	return New(newConfig)
 }
 
+// WithWeightedLoadBalancer spreads requests across endpoints at random in
+// proportion to their weights, e.g. 3:1 sends about 75% of the traffic to
+// the first endpoint. A weight that isn't positive fails every request
+// with ErrInvalidWeights.
+func (c *client) WithWeightedLoadBalancer(weights map[string]int) *client {
+	newConfig := c.config.Clone()
+	newConfig.LoadBalancerWeights = make(map[string]int, len(weights))
+	for endpoint, weight := range weights {
+		newConfig.LoadBalancerWeights[endpoint] = weight
+	}
+	return New(newConfig)
+}
+
 func (c *client) WithHealthCheck(interval time.Duration, endpoint string) *client {
 	newConfig := c.config.Clone()
 	newConfig.HealthCheckInterval = interval
@@ -350,6 +879,14 @@ func (c *client) WithCompression(enabled bool) *client {
 	return New(newConfig)
 }
 
+// WithCompressionMinSize sets the request body size below which bodies are
+// sent uncompressed when compression is enabled
+func (c *client) WithCompressionMinSize(bytes int) *client {
+	newConfig := c.config.Clone()
+	newConfig.CompressionMinSize = bytes
+	return New(newConfig)
+}
+
 func (c *client) WithRequestSigning(keyID, privateKey string) *client {
 	newConfig := c.config.Clone()
 	newConfig.RequestSigningKeyID = keyID
@@ -369,6 +906,11 @@ func (c *client) WithRequestInterceptor(interceptor func(*http.Request) error) *
 	return New(newConfig)
 }
 
+// WithResponseInterceptor calls interceptor with every response, whatever
+// its status, after middleware After hooks and before the status check. The
+// body can be read; to turn an error into a success the interceptor sets
+// StatusCode and a new Body, which becomes the result. An error fails the
+// request.
 func (c *client) WithResponseInterceptor(interceptor func(*http.Response) error) *client {
 	newConfig := c.config.Clone()
 	newConfig.ResponseInterceptors = append(newConfig.ResponseInterceptors, interceptor)
@@ -400,113 +942,633 @@ func (c *client) WithKeepAlive(duration time.Duration) *client {
 	return New(newConfig)
 }
 
+// WithDialTimeout limits how long establishing a connection may take
+func (c *client) WithDialTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.DialTimeout = timeout
+	return New(newConfig)
+}
+
+// WithResponseHeaderTimeout limits how long to wait for the response
+// headers once the request is sent. Unlike WithTimeout it doesn't cover
+// reading the body, so slow bodies and streams aren't cut off.
+func (c *client) WithResponseHeaderTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.ResponseHeaderTimeout = timeout
+	return New(newConfig)
+}
+
+// WithMaxResponseSize fails requests whose response body is larger than
+// bytes, as received or decompressed, with ErrResponseTooLarge instead of
+// reading it into memory. Streams are bounded per record by MaxRecordSize
+// instead.
+func (c *client) WithMaxResponseSize(bytes int64) *client {
+	newConfig := c.config.Clone()
+	newConfig.MaxResponseSize = bytes
+	return New(newConfig)
+}
+
+// WithCodec encodes request bodies and decodes JSON responses with codec
+// instead of encoding/json; nil restores encoding/json
+func (c *client) WithCodec(codec config.Codec) *client {
+	newConfig := c.config.Clone()
+	newConfig.Codec = codec
+	return New(newConfig)
+}
+
+// WithTLSHandshakeTimeout limits how long the TLS handshake of a new
+// connection may take, separately from the overall timeout
+func (c *client) WithTLSHandshakeTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.TLSHandshakeTimeout = timeout
+	return New(newConfig)
+}
+
+// WithExpectContinue sends requests that have a body with "Expect:
+// 100-continue", so the server can reject them, e.g. for a failed auth
+// check, before the body is uploaded. The body is sent once the server
+// answers 100 Continue, or after timeout without an answer; with a zero
+// timeout it is sent right away.
+func (c *client) WithExpectContinue(enabled bool, timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.ExpectContinue = enabled
+	newConfig.ExpectContinueTimeout = timeout
+	return New(newConfig)
+}
+
 func (c *client) WithTLSConfig(config *tls.Config) *client {
 	newConfig := c.config.Clone()
 	newConfig.TLSConfig = config
 	return New(newConfig)
 }
 
+// WithProxy sends requests through the proxy at proxyURL, an http://,
+// https:// or socks5:// URL; without a scheme http:// is assumed. A URL
+// that can't be used fails every request with ErrInvalidProxy instead of
+// silently connecting directly.
 func (c *client) WithProxy(proxyURL string) *client {
 	newConfig := c.config.Clone()
-	if u, err := url.Parse(proxyURL); err == nil {
-		newConfig.ProxyURL = u
-	}
+	newConfig.ProxyURL, newConfig.ProxyErr = parseProxyURL(proxyURL)
+	newConfig.ProxyFunc = nil
+	newConfig.ProxyDisabled = false
 	return New(newConfig)
 }
 
-func (c *client) WithCookieJar(jar http.CookieJar) *client {
+func (c *client) WithProxyFunc(fn func(*http.Request) (*url.URL, error)) *client {
 	newConfig := c.config.Clone()
-	newConfig.CookieJar = jar
+	newConfig.ProxyFunc = fn
+	newConfig.ProxyErr = nil
+	newConfig.ProxyDisabled = false
 	return New(newConfig)
 }
 
-func (c *client) WithRedirectPolicy(policy func(req *http.Request, via []*http.Request) error) *client {
+// WithProxies sends http:// requests through httpProxy and https:// ones
+// through httpsProxy, except for the hosts listed in noProxy, like the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables that are used
+// by default. An empty proxy sends those requests directly. Credentials in
+// a proxy URL are sent as Proxy-Authorization.
+func (c *client) WithProxies(httpProxy, httpsProxy, noProxy string) *client {
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  httpProxy,
+		HTTPSProxy: httpsProxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return c.WithProxyFunc(func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	})
+}
+
+// WithoutProxy disables proxying entirely, including proxies configured
+// through the HTTP_PROXY/HTTPS_PROXY environment variables
+func (c *client) WithoutProxy() *client {
 	newConfig := c.config.Clone()
-	newConfig.RedirectPolicy = policy
+	newConfig.ProxyDisabled = true
+	newConfig.ProxyURL = nil
+	newConfig.ProxyFunc = nil
+	newConfig.ProxyErr = nil
 	return New(newConfig)
 }
 
-// Internal methods
-
-func (c *client) do(ctx context.Context, method, urlStr string, body interface{}) ([]byte, error) {
-	// Check IP whitelist
-	if len(c.ipWhitelist) > 0 {
-		if err := c.checkIPWhitelist(urlStr); err != nil {
-			return nil, err
-		}
+// parseProxyURL parses and checks a proxy URL given to WithProxy
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
 	}
-
-	// Rate limiting
-	if c.rateLimiter != nil {
-		if err := c.rateLimiter.Wait(ctx); err != nil {
-			return nil, fmt.Errorf("rate limit exceeded: %w", err)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// The url.Error would repeat the URL, credentials included
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
 		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProxy, err)
 	}
 
-	// Build URL with load balancing
-	fullURL, err := c.buildURLWithLoadBalancing(urlStr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q, want http, https or socks5", ErrInvalidProxy, u.Scheme)
 	}
-
-	// Prepare request body
-	var reqBody io.Reader
-	if body != nil {
-		jsonData, err := json.Marshal(body)
-		if err != nil {
-			return nil, fmt.Errorf("marshal request body: %w", err)
-		}
-		reqBody = bytes.NewReader(jsonData)
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %s has no host", ErrInvalidProxy, u.Redacted())
 	}
-
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("%w: %s has a path or query, a proxy is only host and port", ErrInvalidProxy, u.Redacted())
 	}
+	return u, nil
+}
 
-	// Set headers
-	c.setHeaders(req, body != nil)
+// WithResolver resolves hostnames with resolver instead of the system
+// resolver. Both *net.Resolver and dns.ResolverFunc satisfy the interface.
+func (c *client) WithResolver(resolver dns.Resolver) *client {
+	newConfig := c.config.Clone()
+	newConfig.Resolver = resolver
+	return New(newConfig)
+}
 
-	// Apply request interceptors
-	for _, interceptor := range c.config.RequestInterceptors {
-		if err := interceptor(req); err != nil {
-			return nil, fmt.Errorf("request interceptor failed: %w", err)
-		}
-	}
+// WithDNSCache caches successful lookups for ttl and missing hosts for
+// negativeTTL, keeping at most maxEntries hosts. The cache is shared by
+// every client derived from this one, including backup clients.
+func (c *client) WithDNSCache(ttl, negativeTTL time.Duration, maxEntries int) *client {
+	newConfig := c.config.Clone()
+	newConfig.DNSCache = dns.NewCache(ttl, negativeTTL, maxEntries, newConfig.Clock)
+	return New(newConfig)
+}
+
+func (c *client) WithCookieJar(jar http.CookieJar) *client {
+	newConfig := c.config.Clone()
+	newConfig.CookieJar = jar
+	return New(newConfig)
+}
+
+func (c *client) WithRedirectPolicy(policy func(req *http.Request, via []*http.Request) error) *client {
+	newConfig := c.config.Clone()
+	newConfig.RedirectPolicy = policy
+	return New(newConfig)
+}
+
+// WithStreamIdleTimeout ends streams when no data arrives for timeout.
+// Streams have no overall timeout, so without it an upstream that stops
+// sending but keeps the connection open blocks the consumer forever. Zero
+// disables the idle timeout.
+func (c *client) WithStreamIdleTimeout(timeout time.Duration) *client {
+	newConfig := c.config.Clone()
+	newConfig.StreamIdleTimeout = timeout
+	return New(newConfig)
+}
+
+// SubscribeSSE streams server-sent events from url, dispatching each event
+// to the handler registered for its "event" type; unnamed events go to the
+// "message" handler, or the "" handler without one. It reconnects with
+// Last-Event-ID whenever the stream ends or drops, and blocks until ctx is
+// done, the server answers 204 No Content or an error status.
+func (c *client) SubscribeSSE(ctx context.Context, url string, handlers map[string]func(data string)) error {
+	fullURL, release, err := c.buildURLWithLoadBalancing(ctx, "GET", url)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	defer release()
+
+	sse := streaming.NewServerSentEvents().WithClient(c.streamClient())
+	return sse.Subscribe(ctx, fullURL, handlers)
+}
+
+// Batch returns a batch whose requests run concurrently through the same
+// request path as single requests: base URL, auth, rate limiting, retries,
+// circuit breaker and interceptors all apply to each item. At most
+// config.MaxBatchSize requests are in flight at once.
+func (c *client) Batch() *batch.BatchRequest {
+	return batch.NewBatchRequestFunc(c.batchDo).WithConcurrency(c.config.MaxBatchSize)
+}
+
+// Pipeline returns a pipeline whose requests run in sequence through the
+// same request path as single requests
+func (c *client) Pipeline() *batch.PipelineRequest {
+	return batch.NewPipelineRequestFunc(c.batchDo)
+}
+
+// batchDo performs a batch or pipeline item, reporting the status of error
+// responses too
+func (c *client) batchDo(ctx context.Context, method, urlStr string, body interface{}, header http.Header) (int, []byte, error) {
+	if len(header) > 0 {
+		ctx = context.WithValue(ctx, requestHeaderKey{}, header)
+	}
+	resp, err := c.doResponse(ctx, method, urlStr, body)
+	if err != nil {
+		var apiErr *retry.APIError
+		if errors.As(err, &apiErr) {
+			return apiErr.StatusCode, nil, err
+		}
+		return 0, nil, err
+	}
+	return resp.statusCode, resp.body, nil
+}
+
+// StreamJSON sends a request and calls fn with each JSON document of the
+// streamed response as it arrives, for newline-delimited (NDJSON) and
+// concatenated JSON APIs such as Docker events or Kubernetes watches. It
+// blocks until the stream ends, ctx is done or fn returns an error. A
+// stream cut off inside a document returns streaming.ErrPartialRecord.
+func (c *client) StreamJSON(ctx context.Context, method, url string, body interface{}, fn func(json.RawMessage) error) error {
+	req, release, err := c.newStreamRequest(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !hasHeader(c.config.Headers, "Accept") {
+		req.Header.Set("Accept", "application/x-ndjson, application/json")
+	}
+
+	resp, err := c.streamClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	rawBody := resp.Body
+	defer rawBody.Close()
+
+	if resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return &retry.APIError{
+			StatusCode: resp.StatusCode,
+			Body:       data,
+			Headers:    resp.Header.Clone(),
+		}
+	}
+
+	if err := decodeBody(resp); err != nil {
+		return err
+	}
+	if resp.Body != rawBody {
+		defer resp.Body.Close()
+	}
+
+	err = streaming.DecodeJSONStream(resp.Body, fn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Preflight sends an OPTIONS request and returns the methods and request
+// headers the server allows, taken from the Allow and
+// Access-Control-Allow-Methods/Headers response headers. CORS servers
+// usually only answer with an Origin header, which can be set with
+// WithHeader.
+func (c *client) Preflight(url string) (allowedMethods []string, allowedHeaders []string, err error) {
+	resp, err := c.doResponse(context.Background(), "OPTIONS", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowedMethods = headerList(resp.header, "Access-Control-Allow-Methods", "Allow")
+	for i, method := range allowedMethods {
+		allowedMethods[i] = strings.ToUpper(method)
+	}
+	allowedMethods = dedupe(allowedMethods)
+	allowedHeaders = dedupe(headerList(resp.header, "Access-Control-Allow-Headers"))
+
+	return allowedMethods, allowedHeaders, nil
+}
+
+// headerList splits the comma-separated values of the given headers
+func headerList(header http.Header, keys ...string) []string {
+	var values []string
+	for _, key := range keys {
+		for _, line := range header.Values(key) {
+			for _, value := range strings.Split(line, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// dedupe removes case-insensitive duplicates, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := values[:0]
+	for _, value := range values {
+		key := strings.ToLower(value)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// Internal methods
+
+// requestHeaderKey carries headers for a single request in its context;
+// doResponse sets them over the client's own
+type requestHeaderKey struct{}
+
+// withDefaultHeader returns a context whose request sends key: value
+// unless it already carries key
+func withDefaultHeader(ctx context.Context, key, value string) context.Context {
+	header, _ := ctx.Value(requestHeaderKey{}).(http.Header)
+	if header.Get(key) != "" {
+		return ctx
+	}
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(key, value)
+	return context.WithValue(ctx, requestHeaderKey{}, header)
+}
+
+// response is a successful response with its body already read
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (c *client) do(ctx context.Context, method, urlStr string, body interface{}, opts ...RequestOption) ([]byte, error) {
+	ctx, urlStr, err := applyRequestOptions(ctx, urlStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.doResponse(ctx, method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+func (c *client) doResponse(ctx context.Context, method, urlStr string, body interface{}) (*response, error) {
+	if c.config.ProxyErr != nil {
+		return nil, c.config.ProxyErr
+	}
+	if c.loadBalancerErr != nil {
+		return nil, c.loadBalancerErr
+	}
+	if c.config.OverallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, c.config.OverallTimeout, ErrOverallTimeout)
+		defer cancel()
+	}
+
+	// Check IP whitelist
+	if len(c.ipWhitelist) > 0 {
+		if err := c.checkIPWhitelist(ctx, urlStr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rate limiting
+	if c.rateLimiter != nil && c.config.RateLimitNonBlocking {
+		if !c.rateLimiter.Allow() {
+			return nil, ErrRateLimited
+		}
+	} else if c.rateLimiter != nil {
+		if err := c.rateLimiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRateLimited, err)
+		}
+	}
+
+	// Build URL with load balancing
+	fullURL, release, err := c.buildURLWithLoadBalancing(ctx, method, urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	defer release()
+
+	// Per-host rate limiting
+	if c.hostLimiter != nil {
+		if err := c.waitHostLimiter(ctx, fullURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// Prepare request body
+	var reqBody io.Reader
+	raw, isRaw := body.(*rawBody)
+	switch {
+	case isRaw && raw.stream != nil:
+		if reqBody, err = raw.stream(); err != nil {
+			return nil, fmt.Errorf("open request body: %w", err)
+		}
+	case isRaw:
+		if raw.data != nil {
+			reqBody = bytes.NewReader(raw.data)
+		}
+	case body != nil:
+		jsonData, err := codecOf(c.config).Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
+	if err != nil {
+		if closer, ok := reqBody.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if isRaw && raw.stream != nil && raw.replayable {
+		req.GetBody = func() (io.ReadCloser, error) {
+			r, err := raw.stream()
+			if err != nil {
+				return nil, err
+			}
+			if rc, ok := r.(io.ReadCloser); ok {
+				return rc, nil
+			}
+			return io.NopCloser(r), nil
+		}
+	}
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	// Set headers
+	c.setHeaders(req, reqBody != nil)
+	if isRaw {
+		if reqBody != nil {
+			req.Header.Set("Content-Type", raw.contentType)
+		}
+		req.Header.Set("Accept", raw.accept)
+	}
+	var token string
+	if c.config.AuthProvider != nil || c.oauth2 != nil {
+		if token, err = c.authorize(ctx, req, false); err != nil {
+			closeBody(req)
+			return nil, err
+		}
+	}
+	if header, ok := ctx.Value(requestHeaderKey{}).(http.Header); ok {
+		for key, values := range header {
+			req.Header[key] = append([]string(nil), values...)
+		}
+	}
+
+	// One key per call, so retries and backups reuse it
+	if c.config.IdempotencyKeys && !isIdempotent(method) && !(isRaw && raw.idempotent) &&
+		req.Header.Get("Idempotency-Key") == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		req.Header.Set("Idempotency-Key", key)
+
+		header, _ := ctx.Value(requestHeaderKey{}).(http.Header)
+		header = header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Idempotency-Key", key)
+		ctx = context.WithValue(ctx, requestHeaderKey{}, header)
+	}
+
+	// Apply request interceptors
+	for _, interceptor := range c.config.RequestInterceptors {
+		if err := interceptor(req); err != nil {
+			closeBody(req)
+			return nil, fmt.Errorf("request interceptor failed: %w", err)
+		}
+	}
 
 	// Sign request if configured
 	if c.requestSigner != nil {
 		if err := c.requestSigner.SignRequest(req); err != nil {
+			closeBody(req)
 			return nil, fmt.Errorf("request signing failed: %w", err)
 		}
 	}
 
+	// A token rejected with 401 is refreshed once per call, unless the
+	// request carries its own Authorization header
+	canRefresh := (c.config.AuthRefreshOn401 || c.oauth2 != nil) && token != "" &&
+		req.Header.Get("Authorization") == "Bearer "+token
+
+	// Requests that may have side effects are only retried when that is
+	// allowed, or when they never reached the server
+	retryable := c.config.RetryNonIdempotent || isIdempotent(method) ||
+		(isRaw && raw.idempotent) || req.Header.Get("Idempotency-Key") != ""
+
 	// Execute with retry
-	data, err := c.retryStrategy.Execute(func() ([]byte, error) {
-		return c.executeRequest(req)
+	var resp *response
+	attempt := 0
+	_, err = c.retryStrategy.Execute(ctx, func() ([]byte, error) {
+		// Every attempt after the first needs a fresh copy of the body
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %w", err)
+			}
+			req.Body = body
+		}
+		attempt++
+
+		attemptReq := req
+		if timeout := c.attemptTimeout(req); timeout > 0 {
+			attemptCtx, cancel := context.WithTimeoutCause(ctx, timeout, ErrAttemptTimeout)
+			defer cancel()
+			attemptReq = req.WithContext(attemptCtx)
+		}
+
+		start := time.Now()
+		r, err := c.executeRequest(attemptReq)
+		if canRefresh && replayable && isUnauthorized(err) {
+			canRefresh = false
+			r, err = c.refreshAuth(attemptReq, token, r, err)
+		}
+		c.recordAttempt(attemptReq, r, err, time.Since(start))
+		if err != nil {
+			// Say which deadline cut the attempt short
+			if cause := context.Cause(attemptReq.Context()); errors.Is(cause, ErrAttemptTimeout) || errors.Is(cause, ErrOverallTimeout) {
+				err = fmt.Errorf("%w: %w", cause, err)
+			}
+			// Another attempt can't succeed once the caller has given up,
+			// or without the body that was consumed by this one
+			if ctx.Err() != nil || !replayable || (!retryable && !notSent(err)) {
+				return nil, retry.Stop(err)
+			}
+			if c.config.AIRetryEnabled && c.ai != nil && !c.ai.ShouldRetry(attemptReq, attemptResponse(r, err), attempt-1) {
+				return nil, retry.Stop(err)
+			}
+			return nil, err
+		}
+		resp = r
+		return r.body, nil
 	})
 
 	// Try backup endpoints if primary fails
-	if err != nil && len(c.backupClients) > 0 {
+	if err != nil && len(c.backupClients) > 0 && replayable {
 		for _, backup := range c.backupClients {
-			if backupData, backupErr := backup.do(ctx, method, urlStr, body); backupErr == nil {
-				return backupData, nil
+			c.logger.Infof("failing over method=%s url=%s backup=%s error=%q", method, urlStr, backup.config.BaseURL, err.Error())
+			backupResp, backupErr := backup.doResponse(ctx, method, urlStr, body)
+			if backupErr == nil {
+				return backupResp, nil
 			}
 		}
 	}
 
-	return data, err
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// refreshAuth repeats req with a token from the auth provider after the
+// server rejected the token `rejected`, or returns r and err as they are
+// when the provider has no other token
+func (c *client) refreshAuth(req *http.Request, rejected string, r *response, err error) (*response, error) {
+	token, authErr := c.authorize(req.Context(), req, true)
+	if authErr != nil {
+		return nil, retry.Stop(authErr)
+	}
+	if token == rejected {
+		return r, err
+	}
+	if c.requestSigner != nil {
+		if err := c.requestSigner.SignRequest(req); err != nil {
+			return nil, retry.Stop(fmt.Errorf("request signing failed: %w", err))
+		}
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("rewind request body: %w", err)
+		}
+		req.Body = body
+	}
+	return c.executeRequest(req)
 }
 
-func (c *client) checkIPWhitelist(urlStr string) error {
+func (c *client) waitHostLimiter(ctx context.Context, fullURL string) error {
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	limiter := c.hostLimiter.Limiter(u.Host)
+	if c.config.RateLimitNonBlocking {
+		if !limiter.Allow() {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	}
+	return nil
+}
+
+func (c *client) checkIPWhitelist(ctx context.Context, urlStr string) error {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return err
 	}
 
 	host := u.Hostname()
-	ips, err := net.LookupIP(host)
+	ips, err := c.lookupHost(ctx, host)
 	if err != nil {
 		return fmt.Errorf("failed to resolve host %s: %w", host, err)
 	}
@@ -517,54 +1579,174 @@ func (c *client) checkIPWhitelist(urlStr string) error {
 		}
 	}
 
-	return fmt.Errorf("IP not whitelisted for host %s", host)
+	return fmt.Errorf("%w for host %s", ErrNotWhitelisted, host)
 }
 
-func (c *client) buildURLWithLoadBalancing(urlStr string) (string, error) {
+// lookupHost resolves host through the configured resolver and DNS cache
+func (c *client) lookupHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	var resolver dns.Resolver = net.DefaultResolver
+	if c.config.Resolver != nil {
+		resolver = c.config.Resolver
+	}
+	if c.config.DNSCache != nil {
+		return c.config.DNSCache.Lookup(ctx, resolver, host)
+	}
+	return resolver.LookupIPAddr(ctx, host)
+}
+
+// buildURLWithLoadBalancing resolves urlStr against the next load balancer
+// endpoint or the base URL. release must be called once the request has
+// finished so the load balancer can track in-flight requests.
+func (c *client) buildURLWithLoadBalancing(ctx context.Context, method, urlStr string) (fullURL string, release func(), err error) {
+	release = func() {}
+	if c.loadBalancerErr != nil {
+		return "", release, c.loadBalancerErr
+	}
+
 	// Use load balancer if configured
 	if c.loadBalancer != nil {
-		endpoint := c.loadBalancer.NextEndpoint()
+		endpoint := c.nextEndpoint(ctx, method, urlStr)
 		if endpoint != "" {
-			base, err := url.Parse(endpoint)
-			if err != nil {
-				return "", err
+			var once sync.Once
+			release = func() {
+				once.Do(func() { c.loadBalancer.ReleaseEndpoint(endpoint) })
 			}
-			rel, err := url.Parse(urlStr)
+
+			fullURL, err := resolveURL(endpoint, urlStr)
 			if err != nil {
-				return "", err
+				release()
+				return "", func() {}, err
 			}
-			return base.ResolveReference(rel).String(), nil
+			return fullURL, release, nil
 		}
 	}
 
 	// Fallback to base URL
 	if c.config.BaseURL == "" {
-		return urlStr, nil
+		return urlStr, release, nil
 	}
+	fullURL, err = resolveURL(c.config.BaseURL, urlStr)
+	return fullURL, release, err
+}
 
-	base, err := url.Parse(c.config.BaseURL)
+// resolveURL joins ref onto base. Unlike plain RFC 3986 resolution the
+// base path is kept as a prefix, so base "https://host/api" with "users" or
+// "/users" gives "https://host/api/users", and the query parameters of both
+// are kept, ref's replacing base's of the same name. Absolute URLs are used
+// as is.
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
 	if err != nil {
 		return "", err
 	}
+	rel, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if rel.IsAbs() || rel.Host != "" {
+		return baseURL.ResolveReference(rel).String(), nil
+	}
+
+	if rel.Path != "" {
+		baseURL.Path = strings.TrimRight(baseURL.Path, "/") + "/"
+		if baseURL.RawPath != "" {
+			baseURL.RawPath = strings.TrimRight(baseURL.RawPath, "/") + "/"
+		}
+	}
+	rel.Path = strings.TrimLeft(rel.Path, "/")
+	rel.RawPath = strings.TrimLeft(rel.RawPath, "/")
+	resolved := baseURL.ResolveReference(rel)
+	resolved.RawQuery = mergeQuery(baseURL.RawQuery, rel.RawQuery)
+	return resolved.String(), nil
+}
+
+// mergeQuery adds the parameters of the query ref to those of base, ref's
+// values replacing base's for the same name
+func mergeQuery(base, ref string) string {
+	if base == "" || ref == "" {
+		return base + ref
+	}
+	values, err := url.ParseQuery(base)
+	if err != nil {
+		return base + "&" + ref
+	}
+	refValues, err := url.ParseQuery(ref)
+	if err != nil {
+		return base + "&" + ref
+	}
+	for name, v := range refValues {
+		values[name] = v
+	}
+	return values.Encode()
+}
+
+// nextEndpoint asks the load balancer for an endpoint, passing the request
+// key to load balancers that route by key
+func (c *client) nextEndpoint(ctx context.Context, method, urlStr string) string {
+	keyed, ok := c.loadBalancer.(loadbalancer.KeyedLoadBalancer)
+	if !ok {
+		return c.loadBalancer.NextEndpoint()
+	}
 
 	rel, err := url.Parse(urlStr)
 	if err != nil {
-		return "", err
+		return keyed.NextEndpoint()
+	}
+	if c.config.LoadBalancerKey == nil {
+		return keyed.NextEndpointForKey(rel.Path)
 	}
 
-	return base.ResolveReference(rel).String(), nil
+	// The key func sees the request before it is resolved against an
+	// endpoint: its method, the URL as passed, the client's headers and ctx
+	req := (&http.Request{
+		Method: method,
+		URL:    rel,
+		Header: make(http.Header),
+	}).WithContext(ctx)
+	for key, value := range c.config.Headers {
+		req.Header.Set(key, value)
+	}
+	return keyed.NextEndpointForKey(c.config.LoadBalancerKey(req))
+}
+
+// WithLoadBalancerKey sets how the "consistent-hash" load balancer derives
+// a request's key; requests with the same key go to the same endpoint. fn
+// gets the request before it is resolved against an endpoint, with its
+// method, the URL as passed, the client's headers and the context. The
+// default key is the URL path.
+func (c *client) WithLoadBalancerKey(fn func(*http.Request) string) *client {
+	newConfig := c.config.Clone()
+	newConfig.LoadBalancerKey = fn
+	return New(newConfig)
+}
+
+// EndpointConnections returns the number of in-flight requests per load
+// balancer endpoint, or nil when the load balancing strategy doesn't track
+// them (only "least-conn" does)
+func (c *client) EndpointConnections() map[string]int64 {
+	if counter, ok := c.loadBalancer.(loadbalancer.ConnectionCounter); ok {
+		return counter.Connections()
+	}
+	return nil
 }
 
 func (c *client) setHeaders(req *http.Request, hasBody bool) {
 	// Set default headers
 	req.Header.Set("User-Agent", c.config.UserAgent)
-	
+
 	if c.config.CompressionEnabled {
-		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		req.Header.Set("Accept-Encoding", acceptEncoding())
 	}
 
 	if hasBody {
 		req.Header.Set("Content-Type", "application/json")
+		if c.config.ExpectContinue {
+			req.Header.Set("Expect", "100-continue")
+		}
 	}
 
 	// Set custom headers
@@ -573,87 +1755,344 @@ func (c *client) setHeaders(req *http.Request, hasBody bool) {
 	}
 }
 
-func (c *client) executeRequest(req *http.Request) ([]byte, error) {
-	// Apply middlewares
-	for _, mw := range c.middlewares {
-		if err := mw.Before(req); err != nil {
-			return nil, err
+func (c *client) executeRequest(req *http.Request) (*response, error) {
+	var resp *http.Response
+
+	// Serve from cache when a fresh entry exists
+	if c.cache != nil {
+		if cached, ok := c.cache.GetCachedResponse(req); ok {
+			resp = cached.Response(req)
+			closeBody(req)
 		}
 	}
-
-	// Execute request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	if resp == nil && middleware.CacheModeOf(req) == middleware.CacheModeOnlyIfCached {
+		closeBody(req)
+		return nil, retry.Stop(ErrCacheMiss)
 	}
-	defer resp.Body.Close()
 
-	// Apply middlewares
-	for _, mw := range c.middlewares {
-		mw.After(resp)
+	if resp == nil {
+		// Apply middlewares
+		for _, mw := range c.middlewares {
+			if err := mw.Before(req); err != nil {
+				closeBody(req)
+				return nil, err
+			}
+		}
+
+		// Execute request
+		var err error
+		if c.flights != nil {
+			resp, err = c.flights.do(req, c.sendLimited)
+		} else {
+			resp, err = c.sendLimited(req)
+		}
+		// Coalesced requests read the shared body up front
+		if errors.Is(err, ErrResponseTooLarge) {
+			return nil, retry.Stop(fmt.Errorf("read response: %w", err))
+		}
+		if err != nil {
+			for i := len(c.middlewares) - 1; i >= 0; i-- {
+				if observer, ok := c.middlewares[i].(middleware.ErrorObserver); ok {
+					observer.OnError(req, err)
+				}
+			}
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+
+		// Unwind the middlewares in reverse, so the first one sees the
+		// response last, like nested handlers
+		for i := len(c.middlewares) - 1; i >= 0; i-- {
+			c.middlewares[i].After(resp)
+		}
 	}
+	// Keep a handle on the underlying body: decoders wrap it, and it has to
+	// be drained before closing for the connection to be reused, including
+	// when decoding fails part way
+	rawBody := resp.Body
+	defer drainAndClose(rawBody)
 
 	// Handle compressed responses
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("gzip decompression failed: %w", err)
+	if err := decodeBody(resp); err != nil {
+		return nil, err
+	}
+	if resp.Body != rawBody {
+		defer resp.Body.Close()
+		// A small compressed body can inflate to any size
+		if c.config.MaxResponseSize > 0 {
+			resp.Body = limitBody(resp.Body, c.config.MaxResponseSize)
 		}
-		defer gzipReader.Close()
-		resp.Body = gzipReader
 	}
 
 	// Read response
-	data, err := io.ReadAll(resp.Body)
+	data, err := readBody(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+		err = fmt.Errorf("read response: %w", err)
+		// The same response would be too large again
+		if errors.Is(err, ErrResponseTooLarge) {
+			return nil, retry.Stop(err)
+		}
+		return nil, err
 	}
 
-	// Apply response interceptors
+	// Apply response interceptors. They run for every status and can read
+	// the body, or replace it and the status to handle an error response.
+	body := io.NopCloser(bytes.NewReader(data))
+	resp.Body = body
 	for _, interceptor := range c.config.ResponseInterceptors {
 		if err := interceptor(resp); err != nil {
 			return nil, fmt.Errorf("response interceptor failed: %w", err)
 		}
 	}
+	if resp.Body != body {
+		data, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read intercepted response: %w", err)
+		}
+	}
 
 	// Check status code
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(data))
+		return nil, &retry.APIError{
+			StatusCode: resp.StatusCode,
+			Body:       data,
+			Headers:    resp.Header.Clone(),
+		}
+	}
+
+	return &response{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       data,
+	}, nil
+}
+
+// closeBody closes the body of a request that fails before it is sent,
+// which the transport does for one it sends: a streamed body holds a
+// writing goroutine and its files until closed
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// sendLimited sends req like send, failing reads of the response body
+// with ErrResponseTooLarge past MaxResponseSize
+func (c *client) sendLimited(req *http.Request) (*http.Response, error) {
+	resp, err := c.send(req)
+	if err == nil && c.config.MaxResponseSize > 0 {
+		resp.Body = limitBody(resp.Body, c.config.MaxResponseSize)
+	}
+	return resp, err
+}
+
+// send performs req. When an idempotent request fails because the server
+// closed a reused keep-alive connection before responding, it is retried
+// once on a fresh connection. Such a failure says nothing about the health
+// of the server, so it is neither surfaced nor counted by the retry
+// strategy or the circuit breaker.
+func (c *client) send(req *http.Request) (*http.Response, error) {
+	var reused bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil || !reused || !isIdempotent(req.Method) || !isConnectionClosed(err) {
+		return resp, err
+	}
+
+	retryReq := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		retryReq.Body = body
+	}
+
+	// Other idle connections to the same server are most likely stale as
+	// well. A custom transport is this client's own, so drop them to make
+	// sure the retry dials a new one; a shared one isn't, so send the retry
+	// on a connection of its own instead.
+	if c.tcpTransport == nil {
+		c.httpClient.CloseIdleConnections()
+		return c.httpClient.Do(retryReq)
+	}
+	fresh := *c.httpClient
+	fresh.Transport = c.freshTransport()
+	return fresh.Do(retryReq)
+}
+
+// isIdempotent reports whether method is idempotent as defined by RFC 9110
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// newIdempotencyKey returns a random version 4 UUID
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate idempotency key: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// notSent reports whether err means the request never left the client, as
+// the connection couldn't be established, so it can't have had any effect
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isConnectionClosed reports whether err means the server closed the
+// connection without sending a response
+func isConnectionClosed(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// environmentProxy selects a proxy from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// like http.ProxyFromEnvironment, but reads the environment when the client
+// is built rather than once per process. It applies to the final request
+// URL, so NO_PROXY exclusions also cover load-balanced and backup endpoints.
+func environmentProxy() func(*http.Request) (*url.URL, error) {
+	proxyFunc := httpproxy.FromEnvironment().ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
 	}
+}
 
-	return data, nil
+// configureProxy wires cfg.ProxyURL into the transport. HTTP(S) proxies go
+// through Transport.Proxy with credentials from the URL userinfo sent as
+// Proxy-Authorization on CONNECT; SOCKS5 proxies replace the dialer. It
+// returns an ErrInvalidProxy error when no dialer can be made for the
+// proxy.
+func configureProxy(transport *http.Transport, cfg *config.Config) error {
+	u := cfg.ProxyURL
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		forward := &net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: cfg.KeepAlive,
+		}
+		dialer, err := proxy.FromURL(u, forward)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+		}
+		transport.Proxy = nil
+		if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+			transport.DialContext = contextDialer.DialContext
+		} else {
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			}
+		}
+	default:
+		transport.Proxy = http.ProxyURL(u)
+		if u.User != nil {
+			password, _ := u.User.Password()
+			credentials := u.User.Username() + ":" + password
+			transport.ProxyConnectHeader = http.Header{
+				"Proxy-Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))},
+			}
+		}
+	}
+	return nil
 }
 
-// Compression transport wrapper
+// Compression transport wrapper. Request bodies of at least minSize bytes
+// are gzipped unless they already have a Content-Encoding or a content
+// type that is compressed anyway. The caller's request is never modified.
 type compressionTransport struct {
-	base http.RoundTripper
+	base    http.RoundTripper
+	minSize int
 }
 
 func (ct *compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if req.Body != nil && req.Header.Get("Content-Encoding") == "" {
+	if req.Body == nil || req.Body == http.NoBody ||
+		req.Header.Get("Content-Encoding") != "" ||
+		isCompressedContentType(req.Header.Get("Content-Type")) {
+		return ct.base.RoundTrip(req)
+	}
+
+	bodyBytes, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	clone := req.Clone(req.Context())
+	if len(bodyBytes) >= ct.minSize {
 		// Compress request body
 		var buf bytes.Buffer
 		gzipWriter := gzip.NewWriter(&buf)
-		
-		bodyBytes, err := io.ReadAll(req.Body)
-		if err != nil {
-			return nil, err
-		}
-		req.Body.Close()
-		
 		if _, err := gzipWriter.Write(bodyBytes); err != nil {
 			return nil, err
 		}
 		if err := gzipWriter.Close(); err != nil {
 			return nil, err
 		}
-		
-		req.Body = io.NopCloser(&buf)
-		req.Header.Set("Content-Encoding", "gzip")
-		req.ContentLength = int64(buf.Len())
+
+		bodyBytes = buf.Bytes()
+		clone.Header.Set("Content-Encoding", "gzip")
 	}
-	
-	return ct.base.RoundTrip(req)
+
+	clone.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+	}
+	clone.ContentLength = int64(len(bodyBytes))
+
+	return ct.base.RoundTrip(clone)
+}
+
+func (ct *compressionTransport) CloseIdleConnections() {
+	if closer, ok := ct.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// isCompressedContentType reports whether contentType is a format that is
+// already compressed, so gzipping it would only add overhead
+func isCompressedContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/zip",
+		"application/zstd", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/vnd.rar", "application/x-rar-compressed":
+		return true
+	}
+	return false
 }
 
 // Health checker implementation
@@ -691,12 +2130,12 @@ func (hc *HealthChecker) checkEndpoints() {
 
 func (hc *HealthChecker) checkEndpoint(ep *EndpointHealth) {
 	resp, err := hc.client.Get(ep.URL)
-	
+
 	hc.mu.Lock()
 	defer hc.mu.Unlock()
-	
+
 	ep.LastCheck = time.Now()
-	
+
 	if err != nil || resp.StatusCode >= 400 {
 		ep.Healthy = false
 		atomic.AddInt64(&ep.Failures, 1)
@@ -704,7 +2143,7 @@ func (hc *HealthChecker) checkEndpoint(ep *EndpointHealth) {
 		ep.Healthy = true
 		atomic.StoreInt64(&ep.Failures, 0)
 	}
-	
+
 	if resp != nil {
 		resp.Body.Close()
 	}
@@ -731,7 +2170,7 @@ func NewRequestSigner(keyID, privateKeyPEM string) (*RequestSigner, error) {
 func (rs *RequestSigner) SignRequest(req *http.Request) error {
 	// Create signature string
 	sigString := rs.createSignatureString(req)
-	
+
 	// Sign the string
 	hash := sha256.Sum256([]byte(sigString))
 	signature, err := rsa.SignPKCS1v15(rand.Reader, rs.privateKey, crypto.SHA256, hash[:])
@@ -749,24 +2188,24 @@ func (rs *RequestSigner) SignRequest(req *http.Request) error {
 
 func (rs *RequestSigner) createSignatureString(req *http.Request) string {
 	var parts []string
-	
+
 	// Add method and path
-	parts = append(parts, fmt.Sprintf("(request-target): %s %s", 
+	parts = append(parts, fmt.Sprintf("(request-target): %s %s",
 		strings.ToLower(req.Method), req.URL.RequestURI()))
-	
+
 	// Add headers in alphabetical order
 	var headerNames []string
 	for name := range req.Header {
 		headerNames = append(headerNames, strings.ToLower(name))
 	}
 	sort.Strings(headerNames)
-	
+
 	for _, name := range headerNames {
 		if name == "signature" {
 			continue
 		}
 		parts = append(parts, fmt.Sprintf("%s: %s", name, req.Header.Get(name)))
 	}
-	
+
 	return strings.Join(parts, "\n")
-}
\ No newline at end of file
+}
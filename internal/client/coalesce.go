@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yourorg/httpclient/internal/middleware"
+)
+
+// noCoalescingKey marks a request context as opted out of coalescing
+type noCoalescingKey struct{}
+
+// WithoutCoalescing returns a context whose requests always go upstream on
+// their own, even on a client with request coalescing enabled
+func WithoutCoalescing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCoalescingKey{}, true)
+}
+
+// coalescingExempt lists request headers that differ between otherwise
+// identical requests without changing the response
+var coalescingExempt = map[string]bool{
+	"Traceparent":  true,
+	"Tracestate":   true,
+	"X-Request-Id": true,
+}
+
+// flight is an upstream request shared by identical requests
+type flight struct {
+	done chan struct{}
+	resp *middleware.CachedResponse
+	err  error
+}
+
+// flightGroup coalesces identical concurrent requests, like singleflight
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do performs req with send, unless an identical request is already in
+// flight, in which case it waits for that one's response. Only bodiless GET
+// and HEAD requests are shared.
+func (g *flightGroup) do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	key, ok := coalescingKey(req)
+	if !ok {
+		return send(req)
+	}
+
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		// The request that went upstream may have been canceled by its own
+		// caller, which says nothing about this one
+		if f.err != nil && (errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded)) && req.Context().Err() == nil {
+			return send(req)
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.resp.Response(req), nil
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.resp, f.err = bufferResponse(send(req))
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.resp.Response(req), nil
+}
+
+// bufferResponse reads the body of resp so it can be handed to every
+// request sharing it
+func bufferResponse(resp *http.Response, err error) (*middleware.CachedResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(resp.Body)
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &middleware.CachedResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// coalescingKey identifies the requests that can share a response: same
+// method and URL, and the same headers apart from coalescingExempt, as the
+// server may vary the response on any of them
+func coalescingKey(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return "", false
+	}
+	if skip, _ := req.Context().Value(noCoalescingKey{}).(bool); skip {
+		return "", false
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if !coalescingExempt[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(req.Method + " " + req.URL.String())
+	for _, name := range names {
+		b.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(req.Header[name], ", "))
+	}
+	return b.String(), true
+}
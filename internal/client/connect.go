@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Connect opens a TCP connection to host ("host:port") through the proxy
+// the client would use for https://host, using an HTTP CONNECT tunnel for
+// HTTP(S) proxies. SOCKS5 proxies and direct connections, when no proxy
+// applies, are dialed as usual.
+func (c *client) Connect(host string) (net.Conn, error) {
+	return c.ConnectContext(context.Background(), host)
+}
+
+func (c *client) ConnectContext(ctx context.Context, host string) (net.Conn, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return nil, fmt.Errorf("invalid host %q: %w", host, err)
+	}
+
+	dial := (&net.Dialer{Timeout: c.config.DialTimeout, KeepAlive: c.config.KeepAlive}).DialContext
+	var proxyFunc func(*http.Request) (*url.URL, error)
+	var tlsConfig *tls.Config
+	if t := c.tcpTransport; t != nil {
+		if t.DialContext != nil {
+			dial = t.DialContext
+		}
+		proxyFunc = t.Proxy
+		tlsConfig = t.TLSClientConfig
+	}
+
+	var proxyURL *url.URL
+	if proxyFunc != nil {
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Scheme: "https", Host: host},
+			Header: make(http.Header),
+		}
+		u, err := proxyFunc(req)
+		if err != nil {
+			return nil, fmt.Errorf("select proxy: %w", err)
+		}
+		proxyURL = u
+	}
+
+	if proxyURL == nil {
+		return dial(ctx, "tcp", host)
+	}
+	return c.connectViaProxy(ctx, dial, tlsConfig, proxyURL, host)
+}
+
+// connectViaProxy establishes a CONNECT tunnel to host through proxyURL
+func (c *client) connectViaProxy(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), tlsConfig *tls.Config, proxyURL *url.URL, host string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy: %w", err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		cfg.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	// Abort the handshake if ctx is done before the proxy answers
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: http.Header{"User-Agent": {c.config.UserAgent}},
+	}
+	if c.tcpTransport != nil {
+		for key, values := range c.tcpTransport.ProxyConnectHeader {
+			req.Header[key] = values
+		}
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("send CONNECT: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+	// A successful response has no body; the tunnel starts right after
+	// the headers, so resp.Body must not be read or closed
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", host, resp.Status)
+	}
+
+	if !stop() {
+		// ctx was cancelled and the deadline already set
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent right after its CONNECT
+// response before reading from the connection itself
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/yourorg/httpclient/internal/config"
+)
+
+// ErrUnexpectedContentType is returned when a response has a Content-Type
+// that can't be decoded into the requested result
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// maxSnippetSize caps the body bytes kept in a ContentTypeError
+const maxSnippetSize = 512
+
+// DecodeFunc decodes a response body into result
+type DecodeFunc func(data []byte, result interface{}) error
+
+var (
+	customDecodersMu sync.RWMutex
+	customDecoders   = make(map[string]DecodeFunc)
+)
+
+// RegisterDecoder makes JSON and JSONContext decode responses of mediaType
+// with fn. It takes precedence over the built-in decoders; a nil fn removes it.
+func RegisterDecoder(mediaType string, fn DecodeFunc) {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	customDecodersMu.Lock()
+	defer customDecodersMu.Unlock()
+
+	if fn == nil {
+		delete(customDecoders, mediaType)
+		return
+	}
+	customDecoders[mediaType] = fn
+}
+
+// jsonCodec is the Codec of clients without one, encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// codecOf returns the Codec of cfg, encoding/json when it has none
+func codecOf(cfg *config.Config) config.Codec {
+	if cfg.Codec != nil {
+		return cfg.Codec
+	}
+	return jsonCodec{}
+}
+
+// acceptHeader lists mediaTypes in an Accept header, most preferred first,
+// weighting each with a lower q-value than the one before. A media type
+// that already has a q parameter keeps it.
+func acceptHeader(mediaTypes ...string) string {
+	// q-values have at most three decimals and 0 means not acceptable, so
+	// long lists get closer steps
+	step := 100
+	if len(mediaTypes) > 10 {
+		step = 1000 / len(mediaTypes)
+	}
+
+	parts := make([]string, 0, len(mediaTypes))
+	for i, mediaType := range mediaTypes {
+		mediaType = strings.TrimSpace(mediaType)
+		if i == 0 || strings.Contains(mediaType, ";q=") {
+			parts = append(parts, mediaType)
+			continue
+		}
+		q := 1000 - i*step
+		parts = append(parts, mediaType+";q="+strings.TrimRight(fmt.Sprintf("0.%03d", q), "0"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ContentTypeError describes a response whose Content-Type doesn't match
+// what the caller asked for, e.g. an HTML error page from a proxy where JSON
+// was expected. It matches ErrUnexpectedContentType.
+type ContentTypeError struct {
+	ContentType string
+	Target      string // the type of the result
+	Body        []byte // the first 512 bytes of the body
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("%v %q for %s: %q", ErrUnexpectedContentType, e.ContentType, e.Target, e.Body)
+}
+
+func (e *ContentTypeError) Unwrap() error {
+	return ErrUnexpectedContentType
+}
+
+// decodeContent decodes data into result according to the Content-Type in
+// header, JSON with codec. JSON is assumed when there's no Content-Type.
+func decodeContent(header http.Header, data []byte, result interface{}, codec config.Codec) error {
+	contentType := header.Get("Content-Type")
+	mediaType := "application/json"
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return contentTypeError(contentType, data, result)
+		}
+		mediaType = parsed
+	}
+
+	customDecodersMu.RLock()
+	decode := customDecoders[mediaType]
+	customDecodersMu.RUnlock()
+	if decode != nil {
+		return decode(data, result)
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return codec.Unmarshal(data, result)
+
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return xml.Unmarshal(data, result)
+
+	case strings.HasPrefix(mediaType, "text/"):
+		switch target := result.(type) {
+		case *string:
+			*target = string(data)
+			return nil
+		case *[]byte:
+			*target = append([]byte(nil), data...)
+			return nil
+		}
+		// Plenty of servers send JSON as text/plain, including Go's own
+		// content sniffing when no Content-Type is set
+		if json.Valid(data) {
+			return codec.Unmarshal(data, result)
+		}
+	}
+
+	return contentTypeError(contentType, data, result)
+}
+
+func contentTypeError(contentType string, data []byte, result interface{}) error {
+	if len(data) > maxSnippetSize {
+		data = data[:maxSnippetSize]
+	}
+	return &ContentTypeError{
+		ContentType: contentType,
+		Target:      reflect.TypeOf(result).String(),
+		Body:        append([]byte(nil), data...),
+	}
+}
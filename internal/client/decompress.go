@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned for responses with a Content-Encoding
+// the client has no decoder for
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decoder wraps a compressed stream in a reader of the decoded bytes
+type decoder func(r io.Reader) (io.ReadCloser, error)
+
+// decoders holds a decoder per Content-Encoding token. Brotli and zstd
+// register themselves when built with the brotli and zstd tags.
+var decoders = map[string]decoder{
+	"gzip":    newGzipDecoder,
+	"x-gzip":  newGzipDecoder,
+	"deflate": newDeflateDecoder,
+}
+
+// encodingPreference orders the encodings advertised in Accept-Encoding
+var encodingPreference = []string{"zstd", "br", "gzip", "deflate"}
+
+// acceptEncoding lists the encodings the client can decode
+func acceptEncoding() string {
+	var encodings []string
+	for _, encoding := range encodingPreference {
+		if _, ok := decoders[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// decodeBody replaces resp.Body with a reader of the decoded content when
+// the response has a Content-Encoding, undoing the encodings in reverse
+// order. Content-Encoding and Content-Length are removed since they no
+// longer describe the body.
+func decodeBody(resp *http.Response) error {
+	header := resp.Header.Get("Content-Encoding")
+	if header == "" {
+		return nil
+	}
+
+	encodings := strings.Split(header, ",")
+	var body io.Reader = resp.Body
+	var decoded decodedBody
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		decode, ok := decoders[encoding]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+		}
+		layer, err := decode(body)
+		if err != nil {
+			decoded.Close()
+			return fmt.Errorf("%s decompression failed: %w", encoding, err)
+		}
+		decoded.decoders = append(decoded.decoders, layer)
+		body = layer
+	}
+	if len(decoded.decoders) == 0 {
+		return nil
+	}
+
+	decoded.Reader = body
+	resp.Body = &decoded
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody reads the innermost decoder. Closing it closes every decoder
+// but not the underlying response body, which stays with the caller.
+type decodedBody struct {
+	io.Reader
+	decoders []io.ReadCloser
+}
+
+func (d *decodedBody) Close() error {
+	var firstErr error
+	for i := len(d.decoders) - 1; i >= 0; i-- {
+		if err := d.decoders[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// maxDrainBytes bounds how much of an unread body is discarded to keep the
+// connection reusable; larger remainders are cheaper to drop with the
+// connection
+const maxDrainBytes = 256 << 10
+
+// drainAndClose discards what is left of body and closes it
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+func newGzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// newDeflateDecoder handles "deflate" as specified (zlib framing) as well
+// as the raw deflate streams some servers send instead
+func newDeflateDecoder(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zlib header has compression method 8 and a checksum over both bytes
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
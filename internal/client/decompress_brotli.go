@@ -0,0 +1,15 @@
+//go:build brotli
+
+package client
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+func init() {
+	decoders["br"] = func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(r)), nil
+	}
+}
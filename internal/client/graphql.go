@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/yourorg/httpclient/internal/graphql"
+	"github.com/yourorg/httpclient/internal/retry"
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+// GraphQL posts query to the GraphQL endpoint and decodes the data of the
+// response into result. The endpoint is config.GraphQLEndpoint, resolved
+// against the base URL, or the base URL itself.
+func (c *client) GraphQL(query string, variables map[string]interface{}, result interface{}) error {
+	return c.GraphQLOp(context.Background(), "", query, variables, result)
+}
+
+func (c *client) GraphQLContext(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	return c.GraphQLOp(ctx, "", query, variables, result)
+}
+
+// GraphQLOp runs the operation named opName from query, which may define
+// several. The request goes through the same headers, interceptors and
+// retries as other requests. Errors reported by the server are returned as
+// *graphql.GraphQLErrors, with any partial data still decoded into result;
+// other failures return the transport error or an *APIError.
+func (c *client) GraphQLOp(ctx context.Context, opName, query string, variables map[string]interface{}, result interface{}) error {
+	return c.gql.QueryOpContext(ctx, opName, query, variables, result)
+}
+
+// GraphQLBatch sends several operations in one HTTP request, for servers
+// that accept an array of operations, and decodes the data of requests[i]
+// into results[i]. When operations fail the others are still decoded and
+// a *graphql.BatchError is returned. The batch is only retried when all of
+// its operations are queries.
+func (c *client) GraphQLBatch(ctx context.Context, requests []graphql.GraphQLRequest, results []interface{}) error {
+	return c.gql.QueryBatchContext(ctx, requests, results)
+}
+
+// GraphQLSubscribe starts the subscription named opName from query, which
+// may be empty, over a graphql-transport-ws WebSocket to the GraphQL
+// endpoint. The handshake carries the same headers and auth as other
+// requests; WithGraphQLInitPayload sets the payload of connection_init.
+// All subscriptions of the client share one socket, closed along with the
+// last of them. ctx bounds connecting; the subscription runs until Close.
+func (c *client) GraphQLSubscribe(ctx context.Context, opName, query string, variables map[string]interface{}) (*graphql.GraphQLSubscription, error) {
+	return c.gql.SubscribeContext(ctx, opName, query, variables)
+}
+
+// graphQLDo is the graphql.DoFunc of c, which posts to the GraphQL endpoint
+// through the same request path as other requests
+func (c *client) graphQLDo(ctx context.Context, payload []byte, idempotent bool) (int, []byte, error) {
+	body := &rawBody{contentType: "application/json", accept: "application/json", data: payload, idempotent: idempotent}
+	resp, err := c.doResponse(ctx, "POST", c.config.GraphQLEndpoint, body)
+	if err != nil {
+		var apiErr *retry.APIError
+		if errors.As(err, &apiErr) {
+			return apiErr.StatusCode, apiErr.Body, err
+		}
+		return 0, nil, err
+	}
+	return resp.statusCode, resp.body, nil
+}
+
+// postGraphQL sends body to the GraphQL endpoint and decodes the response
+// into result
+func (c *client) postGraphQL(ctx context.Context, body *rawBody, result interface{}) error {
+	resp, err := c.doResponse(ctx, "POST", c.config.GraphQLEndpoint, body)
+	if err != nil {
+		// Servers differ in the status they use for GraphQL errors
+		var apiErr *retry.APIError
+		if errors.As(err, &apiErr) {
+			var gqlResp graphql.GraphQLResponse
+			if json.Unmarshal(apiErr.Body, &gqlResp) == nil && len(gqlResp.Errors) > 0 {
+				return gqlResp.Decode(result)
+			}
+		}
+		return err
+	}
+
+	var gqlResp graphql.GraphQLResponse
+	if err := json.Unmarshal(resp.body, &gqlResp); err != nil {
+		return fmt.Errorf("failed to decode GraphQL response: %w", err)
+	}
+	return gqlResp.Decode(result)
+}
+
+// dialGraphQL is the graphql.DialFunc of c, which opens the subscription
+// socket like WebSocket does
+func (c *client) dialGraphQL(ctx context.Context, endpoint, subprotocol string) (*streaming.WebSocketConn, error) {
+	return c.dialWebSocket(ctx, endpoint, &streaming.WebSocketOptions{Subprotocols: []string{subprotocol}})
+}
+
+// WithGraphQLEndpoint sets the endpoint GraphQL requests are posted to,
+// resolved against the base URL
+func (c *client) WithGraphQLEndpoint(endpoint string) *client {
+	newConfig := c.config.Clone()
+	newConfig.GraphQLEndpoint = endpoint
+	return New(newConfig)
+}
+
+// WithGraphQLPersistedQueries enables Automatic Persisted Queries: GraphQL
+// requests carry only the SHA-256 hash of the query, and the full text
+// follows once when the server doesn't know the hash yet. Servers without
+// support for them get full queries from then on.
+func (c *client) WithGraphQLPersistedQueries(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.GraphQLPersistedQueries = enabled
+	return New(newConfig)
+}
+
+// WithGraphQLValidation validates GraphQL queries and their variables
+// against the server's schema before they are sent, so typos and missing
+// required variables fail locally with a *graphql.ValidationError. The
+// schema is introspected on first use and cached by the client.
+func (c *client) WithGraphQLValidation(enabled bool) *client {
+	newConfig := c.config.Clone()
+	newConfig.GraphQLValidation = enabled
+	return New(newConfig)
+}
+
+// WithGraphQLInitPayload sets the payload of the connection_init message
+// that opens the socket of GraphQLSubscribe, where servers expect
+// credentials
+func (c *client) WithGraphQLInitPayload(payload map[string]interface{}) *client {
+	newConfig := c.config.Clone()
+	newConfig.GraphQLInitPayload = payload
+	return New(newConfig)
+}
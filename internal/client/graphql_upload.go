@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourorg/httpclient/internal/graphql"
+)
+
+// Upload is a file sent with GraphQLUpload. A Reader that is also an
+// io.Seeker is rewound when the request is retried; any other reader can be
+// sent only once, so a failed upload isn't retried.
+type Upload struct {
+	Reader      io.Reader
+	Filename    string
+	ContentType string // application/octet-stream when empty
+}
+
+// GraphQLUpload runs query with files attached following the GraphQL
+// multipart request spec (https://github.com/jaydenseric/graphql-multipart-request-spec).
+// The keys of files are dotted paths into variables, like "file" or
+// "input.files.1", and the value at each path is replaced with null. The
+// body is streamed, so files of any size are not held in memory.
+func (c *client) GraphQLUpload(ctx context.Context, query string, variables map[string]interface{}, files map[string]Upload, result interface{}) error {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	vars := variables
+	fileMap := make(map[string][]string, len(keys))
+	uploads := make([]Upload, len(keys))
+	for i, key := range keys {
+		var err error
+		if vars, err = setNull(vars, strings.Split(key, ".")); err != nil {
+			return fmt.Errorf("GraphQL upload %q: %w", key, err)
+		}
+		fileMap[strconv.Itoa(i)] = []string{"variables." + key}
+		uploads[i] = files[key]
+	}
+
+	operations, err := json.Marshal(graphql.GraphQLRequest{Query: query, Variables: vars})
+	if err != nil {
+		return fmt.Errorf("failed to marshal GraphQL request: %w", err)
+	}
+	mapping, err := json.Marshal(fileMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal GraphQL upload map: %w", err)
+	}
+
+	// Every attempt has to send the same Content-Type, boundary included
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Errorf("generate multipart boundary: %w", err)
+	}
+	boundary := hex.EncodeToString(random[:])
+
+	// Remember where seekable files start so retries can rewind them
+	replayable := true
+	offsets := make([]int64, len(uploads))
+	for i, u := range uploads {
+		if u.Reader == nil {
+			return fmt.Errorf("GraphQL upload %q: nil reader", keys[i])
+		}
+		seeker, ok := u.Reader.(io.Seeker)
+		if !ok {
+			replayable = false
+			continue
+		}
+		if offsets[i], err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			replayable = false
+		}
+	}
+
+	var prev *io.PipeReader
+	var prevDone chan struct{}
+	stream := func() (io.Reader, error) {
+		if prev != nil {
+			// Stop the last attempt's writer before touching the files
+			prev.CloseWithError(errUploadRestarted)
+			<-prevDone
+			for i, u := range uploads {
+				if _, err := u.Reader.(io.Seeker).Seek(offsets[i], io.SeekStart); err != nil {
+					return nil, fmt.Errorf("rewind upload %q: %w", keys[i], err)
+				}
+			}
+		}
+
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pw.CloseWithError(writeUploadBody(pw, boundary, operations, mapping, uploads))
+		}()
+		prev, prevDone = pr, done
+		return pr, nil
+	}
+
+	body := &rawBody{
+		contentType: "multipart/form-data; boundary=" + boundary,
+		accept:      "application/json",
+		stream:      stream,
+		replayable:  replayable,
+	}
+	return c.postGraphQL(ctx, body, result)
+}
+
+// writeUploadBody writes the operations, map and file parts in the order
+// the spec requires
+func writeUploadBody(w io.Writer, boundary string, operations, mapping []byte, uploads []Upload) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	if err := mw.WriteField("operations", string(operations)); err != nil {
+		return err
+	}
+	if err := mw.WriteField("map", string(mapping)); err != nil {
+		return err
+	}
+
+	for i, u := range uploads {
+		contentType := u.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%d"; filename="%s"`, i, escapeQuotes(u.Filename)))
+		header.Set("Content-Type", contentType)
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, u.Reader); err != nil {
+			return fmt.Errorf("read upload %d: %w", i, err)
+		}
+	}
+	return mw.Close()
+}
+
+var errUploadRestarted = errors.New("upload restarted")
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// setNull returns a copy of v with the value at path set to null, copying
+// only the maps and slices along the path so the caller's variables are
+// left untouched
+func setNull(v interface{}, path []string) (map[string]interface{}, error) {
+	out, err := setNullAt(v, path)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := out.(map[string]interface{})
+	return m, nil
+}
+
+func setNullAt(v interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	key := path[0]
+
+	switch node := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node)+1)
+		for k, val := range node {
+			copied[k] = val
+		}
+		if len(path) > 1 {
+			child, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("no variable %q", key)
+			}
+			var err error
+			if copied[key], err = setNullAt(child, path[1:]); err != nil {
+				return nil, err
+			}
+		} else {
+			copied[key] = nil
+		}
+		return copied, nil
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, fmt.Errorf("no list index %q", key)
+		}
+		copied := append([]interface{}(nil), node...)
+		if copied[i], err = setNullAt(node[i], path[1:]); err != nil {
+			return nil, err
+		}
+		return copied, nil
+	case nil:
+		if len(path) == 1 && v == nil {
+			return map[string]interface{}{key: nil}, nil
+		}
+	}
+	return nil, fmt.Errorf("can't set %q in %T", key, v)
+}
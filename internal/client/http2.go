@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http2"
+)
+
+// configureHTTP2 forces HTTP/2 over TLS, negotiated through ALPN even with
+// a custom TLS config, or disables it so every request uses HTTP/1.1
+func configureHTTP2(transport *http.Transport, enabled bool) {
+	if enabled {
+		transport.ForceAttemptHTTP2 = true
+		return
+	}
+	transport.ForceAttemptHTTP2 = false
+	// A non-nil, empty map keeps net/http from adding its HTTP/2 support
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+}
+
+// h2cTransport sends cleartext http:// requests over HTTP/2 with prior
+// knowledge (h2c), without an Upgrade round trip. Everything else, and
+// cleartext requests that go through a proxy, is sent by base.
+type h2cTransport struct {
+	h2c   *http2.Transport
+	base  http.RoundTripper
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+func newH2CTransport(base http.RoundTripper, tcp *http.Transport) *h2cTransport {
+	dial := tcp.DialContext
+	return &h2cTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			// h2c is HTTP/2 on a plain TCP connection
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			IdleConnTimeout: tcp.IdleConnTimeout,
+		},
+		base:  base,
+		proxy: tcp.Proxy,
+	}
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" || t.proxied(req) {
+		return t.base.RoundTrip(req)
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+func (t *h2cTransport) proxied(req *http.Request) bool {
+	if t.proxy == nil {
+		return false
+	}
+	proxyURL, err := t.proxy(req)
+	return err != nil || proxyURL != nil
+}
+
+func (t *h2cTransport) CloseIdleConnections() {
+	t.h2c.CloseIdleConnections()
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/clock"
+)
+
+// http3BrokenTimeout is how long a host whose QUIC handshake failed is sent
+// over TCP before HTTP/3 is tried again
+const http3BrokenTimeout = 5 * time.Minute
+
+// http3Transport sends requests over HTTP/3 and falls back to the TCP
+// transport (HTTP/2 or HTTP/1.1) when the QUIC connection can't be
+// established, e.g. because UDP is blocked. In Alt-Svc mode HTTP/3 is only
+// used for hosts that advertised it in an earlier TCP response.
+type http3Transport struct {
+	h3       http.RoundTripper
+	fallback http.RoundTripper
+	altSvc   bool
+	clock    clock.Clock
+
+	mu         sync.Mutex
+	broken     map[string]time.Time // host -> when to try HTTP/3 again
+	advertised map[string]altService
+}
+
+// altService is an HTTP/3 endpoint advertised through Alt-Svc
+type altService struct {
+	port      string
+	expiresAt time.Time
+}
+
+func newHTTP3Transport(h3, fallback http.RoundTripper, altSvc bool, clk clock.Clock) *http3Transport {
+	return &http3Transport{
+		h3:         h3,
+		fallback:   fallback,
+		altSvc:     altSvc,
+		clock:      clock.OrReal(clk),
+		broken:     make(map[string]time.Time),
+		advertised: make(map[string]altService),
+	}
+}
+
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h3Req, ok := t.http3Request(req)
+	if !ok {
+		return t.roundTripFallback(req)
+	}
+
+	resp, err := t.h3.RoundTrip(h3Req)
+	if err == nil {
+		return resp, nil
+	}
+
+	// Only fall back when the request can be sent again
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	if req.Context().Err() != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.broken[req.URL.Host] = t.clock.Now().Add(http3BrokenTimeout)
+	t.mu.Unlock()
+
+	return t.roundTripFallback(req)
+}
+
+// http3Request returns the request to send over HTTP/3, or false when the
+// request should go over TCP
+func (t *http3Transport) http3Request(req *http.Request) (*http.Request, bool) {
+	if req.URL.Scheme != "https" {
+		return nil, false
+	}
+
+	host := req.URL.Host
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if retryAt, ok := t.broken[host]; ok {
+		if now.Before(retryAt) {
+			return nil, false
+		}
+		delete(t.broken, host)
+	}
+
+	if !t.altSvc {
+		return req, true
+	}
+
+	alt, ok := t.advertised[host]
+	if !ok || !now.Before(alt.expiresAt) {
+		delete(t.advertised, host)
+		return nil, false
+	}
+	if alt.port == req.URL.Port() || (alt.port == "443" && req.URL.Port() == "") {
+		return req, true
+	}
+
+	h3Req := req.Clone(req.Context())
+	h3Req.URL.Host = net.JoinHostPort(req.URL.Hostname(), alt.port)
+	h3Req.Host = req.Host
+	if h3Req.Host == "" {
+		h3Req.Host = host
+	}
+	return h3Req, true
+}
+
+func (t *http3Transport) roundTripFallback(req *http.Request) (*http.Response, error) {
+	resp, err := t.fallback.RoundTrip(req)
+	if err == nil && t.altSvc && req.URL.Scheme == "https" {
+		t.recordAltSvc(req.URL.Host, resp.Header.Values("Alt-Svc"))
+	}
+	return resp, err
+}
+
+// recordAltSvc remembers an h3 alternative on the same host advertised in
+// an Alt-Svc header (RFC 7838)
+func (t *http3Transport) recordAltSvc(host string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "clear" {
+				delete(t.advertised, host)
+				return
+			}
+
+			params := strings.Split(entry, ";")
+			protocol, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if !ok || protocol != "h3" {
+				continue
+			}
+			altHost, port, err := net.SplitHostPort(strings.Trim(authority, `"`))
+			if err != nil || (altHost != "" && !strings.EqualFold(altHost, hostname(host))) {
+				continue
+			}
+
+			maxAge := 24 * time.Hour
+			for _, param := range params[1:] {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if key == "ma" {
+					if seconds, err := strconv.Atoi(value); err == nil {
+						maxAge = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+
+			t.advertised[host] = altService{port: port, expiresAt: t.clock.Now().Add(maxAge)}
+			return
+		}
+	}
+}
+
+// CloseIdleConnections closes idle connections of both transports
+func (t *http3Transport) CloseIdleConnections() {
+	for _, rt := range []http.RoundTripper{t.h3, t.fallback} {
+		if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
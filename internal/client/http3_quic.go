@@ -0,0 +1,26 @@
+//go:build http3
+
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/yourorg/httpclient/internal/config"
+)
+
+// newHTTP3RoundTripper returns a QUIC transport sharing the TLS settings
+// and timeouts of the TCP transport
+func newHTTP3RoundTripper(tlsConfig *tls.Config, cfg *config.Config) http.RoundTripper {
+	return &http3.RoundTripper{
+		TLSClientConfig: tlsConfig.Clone(),
+		QUICConfig: &quic.Config{
+			HandshakeIdleTimeout: cfg.TLSHandshakeTimeout,
+			MaxIdleTimeout:       cfg.IdleConnTimeout,
+			KeepAlivePeriod:      cfg.KeepAlive,
+		},
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !http3
+
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/yourorg/httpclient/internal/config"
+)
+
+// newHTTP3RoundTripper returns nil unless built with the http3 tag, in
+// which case WithHTTP3 keeps using HTTP/2 over TCP
+func newHTTP3RoundTripper(tlsConfig *tls.Config, cfg *config.Config) http.RoundTripper {
+	return nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/clock"
+	"github.com/yourorg/httpclient/internal/config"
+)
+
+// oauth2ExpiryDelta is how long before its expiry a token is renewed, so
+// that it doesn't expire on the way to the server
+const oauth2ExpiryDelta = 10 * time.Second
+
+// OAuth2Error is an error response of an OAuth2 token endpoint, see RFC
+// 6749 section 5.2. Code "invalid_grant" means the authorization code or
+// refresh token is no longer valid and the user has to authorize again.
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *OAuth2Error) Error() string {
+	msg := fmt.Sprintf("oauth2 token request failed: %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// oauth2Source gets tokens from the token endpoint of an OAuth2Config and
+// keeps the current one in its session until it expires or the server
+// rejects it
+type oauth2Source struct {
+	cfg        config.OAuth2Config
+	session    *config.OAuth2Session // locked while fetching, so callers share a fetch
+	httpClient *http.Client
+	clock      clock.Clock
+}
+
+func newOAuth2Source(cfg config.OAuth2Config, session *config.OAuth2Session, httpClient *http.Client, clk clock.Clock) *oauth2Source {
+	return &oauth2Source{
+		cfg:        cfg,
+		session:    session,
+		httpClient: httpClient,
+		clock:      clock.OrReal(clk),
+	}
+}
+
+// accessToken is the auth provider of a client with an OAuth2Config. It
+// gets a new token when there is none, it is about to expire, or the
+// server rejected it.
+func (s *oauth2Source) accessToken(ctx context.Context) (string, error) {
+	session := s.session
+	session.Lock()
+	defer session.Unlock()
+
+	if session.Token != nil && !IsAuthRefresh(ctx) &&
+		(session.Token.Expiry.IsZero() || s.clock.Now().Add(oauth2ExpiryDelta).Before(session.Token.Expiry)) {
+		return session.Token.AccessToken, nil
+	}
+
+	form := url.Values{}
+	switch {
+	case session.AuthCode != "":
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", session.AuthCode)
+		if s.cfg.RedirectURL != "" {
+			form.Set("redirect_uri", s.cfg.RedirectURL)
+		}
+	case session.RefreshToken != "":
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", session.RefreshToken)
+	default:
+		form.Set("grant_type", "client_credentials")
+		if len(s.cfg.Scopes) > 0 {
+			form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+		}
+	}
+
+	token, err := s.fetch(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	session.Token = token
+	session.AuthCode = ""
+	if token.RefreshToken != "" {
+		session.RefreshToken = token.RefreshToken
+	} else {
+		// The endpoint keeps the refresh token it was given
+		token.RefreshToken = session.RefreshToken
+	}
+	if s.cfg.OnToken != nil {
+		s.cfg.OnToken(*token)
+	}
+	return token.AccessToken, nil
+}
+
+// fetch posts form to the token endpoint and decodes the token it issues
+func (s *oauth2Source) fetch(ctx context.Context, form url.Values) (*config.OAuth2Token, error) {
+	if s.cfg.AuthStyle == config.OAuth2AuthParams {
+		form.Set("client_id", s.cfg.ClientID)
+		if s.cfg.ClientSecret != "" {
+			form.Set("client_secret", s.cfg.ClientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.AuthStyle != config.OAuth2AuthParams {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token request: %w", err)
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	decodeErr := json.Unmarshal(body, &result)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || result.Error != "" {
+		return nil, &OAuth2Error{StatusCode: resp.StatusCode, Code: result.Error, Description: result.ErrorDescription}
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("oauth2 token response: %w", decodeErr)
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2 token response has no access_token")
+	}
+
+	token := &config.OAuth2Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+	}
+	if result.ExpiresIn > 0 {
+		token.Expiry = s.clock.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
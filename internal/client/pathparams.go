@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/yourorg/httpclient/internal/middleware"
+)
+
+// ErrPathParams is returned, before anything is sent, when the path
+// parameters of a request don't match the placeholders of its URL template,
+// or fill a segment with "", "." or ".."
+var ErrPathParams = errors.New("path parameters don't match the URL template")
+
+// RequestOption customizes a single request
+type RequestOption func(*RequestOptions)
+
+// RequestOptions collects the options of a request
+type RequestOptions struct {
+	PathParams map[string]string
+	// DisallowUnknownFields is read by the typed decoding helpers
+	DisallowUnknownFields bool
+	// CacheMode is set by NoCache, ForceRevalidate and OnlyIfCached
+	CacheMode middleware.CacheMode
+}
+
+// NewRequestOptions applies opts
+func NewRequestOptions(opts []RequestOption) *RequestOptions {
+	o := &RequestOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPathParams treats the request URL as a template and replaces each
+// {name} placeholder in its path with params[name], percent-encoded so it
+// stays within its path segment: "a/b" becomes "a%2Fb". Every placeholder
+// needs a value and every value a placeholder. The template is reported to
+// the metrics and tracing middleware as the request's route.
+func WithPathParams(params map[string]string) RequestOption {
+	return func(o *RequestOptions) {
+		o.PathParams = params
+	}
+}
+
+// applyRequestOptions returns the URL and context to send a request for
+// urlStr with
+func applyRequestOptions(ctx context.Context, urlStr string, opts []RequestOption) (context.Context, string, error) {
+	o := NewRequestOptions(opts)
+	if o.CacheMode != "" {
+		ctx = middleware.WithCacheMode(ctx, o.CacheMode)
+	}
+	if o.PathParams == nil {
+		return ctx, urlStr, nil
+	}
+
+	expanded, err := expandPath(urlStr, o.PathParams)
+	if err != nil {
+		return ctx, "", err
+	}
+	return middleware.WithRoute(ctx, routeOf(urlStr)), expanded, nil
+}
+
+// expandPath fills the placeholders in the path of template with params.
+// A segment that comes out empty, "." or ".." is rejected: the server
+// would resolve it against the segments around it.
+func expandPath(template string, params map[string]string) (string, error) {
+	origin, path, rest := splitTemplate(template)
+
+	used := make(map[string]bool, len(params))
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		filled := false
+		for {
+			open := strings.IndexByte(segment, '{')
+			if open < 0 {
+				break
+			}
+			end := strings.IndexByte(segment[open:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("%w: unterminated placeholder in %q", ErrPathParams, template)
+			}
+			name := segment[open+1 : open+end]
+			value, ok := params[name]
+			if !ok {
+				return "", fmt.Errorf("%w: missing parameter %q", ErrPathParams, name)
+			}
+			used[name] = true
+			filled = true
+			b.WriteString(segment[:open])
+			b.WriteString(url.PathEscape(value))
+			segment = segment[open+end+1:]
+		}
+		b.WriteString(segment)
+		segments[i] = b.String()
+
+		if filled && (segments[i] == "" || segments[i] == "." || segments[i] == "..") {
+			return "", fmt.Errorf("%w: path segment %q in %q", ErrPathParams, segments[i], template)
+		}
+	}
+
+	if len(used) < len(params) {
+		var unknown []string
+		for name := range params {
+			if !used[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		return "", fmt.Errorf("%w: unknown parameters %q", ErrPathParams, unknown)
+	}
+	return origin + strings.Join(segments, "/") + rest, nil
+}
+
+// routeOf returns the path of template, without the scheme, host and query
+func routeOf(template string) string {
+	origin, path, _ := splitTemplate(template)
+	if origin != "" && path == "" {
+		return "/"
+	}
+	return path
+}
+
+// splitTemplate splits a URL template into the scheme and host of an
+// absolute URL, the path, and the query and fragment
+func splitTemplate(template string) (origin, path, rest string) {
+	path = template
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path, rest = path[:i], path[i:]
+	}
+	if i := strings.Index(path, "://"); i >= 0 {
+		end := len(path)
+		if slash := strings.IndexByte(path[i+len("://"):], '/'); slash >= 0 {
+			end = i + len("://") + slash
+		}
+		origin, path = path[:end], path[end:]
+	}
+	return origin, path, rest
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+)
+
+// ProtoMessage is a protobuf message that encodes and decodes itself, as
+// the ones generated by gogo/protobuf do. Messages of
+// google.golang.org/protobuf need a small adapter calling proto.Marshal and
+// proto.Unmarshal, which keeps the client free of a protobuf runtime.
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// protobufContentType is the media type of protobuf request bodies
+const protobufContentType = "application/x-protobuf"
+
+// protobufMediaTypes are the response media types decoded as protobuf
+var protobufMediaTypes = map[string]bool{
+	"application/x-protobuf":          true,
+	"application/protobuf":            true,
+	"application/vnd.google.protobuf": true,
+	"application/octet-stream":        true,
+}
+
+// rawBody is an already encoded request body that doResponse sends as is
+// instead of encoding it as JSON. A nil data sends no body but still sets
+// the Accept header.
+type rawBody struct {
+	contentType string
+	accept      string
+	data        []byte
+
+	// stream, when set, produces the body in place of data. It is called
+	// again for each retry when replayable, otherwise a failed request
+	// isn't retried since its body is gone.
+	stream     func() (io.Reader, error)
+	replayable bool
+
+	// idempotent marks a request that is safe to retry whatever its
+	// method, like a GraphQL query sent as POST
+	idempotent bool
+}
+
+// Proto sends in as a protobuf request body and decodes the protobuf
+// response into out. Either may be nil. The request goes through the same
+// retry, interceptor and middleware stack as JSON requests, and non-2xx
+// responses return an *APIError with the body.
+func (c *client) Proto(method, url string, in, out ProtoMessage) error {
+	return c.ProtoContext(context.Background(), method, url, in, out)
+}
+
+func (c *client) ProtoContext(ctx context.Context, method, url string, in, out ProtoMessage) error {
+	body := &rawBody{contentType: protobufContentType, accept: protobufContentType}
+	if in != nil {
+		data, err := in.Marshal()
+		if err != nil {
+			return fmt.Errorf("marshal protobuf request: %w", err)
+		}
+		body.data = data
+	}
+
+	resp, err := c.doResponse(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(resp.body) == 0 {
+		return nil
+	}
+
+	if contentType := resp.header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !protobufMediaTypes[mediaType] {
+			return contentTypeError(contentType, resp.body, out)
+		}
+	}
+	if err := out.Unmarshal(resp.body); err != nil {
+		return fmt.Errorf("unmarshal protobuf response: %w", err)
+	}
+	return nil
+}
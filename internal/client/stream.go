@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/middleware"
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+// ErrStreamIdleTimeout is returned when a stream receives no data within
+// the idle timeout set with WithStreamIdleTimeout
+var ErrStreamIdleTimeout = errors.New("stream idle timeout")
+
+// streamClient returns an HTTP client for streamed responses. It shares the
+// client's transport, cookie jar and redirect policy, and applies the same
+// headers, request interceptors, signing and middleware as regular requests.
+// Streams stay open, so the client timeout only bounds the wait for the
+// response headers, see streamTransport.
+func (c *client) streamClient() *http.Client {
+	base := c.httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+	streamClient.Transport = &streamTransport{client: c, base: base}
+	return &streamClient
+}
+
+// streamTransport prepares stream requests the way doResponse prepares
+// regular ones. The response cache is skipped because caching reads the
+// whole body.
+type streamTransport struct {
+	client *client
+	base   http.RoundTripper
+}
+
+func (t *streamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.client
+	req = req.Clone(req.Context())
+
+	// Headers set by the streaming code, like Accept and Last-Event-ID,
+	// take precedence over the client defaults
+	own := req.Header
+	req.Header = make(http.Header)
+	c.setHeaders(req, req.Body != nil && req.Body != http.NoBody)
+	for key, values := range own {
+		req.Header[key] = values
+	}
+
+	// Leave gzip to the transport so stream consumers get a decoded body
+	if c.config.CompressionEnabled && req.Header.Get("Accept-Encoding") == acceptEncoding() {
+		req.Header.Del("Accept-Encoding")
+	}
+
+	if err := c.applyRequestHooks(req); err != nil {
+		return nil, err
+	}
+
+	for _, mw := range c.middlewares {
+		if mw == c.cache {
+			continue
+		}
+		if err := mw.Before(req); err != nil {
+			return nil, err
+		}
+	}
+
+	// The client timeout and the idle timeout cancel the request to
+	// unblock a wedged connect or read
+	timeout, idleTimeout := c.config.Timeout, c.config.StreamIdleTimeout
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 || idleTimeout > 0 {
+		req, cancel = withCancel(req)
+	}
+	var headerTimer *time.Timer
+	if timeout > 0 {
+		headerTimer = time.AfterFunc(timeout, cancel)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if headerTimer != nil && !headerTimer.Stop() {
+		// The timer fired, the body is cut off if there is one
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("stream response headers not received within %s: %w", timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		for _, mw := range c.middlewares {
+			if observer, ok := mw.(middleware.ErrorObserver); ok && mw != c.cache {
+				observer.OnError(req, err)
+			}
+		}
+		return nil, err
+	}
+
+	for _, mw := range c.middlewares {
+		if mw != c.cache {
+			mw.After(resp)
+		}
+	}
+
+	switch {
+	case idleTimeout > 0:
+		resp.Body = newIdleTimeoutBody(resp.Body, idleTimeout, cancel)
+	case timeout > 0:
+		resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	}
+	return resp, nil
+}
+
+// cancelOnCloseBody releases the request context when the body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// withCancel returns a copy of req that is aborted by calling cancel
+func withCancel(req *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(req.Context())
+	return req.WithContext(ctx), cancel
+}
+
+// idleTimeoutBody fails a Read that gets no data within timeout with
+// ErrStreamIdleTimeout. Time the consumer spends between reads doesn't
+// count.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+
+	if b.expired.Load() {
+		return n, fmt.Errorf("%w: no data for %s", ErrStreamIdleTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.body.Close()
+}
+
+// applyRequestHooks runs the request interceptors and signs req
+func (c *client) applyRequestHooks(req *http.Request) error {
+	for _, interceptor := range c.config.RequestInterceptors {
+		if err := interceptor(req); err != nil {
+			return fmt.Errorf("request interceptor failed: %w", err)
+		}
+	}
+
+	if c.requestSigner != nil {
+		if err := c.requestSigner.SignRequest(req); err != nil {
+			return fmt.Errorf("request signing failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Stream sends a request and delivers the response body as it arrives, in
+// raw chunks or in records framed by options such as streaming.SplitLines.
+// The request goes through the client's base URL, headers, interceptors and
+// transport. The client timeout only bounds the wait for the response
+// headers; the body then flows for as long as the server sends it.
+func (c *client) Stream(method, url string, body interface{}, opts ...streaming.StreamOption) (<-chan []byte, error) {
+	return c.StreamContext(context.Background(), method, url, body, opts...)
+}
+
+func (c *client) StreamContext(ctx context.Context, method, url string, body interface{}, opts ...streaming.StreamOption) (<-chan []byte, error) {
+	req, release, err := c.newStreamRequest(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	opts = append(opts[:len(opts):len(opts)], streaming.OnStreamEnd(release))
+	ch, err := streaming.NewStreamingClient().
+		WithClient(c.streamClient()).
+		StreamRequest(req, opts...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// newStreamRequest builds a request against the client's base URL with
+// body encoded as JSON. Client headers are added by the stream transport.
+// release must be called when the stream ends.
+func (c *client) newStreamRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, func(), error) {
+	fullURL, release, err := c.buildURLWithLoadBalancing(ctx, method, url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := codecOf(c.config).Marshal(body)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("create request: %w", err)
+	}
+	return req, release, nil
+}
+
+// hasHeader reports whether headers sets key, compared case-insensitively
+func hasHeader(headers map[string]string, key string) bool {
+	for k := range headers {
+		if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(key) {
+			return true
+		}
+	}
+	return false
+}
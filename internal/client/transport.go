@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/config"
+	"github.com/yourorg/httpclient/internal/dns"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// maxSharedTransports bounds the transports kept for sharing; clients with
+// settings beyond that many distinct ones get a transport of their own
+const maxSharedTransports = 64
+
+// transportKey holds the settings a transport is built from. Clients whose
+// settings are equal share the transport and with it the idle connections,
+// so a chain of With calls doesn't start a new connection pool each time.
+type transportKey struct {
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+	tlsConfig             *tls.Config
+	tlsInsecure           bool
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	expectContinueTimeout time.Duration
+	dialTimeout           time.Duration
+	keepAlive             time.Duration
+	dnsCache              *dns.Cache
+	proxyDisabled         bool
+	proxyURL              string
+	proxyEnv              httpproxy.Config
+	http2                 int // 0 default, 1 enabled, 2 disabled
+	http3                 bool
+}
+
+var sharedTransports = struct {
+	sync.Mutex
+	m map[transportKey]*http.Transport
+}{m: make(map[transportKey]*http.Transport)}
+
+// sharedTransport returns the transport for cfg, reusing the one of an
+// earlier client with the same settings. Settings that can't be compared,
+// a custom resolver or proxy function, get a transport of their own.
+func sharedTransport(cfg *config.Config) *http.Transport {
+	if cfg.Resolver != nil || cfg.ProxyFunc != nil || cfg.ProxyErr != nil {
+		return newTransport(cfg)
+	}
+
+	key := transportKey{
+		maxIdleConns:          cfg.MaxIdleConns,
+		maxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		idleConnTimeout:       cfg.IdleConnTimeout,
+		tlsConfig:             cfg.TLSConfig,
+		tlsInsecure:           cfg.TLSInsecureSkipVerify,
+		tlsHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		responseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		expectContinueTimeout: cfg.ExpectContinueTimeout,
+		dialTimeout:           cfg.DialTimeout,
+		keepAlive:             cfg.KeepAlive,
+		dnsCache:              cfg.DNSCache,
+		proxyDisabled:         cfg.ProxyDisabled,
+		http3:                 cfg.HTTP3Enabled,
+	}
+	if !cfg.ProxyDisabled {
+		key.proxyEnv = *httpproxy.FromEnvironment()
+		if cfg.ProxyURL != nil {
+			key.proxyURL = cfg.ProxyURL.String()
+		}
+	}
+	if cfg.HTTP2Enabled != nil {
+		key.http2 = 2
+		if *cfg.HTTP2Enabled {
+			key.http2 = 1
+		}
+	}
+
+	sharedTransports.Lock()
+	defer sharedTransports.Unlock()
+
+	if t, ok := sharedTransports.m[key]; ok {
+		return t
+	}
+	t := newTransport(cfg)
+	if len(sharedTransports.m) < maxSharedTransports {
+		sharedTransports.m[key] = t
+	}
+	return t
+}
+
+// newTransport builds the TCP transport for cfg
+func newTransport(cfg *config.Config) *http.Transport {
+	tlsConfig := cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		}
+	}
+
+	httpTransport := &http.Transport{
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: cfg.ExpectContinueTimeout,
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	httpTransport.DialContext = dialer.DialContext
+	if cfg.Resolver != nil || cfg.DNSCache != nil {
+		httpTransport.DialContext = dns.DialContext(dialer, cfg.Resolver, cfg.DNSCache)
+	}
+
+	if !cfg.ProxyDisabled {
+		httpTransport.Proxy = environmentProxy()
+		proxyErr := cfg.ProxyErr
+		if cfg.ProxyURL != nil {
+			if err := configureProxy(httpTransport, cfg); err != nil && proxyErr == nil {
+				proxyErr = err
+			}
+		}
+		if cfg.ProxyFunc != nil {
+			httpTransport.Proxy = cfg.ProxyFunc
+		}
+		if proxyErr != nil {
+			// Streams and WebSockets fail too rather than go direct
+			httpTransport.Proxy = func(*http.Request) (*url.URL, error) {
+				return nil, proxyErr
+			}
+		}
+	}
+
+	if cfg.HTTP3Enabled {
+		// A custom TLS config disables HTTP/2 unless forced; HTTP/2 is what
+		// requests fall back to when QUIC is unavailable
+		httpTransport.ForceAttemptHTTP2 = true
+	}
+	if cfg.HTTP2Enabled != nil {
+		configureHTTP2(httpTransport, *cfg.HTTP2Enabled)
+	}
+	return httpTransport
+}
+
+// freshTransport returns a transport with the settings of the client's TCP
+// transport that sends each request on a new connection and closes it
+// afterwards, leaving the pool shared with other clients alone. The HTTP/3
+// and h2c layers are left out: they keep connection pools of their own.
+func (c *client) freshTransport() http.RoundTripper {
+	t := c.tcpTransport.Clone()
+	t.DisableKeepAlives = true
+	var transport http.RoundTripper = t
+	if c.config.CompressionEnabled {
+		transport = &compressionTransport{base: transport, minSize: c.config.CompressionMinSize}
+	}
+	return transport
+}
+
+// maxPooledBuffer is the largest buffer returned to bufferPool, so one huge
+// response doesn't keep its memory alive
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// readBody reads r into a pooled buffer and returns a copy of exactly the
+// bytes read, sparing the reallocations of growing a fresh slice
+func readBody(r io.Reader) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// limitedBody fails reads with ErrResponseTooLarge once its body turns out
+// to be longer than n bytes. The error sticks, so a reader that gives up
+// on the body leaves it failing for the next one.
+type limitedBody struct {
+	io.ReadCloser
+	n   int64 // bytes left before the limit
+	max int64
+}
+
+func limitBody(body io.ReadCloser, max int64) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, n: max, max: max}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, b.max)
+	}
+	// Read one byte past the limit to tell a body of exactly max bytes
+	// from a longer one
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n + int(b.n), fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, b.max)
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+// WebSocket opens a WebSocket connection to url, resolved against the base
+// URL. The handshake carries the client's headers, auth and cookies, runs
+// the request interceptors, and uses the client's TLS config, proxy and
+// resolver. With streaming.WithReconnect the connection is re-dialed, with
+// fresh headers, whenever it drops.
+func (c *client) WebSocket(url string, opts ...streaming.WebSocketOption) (streaming.Socket, error) {
+	return c.WebSocketContext(context.Background(), url, opts...)
+}
+
+func (c *client) WebSocketContext(ctx context.Context, url string, opts ...streaming.WebSocketOption) (streaming.Socket, error) {
+	options := streaming.NewWebSocketOptions(opts)
+	if options.Reconnect == nil {
+		conn, err := c.dialWebSocket(ctx, url, options)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn, err := streaming.NewReconnectingWebSocket(ctx, func(ctx context.Context) (*streaming.WebSocketConn, error) {
+		return c.dialWebSocket(ctx, url, options)
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialWebSocket performs the handshake for WebSocketContext
+func (c *client) dialWebSocket(ctx context.Context, urlStr string, options *streaming.WebSocketOptions) (*streaming.WebSocketConn, error) {
+	fullURL, release, err := c.buildURLWithLoadBalancing(ctx, "GET", urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	defer release()
+
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
+	}
+
+	// Interceptors and proxy selection expect an HTTP URL
+	handshakeURL := *u
+	switch u.Scheme {
+	case "ws":
+		handshakeURL.Scheme = "http"
+	case "wss":
+		handshakeURL.Scheme = "https"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", handshakeURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	c.setHeaders(req, false)
+	// The handshake has no body to compress
+	req.Header.Del("Accept-Encoding")
+	if err := c.applyRequestHooks(req); err != nil {
+		return nil, err
+	}
+
+	dialer := streaming.NewWebSocketDialer().
+		WithHeaders(req.Header).
+		WithCookieJar(c.config.CookieJar)
+	if options.PingInterval > 0 {
+		dialer.WithKeepalive(options.PingInterval, options.PongTimeout)
+	}
+	if len(options.Subprotocols) > 0 {
+		dialer.WithSubprotocols(options.Subprotocols...)
+	}
+
+	if t := c.tcpTransport; t != nil {
+		dialer.WithTLSConfig(t.TLSClientConfig).WithProxy(t.Proxy)
+		if t.DialContext != nil {
+			dialer.WithNetDialContext(t.DialContext)
+		}
+	} else {
+		// A custom transport can't be reused for the handshake
+		dialer.WithTLSConfig(c.config.TLSConfig).WithProxy(c.config.ProxyFunc)
+	}
+
+	// The client timeout bounds the handshake, not the connection
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	return dialer.DialContext(ctx, fullURL)
+}
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
+
+	"github.com/yourorg/httpclient/internal/clock"
+	"github.com/yourorg/httpclient/internal/dns"
+	"github.com/yourorg/httpclient/internal/logging"
+	"github.com/yourorg/httpclient/internal/middleware"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // Config holds all client configuration options
@@ -14,12 +22,34 @@ type Config struct {
 	BaseURL     string
 	UserAgent   string
 	Headers     map[string]string
+	Clock       clock.Clock
+
+	// AuthProvider returns the bearer token of each request, replacing an
+	// Authorization header from Headers
+	AuthProvider func(ctx context.Context) (string, error)
+	// AuthRefreshOn401 has a 401 response refresh the token and repeat the
+	// request once
+	AuthRefreshOn401 bool
+
+	// AttemptTimeout bounds each attempt of a request and OverallTimeout
+	// the whole call, retries and backoff included; 0 means no limit
+	AttemptTimeout time.Duration
+	OverallTimeout time.Duration
 
 	// Retry settings
 	Retries         int
 	RetryDelay      time.Duration
 	RetryMultiplier float64
 	RetryMaxDelay   time.Duration
+	OnRetry         func(attempt int, err error, nextDelay time.Duration)
+	RetryBudget     time.Duration // cap on the time spent retrying, 0 for none
+
+	// RetryNonIdempotent retries POST and PATCH requests like the others;
+	// by default they are only retried when they never reached the server
+	RetryNonIdempotent bool
+	// IdempotencyKeys sends a generated Idempotency-Key header with POST and
+	// PATCH requests that don't carry one, the same for every attempt
+	IdempotencyKeys bool
 
 	// Connection settings
 	MaxIdleConns        int
@@ -27,31 +57,90 @@ type Config struct {
 	IdleConnTimeout     time.Duration
 	KeepAlive           time.Duration
 
+	// Timeout bounds a whole request including reading the body; these
+	// bound its phases instead and also apply to streams. 0 means no limit.
+	DialTimeout           time.Duration // establishing the TCP connection
+	TLSHandshakeTimeout   time.Duration // the TLS handshake, also the QUIC one for HTTP/3
+	ResponseHeaderTimeout time.Duration // from sending the request to the response headers
+
+	// ExpectContinue sends requests with a body with "Expect: 100-continue"
+	// and holds the body back until the server agrees, or for at most
+	// ExpectContinueTimeout
+	ExpectContinue        bool
+	ExpectContinueTimeout time.Duration
+
+	// MaxResponseSize bounds the response bodies read into memory, both as
+	// received and decompressed; 0 means no limit
+	MaxResponseSize int64
+
+	// Codec encodes request bodies and decodes JSON responses; nil uses
+	// encoding/json
+	Codec Codec
+
 	// Rate limiting
-	RateLimitRPS int
+	RateLimitRPS         int
+	RateLimitBurst       int
+	RateLimitNonBlocking bool
+	PerHostRateLimitRPS  int
 
 	// Circuit breaker
+	CircuitBreakerEnabled   bool
 	CircuitBreakerThreshold int
 	CircuitBreakerTimeout   time.Duration
+	CircuitStateChange      func(from, to string)
+	// CircuitHostStateChange is also told the host whose circuit changed
+	CircuitHostStateChange func(host string, from, to middleware.CircuitState)
+	// CircuitHalfOpenProbes and CircuitSuccessThreshold are how many
+	// requests a half-open circuit lets through at a time and how many
+	// of them have to succeed in a row to close it; 0 means 1
+	CircuitHalfOpenProbes   int
+	CircuitSuccessThreshold int
 
 	// Caching
-	CacheTTL time.Duration
+	CacheEnabled     bool
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+	// CacheMaxEntries and CacheMaxBytes bound the response cache, evicting
+	// the least recently used entries; zero means no limit
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	// CacheMaxEntrySize keeps larger response bodies out of the cache
+	CacheMaxEntrySize int64
+	// CacheKeyFunc replaces the key responses are cached under
+	CacheKeyFunc func(req *http.Request) string
+	// CachePolicy decides between the lifetime responses set and CacheTTL
+	CachePolicy middleware.CachePolicy
+	// CacheStore holds cached responses instead of the in-memory store
+	CacheStore middleware.CacheStore
+	// RequestCoalescing shares one upstream request between identical
+	// concurrent GET and HEAD requests
+	RequestCoalescing bool
 
 	// Observability
 	MetricsEnabled bool
 	TracingEnabled bool
 	DebugEnabled   bool
 
+	// Logger receives debug output and internal warnings; nil discards
+	// warnings, and debug output then goes to standard output
+	Logger logging.Logger
+
+	// TracePropagator injects the request span into outgoing headers;
+	// nil uses the global OpenTelemetry propagator, or W3C Trace Context
+	TracePropagator propagation.TextMapPropagator
+
 	// Security
 	TLSInsecureSkipVerify bool
-	TLSTimeout            time.Duration
 
 	// Advanced features
 	LoadBalancerEndpoints []string
 	LoadBalancerStrategy  string
+	LoadBalancerWeights   map[string]int // endpoint to weight, overrides the endpoints and strategy
+	LoadBalancerKey       func(*http.Request) string // request key for "consistent-hash", the URL path by default
 	HealthCheckInterval   time.Duration
 	HealthCheckEndpoint   string
 	CompressionEnabled    bool
+	CompressionMinSize    int
 	RequestSigningKeyID   string
 	RequestSigningKey     string
 	IPWhitelist          []string
@@ -59,10 +148,16 @@ type Config struct {
 	CustomTransport      http.RoundTripper
 	TLSConfig            *tls.Config
 	ProxyURL             *url.URL
+	ProxyFunc            func(*http.Request) (*url.URL, error)
+	ProxyErr             error // why the proxy URL given can't be used
+	ProxyDisabled        bool
+	Resolver             dns.Resolver
+	DNSCache             *dns.Cache
 	CookieJar            http.CookieJar
 	RedirectPolicy       func(req *http.Request, via []*http.Request) error
 	RequestInterceptors  []func(*http.Request) error
 	ResponseInterceptors []func(*http.Response) error
+	Middlewares          []middleware.Middleware // run after the built-in ones, see client.WithMiddleware
 
 	// AI/ML Features
 	AIRetryEnabled            bool
@@ -72,6 +167,8 @@ type Config struct {
 
 	// Advanced Networking
 	HTTP3Enabled           bool
+	HTTP3AltSvc            bool
+	HTTP2Enabled           *bool // nil keeps the transport's default
 	MultipathEnabled       bool
 	DNSOverHTTPSEnabled    bool
 	EdgeOptimizationEnabled bool
@@ -80,6 +177,9 @@ type Config struct {
 	MTLSCertFile        string
 	MTLSKeyFile         string
 	OAuth2Config        *OAuth2Config
+	// OAuth2Session holds the tokens of OAuth2Config. Clone shares it, so
+	// that clients derived from one another share their tokens.
+	OAuth2Session       *OAuth2Session
 	JWTConfig           *JWTConfig
 	APIGatewayConfig    *APIGatewayConfig
 	ComplianceStandards []string
@@ -102,10 +202,14 @@ type Config struct {
 	StreamingEnabled    bool
 	WebSocketEnabled    bool
 	ServerSentEventsEnabled bool
+	StreamIdleTimeout   time.Duration // max wait for each stream read, 0 waits forever
 
 	// GraphQL
 	GraphQLEnabled bool
 	GraphQLEndpoint string
+	GraphQLPersistedQueries bool // Automatic Persisted Queries
+	GraphQLValidation bool
+	GraphQLInitPayload map[string]interface{} // connection_init of subscriptions
 
 	// Batch & Pipeline
 	BatchEnabled    bool
@@ -114,12 +218,77 @@ type Config struct {
 	MaxPipelineSize int
 }
 
+// Codec encodes and decodes JSON, e.g. with a faster drop-in replacement
+// for encoding/json. Its methods must be safe for concurrent use.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
 // Advanced configuration types
+
+// OAuth2Config gets the bearer tokens of requests from an OAuth2 token
+// endpoint. Without AuthCode or RefreshToken it uses the client
+// credentials grant.
 type OAuth2Config struct {
 	ClientID     string
 	ClientSecret string
 	TokenURL     string
 	Scopes       []string
+
+	// AuthCode is the code an authorization server redirected the user
+	// back to RedirectURL with. It is exchanged for the first token, and
+	// the refresh token that comes with it renews the later ones.
+	AuthCode    string
+	RedirectURL string
+	// RefreshToken renews tokens for a user, e.g. one saved by OnToken
+	RefreshToken string
+	// AuthStyle is how the client ID and secret are sent to TokenURL,
+	// defaults to OAuth2AuthHeader
+	AuthStyle OAuth2AuthStyle
+	// OnToken is called with every token the endpoint issues, so that a
+	// rotated refresh token can be stored
+	OnToken func(OAuth2Token)
+}
+
+// OAuth2AuthStyle is how a client authenticates to a token endpoint
+type OAuth2AuthStyle string
+
+// OAuth2 client authentication styles, see RFC 6749 section 2.3.1
+const (
+	// OAuth2AuthHeader sends the client ID and secret with HTTP Basic
+	// authentication
+	OAuth2AuthHeader OAuth2AuthStyle = "header"
+	// OAuth2AuthParams sends them as client_id and client_secret in the
+	// request body
+	OAuth2AuthParams OAuth2AuthStyle = "params"
+)
+
+// OAuth2Session is the token state of an OAuth2Config: the current token,
+// the authorization code until it has been exchanged, and the latest
+// refresh token. The code can only be exchanged once and a rotated refresh
+// token replaces the old one, so every client using the config has to
+// share one session. Hold the lock while using the fields.
+type OAuth2Session struct {
+	sync.Mutex
+	Token        *OAuth2Token
+	AuthCode     string
+	RefreshToken string
+}
+
+// NewOAuth2Session returns the session of cfg before its first token
+func NewOAuth2Session(cfg OAuth2Config) *OAuth2Session {
+	return &OAuth2Session{AuthCode: cfg.AuthCode, RefreshToken: cfg.RefreshToken}
+}
+
+// OAuth2Token is a token issued by an OAuth2 token endpoint
+type OAuth2Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	// Expiry is when the access token expires; zero when the endpoint
+	// didn't say
+	Expiry time.Time
 }
 
 type JWTConfig struct {
@@ -146,7 +315,6 @@ type AutoRetryConfig struct {
 	RetryConditions []string
 	JitterEnabled   bool
 }
-}
 
 // Default returns a configuration with sensible defaults
 func Default() *Config {
@@ -155,6 +323,7 @@ func Default() *Config {
 		Timeout:   30 * time.Second,
 		UserAgent: "httpclient/1.0",
 		Headers:   make(map[string]string),
+		Clock:     clock.Real,
 
 		// Retry settings
 		Retries:         3,
@@ -167,6 +336,7 @@ func Default() *Config {
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     90 * time.Second,
 		KeepAlive:           30 * time.Second,
+		DialTimeout:         30 * time.Second,
 
 		// Rate limiting
 		RateLimitRPS: 100,
@@ -185,10 +355,14 @@ func Default() *Config {
 
 		// Security
 		TLSInsecureSkipVerify: false,
-		TLSTimeout:            10 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+
+		// Request bodies smaller than this aren't worth gzipping
+		CompressionMinSize: 1024,
 
-		// AI/ML Features (enabled by default for smart behavior)
-		AIRetryEnabled:              true,
+		// AI/ML Features (enabled by default for smart behavior, except
+		// AI retry, which would change how often requests are retried)
+		AIRetryEnabled:              false,
 		SmartCachingEnabled:         true,
 		PredictivePreloadingEnabled: false,
 		AdaptiveTimeoutEnabled:      true,
@@ -232,9 +406,19 @@ func (c *Config) Clone() *Config {
 		clone.Headers[k] = v
 	}
 
+	clone.Middlewares = append([]middleware.Middleware(nil), c.Middlewares...)
+
+	if c.LoadBalancerWeights != nil {
+		clone.LoadBalancerWeights = make(map[string]int, len(c.LoadBalancerWeights))
+		for k, v := range c.LoadBalancerWeights {
+			clone.LoadBalancerWeights[k] = v
+		}
+	}
+
 	// Clone complex types
 	if c.OAuth2Config != nil {
 		oauth2Clone := *c.OAuth2Config
+		oauth2Clone.Scopes = append([]string(nil), c.OAuth2Config.Scopes...)
 		clone.OAuth2Config = &oauth2Clone
 	}
 	if c.JWTConfig != nil {
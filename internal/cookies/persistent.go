@@ -0,0 +1,220 @@
+package cookies
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// storedCookie is a cookie as saved to disk, with the URL it was set by so
+// loading can replay it into the jar
+type storedCookie struct {
+	URL      string        `json:"url"`
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Domain   string        `json:"domain,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Expires  time.Time     `json:"expires,omitempty"` // zero for a session cookie
+	Secure   bool          `json:"secure,omitempty"`
+	HttpOnly bool          `json:"http_only,omitempty"`
+	SameSite http.SameSite `json:"same_site,omitempty"`
+}
+
+// PersistentJar is an http.CookieJar that keeps its cookies in a JSON file,
+// so sessions survive restarts. Cookies are saved on every change; expired
+// ones are dropped when loading. Session cookies, those without an expiry,
+// are kept too, as a client's session usually depends on them.
+type PersistentJar struct {
+	path string
+	jar  *cookiejar.Jar
+
+	mu      sync.Mutex
+	entries map[string]storedCookie
+	err     error // last save error
+}
+
+// NewPersistentJar returns a jar backed by the file at path, loading the
+// cookies saved there. A missing file starts an empty jar.
+func NewPersistentJar(path string) (*PersistentJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	pj := &PersistentJar{
+		path:    path,
+		jar:     jar,
+		entries: make(map[string]storedCookie),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pj, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cookie file: %w", err)
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("decode cookie file %s: %w", path, err)
+	}
+
+	now := time.Now()
+	for _, sc := range stored {
+		if !sc.Expires.IsZero() && !sc.Expires.After(now) {
+			continue
+		}
+		u, err := url.Parse(sc.URL)
+		if err != nil {
+			continue
+		}
+		jar.SetCookies(u, []*http.Cookie{{
+			Name:     sc.Name,
+			Value:    sc.Value,
+			Domain:   sc.Domain,
+			Path:     sc.Path,
+			Expires:  sc.Expires,
+			Secure:   sc.Secure,
+			HttpOnly: sc.HttpOnly,
+			SameSite: sc.SameSite,
+		}})
+		pj.entries[entryKey(u, sc.Domain, sc.Path, sc.Name)] = sc
+	}
+	return pj, nil
+}
+
+// SetCookies stores cookies received from u and saves the jar. A failed
+// save is reported by Err and the next Save.
+func (pj *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	pj.mu.Lock()
+	defer pj.mu.Unlock()
+
+	pj.jar.SetCookies(u, cookies)
+
+	now := time.Now()
+	origin := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, c := range cookies {
+		path := c.Path
+		if path == "" || path[0] != '/' {
+			path = defaultPath(u.Path)
+		}
+		key := entryKey(u, c.Domain, path, c.Name)
+
+		expires := c.Expires
+		if c.MaxAge > 0 {
+			expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if c.MaxAge < 0 || (!expires.IsZero() && !expires.After(now)) {
+			delete(pj.entries, key)
+			continue
+		}
+
+		pj.entries[key] = storedCookie{
+			URL:      origin,
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     path,
+			Expires:  expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: c.SameSite,
+		}
+	}
+	pj.err = pj.save()
+}
+
+// Cookies returns the cookies to send in a request for u
+func (pj *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return pj.jar.Cookies(u)
+}
+
+// Save writes the cookies to the file
+func (pj *PersistentJar) Save() error {
+	pj.mu.Lock()
+	defer pj.mu.Unlock()
+
+	pj.err = pj.save()
+	return pj.err
+}
+
+// Err returns the error of the last save, nil when it succeeded
+func (pj *PersistentJar) Err() error {
+	pj.mu.Lock()
+	defer pj.mu.Unlock()
+	return pj.err
+}
+
+// save writes the entries to a temporary file and renames it over the jar
+// file, so a crash never leaves a truncated file. The caller holds mu.
+func (pj *PersistentJar) save() error {
+	now := time.Now()
+	stored := make([]storedCookie, 0, len(pj.entries))
+	for key, sc := range pj.entries {
+		if !sc.Expires.IsZero() && !sc.Expires.After(now) {
+			delete(pj.entries, key)
+			continue
+		}
+		stored = append(stored, sc)
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].URL != stored[j].URL {
+			return stored[i].URL < stored[j].URL
+		}
+		return stored[i].Name < stored[j].Name
+	})
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Cookies are credentials, so only the owner may read them
+	tmp, err := os.CreateTemp(filepath.Dir(pj.path), filepath.Base(pj.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save cookies: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save cookies: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save cookies: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), pj.path); err != nil {
+		return fmt.Errorf("save cookies: %w", err)
+	}
+	return nil
+}
+
+// entryKey identifies a cookie like the jar does: by domain, path and name
+func entryKey(u *url.URL, domain, path, name string) string {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	if domain == "" {
+		domain = strings.ToLower(u.Hostname())
+	}
+	return domain + ";" + path + ";" + name
+}
+
+// defaultPath is the path of a cookie set without one, RFC 6265 section 5.1.4
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
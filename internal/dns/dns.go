@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/clock"
+)
+
+// Resolver looks up the IP addresses of a host. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ResolverFunc adapts a plain function to the Resolver interface
+type ResolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f ResolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+// Cache keeps positive and negative lookup results for a limited time.
+// Concurrent lookups of the same host share a single resolver call.
+type Cache struct {
+	entries     map[string]*list.Element
+	lru         *list.List
+	inflight    map[string]*lookup
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	clock       clock.Clock
+	mu          sync.Mutex
+}
+
+type cacheEntry struct {
+	host      string
+	addrs     []net.IPAddr
+	err       error
+	expiresAt time.Time
+}
+
+type lookup struct {
+	done  chan struct{}
+	addrs []net.IPAddr
+	err   error
+}
+
+// NewCache creates a DNS cache. Successful lookups are kept for ttl and
+// "no such host" answers for negativeTTL; at most maxEntries hosts are kept,
+// evicting the least recently used.
+func NewCache(ttl, negativeTTL time.Duration, maxEntries int, clk clock.Clock) *Cache {
+	return &Cache{
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]*lookup),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		clock:       clock.OrReal(clk),
+	}
+}
+
+// Lookup returns the addresses for host, consulting resolver on a miss
+func (c *Cache) Lookup(ctx context.Context, resolver Resolver, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[host]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if c.clock.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.addrs, entry.err
+		}
+		c.removeElement(elem)
+	}
+
+	l, ok := c.inflight[host]
+	if !ok {
+		l = &lookup{done: make(chan struct{})}
+		c.inflight[host] = l
+		// The shared lookup must not be cut short by the first caller
+		// going away; each caller still honors its own context below.
+		go c.resolve(context.WithoutCancel(ctx), resolver, host, l)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return l.addrs, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Len returns the number of cached hosts
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) resolve(ctx context.Context, resolver Resolver, host string, l *lookup) {
+	l.addrs, l.err = resolver.LookupIPAddr(ctx, host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inflight, host)
+	close(l.done)
+
+	ttl := c.ttl
+	if l.err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(l.err, &dnsErr) || !dnsErr.IsNotFound {
+			return // transient failures are not cached
+		}
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	elem := c.lru.PushFront(&cacheEntry{
+		host:      host,
+		addrs:     l.addrs,
+		err:       l.err,
+		expiresAt: c.clock.Now().Add(ttl),
+	})
+	c.entries[host] = elem
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).host)
+}
+
+// DialContext returns a dial function that resolves hosts through resolver,
+// and through cache when it is non-nil, before dialing with dialer
+func DialContext(dialer *net.Dialer, resolver Resolver, cache *Cache) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		var addrs []net.IPAddr
+		if cache != nil {
+			addrs, err = cache.Lookup(ctx, resolver, host)
+		} else {
+			addrs, err = resolver.LookupIPAddr(ctx, host)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no addresses found for host %s", host)
+		}
+
+		// Try each address in turn, returning the first error if all fail
+		var firstErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+}
@@ -0,0 +1,110 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// BatchError reports the operations of a batch that failed. Errors has an
+// entry per operation, nil for the ones that succeeded.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("GraphQL batch: %d of %d operations failed, first: %v", failed, len(e.Errors), first)
+}
+
+// QueryBatch sends several operations in one HTTP request, for servers that
+// accept an array of operations, and decodes the data of requests[i] into
+// results[i]. A nil result discards that operation's data. When operations
+// fail the others are still decoded and a *BatchError is returned.
+func (gc *GraphQLClient) QueryBatch(requests []GraphQLRequest, results []interface{}) error {
+	return gc.QueryBatchContext(context.Background(), requests, results)
+}
+
+func (gc *GraphQLClient) QueryBatchContext(ctx context.Context, requests []GraphQLRequest, results []interface{}) error {
+	if len(results) != len(requests) {
+		return fmt.Errorf("GraphQL batch has %d requests but %d results", len(requests), len(results))
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	if gc.validate {
+		schema, err := gc.Schema(ctx)
+		if err != nil {
+			return err
+		}
+		for i, req := range requests {
+			if err := schema.Validate(req.Query, req.Variables); err != nil {
+				return fmt.Errorf("GraphQL batch operation %d: %w", i, err)
+			}
+		}
+	}
+
+	// The batch may only be retried when all of it only reads
+	idempotent := true
+	for _, req := range requests {
+		kind, _ := OperationKind(req.Query, req.OperationName)
+		idempotent = idempotent && kind == "query"
+	}
+
+	var raw json.RawMessage
+	status, err := gc.send(ctx, requests, &raw, idempotent)
+	if err != nil {
+		return err
+	}
+
+	// A server without batching support answers with a single error
+	// response instead of an array
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var gqlResp GraphQLResponse
+		if err := json.Unmarshal(trimmed, &gqlResp); err == nil && len(gqlResp.Errors) > 0 {
+			return &GraphQLErrors{Errors: gqlResp.Errors}
+		}
+		return fmt.Errorf("GraphQL batch: server did not return an array of responses")
+	}
+	if status >= 400 {
+		return fmt.Errorf("GraphQL HTTP error: %d %s", status, http.StatusText(status))
+	}
+
+	var responses []GraphQLResponse
+	if err := json.Unmarshal(raw, &responses); err != nil {
+		return fmt.Errorf("failed to decode GraphQL batch response: %w", err)
+	}
+	if len(responses) != len(requests) {
+		return fmt.Errorf("GraphQL batch: sent %d operations but got %d responses", len(requests), len(responses))
+	}
+
+	errs := make([]error, len(responses))
+	failed := false
+	for i, resp := range responses {
+		switch {
+		case len(resp.Errors) > 0:
+			errs[i] = &GraphQLErrors{Errors: resp.Errors}
+		case results[i] != nil && len(resp.Data) > 0:
+			if err := json.Unmarshal(resp.Data, results[i]); err != nil {
+				errs[i] = fmt.Errorf("failed to unmarshal GraphQL data: %w", err)
+			}
+		}
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
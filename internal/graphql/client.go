@@ -3,21 +3,57 @@ package graphql
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 )
 
+// Errors a server returns for Automatic Persisted Queries, matched by
+// message or by extensions.code
+const (
+	persistedQueryNotFound     = "PersistedQueryNotFound"
+	persistedQueryNotSupported = "PersistedQueryNotSupported"
+)
+
+// DoFunc posts a JSON encoded GraphQL payload and returns the status and
+// body of the response. Queries are idempotent, so unlike mutations they
+// may be retried. An error status may be returned as an error along with
+// the status and body, which are still decoded when they carry GraphQL
+// errors.
+type DoFunc func(ctx context.Context, payload []byte, idempotent bool) (status int, data []byte, err error)
+
 // GraphQLClient handles GraphQL requests
 type GraphQLClient struct {
 	endpoint string
 	client   *http.Client
+	do       DoFunc
 	headers  map[string]string
+
+	validate bool
+	schemaMu sync.Mutex
+	schema   *Schema
+
+	persistedQueries     bool
+	persistedUnsupported atomic.Bool
+
+	// Subscriptions share one graphql-transport-ws socket, see Subscribe
+	wsEndpoint  string
+	initPayload map[string]interface{}
+	dial        DialFunc
+	wsMu        sync.Mutex
+	ws          *subscriptionSocket
 }
 
 type GraphQLRequest struct {
-	Query     string                 `json:"query"`
-	Variables map[string]interface{} `json:"variables,omitempty"`
+	Query         string                 `json:"query,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
 }
 
 type GraphQLResponse struct {
@@ -25,6 +61,22 @@ type GraphQLResponse struct {
 	Errors []GraphQLError  `json:"errors,omitempty"`
 }
 
+// Decode unmarshals the data of the response into result, which may be
+// nil, and returns the errors of the response as *GraphQLErrors. Partial
+// data that comes with errors is still decoded.
+func (r *GraphQLResponse) Decode(result interface{}) error {
+	if result != nil && len(r.Data) > 0 && !bytes.Equal(r.Data, []byte("null")) {
+		if err := json.Unmarshal(r.Data, result); err != nil {
+			return fmt.Errorf("failed to unmarshal GraphQL data: %w", err)
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		return &GraphQLErrors{Errors: r.Errors}
+	}
+	return nil
+}
+
 type GraphQLError struct {
 	Message   string                 `json:"message"`
 	Locations []GraphQLLocation      `json:"locations,omitempty"`
@@ -38,9 +90,19 @@ type GraphQLLocation struct {
 }
 
 func NewGraphQLClient(endpoint string, client *http.Client) *GraphQLClient {
+	gc := NewGraphQLClientFunc(endpoint, nil)
+	gc.client = client
+	gc.do = gc.httpDo
+	return gc
+}
+
+// NewGraphQLClientFunc creates a client that sends queries with do, which
+// adds its own headers. Subscriptions connect to endpoint, or the URL of
+// WithSubscriptionEndpoint.
+func NewGraphQLClientFunc(endpoint string, do DoFunc) *GraphQLClient {
 	return &GraphQLClient{
 		endpoint: endpoint,
-		client:   client,
+		do:       do,
 		headers:  make(map[string]string),
 	}
 }
@@ -50,60 +112,207 @@ func (gc *GraphQLClient) WithHeader(key, value string) *GraphQLClient {
 	return gc
 }
 
+// WithValidation makes queries and their variables get validated against
+// the server's schema before they are sent, so typos and missing required
+// variables fail locally with a *ValidationError. The schema is introspected
+// on first use and cached.
+func (gc *GraphQLClient) WithValidation() *GraphQLClient {
+	gc.validate = true
+	return gc
+}
+
+// WithPersistedQueries enables Automatic Persisted Queries: only the
+// SHA-256 hash of the query is sent, and the full text follows once when the
+// server replies PersistedQueryNotFound. Servers that answer
+// PersistedQueryNotSupported get full queries from then on.
+func (gc *GraphQLClient) WithPersistedQueries(enabled bool) *GraphQLClient {
+	gc.persistedQueries = enabled
+	return gc
+}
+
+// Schema returns the server's schema, introspecting it on first use
+func (gc *GraphQLClient) Schema(ctx context.Context) (*Schema, error) {
+	gc.schemaMu.Lock()
+	defer gc.schemaMu.Unlock()
+
+	if gc.schema != nil {
+		return gc.schema, nil
+	}
+
+	var data json.RawMessage
+	if err := gc.execute(ctx, "", schemaIntrospectionQuery, nil, &data); err != nil {
+		return nil, fmt.Errorf("failed to introspect GraphQL schema: %w", err)
+	}
+	schema, err := ParseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+
+	gc.schema = schema
+	return schema, nil
+}
+
+// InvalidateSchema drops the cached schema, e.g. after the server was
+// redeployed with a new one
+func (gc *GraphQLClient) InvalidateSchema() {
+	gc.schemaMu.Lock()
+	gc.schema = nil
+	gc.schemaMu.Unlock()
+}
+
 func (gc *GraphQLClient) Query(query string, variables map[string]interface{}, result interface{}) error {
 	return gc.QueryContext(context.Background(), query, variables, result)
 }
 
 func (gc *GraphQLClient) QueryContext(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	return gc.QueryOpContext(ctx, "", query, variables, result)
+}
+
+// QueryOpContext runs the operation named opName from a query document
+// that may define several
+func (gc *GraphQLClient) QueryOpContext(ctx context.Context, opName, query string, variables map[string]interface{}, result interface{}) error {
+	if gc.validate {
+		schema, err := gc.Schema(ctx)
+		if err != nil {
+			return err
+		}
+		if err := schema.Validate(query, variables); err != nil {
+			return err
+		}
+	}
+
+	return gc.execute(ctx, opName, query, variables, result)
+}
+
+func (gc *GraphQLClient) execute(ctx context.Context, opName, query string, variables map[string]interface{}, result interface{}) error {
 	reqBody := GraphQLRequest{
-		Query:     query,
-		Variables: variables,
+		Query:         query,
+		OperationName: opName,
+		Variables:     variables,
 	}
 
-	jsonBody, err := json.Marshal(reqBody)
+	kind, _ := OperationKind(query, opName)
+	idempotent := kind == "query"
+
+	var gqlResp GraphQLResponse
+	var status int
+	var err error
+	if gc.persistedQueries && !gc.persistedUnsupported.Load() {
+		status, err = gc.sendPersisted(ctx, reqBody, &gqlResp, idempotent)
+	} else {
+		status, err = gc.send(ctx, reqBody, &gqlResp, idempotent)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal GraphQL request: %w", err)
+		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", gc.endpoint, bytes.NewReader(jsonBody))
+	if status >= 400 && len(gqlResp.Errors) == 0 {
+		return fmt.Errorf("GraphQL HTTP error: %d %s", status, http.StatusText(status))
+	}
+
+	return gqlResp.Decode(result)
+}
+
+// sendPersisted sends the hash of the query in place of its text and
+// registers the query with the server when it doesn't know the hash yet
+func (gc *GraphQLClient) sendPersisted(ctx context.Context, reqBody GraphQLRequest, out *GraphQLResponse, idempotent bool) (int, error) {
+	query := reqBody.Query
+	hash := sha256.Sum256([]byte(query))
+	reqBody.Query = ""
+	reqBody.Extensions = map[string]interface{}{
+		"persistedQuery": map[string]interface{}{
+			"version":    1,
+			"sha256Hash": hex.EncodeToString(hash[:]),
+		},
+	}
+
+	status, err := gc.send(ctx, reqBody, out, idempotent)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return status, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	switch persistedQueryError(out.Errors) {
+	case persistedQueryNotFound:
+		reqBody.Query = query
+	case persistedQueryNotSupported:
+		gc.persistedUnsupported.Store(true)
+		reqBody.Query = query
+		reqBody.Extensions = nil
+	default:
+		return status, nil
+	}
 
-	// Add custom headers
-	for key, value := range gc.headers {
-		req.Header.Set(key, value)
+	*out = GraphQLResponse{}
+	return gc.send(ctx, reqBody, out, idempotent)
+}
+
+func persistedQueryError(errors []GraphQLError) string {
+	for _, e := range errors {
+		code, _ := e.Extensions["code"].(string)
+		switch {
+		case e.Message == persistedQueryNotFound || code == "PERSISTED_QUERY_NOT_FOUND":
+			return persistedQueryNotFound
+		case e.Message == persistedQueryNotSupported || code == "PERSISTED_QUERY_NOT_SUPPORTED":
+			return persistedQueryNotSupported
+		}
 	}
+	return ""
+}
 
-	resp, err := gc.client.Do(req)
+// send posts payload as JSON and decodes the response body into out,
+// returning the status code. Bodies of error statuses are decoded when
+// possible since servers differ in the status they use for GraphQL errors.
+func (gc *GraphQLClient) send(ctx context.Context, payload, out interface{}, idempotent bool) (int, error) {
+	jsonBody, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("GraphQL request failed: %w", err)
+		return 0, fmt.Errorf("failed to marshal GraphQL request: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("GraphQL HTTP error: %d %s", resp.StatusCode, resp.Status)
+	status, data, err := gc.do(ctx, jsonBody, idempotent)
+	if err != nil && !hasGraphQLErrors(data) {
+		return status, err
 	}
 
-	var gqlResp GraphQLResponse
-	if err := json.NewDecoder(resp.Body).Decode(&gqlResp); err != nil {
-		return fmt.Errorf("failed to decode GraphQL response: %w", err)
+	if err := json.Unmarshal(data, out); err != nil && status < 400 {
+		return status, fmt.Errorf("failed to decode GraphQL response: %w", err)
+	}
+
+	return status, nil
+}
+
+// hasGraphQLErrors reports whether data is a response with errors
+func hasGraphQLErrors(data []byte) bool {
+	var resp GraphQLResponse
+	return json.Unmarshal(data, &resp) == nil && len(resp.Errors) > 0
+}
+
+// httpDo is the DoFunc of a client of NewGraphQLClient, which posts with its
+// http.Client and reports error statuses along with their body
+func (gc *GraphQLClient) httpDo(ctx context.Context, payload []byte, idempotent bool) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", gc.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	if len(gqlResp.Errors) > 0 {
-		return &GraphQLErrors{Errors: gqlResp.Errors}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	// Add custom headers
+	for key, value := range gc.headers {
+		req.Header.Set(key, value)
 	}
 
-	if result != nil && len(gqlResp.Data) > 0 {
-		if err := json.Unmarshal(gqlResp.Data, result); err != nil {
-			return fmt.Errorf("failed to unmarshal GraphQL data: %w", err)
-		}
+	resp, err := gc.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("GraphQL request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return nil
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to read GraphQL response: %w", err)
+	}
+	return resp.StatusCode, data, nil
 }
 
 // GraphQLErrors represents multiple GraphQL errors
@@ -118,20 +327,6 @@ func (e *GraphQLErrors) Error() string {
 	return fmt.Sprintf("GraphQL errors: %d errors occurred", len(e.Errors))
 }
 
-// Subscription support for GraphQL subscriptions over WebSocket
-type GraphQLSubscription struct {
-	client   *GraphQLClient
-	wsClient interface{} // WebSocket client would be injected
-}
-
-func (gc *GraphQLClient) Subscribe(query string, variables map[string]interface{}) (*GraphQLSubscription, error) {
-	// This would implement GraphQL subscriptions over WebSocket
-	// For now, return a placeholder
-	return &GraphQLSubscription{
-		client: gc,
-	}, nil
-}
-
 // Helper functions for common GraphQL operations
 func (gc *GraphQLClient) Introspect() (map[string]interface{}, error) {
 	introspectionQuery := `
@@ -0,0 +1,580 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This is a parser for the executable subset of the GraphQL query language
+// (operations and fragments), just enough to validate a query locally
+// before it is sent.
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunct, value: "...", pos: start}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, value: string(c), pos: start}, nil
+	case isNameStart(c):
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, l.errorf(start, "unexpected character %q", c)
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case ' ', '\t', '\n', '\r', ',':
+			l.pos++
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.src[l.pos:], "\ufeff") {
+				l.pos += len("\ufeff")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokenInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if !l.digits() {
+		return token{}, l.errorf(start, "invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if !l.digits() {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !l.digits() {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) digits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos > start
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		l.pos += 3
+		for l.pos < len(l.src) {
+			switch {
+			case strings.HasPrefix(l.src[l.pos:], `\"""`):
+				l.pos += 4
+			case strings.HasPrefix(l.src[l.pos:], `"""`):
+				l.pos += 3
+				return token{kind: tokenString, value: l.src[start+3 : l.pos-3], pos: start}, nil
+			default:
+				l.pos++
+			}
+		}
+		return token{}, l.errorf(start, "unterminated string")
+	}
+
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+			return token{kind: tokenString, value: l.src[start+1 : l.pos-1], pos: start}, nil
+		case '\n', '\r':
+			return token{}, l.errorf(start, "unterminated string")
+		default:
+			l.pos++
+		}
+	}
+	return token{}, l.errorf(start, "unterminated string")
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: syntax error: %s", location(l.src, pos), fmt.Sprintf(format, args...))
+}
+
+// location returns the line and column of pos in src
+func location(src string, pos int) string {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	line := strings.Count(src[:pos], "\n") + 1
+	column := pos - strings.LastIndex(src[:pos], "\n")
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// document is a parsed query
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []*variableDefinition
+	selections []*selection
+	pos        int
+}
+
+type variableDefinition struct {
+	name       string
+	typ        *TypeRef
+	hasDefault bool
+	pos        int
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	directives    []*directive
+	selections    []*selection
+	pos           int
+}
+
+type selectionKind int
+
+const (
+	fieldSelection selectionKind = iota
+	inlineFragment
+	fragmentSpread
+)
+
+// selection is a field, an inline fragment or a fragment spread
+type selection struct {
+	kind          selectionKind
+	name          string // field or fragment name
+	typeCondition string // inline fragments only
+	arguments     []*argument
+	directives    []*directive
+	selections    []*selection
+	pos           int
+}
+
+type argument struct {
+	name  string
+	value *value
+	pos   int
+}
+
+type directive struct {
+	name      string
+	arguments []*argument
+}
+
+type valueKind int
+
+const (
+	variableValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	booleanValue
+	nullValue
+	enumValue
+	listValue
+	objectValue
+)
+
+type value struct {
+	kind   valueKind
+	raw    string // variable name for variables
+	list   []*value
+	fields []*argument
+	pos    int
+}
+
+// parser keeps the first error it hits and stops consuming tokens after
+// that, so loops only need to check p.err to terminate
+type parser struct {
+	lex *lexer
+	tok token
+	err error
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lex: &lexer{src: src}}
+	p.advance()
+
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.err == nil && p.tok.kind != tokenEOF {
+		p.parseDefinition(doc)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("query contains no operations")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() {
+	if p.err != nil {
+		return
+	}
+	tok, err := p.lex.next()
+	if err != nil {
+		p.err = err
+		p.tok = token{kind: tokenEOF, pos: p.lex.pos}
+		return
+	}
+	p.tok = tok
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = p.lex.errorf(p.tok.pos, format, args...)
+	}
+}
+
+// peek reports whether the current token is the punctuator punct
+func (p *parser) peek(punct string) bool {
+	return p.err == nil && p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+// skip consumes the punctuator punct if it is the current token
+func (p *parser) skip(punct string) bool {
+	if !p.peek(punct) {
+		return false
+	}
+	p.advance()
+	return true
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.fail("expected %q, found %s", punct, p.tok)
+	}
+}
+
+func (p *parser) expectName() string {
+	if p.err != nil {
+		return ""
+	}
+	if p.tok.kind != tokenName {
+		p.fail("expected name, found %s", p.tok)
+		return ""
+	}
+	name := p.tok.value
+	p.advance()
+	return name
+}
+
+// OperationKind returns whether the operation named opName in query is a
+// "query", "mutation" or "subscription". An empty opName selects the only
+// operation of the document.
+func OperationKind(query, opName string) (string, error) {
+	doc, err := parse(query)
+	if err != nil {
+		return "", err
+	}
+	for _, op := range doc.operations {
+		if op.name == opName || (opName == "" && len(doc.operations) == 1) {
+			return op.kind, nil
+		}
+	}
+	if opName == "" {
+		return "", fmt.Errorf("query defines %d operations, an operation name is required", len(doc.operations))
+	}
+	return "", fmt.Errorf("query has no operation named %q", opName)
+}
+
+func (p *parser) parseDefinition(doc *document) {
+	pos := p.tok.pos
+
+	if p.peek("{") {
+		doc.operations = append(doc.operations, &operation{
+			kind:       "query",
+			selections: p.parseSelectionSet(),
+			pos:        pos,
+		})
+		return
+	}
+	if p.tok.kind != tokenName {
+		p.fail("unexpected %s", p.tok)
+		return
+	}
+
+	switch p.tok.value {
+	case "query", "mutation", "subscription":
+		op := &operation{kind: p.tok.value, pos: pos}
+		p.advance()
+		if p.tok.kind == tokenName {
+			op.name = p.expectName()
+		}
+		if p.peek("(") {
+			op.variables = p.parseVariableDefinitions()
+		}
+		p.parseDirectives()
+		op.selections = p.parseSelectionSet()
+		doc.operations = append(doc.operations, op)
+
+	case "fragment":
+		p.advance()
+		frag := &fragment{pos: pos}
+		if frag.name = p.expectName(); frag.name == "on" {
+			p.fail("fragment cannot be named \"on\"")
+			return
+		}
+		if p.expectName() != "on" && p.err == nil {
+			p.fail("expected \"on\" after fragment name")
+			return
+		}
+		frag.typeCondition = p.expectName()
+		frag.directives = p.parseDirectives()
+		frag.selections = p.parseSelectionSet()
+		if _, exists := doc.fragments[frag.name]; exists {
+			p.fail("fragment %q is defined more than once", frag.name)
+			return
+		}
+		doc.fragments[frag.name] = frag
+
+	default:
+		p.fail("unexpected %s", p.tok)
+	}
+}
+
+func (p *parser) parseVariableDefinitions() []*variableDefinition {
+	var defs []*variableDefinition
+	p.expect("(")
+	for p.err == nil && !p.skip(")") {
+		def := &variableDefinition{pos: p.tok.pos}
+		p.expect("$")
+		def.name = p.expectName()
+		p.expect(":")
+		def.typ = p.parseType()
+		if p.skip("=") {
+			p.parseValue(true)
+			def.hasDefault = true
+		}
+		p.parseDirectives()
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+func (p *parser) parseType() *TypeRef {
+	var typ *TypeRef
+	if p.skip("[") {
+		typ = &TypeRef{Kind: "LIST", OfType: p.parseType()}
+		p.expect("]")
+	} else {
+		typ = &TypeRef{Name: p.expectName()}
+	}
+	if p.skip("!") {
+		typ = &TypeRef{Kind: "NON_NULL", OfType: typ}
+	}
+	return typ
+}
+
+func (p *parser) parseSelectionSet() []*selection {
+	var selections []*selection
+	p.expect("{")
+	for p.err == nil && !p.skip("}") {
+		selections = append(selections, p.parseSelection())
+	}
+	if p.err == nil && len(selections) == 0 {
+		p.fail("selection set cannot be empty")
+	}
+	return selections
+}
+
+func (p *parser) parseSelection() *selection {
+	pos := p.tok.pos
+
+	if p.skip("...") {
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			return &selection{
+				kind:       fragmentSpread,
+				name:       p.expectName(),
+				directives: p.parseDirectives(),
+				pos:        pos,
+			}
+		}
+		sel := &selection{kind: inlineFragment, pos: pos}
+		if p.tok.kind == tokenName && p.tok.value == "on" {
+			p.advance()
+			sel.typeCondition = p.expectName()
+		}
+		sel.directives = p.parseDirectives()
+		sel.selections = p.parseSelectionSet()
+		return sel
+	}
+
+	sel := &selection{kind: fieldSelection, name: p.expectName(), pos: pos}
+	if p.skip(":") {
+		// The name was an alias
+		sel.name = p.expectName()
+	}
+	if p.peek("(") {
+		sel.arguments = p.parseArguments(false)
+	}
+	sel.directives = p.parseDirectives()
+	if p.peek("{") {
+		sel.selections = p.parseSelectionSet()
+	}
+	return sel
+}
+
+func (p *parser) parseArguments(constant bool) []*argument {
+	var args []*argument
+	p.expect("(")
+	for p.err == nil && !p.skip(")") {
+		arg := &argument{pos: p.tok.pos, name: p.expectName()}
+		p.expect(":")
+		arg.value = p.parseValue(constant)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) parseDirectives() []*directive {
+	var directives []*directive
+	for p.skip("@") {
+		d := &directive{name: p.expectName()}
+		if p.peek("(") {
+			d.arguments = p.parseArguments(false)
+		}
+		directives = append(directives, d)
+	}
+	return directives
+}
+
+func (p *parser) parseValue(constant bool) *value {
+	v := &value{pos: p.tok.pos}
+
+	switch {
+	case p.peek("$"):
+		if constant {
+			p.fail("variables are not allowed in default values")
+			return v
+		}
+		p.advance()
+		v.kind = variableValue
+		v.raw = p.expectName()
+		return v
+
+	case p.skip("["):
+		v.kind = listValue
+		for p.err == nil && !p.skip("]") {
+			v.list = append(v.list, p.parseValue(constant))
+		}
+		return v
+
+	case p.skip("{"):
+		v.kind = objectValue
+		for p.err == nil && !p.skip("}") {
+			field := &argument{pos: p.tok.pos, name: p.expectName()}
+			p.expect(":")
+			field.value = p.parseValue(constant)
+			v.fields = append(v.fields, field)
+		}
+		return v
+	}
+
+	v.raw = p.tok.value
+	switch p.tok.kind {
+	case tokenInt:
+		v.kind = intValue
+	case tokenFloat:
+		v.kind = floatValue
+	case tokenString:
+		v.kind = stringValue
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = booleanValue
+		case "null":
+			v.kind = nullValue
+		default:
+			v.kind = enumValue
+		}
+	default:
+		p.fail("expected value, found %s", p.tok)
+		return v
+	}
+	p.advance()
+	return v
+}
@@ -0,0 +1,522 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// schemaIntrospectionQuery fetches everything needed to validate queries
+const schemaIntrospectionQuery = `
+	query SchemaIntrospection {
+		__schema {
+			queryType { name }
+			mutationType { name }
+			subscriptionType { name }
+			types {
+				kind
+				name
+				fields(includeDeprecated: true) {
+					name
+					args { name defaultValue type { ...TypeRef } }
+					type { ...TypeRef }
+				}
+				inputFields { name defaultValue type { ...TypeRef } }
+				enumValues(includeDeprecated: true) { name }
+			}
+		}
+	}
+
+	fragment TypeRef on __Type {
+		kind name ofType { kind name ofType { kind name ofType { kind name ofType {
+			kind name ofType { kind name ofType { kind name ofType { kind name } } }
+		} } } }
+	}
+`
+
+// Schema is the introspected type system of a GraphQL server
+type Schema struct {
+	QueryType        string
+	MutationType     string
+	SubscriptionType string
+	Types            map[string]*SchemaType
+}
+
+// SchemaType is a named type in the schema
+type SchemaType struct {
+	Kind        string        `json:"kind"`
+	Name        string        `json:"name"`
+	Fields      []*Field      `json:"fields"`
+	InputFields []*InputValue `json:"inputFields"`
+	EnumValues  []EnumValue   `json:"enumValues"`
+}
+
+// Field is a field of an object or interface type
+type Field struct {
+	Name string        `json:"name"`
+	Args []*InputValue `json:"args"`
+	Type *TypeRef      `json:"type"`
+}
+
+// InputValue is a field argument or an input object field
+type InputValue struct {
+	Name         string   `json:"name"`
+	Type         *TypeRef `json:"type"`
+	DefaultValue *string  `json:"defaultValue"`
+}
+
+// EnumValue is a value of an enum type
+type EnumValue struct {
+	Name string `json:"name"`
+}
+
+// TypeRef references a type, possibly wrapped in lists and non-null
+type TypeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *TypeRef `json:"ofType"`
+}
+
+func (t *TypeRef) String() string {
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// NamedType returns the name of the type with lists and non-null unwrapped
+func (t *TypeRef) NamedType() string {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t.Name
+}
+
+func (t *TypeRef) required() bool {
+	return t.Kind == "NON_NULL"
+}
+
+// ParseSchema builds a Schema from the data of an introspection query
+// selecting __schema
+func ParseSchema(data []byte) (*Schema, error) {
+	var result struct {
+		Schema *struct {
+			QueryType        *struct{ Name string } `json:"queryType"`
+			MutationType     *struct{ Name string } `json:"mutationType"`
+			SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+			Types            []*SchemaType          `json:"types"`
+		} `json:"__schema"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode GraphQL schema: %w", err)
+	}
+	if result.Schema == nil || result.Schema.QueryType == nil {
+		return nil, fmt.Errorf("GraphQL introspection result has no query type")
+	}
+
+	schema := &Schema{
+		QueryType: result.Schema.QueryType.Name,
+		Types:     make(map[string]*SchemaType, len(result.Schema.Types)),
+	}
+	if result.Schema.MutationType != nil {
+		schema.MutationType = result.Schema.MutationType.Name
+	}
+	if result.Schema.SubscriptionType != nil {
+		schema.SubscriptionType = result.Schema.SubscriptionType.Name
+	}
+	for _, t := range result.Schema.Types {
+		schema.Types[t.Name] = t
+	}
+	return schema, nil
+}
+
+func (t *SchemaType) field(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *SchemaType) inputField(name string) *InputValue {
+	return findInputValue(t.InputFields, name)
+}
+
+func (t *SchemaType) hasEnumValue(name string) bool {
+	for _, v := range t.EnumValues {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SchemaType) composite() bool {
+	return t.Kind == "OBJECT" || t.Kind == "INTERFACE" || t.Kind == "UNION"
+}
+
+func (t *SchemaType) input() bool {
+	return t.Kind == "SCALAR" || t.Kind == "ENUM" || t.Kind == "INPUT_OBJECT"
+}
+
+func findInputValue(values []*InputValue, name string) *InputValue {
+	for _, v := range values {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// ValidationError lists the problems found when validating a query and
+// its variables against the schema
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("GraphQL validation error: %s", e.Errors[0])
+	}
+	return fmt.Sprintf("GraphQL validation errors: %s", strings.Join(e.Errors, "; "))
+}
+
+// Validate checks that query is well-formed, only selects fields and
+// arguments that exist in the schema, and that variables supplies every
+// required variable with a value of the declared type. It returns a
+// *ValidationError describing every problem found.
+func (s *Schema) Validate(query string, variables map[string]interface{}) error {
+	doc, err := parse(query)
+	if err != nil {
+		return &ValidationError{Errors: []string{err.Error()}}
+	}
+
+	v := &validator{schema: s, doc: doc, query: query}
+	for _, op := range doc.operations {
+		v.operation(op, variables)
+	}
+	if len(v.errors) > 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+type validator struct {
+	schema *Schema
+	doc    *document
+	query  string
+	errors []string
+
+	// Per operation
+	usedVariables map[string]int // name -> position of first use
+	fragments     map[string]bool
+}
+
+func (v *validator) errorf(pos int, format string, args ...interface{}) {
+	v.errors = append(v.errors, location(v.query, pos)+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) operation(op *operation, variables map[string]interface{}) {
+	v.usedVariables = make(map[string]int)
+	v.fragments = make(map[string]bool)
+
+	rootName := map[string]string{
+		"query":        v.schema.QueryType,
+		"mutation":     v.schema.MutationType,
+		"subscription": v.schema.SubscriptionType,
+	}[op.kind]
+	root := v.schema.Types[rootName]
+	if root == nil {
+		v.errorf(op.pos, "schema does not support %s operations", op.kind)
+		return
+	}
+	v.selectionSet(root, op.selections)
+
+	opName := op.name
+	if opName == "" {
+		opName = "(anonymous)"
+	}
+
+	defined := make(map[string]bool)
+	for _, def := range op.variables {
+		if defined[def.name] {
+			v.errorf(def.pos, "variable $%s is defined more than once", def.name)
+			continue
+		}
+		defined[def.name] = true
+
+		t := v.schema.Types[def.typ.NamedType()]
+		if t == nil {
+			v.errorf(def.pos, "variable $%s has unknown type %s", def.name, def.typ)
+			continue
+		}
+		if !t.input() {
+			v.errorf(def.pos, "variable $%s cannot be of output type %s", def.name, def.typ)
+			continue
+		}
+		if _, used := v.usedVariables[def.name]; !used {
+			v.errorf(def.pos, "variable $%s is never used in operation %s", def.name, opName)
+		}
+
+		value, ok := variables[def.name]
+		if !ok || value == nil {
+			if def.typ.required() && !def.hasDefault {
+				v.addf("variable $%s of required type %s was not provided", def.name, def.typ)
+			}
+			continue
+		}
+		// Normalize Go values to their JSON form, which is what the
+		// server sees
+		data, err := json.Marshal(value)
+		if err != nil {
+			v.addf("variable $%s: %v", def.name, err)
+			continue
+		}
+		var normalized interface{}
+		json.Unmarshal(data, &normalized)
+		v.checkValue("$"+def.name, def.typ, normalized)
+	}
+
+	used := make([]string, 0, len(v.usedVariables))
+	for name := range v.usedVariables {
+		if !defined[name] {
+			used = append(used, name)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return v.usedVariables[used[i]] < v.usedVariables[used[j]] })
+	for _, name := range used {
+		v.errorf(v.usedVariables[name], "variable $%s is not defined by operation %s", name, opName)
+	}
+}
+
+func (v *validator) selectionSet(parent *SchemaType, selections []*selection) {
+	for _, sel := range selections {
+		v.directives(sel.directives)
+
+		switch sel.kind {
+		case fieldSelection:
+			v.field(parent, sel)
+
+		case inlineFragment:
+			t := parent
+			if sel.typeCondition != "" {
+				if t = v.schema.Types[sel.typeCondition]; t == nil {
+					v.errorf(sel.pos, "unknown type %q in inline fragment", sel.typeCondition)
+					continue
+				}
+			}
+			v.selectionSet(t, sel.selections)
+
+		case fragmentSpread:
+			frag := v.doc.fragments[sel.name]
+			if frag == nil {
+				v.errorf(sel.pos, "unknown fragment %q", sel.name)
+				continue
+			}
+			if v.fragments[frag.name] {
+				continue
+			}
+			v.fragments[frag.name] = true
+
+			t := v.schema.Types[frag.typeCondition]
+			if t == nil {
+				v.errorf(frag.pos, "unknown type %q in fragment %q", frag.typeCondition, frag.name)
+				continue
+			}
+			v.directives(frag.directives)
+			v.selectionSet(t, frag.selections)
+		}
+	}
+}
+
+func (v *validator) field(parent *SchemaType, sel *selection) {
+	if sel.name == "__typename" {
+		if sel.selections != nil {
+			v.errorf(sel.pos, "field \"__typename\" cannot have a selection of subfields")
+		}
+		return
+	}
+	if parent.Name == v.schema.QueryType && (sel.name == "__schema" || sel.name == "__type") {
+		// Introspection fields aren't listed in the schema
+		v.arguments(sel.arguments)
+		return
+	}
+
+	def := parent.field(sel.name)
+	if def == nil {
+		v.errorf(sel.pos, "cannot query field %q on type %q", sel.name, parent.Name)
+		v.arguments(sel.arguments)
+		return
+	}
+
+	v.arguments(sel.arguments)
+	for _, arg := range sel.arguments {
+		if findInputValue(def.Args, arg.name) == nil {
+			v.errorf(arg.pos, "unknown argument %q on field %s.%s", arg.name, parent.Name, def.Name)
+		}
+	}
+	for _, argDef := range def.Args {
+		if !argDef.Type.required() || argDef.DefaultValue != nil {
+			continue
+		}
+		provided := false
+		for _, arg := range sel.arguments {
+			provided = provided || (arg.name == argDef.Name && arg.value.kind != nullValue)
+		}
+		if !provided {
+			v.errorf(sel.pos, "field %s.%s requires argument %q of type %s", parent.Name, def.Name, argDef.Name, argDef.Type)
+		}
+	}
+
+	t := v.schema.Types[def.Type.NamedType()]
+	if t == nil {
+		return
+	}
+	switch {
+	case t.composite() && sel.selections == nil:
+		v.errorf(sel.pos, "field %q of type %s must have a selection of subfields", sel.name, def.Type)
+	case t.composite():
+		v.selectionSet(t, sel.selections)
+	case sel.selections != nil:
+		v.errorf(sel.pos, "field %q of type %s cannot have a selection of subfields", sel.name, def.Type)
+	}
+}
+
+func (v *validator) directives(directives []*directive) {
+	for _, d := range directives {
+		v.arguments(d.arguments)
+	}
+}
+
+// arguments records the variables used in args
+func (v *validator) arguments(args []*argument) {
+	for _, arg := range args {
+		v.useVariables(arg.value)
+	}
+}
+
+func (v *validator) useVariables(val *value) {
+	switch val.kind {
+	case variableValue:
+		if _, ok := v.usedVariables[val.raw]; !ok {
+			v.usedVariables[val.raw] = val.pos
+		}
+	case listValue:
+		for _, item := range val.list {
+			v.useVariables(item)
+		}
+	case objectValue:
+		v.arguments(val.fields)
+	}
+}
+
+// checkValue checks a JSON-decoded variable value against typ
+func (v *validator) checkValue(path string, typ *TypeRef, val interface{}) {
+	if typ.required() {
+		if val == nil {
+			v.addf("%s: expected non-null %s", path, typ.OfType)
+			return
+		}
+		v.checkValue(path, typ.OfType, val)
+		return
+	}
+	if val == nil {
+		return
+	}
+
+	if typ.Kind == "LIST" {
+		list, ok := val.([]interface{})
+		if !ok {
+			// A single value is coerced to a list of one
+			v.checkValue(path, typ.OfType, val)
+			return
+		}
+		for i, item := range list {
+			v.checkValue(fmt.Sprintf("%s[%d]", path, i), typ.OfType, item)
+		}
+		return
+	}
+
+	t := v.schema.Types[typ.Name]
+	if t == nil {
+		return
+	}
+
+	switch t.Kind {
+	case "SCALAR":
+		ok := true
+		switch t.Name {
+		case "Int":
+			n, isNumber := val.(float64)
+			ok = isNumber && n == math.Trunc(n)
+		case "Float":
+			_, ok = val.(float64)
+		case "String":
+			_, ok = val.(string)
+		case "Boolean":
+			_, ok = val.(bool)
+		case "ID":
+			n, isNumber := val.(float64)
+			_, isString := val.(string)
+			ok = isString || (isNumber && n == math.Trunc(n))
+		}
+		if !ok {
+			v.addf("%s: expected %s, got %s", path, t.Name, jsonKind(val))
+		}
+
+	case "ENUM":
+		if s, ok := val.(string); !ok || !t.hasEnumValue(s) {
+			v.addf("%s: %v is not a valid %s value", path, val, t.Name)
+		}
+
+	case "INPUT_OBJECT":
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			v.addf("%s: expected %s object, got %s", path, t.Name, jsonKind(val))
+			return
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if t.inputField(name) == nil {
+				v.addf("%s: unknown field %q for %s", path, name, t.Name)
+			}
+		}
+		for _, f := range t.InputFields {
+			fieldVal, present := obj[f.Name]
+			if !present && f.DefaultValue != nil {
+				continue
+			}
+			v.checkValue(path+"."+f.Name, f.Type, fieldVal)
+		}
+	}
+}
+
+func jsonKind(val interface{}) string {
+	switch val.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
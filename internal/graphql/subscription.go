@@ -0,0 +1,438 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+// graphqlTransportWS is the WebSocket subprotocol of the graphql-ws
+// library, see https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const graphqlTransportWS = "graphql-transport-ws"
+
+// connectionAckTimeout bounds the wait for the server to acknowledge
+// connection_init when the context has no earlier deadline
+const connectionAckTimeout = 10 * time.Second
+
+// ErrSubscriptionsClosed is returned by a subscription whose socket was
+// closed, e.g. because the server went away
+var ErrSubscriptionsClosed = errors.New("GraphQL subscription socket closed")
+
+// wsMessage is a graphql-transport-ws protocol message
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WithSubscriptionEndpoint sets the URL subscriptions connect to. By default
+// it is the query endpoint, with http(s) turned into ws(s).
+func (gc *GraphQLClient) WithSubscriptionEndpoint(endpoint string) *GraphQLClient {
+	gc.wsEndpoint = endpoint
+	return gc
+}
+
+// DialFunc opens the WebSocket of subscriptions to endpoint, offering
+// subprotocol in the handshake
+type DialFunc func(ctx context.Context, endpoint, subprotocol string) (*streaming.WebSocketConn, error)
+
+// WithSubscriptionDialer makes subscriptions connect with dial, which adds
+// its own headers, in place of a dialer set up from the http.Client
+func (gc *GraphQLClient) WithSubscriptionDialer(dial DialFunc) *GraphQLClient {
+	gc.dial = dial
+	return gc
+}
+
+// WithConnectionInitPayload sets the payload of the connection_init message
+// that opens the subscription socket, where servers expect credentials
+func (gc *GraphQLClient) WithConnectionInitPayload(payload map[string]interface{}) *GraphQLClient {
+	gc.initPayload = payload
+	return gc
+}
+
+// GraphQLSubscription is a running subscription. Events delivers the
+// payload of each result until the server completes the subscription, it
+// fails or Close is called; Err then tells which. Results are queued per
+// subscription, so one slow reader doesn't hold up the others on the
+// socket.
+type GraphQLSubscription struct {
+	id     string
+	socket *subscriptionSocket
+	events chan GraphQLResponse
+
+	mu     sync.Mutex
+	queue  []GraphQLResponse
+	ended  bool // no more results; queued ones are still delivered
+	err    error
+	notify chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{} // Close was called, queued results are dropped
+}
+
+// Events returns the results of the subscription. Each one may carry
+// errors for part of the data; use Decode to read it.
+func (s *GraphQLSubscription) Events() <-chan GraphQLResponse {
+	return s.events
+}
+
+// Err returns why the subscription ended once Events is closed: nil when
+// the server completed it or Close was called, *GraphQLErrors when the
+// server reported an error, or the error that broke the socket
+func (s *GraphQLSubscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops the subscription. The socket is closed along with the last
+// subscription using it.
+func (s *GraphQLSubscription) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return s.socket.unsubscribe(s, true)
+}
+
+// push queues a result for the subscriber
+func (s *GraphQLSubscription) push(resp GraphQLResponse) {
+	s.mu.Lock()
+	if !s.ended {
+		s.queue = append(s.queue, resp)
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// end marks the subscription done with err; Events is closed once the
+// queued results are read
+func (s *GraphQLSubscription) end(err error) {
+	s.mu.Lock()
+	if !s.ended {
+		s.ended = true
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *GraphQLSubscription) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pump moves queued results to Events
+func (s *GraphQLSubscription) pump() {
+	defer close(s.events)
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			ended := s.ended
+			s.mu.Unlock()
+			if ended {
+				return
+			}
+			select {
+			case <-s.notify:
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.events <- next:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Subscribe starts a subscription over a graphql-transport-ws WebSocket.
+// All subscriptions of the client share one socket, opened by the first.
+func (gc *GraphQLClient) Subscribe(query string, variables map[string]interface{}) (*GraphQLSubscription, error) {
+	return gc.SubscribeContext(context.Background(), "", query, variables)
+}
+
+// SubscribeContext is Subscribe for the operation named opName, which may
+// be empty. ctx bounds connecting; the subscription runs until Close.
+func (gc *GraphQLClient) SubscribeContext(ctx context.Context, opName, query string, variables map[string]interface{}) (*GraphQLSubscription, error) {
+	if gc.validate {
+		schema, err := gc.Schema(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := schema.Validate(query, variables); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := json.Marshal(GraphQLRequest{
+		Query:         query,
+		OperationName: opName,
+		Variables:     variables,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GraphQL request: %w", err)
+	}
+
+	gc.wsMu.Lock()
+	socket := gc.ws
+	if socket == nil {
+		socket, err = gc.dialSubscriptions(ctx)
+		if err != nil {
+			gc.wsMu.Unlock()
+			return nil, err
+		}
+		gc.ws = socket
+	}
+	sub := socket.add()
+	gc.wsMu.Unlock()
+
+	if err := socket.send(wsMessage{ID: sub.id, Type: "subscribe", Payload: payload}); err != nil {
+		socket.unsubscribe(sub, false)
+		return nil, fmt.Errorf("failed to start GraphQL subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// dialSubscriptions opens the socket and waits for the server to accept
+// connection_init. The caller holds wsMu.
+func (gc *GraphQLClient) dialSubscriptions(ctx context.Context) (*subscriptionSocket, error) {
+	endpoint := gc.wsEndpoint
+	if endpoint == "" {
+		endpoint = gc.endpoint
+	}
+
+	dial := gc.dial
+	if dial == nil {
+		dial = gc.dialHTTP
+	}
+	conn, err := dial(ctx, endpoint, graphqlTransportWS)
+	if err != nil {
+		return nil, fmt.Errorf("GraphQL subscription connect failed: %w", err)
+	}
+	if conn.Subprotocol() != graphqlTransportWS {
+		conn.Close()
+		return nil, fmt.Errorf("GraphQL subscription server doesn't support %s", graphqlTransportWS)
+	}
+
+	s := &subscriptionSocket{
+		gc:     gc,
+		conn:   conn,
+		subs:   make(map[string]*GraphQLSubscription),
+		acked:  make(chan struct{}),
+		failed: make(chan struct{}),
+	}
+	go s.readLoop()
+
+	init := wsMessage{Type: "connection_init"}
+	if gc.initPayload != nil {
+		if init.Payload, err = json.Marshal(gc.initPayload); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to marshal connection_init payload: %w", err)
+		}
+	}
+	if err := s.send(init); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("GraphQL subscription connection_init failed: %w", err)
+	}
+
+	timer := time.NewTimer(connectionAckTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.acked:
+		return s, nil
+	case <-s.failed:
+		return nil, fmt.Errorf("GraphQL subscription connection_init failed: %w", s.err)
+	case <-ctx.Done():
+		conn.Close()
+		return nil, ctx.Err()
+	case <-timer.C:
+		conn.Close()
+		return nil, fmt.Errorf("GraphQL subscription server didn't acknowledge connection_init within %s", connectionAckTimeout)
+	}
+}
+
+// dialHTTP is the DialFunc of a client without WithSubscriptionDialer,
+// which dials with the headers and transport settings of the client
+func (gc *GraphQLClient) dialHTTP(ctx context.Context, endpoint, subprotocol string) (*streaming.WebSocketConn, error) {
+	dialer := streaming.NewWebSocketDialer().WithSubprotocols(subprotocol)
+	for key, value := range gc.headers {
+		dialer.WithHeader(key, value)
+	}
+	if gc.client != nil {
+		if t, ok := gc.client.Transport.(*http.Transport); ok {
+			dialer.WithTLSConfig(t.TLSClientConfig).WithProxy(t.Proxy)
+		}
+		dialer.WithCookieJar(gc.client.Jar)
+	}
+	return dialer.DialContext(ctx, endpoint)
+}
+
+// subscriptionSocket multiplexes the subscriptions of a client over one
+// WebSocket. Locks are taken in the order GraphQLClient.wsMu, then mu.
+type subscriptionSocket struct {
+	gc   *GraphQLClient
+	conn *streaming.WebSocketConn
+
+	mu     sync.Mutex
+	subs   map[string]*GraphQLSubscription
+	nextID int
+	closed bool
+
+	acked  chan struct{}
+	failed chan struct{}
+	err    error // why the socket failed, set before failed is closed
+}
+
+func (s *subscriptionSocket) add() *GraphQLSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	sub := &GraphQLSubscription{
+		id:     strconv.Itoa(s.nextID),
+		socket: s,
+		events: make(chan GraphQLResponse),
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	s.subs[sub.id] = sub
+	go sub.pump()
+	return sub
+}
+
+func (s *subscriptionSocket) send(msg wsMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.Send(string(data))
+}
+
+// unsubscribe ends sub, telling the server when it still runs there, and
+// closes the socket when no subscriptions are left
+func (s *subscriptionSocket) unsubscribe(sub *GraphQLSubscription, notify bool) error {
+	s.gc.wsMu.Lock()
+	s.mu.Lock()
+	_, active := s.subs[sub.id]
+	delete(s.subs, sub.id)
+	last := active && len(s.subs) == 0 && !s.closed
+	if last {
+		s.closed = true
+		if s.gc.ws == s {
+			s.gc.ws = nil
+		}
+	}
+	s.mu.Unlock()
+	s.gc.wsMu.Unlock()
+
+	sub.end(nil)
+	if !active {
+		return nil
+	}
+
+	var err error
+	if notify {
+		err = s.send(wsMessage{ID: sub.id, Type: "complete"})
+	}
+	if last {
+		if closeErr := s.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (s *subscriptionSocket) readLoop() {
+	for {
+		data, err := s.conn.Receive()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.conn.CloseWithStatus(4400, "invalid message")
+			s.fail(fmt.Errorf("invalid graphql-transport-ws message: %w", err))
+			return
+		}
+
+		switch msg.Type {
+		case "connection_ack":
+			select {
+			case <-s.acked:
+			default:
+				close(s.acked)
+			}
+		case "ping":
+			s.send(wsMessage{Type: "pong", Payload: msg.Payload})
+		case "next":
+			if sub := s.lookup(msg.ID); sub != nil {
+				var resp GraphQLResponse
+				if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+					resp.Errors = []GraphQLError{{Message: fmt.Sprintf("invalid subscription result: %v", err)}}
+				}
+				sub.push(resp)
+			}
+		case "error":
+			if sub := s.lookup(msg.ID); sub != nil {
+				var errs []GraphQLError
+				if err := json.Unmarshal(msg.Payload, &errs); err != nil || len(errs) == 0 {
+					errs = []GraphQLError{{Message: "subscription failed"}}
+				}
+				sub.end(&GraphQLErrors{Errors: errs})
+				s.unsubscribe(sub, false)
+			}
+		case "complete":
+			if sub := s.lookup(msg.ID); sub != nil {
+				s.unsubscribe(sub, false)
+			}
+		}
+	}
+}
+
+func (s *subscriptionSocket) lookup(id string) *GraphQLSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subs[id]
+}
+
+// fail ends every subscription on the socket with err
+func (s *subscriptionSocket) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+		close(s.failed)
+	}
+	s.mu.Unlock()
+
+	s.gc.wsMu.Lock()
+	s.mu.Lock()
+	s.closed = true
+	if s.gc.ws == s {
+		s.gc.ws = nil
+	}
+	subs := s.subs
+	s.subs = make(map[string]*GraphQLSubscription)
+	s.mu.Unlock()
+	s.gc.wsMu.Unlock()
+
+	for _, sub := range subs {
+		sub.end(fmt.Errorf("%w: %w", ErrSubscriptionsClosed, err))
+	}
+	s.conn.Close()
+}
@@ -0,0 +1,122 @@
+package loadbalancer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// KeyedLoadBalancer is implemented by load balancers that pick the endpoint
+// from a request key, so requests with the same key reach the same endpoint
+type KeyedLoadBalancer interface {
+	LoadBalancer
+	NextEndpointForKey(key string) string
+}
+
+// virtualNodes is the number of points each endpoint gets on the hash ring.
+// More points spread keys more evenly between endpoints.
+const virtualNodes = 160
+
+// Consistent Hash Load Balancer
+type consistentHashLB struct {
+	endpoints []string
+	ring      []uint32          // sorted point hashes
+	owners    map[uint32]string // point hash to endpoint
+	mu        sync.RWMutex
+}
+
+// NewConsistentHashLB creates a load balancer that maps request keys to
+// endpoints on a hash ring. Adding or removing an endpoint only moves the
+// keys between it and its ring neighbours, about 1/n of them.
+func NewConsistentHashLB(endpoints []string) LoadBalancer {
+	ch := &consistentHashLB{endpoints: append([]string(nil), endpoints...)}
+	ch.rebuild()
+	return ch
+}
+
+// NextEndpoint picks the endpoint for the empty key
+func (ch *consistentHashLB) NextEndpoint() string {
+	return ch.NextEndpointForKey("")
+}
+
+func (ch *consistentHashLB) NextEndpointForKey(key string) string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	if len(ch.ring) == 0 {
+		return ""
+	}
+
+	// The first point clockwise from the key owns it
+	h := hashKey(key)
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i] >= h })
+	if i == len(ch.ring) {
+		i = 0
+	}
+	return ch.owners[ch.ring[i]]
+}
+
+// ReleaseEndpoint is a no-op; the selection doesn't depend on load
+func (ch *consistentHashLB) ReleaseEndpoint(endpoint string) {}
+
+func (ch *consistentHashLB) AddEndpoint(endpoint string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.endpoints = append(ch.endpoints, endpoint)
+	ch.rebuild()
+}
+
+func (ch *consistentHashLB) RemoveEndpoint(endpoint string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for i, ep := range ch.endpoints {
+		if ep == endpoint {
+			ch.endpoints = append(ch.endpoints[:i], ch.endpoints[i+1:]...)
+			break
+		}
+	}
+	ch.rebuild()
+}
+
+func (ch *consistentHashLB) GetHealthyEndpoints() []string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	result := make([]string, len(ch.endpoints))
+	copy(result, ch.endpoints)
+	return result
+}
+
+// rebuild recomputes the ring from the endpoints; the caller holds the
+// write lock or has exclusive access
+func (ch *consistentHashLB) rebuild() {
+	ch.ring = make([]uint32, 0, len(ch.endpoints)*virtualNodes)
+	ch.owners = make(map[uint32]string, len(ch.endpoints)*virtualNodes)
+	for _, ep := range ch.endpoints {
+		for i := 0; i < virtualNodes; i++ {
+			h := hashKey(ep + "#" + strconv.Itoa(i))
+			if _, taken := ch.owners[h]; taken {
+				continue
+			}
+			ch.owners[h] = ep
+			ch.ring = append(ch.ring, h)
+		}
+	}
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
+}
+
+// hashKey hashes key with FNV-1a, then mixes the bits so that similar keys
+// like "endpoint#1" and "endpoint#2" land far apart on the ring
+func hashKey(key string) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x)
+}
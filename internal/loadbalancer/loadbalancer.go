@@ -1,26 +1,37 @@
 package loadbalancer
 
 import (
-	"math/rand"
+	"fmt"
+	"math/rand/v2"
+	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 )
 
 // LoadBalancer defines the interface for load balancing strategies
 type LoadBalancer interface {
 	NextEndpoint() string
+	// ReleaseEndpoint is called when a request sent to an endpoint returned
+	// by NextEndpoint has finished, whether it succeeded or not
+	ReleaseEndpoint(endpoint string)
 	AddEndpoint(endpoint string)
 	RemoveEndpoint(endpoint string)
 	GetHealthyEndpoints() []string
 }
 
+// ConnectionCounter is implemented by load balancers that track in-flight
+// requests per endpoint
+type ConnectionCounter interface {
+	Connections() map[string]int64
+}
+
 // Strategy types
 const (
 	RoundRobin     = "round-robin"
 	Random         = "random"
 	WeightedRandom = "weighted-random"
 	LeastConn      = "least-conn"
+	ConsistentHash = "consistent-hash"
 )
 
 // New creates a new load balancer with the specified strategy
@@ -32,6 +43,8 @@ func New(endpoints []string, strategy string) LoadBalancer {
 		return NewWeightedRandomLB(endpoints)
 	case LeastConn:
 		return NewLeastConnLB(endpoints)
+	case ConsistentHash:
+		return NewConsistentHashLB(endpoints)
 	default:
 		return NewRoundRobinLB(endpoints)
 	}
@@ -62,6 +75,9 @@ func (rr *roundRobinLB) NextEndpoint() string {
 	return rr.endpoints[index]
 }
 
+// ReleaseEndpoint is a no-op; the selection doesn't depend on load
+func (rr *roundRobinLB) ReleaseEndpoint(endpoint string) {}
+
 func (rr *roundRobinLB) AddEndpoint(endpoint string) {
 	rr.mu.Lock()
 	defer rr.mu.Unlock()
@@ -92,14 +108,12 @@ func (rr *roundRobinLB) GetHealthyEndpoints() []string {
 // Random Load Balancer
 type randomLB struct {
 	endpoints []string
-	rand      *rand.Rand
 	mu        sync.RWMutex
 }
 
 func NewRandomLB(endpoints []string) LoadBalancer {
 	return &randomLB{
 		endpoints: endpoints,
-		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
@@ -111,10 +125,15 @@ func (r *randomLB) NextEndpoint() string {
 		return ""
 	}
 	
-	index := r.rand.Intn(len(r.endpoints))
+	// The top-level functions are safe for concurrent use, unlike a
+	// *rand.Rand, as NextEndpoint only holds the read lock
+	index := rand.IntN(len(r.endpoints))
 	return r.endpoints[index]
 }
 
+// ReleaseEndpoint is a no-op; the selection doesn't depend on load
+func (r *randomLB) ReleaseEndpoint(endpoint string) {}
+
 func (r *randomLB) AddEndpoint(endpoint string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -145,7 +164,6 @@ func (r *randomLB) GetHealthyEndpoints() []string {
 // Weighted Random Load Balancer
 type weightedRandomLB struct {
 	endpoints []WeightedEndpoint
-	rand      *rand.Rand
 	mu        sync.RWMutex
 }
 
@@ -162,10 +180,27 @@ func NewWeightedRandomLB(endpoints []string) LoadBalancer {
 	
 	return &weightedRandomLB{
 		endpoints: weighted,
-		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
+// NewWeightedLB creates a weighted random load balancer that picks each
+// endpoint in proportion to its weight. Weights must be positive.
+func NewWeightedLB(weights map[string]int) (LoadBalancer, error) {
+	weighted := make([]WeightedEndpoint, 0, len(weights))
+	for ep, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("endpoint %s: weight must be positive, got %d", ep, weight)
+		}
+		weighted = append(weighted, WeightedEndpoint{URL: ep, Weight: weight})
+	}
+	// Map order is random; keep the endpoint order stable
+	sort.Slice(weighted, func(i, j int) bool { return weighted[i].URL < weighted[j].URL })
+
+	return &weightedRandomLB{
+		endpoints: weighted,
+	}, nil
+}
+
 func (wr *weightedRandomLB) NextEndpoint() string {
 	wr.mu.RLock()
 	defer wr.mu.RUnlock()
@@ -183,7 +218,7 @@ func (wr *weightedRandomLB) NextEndpoint() string {
 		return ""
 	}
 	
-	target := wr.rand.Intn(totalWeight)
+	target := rand.IntN(totalWeight)
 	current := 0
 	
 	for _, ep := range wr.endpoints {
@@ -196,6 +231,9 @@ func (wr *weightedRandomLB) NextEndpoint() string {
 	return wr.endpoints[0].URL
 }
 
+// ReleaseEndpoint is a no-op; the selection doesn't depend on load
+func (wr *weightedRandomLB) ReleaseEndpoint(endpoint string) {}
+
 func (wr *weightedRandomLB) AddEndpoint(endpoint string) {
 	wr.mu.Lock()
 	defer wr.mu.Unlock()
@@ -269,6 +307,30 @@ func (lc *leastConnLB) NextEndpoint() string {
 	return lc.endpoints[selectedIndex].URL
 }
 
+func (lc *leastConnLB) ReleaseEndpoint(endpoint string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for i := range lc.endpoints {
+		if lc.endpoints[i].URL == endpoint && lc.endpoints[i].Connections > 0 {
+			lc.endpoints[i].Connections--
+			return
+		}
+	}
+}
+
+// Connections returns the number of in-flight requests per endpoint
+func (lc *leastConnLB) Connections() map[string]int64 {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	result := make(map[string]int64, len(lc.endpoints))
+	for _, ep := range lc.endpoints {
+		result[ep.URL] = ep.Connections
+	}
+	return result
+}
+
 func (lc *leastConnLB) AddEndpoint(endpoint string) {
 	lc.mu.Lock()
 	defer lc.mu.Unlock()
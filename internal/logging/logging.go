@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Logger receives the client's debug output and internal warnings. Log
+// lines carry their details as key=value fields, e.g.
+// "response method=GET url=https://example.com status=200 duration=12ms".
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Nop discards everything
+var Nop Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// OrNop returns l, or Nop when l is nil
+func OrNop(l Logger) Logger {
+	if l == nil {
+		return Nop
+	}
+	return l
+}
+
+// NewWriter returns a Logger writing one line per message to w, prefixed
+// with its level, e.g. "[DEBUG] request method=GET ..."
+func NewWriter(w io.Writer) Logger {
+	return &writerLogger{w: w}
+}
+
+type writerLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *writerLogger) Debugf(format string, args ...interface{}) { l.write("DEBUG", format, args) }
+func (l *writerLogger) Infof(format string, args ...interface{})  { l.write("INFO", format, args) }
+func (l *writerLogger) Errorf(format string, args ...interface{}) { l.write("ERROR", format, args) }
+
+func (l *writerLogger) write(level, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "[%s] %s\n", level, fmt.Sprintf(format, args...))
+}
@@ -2,125 +2,710 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
-	"sync"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
-)
 
-// CacheEntry represents a cached response
-type CacheEntry struct {
-	Response  *CachedResponse
-	ExpiresAt time.Time
-}
+	"github.com/yourorg/httpclient/internal/clock"
+)
 
 // CachedResponse represents a cached HTTP response
 type CachedResponse struct {
 	StatusCode int
 	Headers    http.Header
 	Body       []byte
+
+	// ExpiresAt is when the response stops being fresh
+	ExpiresAt time.Time
+	// URL is the URL of the request the response answers
+	URL string
+	// Vary holds the request's values of the headers the response varies
+	// on
+	Vary http.Header
+}
+
+// Response converts the cached entry into an *http.Response for req
+func (r *CachedResponse) Response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Headers.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// Cache is a middleware that can also answer requests from stored responses
+type Cache interface {
+	Middleware
+	GetCachedResponse(req *http.Request) (*CachedResponse, bool)
+	// Invalidate removes the responses stored for rawURL. Without a query
+	// it covers every query of the path, and a * in the path matches any
+	// part of a path segment, as in https://api.example.com/users/*/posts.
+	// With a CacheStore other than the default one, only the response to a
+	// GET of rawURL without credentials is removed, and * isn't special.
+	Invalidate(rawURL string)
+	// Clear removes every stored response
+	Clear()
+	// Stats returns the cache counters and its current size
+	Stats() CacheStats
+}
+
+// CacheStats describes the use of a response cache. The evictions and size
+// are those of the default in-memory store.
+type CacheStats struct {
+	Hits          int64 // requests answered from the cache
+	Misses        int64 // GET requests that found no fresh entry
+	Revalidations int64 // stale entries refreshed by a 304
+	Errors        int64 // failed CacheStore calls, taken as misses
+	Evictions     int64 // entries removed to stay within the limits
+	Expirations   int64 // entries removed because their time ran out
+	Entries       int   // entries stored now
+	Bytes         int64 // body bytes stored now
+}
+
+// CacheOptions configures the cache middleware
+type CacheOptions struct {
+	// TTL for successful responses; zero disables caching them
+	TTL time.Duration
+	// NegativeTTL for 404/410 responses; zero disables negative caching
+	NegativeTTL time.Duration
+	// Clock used for expiry, defaults to the real clock
+	Clock clock.Clock
+	// Store holds the responses, defaults to a store in memory bounded by
+	// MaxEntries and MaxBytes
+	Store CacheStore
+	// MaxEntries and MaxBytes bound the number of entries and the total
+	// size of their bodies in the default store, evicting the least
+	// recently used entries; zero means no limit
+	MaxEntries int
+	MaxBytes   int64
+	// MaxEntrySize is the largest body that is cached; zero means no
+	// limit besides MaxBytes
+	MaxEntrySize int64
+	// KeyFunc returns the key responses to req are stored under, e.g. to
+	// include headers the server varies on without saying so in Vary.
+	// Defaults to the method, URL and Authorization header.
+	KeyFunc func(req *http.Request) string
+	// Policy decides between the lifetime the server sets and TTL,
+	// defaults to CacheRespectServer
+	Policy CachePolicy
+	// Admit, if set, decides whether a cacheable response to req with a
+	// body of size bytes is stored
+	Admit func(req *http.Request, size int64) bool
+}
+
+// CachePolicy decides how long a response is fresh
+type CachePolicy string
+
+// Cache policies
+const (
+	// CacheRespectServer uses the lifetime set by Cache-Control max-age,
+	// s-maxage or Expires when the response has one, TTL otherwise
+	CacheRespectServer CachePolicy = "respect-server"
+	// CacheOverride uses TTL whatever the response says, except that
+	// must-revalidate keeps the server's lifetime. no-store, private and
+	// no-cache are honored either way.
+	CacheOverride CachePolicy = "override"
+)
+
+// ErrCacheMiss is returned for a request that may only be answered from
+// the cache when no fresh response is stored
+var ErrCacheMiss = errors.New("response not in cache")
+
+// CacheMode overrides how the cache handles a single request, like the
+// Cache-Control directive of the same name on a request
+type CacheMode string
+
+// Cache modes
+const (
+	// CacheModeNoStore neither answers the request from the cache nor
+	// stores its response
+	CacheModeNoStore CacheMode = "no-store"
+	// CacheModeNoCache sends the request even when a fresh response is
+	// stored, conditionally when it has a validator, and stores the answer
+	CacheModeNoCache CacheMode = "no-cache"
+	// CacheModeOnlyIfCached answers the request from a fresh stored
+	// response or fails it with ErrCacheMiss
+	CacheModeOnlyIfCached CacheMode = "only-if-cached"
+)
+
+// cacheModeKey carries the CacheMode of a request in its context
+type cacheModeKey struct{}
+
+// WithCacheMode returns a context whose requests the cache handles as mode
+// says
+func WithCacheMode(ctx context.Context, mode CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, mode)
 }
 
+// CacheModeOf returns the CacheMode of req, "" when it has none
+func CacheModeOf(req *http.Request) CacheMode {
+	mode, _ := req.Context().Value(cacheModeKey{}).(CacheMode)
+	return mode
+}
+
+// revalidateWindow is how long an expired entry with an ETag or
+// Last-Modified is kept to revalidate it with a conditional request
+const revalidateWindow = time.Hour
+
 // Cache middleware for HTTP responses
 type cacheMiddleware struct {
-	cache map[string]*CacheEntry
-	ttl   time.Duration
-	mu    sync.RWMutex
+	store       CacheStore
+	memory      *memoryStore // store when it is the default one
+	maxEntry    int64
+	keyFunc     func(*http.Request) string
+	policy      CachePolicy
+	admit       func(req *http.Request, size int64) bool
+	ttl         time.Duration
+	negativeTTL time.Duration
+	clock       clock.Clock
+
+	hits, misses, revalidations, errors int64 // updated atomically
 }
 
 // NewCache creates a new cache middleware
-func NewCache(ttl time.Duration) Middleware {
+func NewCache(opts CacheOptions) Cache {
 	cm := &cacheMiddleware{
-		cache: make(map[string]*CacheEntry),
-		ttl:   ttl,
+		store:       opts.Store,
+		maxEntry:    opts.MaxEntrySize,
+		keyFunc:     opts.KeyFunc,
+		policy:      opts.Policy,
+		admit:       opts.Admit,
+		ttl:         opts.TTL,
+		negativeTTL: opts.NegativeTTL,
+		clock:       clock.OrReal(opts.Clock),
+	}
+	if cm.store == nil {
+		cm.memory = newMemoryStore(opts)
+		cm.store = cm.memory
 	}
-	
-	// Start cleanup goroutine
-	go cm.cleanup()
-	
 	return cm
 }
 
 func (c *cacheMiddleware) Before(req *http.Request) error {
 	// Only cache GET requests
-	if req.Method != "GET" {
+	if req.Method != "GET" || CacheModeOf(req) == CacheModeNoStore {
 		return nil
 	}
-	
-	key := c.generateKey(req)
-	
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
-	
-	if exists && time.Now().Before(entry.ExpiresAt) {
-		// Cache hit - we'll handle this in a custom way
-		// For now, just mark the request as cacheable
-		req.Header.Set("X-Cache-Key", key)
-	}
-	
+
+	// A stale entry with a validator is revalidated: the server answers
+	// 304 Not Modified when the stored response is still current. Under
+	// CacheModeNoCache so is a fresh one.
+	_, entry, state := c.state(req, c.clock.Now())
+	revalidate := state == entryStale ||
+		(state == entryFresh && CacheModeOf(req) == CacheModeNoCache && isRevalidatable(entry.Headers))
+	if !revalidate || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil
+	}
+	if etag := entry.Headers.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	} else if modified := entry.Headers.Get("Last-Modified"); modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+
 	return nil
 }
 
 func (c *cacheMiddleware) After(resp *http.Response) {
-	// Only cache successful GET responses
-	if resp.Request.Method != "GET" || resp.StatusCode >= 400 {
+	// A successful unsafe request may have changed the resource, so its
+	// stored responses are stale, along with those of the resources the
+	// response points to
+	if !isSafeMethod(resp.Request.Method) {
+		if resp.StatusCode < 400 {
+			c.invalidateChanged(resp)
+		}
 		return
 	}
-	
-	key := resp.Request.Header.Get("X-Cache-Key")
-	if key == "" {
-		key = c.generateKey(resp.Request)
+
+	// Only cache successful GET responses, plus missing resources when
+	// negative caching is enabled
+	if resp.Request.Method != "GET" || CacheModeOf(resp.Request) == CacheModeNoStore {
+		return
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		c.revalidated(resp)
+		return
+	}
+
+	ttl := c.ttlFor(resp.StatusCode)
+	if ttl <= 0 {
+		return
+	}
+	req := resp.Request
+	vary, cacheable := varyValues(req, resp.Header)
+	// no-store on either side keeps the response out of the cache, and so
+	// does private: the cache is shared by every request of the client,
+	// whatever credentials they carry. A response that is stale on arrival
+	// is only worth keeping when it can be revalidated.
+	ttl = c.responseTTL(resp.Header, ttl)
+	if !cacheable || parseCacheControl(req.Header).noStore || parseCacheControl(resp.Header).uncacheable() ||
+		(ttl <= 0 && !isRevalidatable(resp.Header)) {
+		key, _ := c.lookup(req)
+		c.check(c.store.Delete(req.Context(), key))
+		return
+	}
+
+	// Read and cache the response body, unless it turns out too large
+	if c.maxEntry > 0 && resp.ContentLength > c.maxEntry {
+		return
 	}
-	
-	// Read and cache the response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp)
 	if err != nil {
 		return
 	}
-	resp.Body.Close()
-	
+	if c.admit != nil && !c.admit(req, int64(len(body))) {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return
+	}
+
 	// Create cached response
 	cachedResp := &CachedResponse{
 		StatusCode: resp.StatusCode,
 		Headers:    resp.Header.Clone(),
 		Body:       body,
+		ExpiresAt:  c.clock.Now().Add(ttl),
+		URL:        req.URL.String(),
+		Vary:       vary,
 	}
-	
-	// Store in cache
-	c.mu.Lock()
-	c.cache[key] = &CacheEntry{
-		Response:  cachedResp,
-		ExpiresAt: time.Now().Add(c.ttl),
+
+	// Store in cache; a response that varies is stored under a key that
+	// includes the request's values of the headers it varies on, so each
+	// representation has its own entry
+	key := c.generateKey(req)
+	if len(vary) > 0 {
+		marker, ok := c.varyMarker(req, key, vary, c.storeTTL(cachedResp))
+		if !ok {
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return
+		}
+		key = variantKey(key, marker, req)
 	}
-	c.mu.Unlock()
-	
+	c.check(c.store.Set(req.Context(), key, cachedResp, c.storeTTL(cachedResp)))
+
 	// Restore body for the original response
 	resp.Body = io.NopCloser(bytes.NewReader(body))
 }
 
+// varyMarker returns the marker stored under base, the key of req without
+// its Vary values, that says which headers the response to req varies on.
+// A new marker is stored when there is none for those headers.
+//
+// A marker is a CachedResponse without a status, whose Vary lists the
+// header names, whose ExpiresAt is when its last representation may be
+// dropped and whose Body is a random generation. The generation is
+// part of the keys of the representations, so removing the marker of a
+// resource makes all of them unreachable, even in a store that can only
+// remove a single key.
+func (c *cacheMiddleware) varyMarker(req *http.Request, base string, vary http.Header, ttl time.Duration) (*CachedResponse, bool) {
+	ctx := req.Context()
+	marker, ok, err := c.store.Get(ctx, base)
+	if !c.check(err) {
+		return nil, false
+	}
+	if ok && isVaryMarker(marker) && sameNames(marker.Vary, vary) {
+		// Keep the marker for as long as its newest representation
+		if c.clock.Now().Add(ttl).After(marker.ExpiresAt) {
+			refreshed := *marker
+			refreshed.ExpiresAt = c.clock.Now().Add(ttl)
+			c.check(c.store.Set(ctx, base, &refreshed, ttl))
+		}
+		return marker, true
+	}
+
+	names := make(http.Header, len(vary))
+	for name := range vary {
+		names[name] = nil
+	}
+	marker = &CachedResponse{
+		Headers:   make(http.Header),
+		Body:      []byte(strconv.FormatUint(rand.Uint64(), 36)),
+		ExpiresAt: c.clock.Now().Add(ttl),
+		URL:       req.URL.String(),
+		Vary:      names,
+	}
+	if !c.check(c.store.Set(ctx, base, marker, ttl)) {
+		return nil, false
+	}
+	return marker, true
+}
+
+func isVaryMarker(r *CachedResponse) bool {
+	return r.StatusCode == 0
+}
+
+// sameNames reports whether a and b hold the same header names
+func sameNames(a, b http.Header) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// variantKey returns the key of the representation for req of the
+// resource whose marker is stored under base
+func variantKey(base string, marker *CachedResponse, req *http.Request) string {
+	h := md5.New()
+	fmt.Fprintf(h, "%s\n%s", base, marker.Body)
+	for _, name := range sortedNames(marker.Vary) {
+		fmt.Fprintf(h, "\n%s: %s", name, normalizeVaryValue(req.Header.Values(name)))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// storeTTL returns how long a store keeps resp: until it expires, or for
+// revalidateWindow longer when it can be revalidated
+func (c *cacheMiddleware) storeTTL(resp *CachedResponse) time.Duration {
+	ttl := resp.ExpiresAt.Sub(c.clock.Now())
+	if isRevalidatable(resp.Headers) {
+		ttl += revalidateWindow
+	}
+	return ttl
+}
+
+// check counts a failed store call and reports whether err is nil
+func (c *cacheMiddleware) check(err error) bool {
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		return false
+	}
+	return true
+}
+
+// readBody reads the body of resp to cache it. A body larger than
+// MaxEntrySize fails with errEntryTooLarge and is left for the caller to
+// read in full.
+func (c *cacheMiddleware) readBody(resp *http.Response) ([]byte, error) {
+	if c.maxEntry <= 0 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return body, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxEntry+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxEntry {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, errEntryTooLarge
+	}
+	resp.Body.Close()
+	return body, nil
+}
+
+// errEntryTooLarge is returned by readBody for a body over MaxEntrySize
+var errEntryTooLarge = errors.New("response body exceeds the cache entry size limit")
+
+// revalidated turns a 304 answer to a conditional request into the stored
+// response, refreshed with the headers of the 304 and a new expiry
+func (c *cacheMiddleware) revalidated(resp *http.Response) {
+	key, entry := c.lookup(resp.Request)
+	if entry == nil || !matchesValidator(resp.Request.Header, entry.Headers) {
+		return
+	}
+
+	stored := *entry
+	stored.Headers = entry.Headers.Clone()
+	for name, values := range resp.Header {
+		stored.Headers[name] = values
+	}
+	atomic.AddInt64(&c.revalidations, 1)
+	cacheLookups.WithLabelValues(lookupRevalidated).Inc()
+	ttl := c.responseTTL(stored.Headers, c.ttlFor(stored.StatusCode))
+	stored.ExpiresAt = c.clock.Now().Add(ttl)
+	c.check(c.store.Set(resp.Request.Context(), key, &stored, c.storeTTL(&stored)))
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	*resp = *stored.Response(resp.Request)
+}
+
+// Invalidate removes the responses stored for rawURL, see Cache
+func (c *cacheMiddleware) Invalidate(rawURL string) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	c.invalidate(context.Background(), target, nil)
+}
+
+// Clear removes every stored response
+func (c *cacheMiddleware) Clear() {
+	if clearer, ok := c.store.(interface{ Clear(context.Context) error }); ok {
+		c.check(clearer.Clear(context.Background()))
+	}
+}
+
+// Stats returns the cache counters and its current size, see Cache
+func (c *cacheMiddleware) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Revalidations: atomic.LoadInt64(&c.revalidations),
+		Errors:        atomic.LoadInt64(&c.errors),
+	}
+	if c.memory != nil {
+		c.memory.stats(&stats)
+	}
+	return stats
+}
+
+// invalidateChanged drops the responses stored for the target of an
+// unsafe request and for the Location and Content-Location of its
+// response when they are on the same host, as RFC 9111 section 4.4 asks
+func (c *cacheMiddleware) invalidateChanged(resp *http.Response) {
+	req := resp.Request
+	target := req.URL
+
+	c.invalidate(req.Context(), &url.URL{Scheme: target.Scheme, Host: target.Host, Path: target.Path}, req.Header)
+	if target.RawQuery != "" && c.memory == nil {
+		c.invalidate(req.Context(), target, req.Header)
+	}
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if u, err := target.Parse(value); err == nil && u.Host == target.Host {
+			c.invalidate(req.Context(), u, req.Header)
+		}
+	}
+}
+
+// invalidate removes the entries for target, for any query when target
+// has none. Other stores than the default one can only remove the
+// response to a GET of target with header, which holds the credentials.
+func (c *cacheMiddleware) invalidate(ctx context.Context, target *url.URL, header http.Header) {
+	if c.memory != nil {
+		c.memory.deleteURL(target)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target.String(), nil)
+	if err != nil {
+		return
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	// Removing the marker of a resource that varies removes all of its
+	// representations
+	c.check(c.store.Delete(ctx, c.generateKey(req)))
+}
+
+// isSafeMethod reports whether method is read-only, see RFC 9110 section 9.2.1
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// ttlFor returns how long a response with the given status is cached
+func (c *cacheMiddleware) ttlFor(status int) time.Duration {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return c.negativeTTL
+	case status >= 400:
+		return 0
+	}
+	return c.ttl
+}
+
+// matchesValidator reports whether the conditional headers of a request
+// were taken from the stored response headers
+func matchesValidator(req, stored http.Header) bool {
+	if etag := req.Get("If-None-Match"); etag != "" {
+		return etag == stored.Get("ETag")
+	}
+	modified := req.Get("If-Modified-Since")
+	return modified != "" && modified == stored.Get("Last-Modified")
+}
+
+// cacheDirectives are the Cache-Control directives the cache acts on
+type cacheDirectives struct {
+	noStore        bool
+	noCache        bool
+	private        bool
+	mustRevalidate bool
+	maxAge         time.Duration // -1 when not given
+	sMaxAge        time.Duration // -1 when not given
+}
+
+func parseCacheControl(header http.Header) cacheDirectives {
+	d := cacheDirectives{maxAge: -1, sMaxAge: -1}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store":
+				d.noStore = true
+			case "no-cache":
+				d.noCache = true
+			case "private":
+				d.private = true
+			case "must-revalidate", "proxy-revalidate":
+				d.mustRevalidate = true
+			case "max-age":
+				d.maxAge = parseSeconds(arg, d.maxAge)
+			case "s-maxage":
+				d.sMaxAge = parseSeconds(arg, d.sMaxAge)
+			}
+		}
+	}
+	return d
+}
+
+// parseSeconds parses the delta-seconds argument of a directive, keeping
+// fallback when it is invalid
+func parseSeconds(arg string, fallback time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(strings.Trim(arg, `"`))
+	if err != nil || seconds < 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (d cacheDirectives) uncacheable() bool {
+	return d.noStore || d.private
+}
+
+// responseTTL applies the expiry a response sets to ttl: no-cache makes it
+// stale right away, so it is revalidated before reuse. Under
+// CacheRespectServer the server's lifetime replaces ttl; under
+// CacheOverride it only does with must-revalidate.
+func (c *cacheMiddleware) responseTTL(header http.Header, ttl time.Duration) time.Duration {
+	d := parseCacheControl(header)
+	if d.noCache {
+		return 0
+	}
+	if c.policy == CacheOverride && !d.mustRevalidate {
+		return ttl
+	}
+	if lifetime, ok := c.serverLifetime(header, d); ok {
+		return lifetime
+	}
+	return ttl
+}
+
+// serverLifetime returns how long the response says it is fresh: s-maxage
+// first, as the cache is shared, then max-age, then Expires
+func (c *cacheMiddleware) serverLifetime(header http.Header, d cacheDirectives) (time.Duration, bool) {
+	switch {
+	case d.sMaxAge >= 0:
+		return d.sMaxAge, true
+	case d.maxAge >= 0:
+		return d.maxAge, true
+	}
+
+	value := header.Get("Expires")
+	if value == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		// An invalid date, like "0", means already expired
+		return 0, true
+	}
+	// Measure against the server's Date so clock skew doesn't matter
+	now := c.clock.Now()
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		now = date
+	}
+	return max(expires.Sub(now), 0), true
+}
+
 func (c *cacheMiddleware) generateKey(req *http.Request) string {
-	key := fmt.Sprintf("%s:%s", req.Method, req.URL.String())
+	if c.keyFunc != nil {
+		return c.keyFunc(req)
+	}
+	// Responses are only shared between requests with the same credentials
+	key := fmt.Sprintf("%s:%s:%s", req.Method, req.URL.String(), req.Header.Get("Authorization"))
 	hash := md5.Sum([]byte(key))
 	return fmt.Sprintf("%x", hash)
 }
 
-func (c *cacheMiddleware) cleanup() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	
-	for range ticker.C {
-		now := time.Now()
-		c.mu.Lock()
-		for key, entry := range c.cache {
-			if now.After(entry.ExpiresAt) {
-				delete(c.cache, key)
+// varyValues records the values req has for the headers the response
+// varies on. A response that varies on "*" can't be reused and isn't
+// cacheable.
+func varyValues(req *http.Request, header http.Header) (http.Header, bool) {
+	var vary http.Header
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			switch name {
+			case "":
+				continue
+			case "*":
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(http.Header)
 			}
+			vary[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+		}
+	}
+	return vary, true
+}
+
+// matchesVary reports whether req has the same values as the request the
+// response was stored for in every header it varies on
+func (r *CachedResponse) matchesVary(req *http.Request) bool {
+	for name, values := range r.Vary {
+		if normalizeVaryValue(req.Header.Values(name)) != normalizeVaryValue(values) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeVaryValue joins the values of a header so that "gzip, br" and
+// "gzip,br" compare equal
+func normalizeVaryValue(values []string) string {
+	var parts []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			parts = append(parts, strings.TrimSpace(part))
 		}
-		c.mu.Unlock()
 	}
+	return strings.Join(parts, ",")
+}
+
+func sortedNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // GetCachedResponse retrieves a cached response if available
@@ -128,16 +713,73 @@ func (c *cacheMiddleware) GetCachedResponse(req *http.Request) (*CachedResponse,
 	if req.Method != "GET" {
 		return nil, false
 	}
-	
-	key := c.generateKey(req)
-	
-	c.mu.RLock()
-	entry, exists := c.cache[key]
-	c.mu.RUnlock()
-	
-	if !exists || time.Now().After(entry.ExpiresAt) {
+	switch CacheModeOf(req) {
+	case CacheModeNoStore, CacheModeNoCache:
 		return nil, false
 	}
-	
-	return entry.Response, true
-}
\ No newline at end of file
+
+	_, entry, state := c.state(req, c.clock.Now())
+	if state != entryFresh {
+		atomic.AddInt64(&c.misses, 1)
+		cacheLookups.WithLabelValues(lookupMiss).Inc()
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	cacheLookups.WithLabelValues(lookupHit).Inc()
+	return entry, true
+}
+
+// entryState is how the cache can answer a request
+type entryState int
+
+const (
+	// entryAbsent means nothing usable is stored and the request is sent
+	// as is
+	entryAbsent entryState = iota
+	// entryStale means the entry expired but has an ETag or Last-Modified,
+	// so a conditional request can revalidate it
+	entryStale
+	// entryFresh means the entry is served without asking the server
+	entryFresh
+)
+
+// state returns the key and entry stored for req and how the entry can
+// answer req at now
+func (c *cacheMiddleware) state(req *http.Request, now time.Time) (string, *CachedResponse, entryState) {
+	key, entry := c.lookup(req)
+	switch {
+	case entry == nil:
+		return key, nil, entryAbsent
+	case now.Before(entry.ExpiresAt):
+		return key, entry, entryFresh
+	case isRevalidatable(entry.Headers) && now.Before(entry.ExpiresAt.Add(revalidateWindow)):
+		return key, entry, entryStale
+	}
+	return key, nil, entryAbsent
+}
+
+// lookup returns the key of the entry for req, following the marker of a
+// resource that varies, and the entry when one that matches req is stored
+func (c *cacheMiddleware) lookup(req *http.Request) (string, *CachedResponse) {
+	ctx := req.Context()
+	key := c.generateKey(req)
+	entry, ok, err := c.store.Get(ctx, key)
+	if !c.check(err) || !ok {
+		return key, nil
+	}
+	if isVaryMarker(entry) {
+		key = variantKey(key, entry, req)
+		entry, ok, err = c.store.Get(ctx, key)
+		if !c.check(err) || !ok {
+			return key, nil
+		}
+	}
+	if isVaryMarker(entry) || !entry.matchesVary(req) {
+		return key, nil
+	}
+	return key, entry
+}
+
+func isRevalidatable(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
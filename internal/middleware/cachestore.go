@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/yourorg/httpclient/internal/clock"
+)
+
+// CacheStore holds the responses of a response cache, e.g. in Redis so that
+// the replicas of a service share them. Keys are opaque strings made of
+// letters and digits. Get reports false for a key it doesn't hold, and Set
+// keeps resp for up to ttl; a store may drop entries earlier. The cache
+// doesn't modify a response after Set or Get, so a store may keep the
+// pointer. Errors are treated as cache misses and never fail a request.
+//
+// A store can also implement Clear(ctx context.Context) error, which
+// Cache.Clear then calls.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, bool, error)
+	Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// cachedResponseVersion is the version of the encoding of CachedResponse
+const cachedResponseVersion = 1
+
+// encodedResponse is the encoding of CachedResponse. Fields are only ever
+// added, so that responses written by one version are read by the next.
+type encodedResponse struct {
+	Version    int         `json:"v"`
+	StatusCode int         `json:"status"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	URL        string      `json:"url,omitempty"`
+	Vary       http.Header `json:"vary,omitempty"`
+}
+
+// MarshalBinary encodes the response, with its status, headers, body and
+// expiry, for stores that hold bytes. The encoding is versioned JSON.
+func (r *CachedResponse) MarshalBinary() ([]byte, error) {
+	return json.Marshal(encodedResponse{
+		Version:    cachedResponseVersion,
+		StatusCode: r.StatusCode,
+		Headers:    r.Headers,
+		Body:       r.Body,
+		ExpiresAt:  r.ExpiresAt,
+		URL:        r.URL,
+		Vary:       r.Vary,
+	})
+}
+
+// UnmarshalBinary decodes a response encoded by MarshalBinary
+func (r *CachedResponse) UnmarshalBinary(data []byte) error {
+	var e encodedResponse
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("decode cached response: %w", err)
+	}
+	if e.Version != cachedResponseVersion {
+		return fmt.Errorf("decode cached response: unsupported version %d", e.Version)
+	}
+	*r = CachedResponse{
+		StatusCode: e.StatusCode,
+		Headers:    e.Headers,
+		Body:       e.Body,
+		ExpiresAt:  e.ExpiresAt,
+		URL:        e.URL,
+		Vary:       e.Vary,
+	}
+	return nil
+}
+
+var (
+	cacheEvictions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httpclient_cache_evictions_total",
+			Help: "Responses removed from the response cache",
+		},
+		[]string{"reason"},
+	)
+
+	cacheLookups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "httpclient_cache_lookups_total",
+			Help: "GET requests looked up in the response cache, by result",
+		},
+		[]string{"result"},
+	)
+
+	cacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "httpclient_cache_entries",
+		Help: "Responses stored in response caches",
+	})
+
+	cacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "httpclient_cache_bytes",
+		Help: "Body bytes stored in response caches",
+	})
+)
+
+// Results of a cache lookup
+const (
+	lookupHit         = "hit"
+	lookupMiss        = "miss"
+	lookupRevalidated = "revalidated"
+)
+
+// Reasons an entry is evicted
+const (
+	evictedLimit   = "limit"
+	evictedExpired = "expired"
+)
+
+// memoryStore is the default CacheStore: a map in the process, bounded by
+// MaxEntries and MaxBytes with least recently used eviction
+type memoryStore struct {
+	entries    map[string]*list.Element
+	lru        *list.List // most recently used first
+	bytes      int64      // body bytes of all entries
+	maxEntries int
+	maxBytes   int64
+	clock      clock.Clock
+	mu         sync.Mutex
+
+	evictions, expirations int64
+}
+
+type memoryEntry struct {
+	key   string
+	resp  *CachedResponse
+	url   *url.URL // the parsed resp.URL, to invalidate the entry by
+	until time.Time
+}
+
+func newMemoryStore(opts CacheOptions) *memoryStore {
+	s := &memoryStore{
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: opts.MaxEntries,
+		maxBytes:   opts.MaxBytes,
+		clock:      clock.OrReal(opts.Clock),
+	}
+	go s.cleanup()
+	return s
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (*CachedResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, exists := s.entries[key]
+	if !exists {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryEntry)
+	if !s.clock.Now().Before(entry.until) {
+		s.evict(key, evictedExpired)
+		return nil, false, nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.resp, true, nil
+}
+
+// Set adds or replaces the entry for key as the most recently used and
+// evicts the least recently used entries beyond the limits. A response
+// larger than MaxBytes on its own isn't stored.
+func (s *memoryStore) Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(key, "")
+	size := int64(len(resp.Body))
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return nil
+	}
+
+	entry := &memoryEntry{key: key, resp: resp, until: s.clock.Now().Add(ttl)}
+	entry.url, _ = url.Parse(resp.URL)
+	s.entries[key] = s.lru.PushFront(entry)
+	s.bytes += size
+	cacheEntries.Inc()
+	cacheBytes.Add(float64(size))
+	for (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.evict(s.lru.Back().Value.(*memoryEntry).key, evictedLimit)
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(key, "")
+	return nil
+}
+
+func (s *memoryStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		s.evict(key, "")
+	}
+	return nil
+}
+
+// deleteURL removes the entries for target, for any query when target has
+// none. A * in the path of target matches any part of a path segment.
+func (s *memoryStore) deleteURL(target *url.URL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pattern := target.EscapedPath()
+	for key, elem := range s.entries {
+		u := elem.Value.(*memoryEntry).url
+		if u == nil || u.Host != target.Host || !matchPath(pattern, u.EscapedPath()) {
+			continue
+		}
+		if target.Scheme != "" && u.Scheme != target.Scheme {
+			continue
+		}
+		if target.RawQuery != "" && u.RawQuery != target.RawQuery {
+			continue
+		}
+		s.evict(key, "")
+	}
+}
+
+// matchPath reports whether the escaped path p matches pattern, where *
+// stands for any part of a path segment
+func matchPath(pattern, p string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == p
+	}
+	matched, err := path.Match(pattern, p)
+	return err == nil && matched
+}
+
+// stats fills in the eviction counts and size of the store
+func (s *memoryStore) stats(stats *CacheStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats.Evictions = s.evictions
+	stats.Expirations = s.expirations
+	stats.Entries = s.lru.Len()
+	stats.Bytes = s.bytes
+}
+
+func (s *memoryStore) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := s.clock.Now()
+		s.mu.Lock()
+		for key, elem := range s.entries {
+			if !now.Before(elem.Value.(*memoryEntry).until) {
+				s.evict(key, evictedExpired)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// evict deletes the entry for key, if any, and counts it as evicted for
+// reason unless reason is empty. Every entry leaves the store through
+// here. The caller holds s.mu.
+func (s *memoryStore) evict(key, reason string) {
+	elem, exists := s.entries[key]
+	if !exists {
+		return
+	}
+	size := int64(len(elem.Value.(*memoryEntry).resp.Body))
+	s.lru.Remove(elem)
+	delete(s.entries, key)
+	s.bytes -= size
+
+	switch reason {
+	case evictedLimit:
+		s.evictions++
+	case evictedExpired:
+		s.expirations++
+	}
+	if reason != "" {
+		cacheEvictions.WithLabelValues(reason).Inc()
+	}
+	cacheEntries.Dec()
+	cacheBytes.Sub(float64(size))
+}
@@ -1,10 +1,15 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/yourorg/httpclient/internal/clock"
 )
 
 // CircuitState represents the state of the circuit breaker
@@ -16,81 +21,308 @@ const (
 	StateHalfOpen
 )
 
-// CircuitBreaker middleware
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker is a middleware that also reports its state
+type CircuitBreaker interface {
+	Middleware
+	GetState() CircuitState
+	GetFailures() int64
+	GetHostState(host string) (CircuitState, int64)
+}
+
+// ErrCircuitOpen is returned while the circuit breaker rejects requests
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// MaxCircuits is how many failing hosts a circuit breaker tracks at most.
+// Beyond it the circuit that failed longest ago is dropped, which closes
+// it.
+const MaxCircuits = 1000
+
+var circuitStates = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "httpclient_circuit_state",
+		Help: "State of the circuit of each host that isn't closed: 1 open, 2 half-open",
+	},
+	[]string{"host"},
+)
+
+// CircuitBreakerOptions configures a circuit breaker
+type CircuitBreakerOptions struct {
+	// Threshold is how many consecutive failures open a host's circuit
+	Threshold int
+	// Timeout is how long an open circuit rejects requests before it lets
+	// probes through
+	Timeout time.Duration
+	// HalfOpenProbes is how many requests a half-open circuit lets through
+	// at a time, 1 by default. A probe that hasn't finished after Timeout
+	// no longer counts.
+	HalfOpenProbes int
+	// SuccessThreshold is how many consecutive successful probes close a
+	// half-open circuit, 1 by default
+	SuccessThreshold int
+	// Clock used for the timeout, defaults to the real clock
+	Clock clock.Clock
+	// OnStateChange, if non-nil, is called after every state transition
+	// of a host's circuit
+	OnStateChange func(host string, from, to CircuitState)
+}
+
+// CircuitBreaker middleware. Each request host has its own circuit, so a
+// failing backend doesn't block requests to healthy ones.
 type circuitBreakerMiddleware struct {
-	state         CircuitState
-	failures      int64
-	lastFailTime  time.Time
+	circuits      map[string]*circuit // hosts that are failing; absent means closed
 	threshold     int64
 	timeout       time.Duration
-	mu            sync.RWMutex
+	probes        int
+	successes     int
+	clock         clock.Clock
+	onStateChange func(host string, from, to CircuitState)
+	mu            sync.Mutex
+}
+
+type circuit struct {
+	state        CircuitState
+	failures     int64
+	lastFailTime time.Time
+
+	// In the half-open state
+	probes    int       // requests let through and not yet finished
+	probeTime time.Time // when the last probe was let through
+	successes int       // consecutive successful probes
 }
 
 // NewCircuitBreaker creates a new circuit breaker middleware
-func NewCircuitBreaker(threshold int, timeout time.Duration) Middleware {
-	return &circuitBreakerMiddleware{
-		state:     StateClosed,
-		threshold: int64(threshold),
-		timeout:   timeout,
+func NewCircuitBreaker(opts CircuitBreakerOptions) CircuitBreaker {
+	cb := &circuitBreakerMiddleware{
+		circuits:      make(map[string]*circuit),
+		threshold:     int64(opts.Threshold),
+		timeout:       opts.Timeout,
+		probes:        opts.HalfOpenProbes,
+		successes:     opts.SuccessThreshold,
+		clock:         clock.OrReal(opts.Clock),
+		onStateChange: opts.OnStateChange,
+	}
+	if cb.probes < 1 {
+		cb.probes = 1
+	}
+	if cb.successes < 1 {
+		cb.successes = 1
 	}
+	return cb
 }
 
 func (cb *circuitBreakerMiddleware) Before(req *http.Request) error {
-	cb.mu.RLock()
-	state := cb.state
-	failures := cb.failures
-	lastFailTime := cb.lastFailTime
-	cb.mu.RUnlock()
-	
-	switch state {
+	host := req.URL.Host
+
+	cb.mu.Lock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		cb.mu.Unlock()
+		return nil
+	}
+	from := c.state
+
+	now := cb.clock.Now()
+	switch c.state {
 	case StateOpen:
-		if time.Since(lastFailTime) > cb.timeout {
-			cb.mu.Lock()
-			cb.state = StateHalfOpen
+		if now.Sub(c.lastFailTime) <= cb.timeout {
 			cb.mu.Unlock()
-		} else {
-			return fmt.Errorf("circuit breaker is open")
+			return ErrCircuitOpen
 		}
-	case StateHalfOpen:
-		// Allow one request through
+		c.state = StateHalfOpen
+		c.probes, c.successes = 0, 0
 	case StateClosed:
 		// Normal operation
 	}
-	
+	if c.state == StateHalfOpen {
+		// Probes that never finished, e.g. because a later middleware
+		// failed the request, give their places up after the timeout
+		if c.probes >= cb.probes && now.Sub(c.probeTime) > cb.timeout {
+			c.probes = 0
+		}
+		if c.probes >= cb.probes {
+			cb.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.probes++
+		c.probeTime = now
+	}
+
+	to := c.state
+	cb.mu.Unlock()
+	cb.notify(host, from, to)
+
 	return nil
 }
 
 func (cb *circuitBreakerMiddleware) After(resp *http.Response) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-	
+	if resp.Request == nil {
+		return
+	}
+	host := resp.Request.URL.Host
+
 	if resp.StatusCode >= 500 {
 		// Server error - count as failure
-		cb.failures++
-		cb.lastFailTime = time.Now()
-		
-		if cb.failures >= cb.threshold {
-			cb.state = StateOpen
+		cb.recordFailure(host)
+		return
+	}
+
+	// Success - reset failures, or count towards closing a half-open
+	// circuit
+	cb.mu.Lock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		cb.mu.Unlock()
+		return
+	}
+	from := c.state
+	if c.state == StateHalfOpen {
+		c.endProbe()
+		c.successes++
+		if c.successes < cb.successes {
+			cb.mu.Unlock()
+			return
 		}
-	} else {
-		// Success - reset failures
-		cb.failures = 0
-		if cb.state == StateHalfOpen {
-			cb.state = StateClosed
+	}
+	delete(cb.circuits, host)
+	cb.mu.Unlock()
+	cb.notify(host, from, StateClosed)
+}
+
+// OnError counts transport errors, such as refused connections and
+// timeouts, as failures. A request canceled by its caller says nothing
+// about the host and only gives up its place as a probe.
+func (cb *circuitBreakerMiddleware) OnError(req *http.Request, err error) {
+	host := req.URL.Host
+	if !errors.Is(err, context.Canceled) {
+		cb.recordFailure(host)
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if c, ok := cb.circuits[host]; ok && c.state == StateHalfOpen {
+		c.endProbe()
+	}
+}
+
+func (cb *circuitBreakerMiddleware) recordFailure(host string) {
+	cb.mu.Lock()
+	c, ok := cb.circuits[host]
+	var dropped string
+	var droppedState CircuitState
+	if !ok {
+		if len(cb.circuits) >= MaxCircuits {
+			dropped, droppedState = cb.dropOldest()
 		}
+		c = &circuit{state: StateClosed}
+		cb.circuits[host] = c
+	}
+	from := c.state
+
+	c.failures++
+	c.lastFailTime = cb.clock.Now()
+	if c.failures >= cb.threshold || c.state == StateHalfOpen {
+		// A failed probe opens the circuit again
+		c.state = StateOpen
+		c.probes, c.successes = 0, 0
+	}
+
+	to := c.state
+	cb.mu.Unlock()
+	if dropped != "" {
+		cb.notify(dropped, droppedState, StateClosed)
+	}
+	cb.notify(host, from, to)
+}
+
+// endProbe gives up the place of a finished probe
+func (c *circuit) endProbe() {
+	if c.probes > 0 {
+		c.probes--
+	}
+}
+
+// dropOldest forgets the circuit that failed longest ago and returns its
+// host and state. The caller holds cb.mu.
+func (cb *circuitBreakerMiddleware) dropOldest() (string, CircuitState) {
+	var oldest string
+	var oldestTime time.Time
+	for host, c := range cb.circuits {
+		if oldest == "" || c.lastFailTime.Before(oldestTime) {
+			oldest, oldestTime = host, c.lastFailTime
+		}
+	}
+	state := cb.circuits[oldest].state
+	delete(cb.circuits, oldest)
+	return oldest, state
+}
+
+// notify runs the state change callback outside the lock, so that it may
+// query the breaker itself, and updates the host's state metric
+func (cb *circuitBreakerMiddleware) notify(host string, from, to CircuitState) {
+	if from == to {
+		return
+	}
+	if to == StateClosed {
+		circuitStates.DeleteLabelValues(host)
+	} else {
+		circuitStates.WithLabelValues(host).Set(float64(to))
+	}
+	if cb.onStateChange != nil {
+		cb.onStateChange(host, from, to)
 	}
 }
 
-// GetState returns the current circuit breaker state
+// GetState returns the worst state across all hosts: open if any circuit
+// is open, otherwise half-open if any is half-open, otherwise closed
 func (cb *circuitBreakerMiddleware) GetState() CircuitState {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
-	return cb.state
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state := StateClosed
+	for _, c := range cb.circuits {
+		switch c.state {
+		case StateOpen:
+			return StateOpen
+		case StateHalfOpen:
+			state = StateHalfOpen
+		}
+	}
+	return state
 }
 
-// GetFailures returns the current failure count
+// GetFailures returns the failure count summed across all hosts
 func (cb *circuitBreakerMiddleware) GetFailures() int64 {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
-	return cb.failures
-}
\ No newline at end of file
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var failures int64
+	for _, c := range cb.circuits {
+		failures += c.failures
+	}
+	return failures
+}
+
+// GetHostState returns the state and failure count of host's circuit
+func (cb *circuitBreakerMiddleware) GetHostState(host string) (CircuitState, int64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if c, ok := cb.circuits[host]; ok {
+		return c.state, c.failures
+	}
+	return StateClosed, 0
+}
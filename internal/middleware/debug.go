@@ -1,36 +1,67 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
 	"net/http"
 	"time"
+
+	"github.com/yourorg/httpclient/internal/logging"
 )
 
+// sensitiveHeaders are logged with their values redacted
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+type debugStartKey struct{}
+
 type debugMiddleware struct {
-	startTime time.Time
+	logger logging.Logger
 }
 
-// NewDebug creates a new debug middleware
-func NewDebug() Middleware {
-	return &debugMiddleware{}
+// NewDebug creates a middleware that logs each request, its headers and its
+// response or failure to logger at debug level
+func NewDebug(logger logging.Logger) Middleware {
+	return &debugMiddleware{logger: logging.OrNop(logger)}
 }
 
 func (d *debugMiddleware) Before(req *http.Request) error {
-	d.startTime = time.Now()
-	fmt.Printf("[DEBUG] %s %s\n", req.Method, req.URL.String())
-	
-	// Print headers
+	d.logger.Debugf("request method=%s url=%s", req.Method, req.URL.String())
 	for key, values := range req.Header {
 		for _, value := range values {
-			fmt.Printf("[DEBUG] Header: %s: %s\n", key, value)
+			if sensitiveHeaders[key] {
+				value = "[REDACTED]"
+			}
+			d.logger.Debugf("header %s: %s", key, value)
 		}
 	}
-	
+
+	// The start time travels in the request context, so concurrent
+	// requests sharing the middleware each time their own
+	*req = *req.WithContext(context.WithValue(req.Context(), debugStartKey{}, time.Now()))
 	return nil
 }
 
 func (d *debugMiddleware) After(resp *http.Response) {
-	duration := time.Since(d.startTime)
-	fmt.Printf("[DEBUG] Response: %d %s (took %v)\n", 
-		resp.StatusCode, resp.Status, duration)
-}
\ No newline at end of file
+	if resp.Request == nil {
+		return
+	}
+	d.logger.Debugf("response method=%s url=%s status=%d duration=%s",
+		resp.Request.Method, resp.Request.URL.String(), resp.StatusCode, elapsed(resp.Request))
+}
+
+func (d *debugMiddleware) OnError(req *http.Request, err error) {
+	d.logger.Errorf("request failed method=%s url=%s duration=%s error=%q",
+		req.Method, req.URL.String(), elapsed(req), err.Error())
+}
+
+func elapsed(req *http.Request) time.Duration {
+	start, ok := req.Context().Value(debugStartKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(start)
+}
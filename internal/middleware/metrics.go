@@ -15,7 +15,7 @@ var (
 			Name: "httpclient_requests_total",
 			Help: "Total number of HTTP requests made",
 		},
-		[]string{"method", "status_code"},
+		[]string{"method", "route", "status_code", "protocol"},
 	)
 
 	requestDuration = promauto.NewHistogramVec(
@@ -24,13 +24,14 @@ var (
 			Help:    "HTTP request duration in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "status_code"},
+		[]string{"method", "route", "status_code", "protocol"},
 	)
 )
 
 type metricsMiddleware struct {
 	startTime time.Time
 	method    string
+	route     string
 }
 
 // NewMetrics creates a new metrics middleware
@@ -41,6 +42,7 @@ func NewMetrics() Middleware {
 func (m *metricsMiddleware) Before(req *http.Request) error {
 	m.startTime = time.Now()
 	m.method = req.Method
+	m.route = Route(req)
 	return nil
 }
 
@@ -48,6 +50,6 @@ func (m *metricsMiddleware) After(resp *http.Response) {
 	duration := time.Since(m.startTime).Seconds()
 	statusCode := strconv.Itoa(resp.StatusCode)
 
-	requestsTotal.WithLabelValues(m.method, statusCode).Inc()
-	requestDuration.WithLabelValues(m.method, statusCode).Observe(duration)
-}
\ No newline at end of file
+	requestsTotal.WithLabelValues(m.method, m.route, statusCode, resp.Proto).Inc()
+	requestDuration.WithLabelValues(m.method, m.route, statusCode, resp.Proto).Observe(duration)
+}
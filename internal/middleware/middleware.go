@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -10,6 +11,29 @@ type Middleware interface {
 	After(resp *http.Response)
 }
 
+// ErrorObserver is implemented by middleware that also needs to see
+// requests which failed before any response was received
+type ErrorObserver interface {
+	OnError(req *http.Request, err error)
+}
+
+// routeKey carries the URL template of a request in its context
+type routeKey struct{}
+
+// WithRoute returns a context whose requests were built from the URL
+// template route, e.g. "/users/{id}"
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// Route returns the URL template req was built from, or "" when its URL was
+// given as is. Unlike the URL it has few distinct values, so it suits
+// metric labels and span names.
+func Route(req *http.Request) string {
+	route, _ := req.Context().Value(routeKey{}).(string)
+	return route
+}
+
 // middlewareFunc is an adapter to allow functions to be used as middleware
 type middlewareFunc struct {
 	before func(*http.Request) error
@@ -1,45 +1,85 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// defaultPropagator is used when neither the client nor the application,
+// through otel.SetTextMapPropagator, set one
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
 type tracingMiddleware struct {
-	tracer trace.Tracer
-	span   trace.Span
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
 }
 
-// NewTracing creates a new tracing middleware
-func NewTracing() Middleware {
+// NewTracing creates a new tracing middleware. The span of each request is
+// injected into its headers with propagator, or the global propagator when
+// nil, so downstream services can join the trace; W3C traceparent and
+// tracestate are sent when no propagator is configured anywhere.
+func NewTracing(propagator propagation.TextMapPropagator) Middleware {
 	return &tracingMiddleware{
-		tracer: otel.Tracer("httpclient"),
+		tracer:     otel.Tracer("httpclient"),
+		propagator: propagator,
 	}
 }
 
 func (t *tracingMiddleware) Before(req *http.Request) error {
+	attributes := []attribute.KeyValue{
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.String()),
+	}
+	if route := Route(req); route != "" {
+		attributes = append(attributes, attribute.String("http.route", route))
+	}
 	ctx := req.Context()
-	ctx, span := t.tracer.Start(ctx, "http_request",
-		trace.WithAttributes(
-			attribute.String("http.method", req.Method),
-			attribute.String("http.url", req.URL.String()),
-		),
+	ctx, _ = t.tracer.Start(ctx, "http_request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
 	)
-	
-	t.span = span
+
+	t.textMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	*req = *req.WithContext(ctx)
 	return nil
 }
 
+// textMapPropagator is looked up per request since the application may set
+// the global propagator after the client was created
+func (t *tracingMiddleware) textMapPropagator() propagation.TextMapPropagator {
+	if t.propagator != nil {
+		return t.propagator
+	}
+	if global := otel.GetTextMapPropagator(); len(global.Fields()) > 0 {
+		return global
+	}
+	return defaultPropagator
+}
+
+// The span travels in the request context, so concurrent requests sharing
+// the middleware each end their own
 func (t *tracingMiddleware) After(resp *http.Response) {
-	if t.span != nil {
-		t.span.SetAttributes(
-			attribute.Int("http.status_code", resp.StatusCode),
-		)
-		t.span.End()
+	if resp.Request == nil {
+		return
+	}
+	span := trace.SpanFromContext(resp.Request.Context())
+	span.SetAttributes(
+		attribute.Int("http.status_code", resp.StatusCode),
+	)
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
 	}
-}
\ No newline at end of file
+	span.End()
+}
+
+func (t *tracingMiddleware) OnError(req *http.Request, err error) {
+	span := trace.SpanFromContext(req.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
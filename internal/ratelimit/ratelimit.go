@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultIdleTimeout is how long a host's limiter is kept after its last use
+const DefaultIdleTimeout = 10 * time.Minute
+
+// PerHost maintains an independent token-bucket limiter for each host
+type PerHost struct {
+	limiters    map[string]*hostLimiter
+	rps         int
+	burst       int
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	mu          sync.Mutex
+}
+
+type hostLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// NewPerHost creates a per-host limiter allowing rps requests per second with
+// the given burst for every host. Limiters idle for longer than idleTimeout
+// are discarded so the map doesn't grow without bound.
+func NewPerHost(rps, burst int, idleTimeout time.Duration) *PerHost {
+	if burst <= 0 {
+		burst = rps
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+
+	return &PerHost{
+		limiters:    make(map[string]*hostLimiter),
+		rps:         rps,
+		burst:       burst,
+		idleTimeout: idleTimeout,
+		lastSweep:   time.Now(),
+	}
+}
+
+// Limiter returns the limiter for host, creating it on first use
+func (p *PerHost) Limiter(host string) *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastSweep) > p.idleTimeout {
+		p.sweep(now)
+	}
+
+	hl, exists := p.limiters[host]
+	if !exists {
+		hl = &hostLimiter{
+			limiter: rate.NewLimiter(rate.Limit(p.rps), p.burst),
+		}
+		p.limiters[host] = hl
+	}
+	hl.lastUsed = now
+
+	return hl.limiter
+}
+
+// Len returns the number of hosts currently tracked
+func (p *PerHost) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.limiters)
+}
+
+// sweep removes limiters that have been idle for longer than idleTimeout
+func (p *PerHost) sweep(now time.Time) {
+	for host, hl := range p.limiters {
+		if now.Sub(hl.lastUsed) > p.idleTimeout {
+			delete(p.limiters, host)
+		}
+	}
+	p.lastSweep = now
+}
@@ -1,25 +1,55 @@
 package retry
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/yourorg/httpclient/internal/clock"
 	"github.com/yourorg/httpclient/internal/config"
 )
 
-// Strategy defines the retry strategy interface
+// ErrMaxRetries is returned when every attempt has failed
+var ErrMaxRetries = errors.New("max retries exceeded")
+
+// ErrRetryBudgetExceeded is returned, wrapping the last attempt's error,
+// when waiting for another attempt would exceed the retry budget
+var ErrRetryBudgetExceeded = errors.New("retry budget exceeded")
+
+// stopError marks an error that must not be retried
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string { return e.err.Error() }
+func (e *stopError) Unwrap() error { return e.err }
+
+// Stop wraps err so Execute returns it without further attempts, e.g.
+// because the request's context is done
+func Stop(err error) error {
+	return &stopError{err: err}
+}
+
+// Strategy defines the retry strategy interface. Execute stops waiting
+// for the next attempt once ctx is done.
 type Strategy interface {
-	Execute(fn func() ([]byte, error)) ([]byte, error)
+	Execute(ctx context.Context, fn func() ([]byte, error)) ([]byte, error)
 }
 
 // exponentialBackoff implements exponential backoff retry strategy
 type exponentialBackoff struct {
-	maxRetries  int
-	baseDelay   time.Duration
-	multiplier  float64
-	maxDelay    time.Duration
+	maxRetries int
+	baseDelay  time.Duration
+	multiplier float64
+	maxDelay   time.Duration
+	clock      clock.Clock
+	onRetry    func(attempt int, err error, nextDelay time.Duration)
+	budget     time.Duration
 }
 
 // NewExponentialBackoff creates a new exponential backoff retry strategy
@@ -29,35 +59,62 @@ func NewExponentialBackoff(cfg *config.Config) Strategy {
 		baseDelay:  cfg.RetryDelay,
 		multiplier: cfg.RetryMultiplier,
 		maxDelay:   cfg.RetryMaxDelay,
+		clock:      clock.OrReal(cfg.Clock),
+		onRetry:    cfg.OnRetry,
+		budget:     cfg.RetryBudget,
 	}
 }
 
-func (e *exponentialBackoff) Execute(fn func() ([]byte, error)) ([]byte, error) {
+func (e *exponentialBackoff) Execute(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
 	var lastErr error
-	
+	start := e.clock.Now()
+
 	for attempt := 0; attempt <= e.maxRetries; attempt++ {
 		data, err := fn()
 		if err == nil {
 			return data, nil
 		}
-		
+
 		lastErr = err
-		
-		// Don't retry on client errors (4xx)
-		if httpErr, ok := err.(*HTTPError); ok {
-			if httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 {
-				return nil, err
-			}
+
+		var stop *stopError
+		if errors.As(err, &stop) {
+			return nil, stop.err
+		}
+
+		// Don't retry on client errors (4xx), except the ones that ask
+		// to try again later
+		if httpErr, ok := err.(*HTTPError); ok && !retryableStatus(httpErr.StatusCode) {
+			return nil, err
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !retryableStatus(apiErr.StatusCode) {
+			return nil, err
 		}
-		
+
 		// Don't sleep after the last attempt
 		if attempt < e.maxRetries {
 			delay := e.calculateDelay(attempt)
-			time.Sleep(delay)
+			if apiErr != nil {
+				if after, ok := retryAfter(apiErr.Headers, e.clock.Now()); ok {
+					delay = min(after, e.maxDelay)
+				}
+			}
+			if e.budget > 0 && e.clock.Now().Sub(start)+delay > e.budget {
+				return nil, fmt.Errorf("%w: %w", ErrRetryBudgetExceeded, lastErr)
+			}
+			if e.onRetry != nil {
+				e.onRetry(attempt+1, err, delay)
+			}
+			select {
+			case <-e.clock.After(delay):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w: %w", context.Cause(ctx), lastErr)
+			}
 		}
 	}
-	
-	return nil, fmt.Errorf("max retries exceeded: %w", lastErr)
+
+	return nil, fmt.Errorf("%w: %w", ErrMaxRetries, lastErr)
 }
 
 func (e *exponentialBackoff) calculateDelay(attempt int) time.Duration {
@@ -68,6 +125,32 @@ func (e *exponentialBackoff) calculateDelay(attempt int) time.Duration {
 	return time.Duration(delay)
 }
 
+// retryableStatus reports whether a response with status code may succeed
+// when repeated: server errors, 408 Request Timeout and 429 Too Many
+// Requests
+func retryableStatus(code int) bool {
+	return code < 400 || code >= 500 ||
+		code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// retryAfter returns the delay the Retry-After header in h asks for, given
+// in seconds or as an HTTP date, and whether it has a valid one. Execute
+// caps the delay at the maximum backoff delay, so a server can't stall the
+// client for hours
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
 // HTTPError represents an HTTP error with status code
 type HTTPError struct {
 	StatusCode int
@@ -84,4 +167,21 @@ func NewHTTPError(statusCode int, message string) *HTTPError {
 		StatusCode: statusCode,
 		Message:    message,
 	}
-}
\ No newline at end of file
+}
+
+// APIError is returned for responses with a 4xx or 5xx status code. It keeps
+// the status, body and headers so callers don't have to parse the message.
+type APIError struct {
+	StatusCode int
+	Body       []byte
+	Headers    http.Header
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, string(e.Body))
+}
+
+// Unmarshal decodes the JSON error payload into v
+func (e *APIError) Unmarshal(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
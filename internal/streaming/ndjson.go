@@ -0,0 +1,40 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrPartialRecord is returned when a JSON stream ends in the middle of a
+// record, e.g. because the connection dropped
+var ErrPartialRecord = errors.New("stream ended with a partial JSON record")
+
+// DecodeJSONStream reads a stream of JSON documents from r and calls fn
+// with each complete one. Documents may be newline-delimited (NDJSON, JSON
+// Lines) or simply concatenated, and may arrive split across reads at any
+// point. Decoding stops at the first error from fn, which is returned; a
+// clean end of stream returns nil.
+func DecodeJSONStream(r io.Reader, fn func(json.RawMessage) error) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var record json.RawMessage
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			partial, _ := io.ReadAll(decoder.Buffered())
+			return fmt.Errorf("%w: %q", ErrPartialRecord, bytes.TrimSpace(partial))
+		}
+		if err != nil {
+			return fmt.Errorf("decode JSON stream at offset %d: %w", decoder.InputOffset(), err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrWebSocketClosed is returned by a WebSocket after Close
+	ErrWebSocketClosed = errors.New("websocket closed")
+	// ErrDisconnected is returned by Send when a message can't be queued
+	// while a reconnecting WebSocket is disconnected
+	ErrDisconnected = errors.New("websocket disconnected, message dropped")
+	// ErrReconnectFailed is returned by Receive when every reconnect
+	// attempt allowed by the policy has failed
+	ErrReconnectFailed = errors.New("websocket reconnect failed")
+)
+
+// DefaultMaxPending is the number of messages a reconnecting WebSocket
+// queues while disconnected unless the policy says otherwise
+const DefaultMaxPending = 100
+
+// Socket is the API shared by WebSocketConn and ReconnectingWebSocket
+type Socket interface {
+	Send(data interface{}) error
+	SendJSON(v interface{}) error
+	Receive() ([]byte, error)
+	ReceiveJSON(v interface{}) error
+	ReceiveMessage() (Message, error)
+	ReceiveTyped() (MessageType, []byte, error)
+	Listen(ctx context.Context) (<-chan Message, <-chan error)
+	ReadChannel() <-chan Message
+	Close() error
+	CloseWithStatus(code int, reason string) error
+}
+
+// ReceiveAs receives the next message and decodes it from JSON into a T
+func ReceiveAs[T any](s Socket) (T, error) {
+	var v T
+	err := s.ReceiveJSON(&v)
+	return v, err
+}
+
+// ReconnectPolicy controls how a dropped WebSocket is re-dialed. Zero
+// fields take the defaults noted below.
+type ReconnectPolicy struct {
+	MaxAttempts  int           // reconnect attempts per drop, 0 for unlimited
+	InitialDelay time.Duration // delay before the first attempt, 500ms
+	MaxDelay     time.Duration // cap on the delay between attempts, 30s
+	Multiplier   float64       // delay growth per attempt, 2
+	Jitter       float64       // fraction of each delay randomly taken off, 0 to 1
+
+	// Messages sent while disconnected are queued, up to MaxPending
+	// (DefaultMaxPending when 0), and sent after reconnecting. With
+	// DropWhileDisconnected they are rejected with ErrDisconnected instead.
+	MaxPending            int
+	DropWhileDisconnected bool
+}
+
+func (p ReconnectPolicy) delay(attempt int, r *rand.Rand) time.Duration {
+	initial, maxDelay, multiplier := p.InitialDelay, p.MaxDelay, p.Multiplier
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt)), float64(maxDelay))
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * r.Float64()
+	}
+	return time.Duration(d)
+}
+
+// WebSocketOption customizes a single WebSocket call
+type WebSocketOption func(*WebSocketOptions)
+
+// WebSocketOptions collects the options of a WebSocket call
+type WebSocketOptions struct {
+	Reconnect   *ReconnectPolicy
+	OnReconnect func(Socket) error
+
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// Subprotocols are offered in the handshake, in order of preference
+	Subprotocols []string
+}
+
+// NewWebSocketOptions applies opts
+func NewWebSocketOptions(opts []WebSocketOption) *WebSocketOptions {
+	o := &WebSocketOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithReconnect re-dials the connection with exponential backoff when it
+// drops, see ReconnectingWebSocket
+func WithReconnect(policy ReconnectPolicy) WebSocketOption {
+	return func(o *WebSocketOptions) {
+		o.Reconnect = &policy
+	}
+}
+
+// OnReconnect calls fn with each new connection after a reconnect, before
+// queued messages are sent, e.g. to re-authenticate or resubscribe. An
+// error fails the attempt and the connection is dialed again.
+func OnReconnect(fn func(conn Socket) error) WebSocketOption {
+	return func(o *WebSocketOptions) {
+		o.OnReconnect = fn
+	}
+}
+
+// WithPingKeepalive pings the server every interval and closes the
+// connection with ErrKeepaliveTimeout when nothing arrives within timeout
+// of a ping, see WebSocketDialer.WithKeepalive. With WithReconnect a dead
+// connection is re-dialed.
+func WithPingKeepalive(interval, timeout time.Duration) WebSocketOption {
+	return func(o *WebSocketOptions) {
+		o.PingInterval = interval
+		o.PongTimeout = timeout
+	}
+}
+
+// ReconnectingWebSocket is a WebSocket connection that is re-dialed when
+// it drops. Receive reconnects transparently, so messages keep flowing
+// after a drop; messages sent while disconnected are queued until the
+// connection is back. Close stops reconnecting for good.
+type ReconnectingWebSocket struct {
+	dial        func(ctx context.Context) (*WebSocketConn, error)
+	policy      ReconnectPolicy
+	onReconnect func(Socket) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	reconnectMu sync.Mutex // one reconnect at a time
+	mu          sync.Mutex
+	conn        *WebSocketConn // nil while disconnected
+	pending     []interface{}
+	closed      bool
+	reconnects  int64
+	rand        *rand.Rand
+
+	readOnce sync.Once
+	messages <-chan Message // see ReadChannel
+	readErr  error          // why messages was closed
+}
+
+// NewReconnectingWebSocket dials a connection with dial, which is called
+// again to reconnect whenever the connection drops
+func NewReconnectingWebSocket(ctx context.Context, dial func(ctx context.Context) (*WebSocketConn, error), options *WebSocketOptions) (*ReconnectingWebSocket, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &ReconnectingWebSocket{
+		dial:        dial,
+		onReconnect: options.OnReconnect,
+		conn:        conn,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if options.Reconnect != nil {
+		rw.policy = *options.Reconnect
+	}
+	// Reconnects outlive the context the connection was opened with
+	rw.ctx, rw.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	return rw, nil
+}
+
+// Send sends data on the current connection, or queues it while
+// disconnected according to the reconnect policy
+func (rw *ReconnectingWebSocket) Send(data interface{}) error {
+	for {
+		rw.mu.Lock()
+		if rw.closed {
+			rw.mu.Unlock()
+			return ErrWebSocketClosed
+		}
+		conn := rw.conn
+		if conn == nil {
+			err := rw.queue(data)
+			rw.mu.Unlock()
+			return err
+		}
+		rw.mu.Unlock()
+
+		// A slow write mustn't hold up Receive, reconnects or Close
+		err := conn.Send(data)
+		if err == nil {
+			return nil
+		}
+		conn.abort()
+
+		rw.mu.Lock()
+		if rw.conn == conn {
+			rw.conn = nil
+		}
+		rw.mu.Unlock()
+	}
+}
+
+// queue keeps data to be sent after reconnecting, if the policy allows.
+// The caller holds rw.mu.
+func (rw *ReconnectingWebSocket) queue(data interface{}) error {
+	maxPending := rw.policy.MaxPending
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+	if rw.policy.DropWhileDisconnected || len(rw.pending) >= maxPending {
+		return ErrDisconnected
+	}
+	rw.pending = append(rw.pending, data)
+	return nil
+}
+
+// SendJSON sends v encoded as JSON in a text message, like Send
+func (rw *ReconnectingWebSocket) SendJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return rw.Send(json.RawMessage(payload))
+}
+
+// Receive returns the next message, reconnecting as often as needed. It
+// fails with ErrReconnectFailed once a drop can't be recovered within the
+// policy's attempts, and with ErrWebSocketClosed after Close.
+func (rw *ReconnectingWebSocket) Receive() ([]byte, error) {
+	msg, err := rw.ReceiveMessage()
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data, nil
+}
+
+// ReceiveMessage is Receive returning the message type and arrival time too
+func (rw *ReconnectingWebSocket) ReceiveMessage() (Message, error) {
+	return rw.receive(context.Background())
+}
+
+// ReceiveTyped returns the type and payload of the next message,
+// reconnecting as Receive does
+func (rw *ReconnectingWebSocket) ReceiveTyped() (MessageType, []byte, error) {
+	return splitMessage(rw.ReceiveMessage())
+}
+
+// Listen delivers messages on a channel, reconnecting as Receive does,
+// until ctx is done, Close is called or reconnecting fails. The error
+// channel gets ErrReconnectFailed in the last case.
+func (rw *ReconnectingWebSocket) Listen(ctx context.Context) (<-chan Message, <-chan error) {
+	return listen(ctx, rw.receive)
+}
+
+// ReadChannel returns a channel delivering messages, reconnecting as
+// Receive does, until Close is called or reconnecting fails. Every call
+// returns the same channel, so any number of goroutines can range over it,
+// each message going to one of them. Once it is closed Receive returns
+// why, e.g. ErrReconnectFailed.
+func (rw *ReconnectingWebSocket) ReadChannel() <-chan Message {
+	rw.readOnce.Do(func() {
+		rw.messages, _ = listen(rw.ctx, func(ctx context.Context) (Message, error) {
+			msg, err := rw.receive(ctx)
+			if err != nil {
+				rw.mu.Lock()
+				rw.readErr = err
+				rw.mu.Unlock()
+			}
+			return msg, err
+		})
+	})
+	return rw.messages
+}
+
+func (rw *ReconnectingWebSocket) receive(ctx context.Context) (Message, error) {
+	for {
+		rw.mu.Lock()
+		conn, closed, readErr := rw.conn, rw.closed, rw.readErr
+		rw.mu.Unlock()
+
+		if closed {
+			return Message{}, ErrWebSocketClosed
+		}
+		if readErr != nil {
+			// The ReadChannel reader gave up, and so does everyone else
+			return Message{}, readErr
+		}
+		if conn == nil {
+			if err := rw.reconnect(ctx); err != nil {
+				return Message{}, err
+			}
+			continue
+		}
+
+		msg, err := conn.receive(ctx)
+		if err == nil {
+			return msg, nil
+		}
+		if ctx.Err() != nil {
+			return Message{}, ctx.Err()
+		}
+
+		rw.mu.Lock()
+		if rw.conn == conn {
+			rw.conn = nil
+		}
+		rw.mu.Unlock()
+		conn.abort()
+	}
+}
+
+func (rw *ReconnectingWebSocket) ReceiveJSON(v interface{}) error {
+	data, err := rw.Receive()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// reconnect dials until a connection is up, the policy gives up, the
+// socket is closed or ctx is done
+func (rw *ReconnectingWebSocket) reconnect(ctx context.Context) error {
+	rw.reconnectMu.Lock()
+	defer rw.reconnectMu.Unlock()
+
+	var lastErr error
+	for attempt := 0; rw.policy.MaxAttempts <= 0 || attempt < rw.policy.MaxAttempts; attempt++ {
+		rw.mu.Lock()
+		connected, closed := rw.conn != nil, rw.closed
+		delay := rw.policy.delay(attempt, rw.rand)
+		rw.mu.Unlock()
+		if closed {
+			return ErrWebSocketClosed
+		}
+		if connected {
+			// Another caller reconnected while this one waited
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-rw.ctx.Done():
+			timer.Stop()
+			return ErrWebSocketClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		conn, err := rw.dial(rw.ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if rw.onReconnect != nil {
+			if err := rw.onReconnect(conn); err != nil {
+				conn.Close()
+				lastErr = fmt.Errorf("reconnect callback: %w", err)
+				continue
+			}
+		}
+
+		if err := rw.attach(conn); err != nil {
+			if errors.Is(err, ErrWebSocketClosed) {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+		atomic.AddInt64(&rw.reconnects, 1)
+		return nil
+	}
+
+	return fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, rw.policy.MaxAttempts, lastErr)
+}
+
+// attach sends the queued messages on conn and makes it the current
+// connection
+func (rw *ReconnectingWebSocket) attach(conn *WebSocketConn) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		conn.Close()
+		return ErrWebSocketClosed
+	}
+	for len(rw.pending) > 0 {
+		if err := conn.Send(rw.pending[0]); err != nil {
+			conn.abort()
+			return fmt.Errorf("send queued message: %w", err)
+		}
+		rw.pending = rw.pending[1:]
+	}
+	rw.conn = conn
+	return nil
+}
+
+// Reconnects returns the number of times the connection was re-established
+func (rw *ReconnectingWebSocket) Reconnects() int64 {
+	return atomic.LoadInt64(&rw.reconnects)
+}
+
+// Close closes the connection with code 1000, normal closure, and stops
+// reconnecting. Queued messages are discarded.
+func (rw *ReconnectingWebSocket) Close() error {
+	return rw.CloseWithStatus(CloseNormalClosure, "")
+}
+
+// CloseWithStatus closes the connection with code and reason, see
+// WebSocketConn.CloseWithStatus, and stops reconnecting
+func (rw *ReconnectingWebSocket) CloseWithStatus(code int, reason string) error {
+	rw.mu.Lock()
+	if rw.closed {
+		rw.mu.Unlock()
+		return nil
+	}
+	rw.closed = true
+	rw.cancel()
+	rw.pending = nil
+	conn := rw.conn
+	rw.conn = nil
+	rw.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.CloseWithStatus(code, reason)
+}
@@ -0,0 +1,157 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+)
+
+// ErrRecordTooLong is returned when a framed stream record exceeds the
+// maximum record size
+var ErrRecordTooLong = errors.New("stream record exceeds the maximum size")
+
+// DefaultMaxRecordSize is the largest record a framed stream accepts
+// unless MaxRecordSize says otherwise
+const DefaultMaxRecordSize = bufio.MaxScanTokenSize
+
+// DefaultStreamBufferSize is the number of values a stream channel holds
+// unless StreamBufferSize says otherwise
+const DefaultStreamBufferSize = 100
+
+// StreamOption customizes a single Stream call
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	split      bufio.SplitFunc // nil forwards raw reads
+	maxSize    int
+	bufferSize int
+	onError    func(error)
+	onEnd      []func()
+}
+
+func newStreamOptions(opts []StreamOption) *streamOptions {
+	o := &streamOptions{maxSize: DefaultMaxRecordSize, bufferSize: DefaultStreamBufferSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// SplitLines delivers one line per channel value, without its LF or CRLF
+// terminator, like bufio.ScanLines
+func SplitLines() StreamOption {
+	return func(o *streamOptions) {
+		o.split = bufio.ScanLines
+	}
+}
+
+// SplitDelimiter delivers the records between occurrences of delim,
+// without the delimiter. Data after the last delimiter is delivered when
+// the stream ends.
+func SplitDelimiter(delim byte) StreamOption {
+	return func(o *streamOptions) {
+		o.split = func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, delim); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		}
+	}
+}
+
+// SplitSSE delivers whole server-sent events: the field lines of an event
+// without the blank line that ends it. An event cut off by the end of the
+// stream is dropped, as the SSE spec requires.
+func SplitSSE() StreamOption {
+	return func(o *streamOptions) {
+		o.split = scanSSEEvents
+	}
+}
+
+// MaxRecordSize sets the largest record, including its delimiter, that a
+// framed stream accepts. A longer record ends the stream with
+// ErrRecordTooLong instead of being truncated.
+func MaxRecordSize(n int) StreamOption {
+	return func(o *streamOptions) {
+		o.maxSize = n
+	}
+}
+
+// StreamBufferSize sets how many values the stream channel holds, 0 for an
+// unbuffered channel. Once it is full the stream stops reading the response
+// body until the consumer catches up, so the server is slowed down by TCP
+// flow control rather than the client buffering without bound.
+func StreamBufferSize(n int) StreamOption {
+	return func(o *streamOptions) {
+		o.bufferSize = max(n, 0)
+	}
+}
+
+// OnStreamError calls fn with the error that ends a stream early, such as
+// ErrRecordTooLong or a dropped connection, just before the channel is
+// closed. It isn't called when the stream ends cleanly or its context is
+// done.
+func OnStreamError(fn func(error)) StreamOption {
+	return func(o *streamOptions) {
+		o.onError = fn
+	}
+}
+
+// OnStreamEnd calls fn once the stream has ended, for whatever reason, and
+// its channel is about to be closed
+func OnStreamEnd(fn func()) StreamOption {
+	return func(o *streamOptions) {
+		o.onEnd = append(o.onEnd, fn)
+	}
+}
+
+// scanSSEEvents is a bufio.SplitFunc that returns the lines of each event,
+// which ends at a blank line. Lines may end in LF, CRLF or a lone CR.
+func scanSSEEvents(data []byte, atEOF bool) (int, []byte, error) {
+	start, lineStart := 0, 0
+	for i := 0; i < len(data); {
+		var n int
+		switch data[i] {
+		case '\n':
+			n = 1
+		case '\r':
+			switch {
+			case i+1 < len(data) && data[i+1] == '\n':
+				n = 2
+			case i+1 < len(data) || atEOF:
+				n = 1
+			default:
+				// Need the next byte to tell CR from CRLF
+				return start, nil, nil
+			}
+		default:
+			i++
+			continue
+		}
+
+		if i == lineStart {
+			if lineStart == start {
+				// Blank lines outside an event carry nothing
+				start = i + n
+			} else {
+				return i + n, trimLineEnd(data[start:i]), nil
+			}
+		}
+		i += n
+		lineStart = i
+	}
+
+	if atEOF {
+		return len(data), nil, nil
+	}
+	return start, nil, nil
+}
+
+// trimLineEnd removes one trailing LF, CRLF or CR
+func trimLineEnd(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
@@ -1,29 +1,141 @@
 package streaming
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// WritePolicy controls what Send does when the write buffer is full
+type WritePolicy string
+
+// Write policies
+const (
+	WriteBlock      WritePolicy = "block"
+	WriteDropOldest WritePolicy = "drop-oldest"
+	WriteDropNewest WritePolicy = "drop-newest"
+)
+
+// MessageType is the frame type of a WebSocket message
+type MessageType int
+
+// Message types. CloseMessage is only reported by ReceiveTyped, for the
+// close frame that ends the connection; pings and pongs are answered and
+// tracked by the connection itself and never returned.
+const (
+	TextMessage   MessageType = websocket.TextMessage
+	BinaryMessage MessageType = websocket.BinaryMessage
+	CloseMessage  MessageType = websocket.CloseMessage
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	case CloseMessage:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is a message received on a WebSocket
+type Message struct {
+	Type     MessageType
+	Data     []byte
+	Received time.Time
+}
+
+// Close codes, see RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure = websocket.CloseNormalClosure
+	CloseGoingAway     = websocket.CloseGoingAway
+)
+
+// closeTimeout bounds the close handshake: sending the close frame and
+// waiting for the server's reply
+const closeTimeout = time.Second
+
+// CloseError is returned by Receive once the server has closed the
+// connection, with the code and reason from its close frame
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed by server: %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed by server: %d %s", e.Code, e.Reason)
+}
+
+// ErrKeepaliveTimeout is returned when a WebSocket with keepalive enabled
+// gets no pong, or any other frame, in time and is closed as dead
+var ErrKeepaliveTimeout = errors.New("websocket keepalive timeout")
+
 // WebSocketConn represents a WebSocket connection
 type WebSocketConn struct {
-	conn   *websocket.Conn
-	mu     sync.Mutex
-	closed bool
+	conn    *websocket.Conn
+	mu      sync.Mutex
+	closed  bool
+	writeMu sync.Mutex // one unbuffered Send at a time, without holding mu
+
+	// Keepalive pings, sent by the write pump when the interval is set
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	keepaliveErr error
+
+	// A single reader goroutine, started by the first receive, hands whole
+	// messages to concurrent receivers. incoming is closed once reading
+	// fails, with the reason in readErr, and readDone once the reader is
+	// gone.
+	readOnce sync.Once
+	incoming chan Message
+	readErr  error
+	readDone chan struct{}
+
+	// Buffered write pump, only set up when the dialer has a write buffer
+	writeQueue  chan wsMessage
+	writePolicy WritePolicy
+	writeErr    error
+	dropped     int64
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+type wsMessage struct {
+	messageType int
+	payload     []byte
 }
 
 // WebSocketDialer handles WebSocket connections
 type WebSocketDialer struct {
-	dialer   *websocket.Dialer
-	headers  http.Header
-	timeout  time.Duration
+	dialer      *websocket.Dialer
+	headers     http.Header
+	timeout     time.Duration
+	writeBuffer int
+	writePolicy WritePolicy
+
+	pingInterval time.Duration
+	pongTimeout  time.Duration
 }
 
 func NewWebSocketDialer() *WebSocketDialer {
@@ -33,8 +145,9 @@ func NewWebSocketDialer() *WebSocketDialer {
 			ReadBufferSize:   1024,
 			WriteBufferSize:  1024,
 		},
-		headers: make(http.Header),
-		timeout: 30 * time.Second,
+		headers:     make(http.Header),
+		timeout:     30 * time.Second,
+		writePolicy: WriteBlock,
 	}
 }
 
@@ -43,12 +156,78 @@ func (wd *WebSocketDialer) WithHeader(key, value string) *WebSocketDialer {
 	return wd
 }
 
+// WithHeaders adds headers to the handshake request
+func (wd *WebSocketDialer) WithHeaders(headers http.Header) *WebSocketDialer {
+	for key, values := range headers {
+		for _, value := range values {
+			wd.headers.Add(key, value)
+		}
+	}
+	return wd
+}
+
+// WithTLSConfig sets the TLS configuration for wss:// connections
+func (wd *WebSocketDialer) WithTLSConfig(config *tls.Config) *WebSocketDialer {
+	wd.dialer.TLSClientConfig = config
+	return wd
+}
+
+// WithProxy selects the HTTP or SOCKS5 proxy for each handshake request;
+// a nil URL connects directly
+func (wd *WebSocketDialer) WithProxy(proxy func(*http.Request) (*url.URL, error)) *WebSocketDialer {
+	wd.dialer.Proxy = proxy
+	return wd
+}
+
+// WithNetDialContext sets the function used to open TCP connections
+func (wd *WebSocketDialer) WithNetDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *WebSocketDialer {
+	wd.dialer.NetDialContext = dial
+	return wd
+}
+
+// WithCookieJar sends the jar's cookies with the handshake and stores
+// the cookies it sets
+func (wd *WebSocketDialer) WithCookieJar(jar http.CookieJar) *WebSocketDialer {
+	wd.dialer.Jar = jar
+	return wd
+}
+
+// WithSubprotocols asks the server for one of protocols, in order of
+// preference, see WebSocketConn.Subprotocol
+func (wd *WebSocketDialer) WithSubprotocols(protocols ...string) *WebSocketDialer {
+	wd.dialer.Subprotocols = protocols
+	return wd
+}
+
 func (wd *WebSocketDialer) WithTimeout(timeout time.Duration) *WebSocketDialer {
 	wd.timeout = timeout
 	wd.dialer.HandshakeTimeout = timeout
 	return wd
 }
 
+// WithWriteBuffer queues up to n outgoing messages and writes them from a
+// single goroutine, so a slow network doesn't block every sender. What
+// happens when the queue is full is controlled by WithWritePolicy.
+func (wd *WebSocketDialer) WithWriteBuffer(n int) *WebSocketDialer {
+	wd.writeBuffer = n
+	return wd
+}
+
+// WithKeepalive pings the server every interval and closes the connection
+// with ErrKeepaliveTimeout when nothing, not even a pong, arrives within
+// timeout of a ping. Pongs are handled while Receive is being called, so a
+// keepalive connection needs a read loop. Sends go through the write pump.
+func (wd *WebSocketDialer) WithKeepalive(interval, timeout time.Duration) *WebSocketDialer {
+	wd.pingInterval = interval
+	wd.pongTimeout = timeout
+	return wd
+}
+
+func (wd *WebSocketDialer) WithWritePolicy(policy WritePolicy) *WebSocketDialer {
+	wd.writePolicy = policy
+	return wd
+}
+
 func (wd *WebSocketDialer) Dial(urlStr string) (*WebSocketConn, error) {
 	return wd.DialContext(context.Background(), urlStr)
 }
@@ -76,19 +255,29 @@ func (wd *WebSocketDialer) DialContext(ctx context.Context, urlStr string) (*Web
 		return nil, fmt.Errorf("WebSocket dial failed: %w", err)
 	}
 
-	return &WebSocketConn{
-		conn: conn,
-	}, nil
-}
-
-func (wc *WebSocketConn) Send(data interface{}) error {
-	wc.mu.Lock()
-	defer wc.mu.Unlock()
+	wc := &WebSocketConn{
+		conn:     conn,
+		incoming: make(chan Message),
+		readDone: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
 
-	if wc.closed {
-		return fmt.Errorf("connection is closed")
+	// gorilla allows one writer at a time, so pings and buffered sends
+	// are written by the pump
+	if wd.writeBuffer > 0 || wd.pingInterval > 0 {
+		wc.writeQueue = make(chan wsMessage, wd.writeBuffer)
+		wc.writePolicy = wd.writePolicy
+		wc.stopped = make(chan struct{})
+		if wd.pingInterval > 0 {
+			wc.startKeepalive(wd.pingInterval, wd.pongTimeout)
+		}
+		go wc.writePump()
 	}
 
+	return wc, nil
+}
+
+func (wc *WebSocketConn) Send(data interface{}) error {
 	var messageType int
 	var payload []byte
 	var err error
@@ -108,20 +297,314 @@ func (wc *WebSocketConn) Send(data interface{}) error {
 		}
 	}
 
+	if wc.writeQueue != nil {
+		return wc.enqueue(wsMessage{messageType: messageType, payload: payload})
+	}
+
+	wc.writeMu.Lock()
+	defer wc.writeMu.Unlock()
+
+	wc.mu.Lock()
+	closed := wc.closed
+	wc.mu.Unlock()
+	if closed {
+		return ErrWebSocketClosed
+	}
+
+	// Close and abort may run meanwhile; they unblock a stalled write
 	return wc.conn.WriteMessage(messageType, payload)
 }
 
-func (wc *WebSocketConn) Receive() ([]byte, error) {
-	if wc.closed {
-		return nil, fmt.Errorf("connection is closed")
+// SendJSON sends v encoded as JSON in a text message
+func (wc *WebSocketConn) SendJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return wc.Send(json.RawMessage(payload))
+}
+
+// enqueue hands a message to the write pump, applying the write policy
+// when the buffer is full
+func (wc *WebSocketConn) enqueue(msg wsMessage) error {
+	select {
+	case <-wc.stopped:
+		return wc.stoppedErr()
+	default:
 	}
 
-	_, data, err := wc.conn.ReadMessage()
+	switch wc.writePolicy {
+	case WriteDropNewest:
+		select {
+		case wc.writeQueue <- msg:
+		default:
+			atomic.AddInt64(&wc.dropped, 1)
+		}
+	case WriteDropOldest:
+		for {
+			select {
+			case wc.writeQueue <- msg:
+				return nil
+			default:
+			}
+			select {
+			case <-wc.writeQueue:
+				atomic.AddInt64(&wc.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case wc.writeQueue <- msg:
+		case <-wc.stopped:
+			return wc.stoppedErr()
+		}
+	}
+
+	return nil
+}
+
+// writePump is the only writer on the connection while buffering or
+// keepalive is enabled
+func (wc *WebSocketConn) writePump() {
+	defer close(wc.stopped)
+
+	var ping <-chan time.Time
+	if wc.pingInterval > 0 {
+		ticker := time.NewTicker(wc.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		var err error
+		select {
+		case msg := <-wc.writeQueue:
+			err = wc.conn.WriteMessage(msg.messageType, msg.payload)
+		case <-ping:
+			err = wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wc.pongTimeout))
+		case <-wc.done:
+			return
+		}
+		if err != nil {
+			wc.mu.Lock()
+			wc.writeErr = err
+			wc.mu.Unlock()
+			return
+		}
+	}
+}
+
+// startKeepalive makes every frame from the server, pongs included, push
+// the read deadline back, so Receive fails once the server goes quiet
+func (wc *WebSocketConn) startKeepalive(interval, timeout time.Duration) {
+	wc.pingInterval = interval
+	wc.pongTimeout = timeout
+	wc.extendReadDeadline()
+
+	wc.conn.SetPongHandler(func(string) error {
+		wc.extendReadDeadline()
+		return nil
+	})
+	wc.conn.SetPingHandler(func(data string) error {
+		wc.extendReadDeadline()
+		err := wc.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(timeout))
+		var netErr net.Error
+		if err == websocket.ErrCloseSent || errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
+}
+
+func (wc *WebSocketConn) extendReadDeadline() {
+	wc.conn.SetReadDeadline(time.Now().Add(wc.pingInterval + wc.pongTimeout))
+}
+
+func (wc *WebSocketConn) stoppedErr() error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.keepaliveErr != nil {
+		return wc.keepaliveErr
+	}
+	if wc.writeErr != nil {
+		return fmt.Errorf("failed to write message: %w", wc.writeErr)
+	}
+	return ErrWebSocketClosed
+}
+
+// Buffered returns the number of messages waiting in the write buffer
+func (wc *WebSocketConn) Buffered() int {
+	return len(wc.writeQueue)
+}
+
+// Dropped returns the number of messages discarded by the write policy
+func (wc *WebSocketConn) Dropped() int64 {
+	return atomic.LoadInt64(&wc.dropped)
+}
+
+func (wc *WebSocketConn) Receive() ([]byte, error) {
+	msg, err := wc.ReceiveMessage()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read message: %w", err)
+		return nil, err
 	}
+	return msg.Data, nil
+}
+
+// ReceiveMessage returns the next message along with its type and the
+// time it arrived
+func (wc *WebSocketConn) ReceiveMessage() (Message, error) {
+	return wc.receive(context.Background())
+}
+
+// ReceiveTyped returns the type and payload of the next message. When the
+// server closes the connection it returns CloseMessage along with the
+// *CloseError carrying the close code and reason.
+func (wc *WebSocketConn) ReceiveTyped() (MessageType, []byte, error) {
+	return splitMessage(wc.ReceiveMessage())
+}
+
+// splitMessage returns the parts of a received message, reporting a close
+// frame as CloseMessage
+func splitMessage(msg Message, err error) (MessageType, []byte, error) {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return CloseMessage, nil, err
+	}
+	return msg.Type, msg.Data, err
+}
+
+// Listen delivers messages on a channel until ctx is done or the
+// connection ends. Both channels are closed then; a connection that
+// fails, rather than being closed with Close, sends its error first.
+// Messages go to either Listen or Receive, never both.
+func (wc *WebSocketConn) Listen(ctx context.Context) (<-chan Message, <-chan error) {
+	return listen(ctx, wc.receive)
+}
+
+// ReadChannel returns the channel the connection's single reader delivers
+// messages on. Every call returns the same channel, so any number of
+// goroutines can range over it, each message going to one of them. It is
+// closed when the connection ends; Receive then returns why.
+func (wc *WebSocketConn) ReadChannel() <-chan Message {
+	wc.readOnce.Do(func() { go wc.readLoop() })
+	return wc.incoming
+}
+
+func (wc *WebSocketConn) receive(ctx context.Context) (Message, error) {
+	wc.mu.Lock()
+	closed := wc.closed
+	wc.mu.Unlock()
+	if closed {
+		return Message{}, ErrWebSocketClosed
+	}
+
+	wc.readOnce.Do(func() { go wc.readLoop() })
+	select {
+	case msg, ok := <-wc.incoming:
+		if !ok {
+			return Message{}, wc.readError()
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// readLoop is the only reader on the connection. gorilla allows one
+// reader at a time, and control frames are only handled while reading.
+func (wc *WebSocketConn) readLoop() {
+	defer close(wc.readDone)
+	defer close(wc.incoming)
+
+	for {
+		messageType, data, err := wc.conn.ReadMessage()
+		if err != nil {
+			wc.setReadError(err)
+			return
+		}
+		if wc.pingInterval > 0 {
+			wc.extendReadDeadline()
+		}
+
+		msg := Message{Type: MessageType(messageType), Data: data, Received: time.Now()}
+		select {
+		case wc.incoming <- msg:
+		case <-wc.done:
+			// Closing; keep reading until the server's close frame
+		}
+	}
+}
+
+func (wc *WebSocketConn) setReadError(err error) {
+	var netErr net.Error
+	if wc.pingInterval > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+		err = fmt.Errorf("%w: nothing received for %s", ErrKeepaliveTimeout, wc.pingInterval+wc.pongTimeout)
+		wc.mu.Lock()
+		wc.keepaliveErr = err
+		wc.readErr = err
+		wc.mu.Unlock()
+		// A dead connection can't complete a close handshake
+		wc.abort()
+		return
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		err = &CloseError{Code: closeErr.Code, Reason: closeErr.Text}
+	} else {
+		err = fmt.Errorf("failed to read message: %w", err)
+	}
+	wc.mu.Lock()
+	wc.readErr = err
+	wc.mu.Unlock()
+}
+
+func (wc *WebSocketConn) readError() error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.keepaliveErr != nil {
+		return wc.keepaliveErr
+	}
+	if wc.closed {
+		return ErrWebSocketClosed
+	}
+	if wc.readErr != nil {
+		return wc.readErr
+	}
+	return ErrWebSocketClosed
+}
+
+// listen forwards the messages returned by receive until it fails or ctx
+// is done
+func listen(ctx context.Context, receive func(context.Context) (Message, error)) (<-chan Message, <-chan error) {
+	messages := make(chan Message)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(messages)
+
+		for {
+			msg, err := receive(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, ErrWebSocketClosed) {
+					errs <- err
+				}
+				return
+			}
+
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-	return data, nil
+	return messages, errs
 }
 
 func (wc *WebSocketConn) ReceiveJSON(v interface{}) error {
@@ -133,18 +616,57 @@ func (wc *WebSocketConn) ReceiveJSON(v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
+// Close closes the connection with code 1000, normal closure
 func (wc *WebSocketConn) Close() error {
-	wc.mu.Lock()
-	defer wc.mu.Unlock()
+	return wc.CloseWithStatus(CloseNormalClosure, "")
+}
 
+// CloseWithStatus sends a close frame with code and reason, waits briefly
+// for the server to answer with its own and closes the connection.
+// Messages arriving in the meantime are discarded.
+func (wc *WebSocketConn) CloseWithStatus(code int, reason string) error {
+	wc.mu.Lock()
 	if wc.closed {
+		wc.mu.Unlock()
 		return nil
 	}
-
 	wc.closed = true
+	close(wc.done)
+	wc.mu.Unlock()
+
+	deadline := time.Now().Add(closeTimeout)
+	err := wc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	if err == nil {
+		// The reply is only seen by reading
+		wc.readOnce.Do(func() { go wc.readLoop() })
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-wc.readDone:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
 	return wc.conn.Close()
 }
 
+// abort closes the connection without a close handshake
+func (wc *WebSocketConn) abort() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if wc.closed {
+		return
+	}
+	wc.closed = true
+	close(wc.done)
+	wc.conn.Close()
+}
+
+// Subprotocol returns the subprotocol the server picked, "" if none
+func (wc *WebSocketConn) Subprotocol() string {
+	return wc.conn.Subprotocol()
+}
+
 func (wc *WebSocketConn) SetReadDeadline(t time.Time) error {
 	return wc.conn.SetReadDeadline(t)
 }
@@ -166,11 +688,18 @@ func NewStreamingClient() *StreamingClient {
 	}
 }
 
-func (sc *StreamingClient) Stream(method, url string, body interface{}) (<-chan []byte, error) {
-	return sc.StreamContext(context.Background(), method, url, body)
+func (sc *StreamingClient) Stream(method, url string, body interface{}, opts ...StreamOption) (<-chan []byte, error) {
+	return sc.StreamContext(context.Background(), method, url, body, opts...)
+}
+
+// WithClient sends stream requests through client, which should not have
+// a timeout since the stream stays open
+func (sc *StreamingClient) WithClient(client *http.Client) *StreamingClient {
+	sc.client = client
+	return sc
 }
 
-func (sc *StreamingClient) StreamContext(ctx context.Context, method, url string, body interface{}) (<-chan []byte, error) {
+func (sc *StreamingClient) StreamContext(ctx context.Context, method, url string, body interface{}, opts ...StreamOption) (<-chan []byte, error) {
 	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -180,6 +709,21 @@ func (sc *StreamingClient) StreamContext(ctx context.Context, method, url string
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
 
+	return sc.StreamRequest(req, opts...)
+}
+
+// StreamRequest sends req and delivers the response body as it arrives,
+// in raw chunks or in records framed by a split option such as
+// SplitLines. Every value is a fresh slice the consumer may keep. The
+// channel is closed when the body ends or the request's context is done.
+//
+// A slow consumer applies backpressure: once StreamBufferSize values are
+// waiting, the body isn't read until one is received, so at most that many
+// values plus one read buffer are held in memory.
+func (sc *StreamingClient) StreamRequest(req *http.Request, opts ...StreamOption) (<-chan []byte, error) {
+	ctx := req.Context()
+	options := newStreamOptions(opts)
+
 	resp, err := sc.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
@@ -190,41 +734,99 @@ func (sc *StreamingClient) StreamContext(ctx context.Context, method, url string
 		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
 	}
 
-	ch := make(chan []byte, 100)
+	ch := make(chan []byte, options.bufferSize)
 
 	go func() {
-		defer resp.Body.Close()
 		defer close(ch)
+		defer func() {
+			for _, fn := range options.onEnd {
+				fn()
+			}
+		}()
+		defer resp.Body.Close()
 
-		buffer := make([]byte, 4096)
-		for {
+		send := func(data []byte) bool {
 			select {
+			case ch <- append([]byte(nil), data...):
+				return true
 			case <-ctx.Done():
-				return
-			default:
-				n, err := resp.Body.Read(buffer)
-				if err != nil {
-					return
-				}
-				if n > 0 {
-					data := make([]byte, n)
-					copy(data, buffer[:n])
-					select {
-					case ch <- data:
-					case <-ctx.Done():
-						return
-					}
-				}
+				return false
 			}
 		}
+
+		var err error
+		if options.split != nil {
+			err = scanRecords(resp.Body, options, send)
+		} else {
+			err = readChunks(resp.Body, send)
+		}
+		if err != nil && ctx.Err() == nil && options.onError != nil {
+			options.onError(err)
+		}
 	}()
 
 	return ch, nil
 }
 
+// chunkSize is the size of the buffers stream bodies are read into
+const chunkSize = 4096
+
+// chunkPool recycles the read buffers of streams, which send copies of
+// what they read
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, chunkSize)
+		return &buffer
+	},
+}
+
+// readChunks passes each read from r to send until r ends or send fails
+func readChunks(r io.Reader, send func([]byte) bool) error {
+	pooled := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(pooled)
+	buffer := *pooled
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 && !send(buffer[:n]) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanRecords passes each record framed by options.split to send until r
+// ends or send fails
+func scanRecords(r io.Reader, options *streamOptions, send func([]byte) bool) error {
+	// The scanner starts out in a pooled buffer and only allocates for
+	// records that outgrow it
+	pooled := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(pooled)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer((*pooled)[:0:min(chunkSize, options.maxSize)], options.maxSize)
+	scanner.Split(options.split)
+	for scanner.Scan() {
+		if !send(scanner.Bytes()) {
+			return nil
+		}
+	}
+
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("%w of %d bytes", ErrRecordTooLong, options.maxSize)
+	}
+	return err
+}
+
 // ServerSentEvents handles SSE connections
 type ServerSentEvents struct {
-	client *http.Client
+	client     *http.Client
+	headers    http.Header
+	retryDelay time.Duration
 }
 
 func NewServerSentEvents() *ServerSentEvents {
@@ -232,30 +834,38 @@ func NewServerSentEvents() *ServerSentEvents {
 		client: &http.Client{
 			Timeout: 0, // No timeout for SSE
 		},
+		headers:    make(http.Header),
+		retryDelay: 3 * time.Second,
 	}
 }
 
+// WithClient sends SSE requests through client, which should not have a
+// timeout since the stream stays open
+func (sse *ServerSentEvents) WithClient(client *http.Client) *ServerSentEvents {
+	sse.client = client
+	return sse
+}
+
+func (sse *ServerSentEvents) WithHeader(key, value string) *ServerSentEvents {
+	sse.headers.Set(key, value)
+	return sse
+}
+
+// WithRetryDelay sets how long Subscribe waits before reconnecting when the
+// server hasn't sent a retry field
+func (sse *ServerSentEvents) WithRetryDelay(delay time.Duration) *ServerSentEvents {
+	sse.retryDelay = delay
+	return sse
+}
+
 func (sse *ServerSentEvents) Connect(url string) (<-chan SSEEvent, error) {
 	return sse.ConnectContext(context.Background(), url)
 }
 
 func (sse *ServerSentEvents) ConnectContext(ctx context.Context, url string) (<-chan SSEEvent, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := sse.open(ctx, url, "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Cache-Control", "no-cache")
-
-	resp, err := sse.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-
-	if resp.StatusCode >= 400 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		return nil, err
 	}
 
 	ch := make(chan SSEEvent, 100)
@@ -273,9 +883,6 @@ func (sse *ServerSentEvents) ConnectContext(ctx context.Context, url string) (<-
 				return
 			default:
 				n, err := resp.Body.Read(buffer)
-				if err != nil {
-					return
-				}
 				if n > 0 {
 					events := parser.Parse(buffer[:n])
 					for _, event := range events {
@@ -286,6 +893,9 @@ func (sse *ServerSentEvents) ConnectContext(ctx context.Context, url string) (<-
 						}
 					}
 				}
+				if err != nil {
+					return
+				}
 			}
 		}
 	}()
@@ -293,37 +903,265 @@ func (sse *ServerSentEvents) ConnectContext(ctx context.Context, url string) (<-
 	return ch, nil
 }
 
+// Subscribe reads events from url and calls the handler registered for
+// each event type, as set by the "event" field. Events without a type go
+// to the "message" handler, or to the "" handler if there is none. When
+// the stream ends or the connection drops, Subscribe reconnects with the
+// Last-Event-ID header set. It returns when ctx is done, when the server
+// answers 204 No Content, or on an error status.
+func (sse *ServerSentEvents) Subscribe(ctx context.Context, url string, handlers map[string]func(data string)) error {
+	parser := NewSSEParser()
+
+	for {
+		err := sse.subscribeOnce(ctx, url, parser, handlers)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errStreamDone) {
+			return nil
+		}
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			return err
+		}
+
+		// A partially received event is discarded on reconnect
+		parser.reset()
+
+		delay := sse.retryDelay
+		if retry := parser.Retry(); retry > 0 {
+			delay = retry
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// errStreamDone means the server asked the client not to reconnect
+var errStreamDone = errors.New("event stream closed by server")
+
+// statusError is returned for error responses, which are not retried
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Status)
+}
+
+func (sse *ServerSentEvents) subscribeOnce(ctx context.Context, url string, parser *SSEParser, handlers map[string]func(data string)) error {
+	resp, err := sse.open(ctx, url, parser.LastID())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return errStreamDone
+	}
+
+	buffer := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			for _, event := range parser.Parse(buffer[:n]) {
+				dispatchEvent(handlers, event)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func dispatchEvent(handlers map[string]func(data string), event SSEEvent) {
+	if handler, ok := handlers[event.Type]; ok {
+		handler(event.Data)
+		return
+	}
+	if event.Type == "message" {
+		if handler, ok := handlers[""]; ok {
+			handler(event.Data)
+		}
+	}
+}
+
+// open starts an event stream request, resuming after lastID if set
+func (sse *ServerSentEvents) open(ctx context.Context, url, lastID string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, values := range sse.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := sse.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp, nil
+}
+
 type SSEEvent struct {
 	Type string
 	Data string
 	ID   string
 }
 
+// SSEParser turns a byte stream into events following the
+// text/event-stream format. Input may be split at any point, including
+// in the middle of a line.
 type SSEParser struct {
-	buffer []byte
+	buffer     []byte // incomplete trailing line carried across Parse calls
+	skipLF     bool   // the last chunk ended in CR, so a leading LF completes a CRLF
+	bomChecked bool
+	eventType  string
+	data       []string
+	lastID     string
+	retry      time.Duration
 }
 
+// utf8BOM may start a stream and is not part of the first line
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 func NewSSEParser() *SSEParser {
 	return &SSEParser{
 		buffer: make([]byte, 0),
 	}
 }
 
+// Parse consumes data and returns the events completed by it
 func (p *SSEParser) Parse(data []byte) []SSEEvent {
+	if p.skipLF && len(data) > 0 {
+		if data[0] == '\n' {
+			data = data[1:]
+		}
+		p.skipLF = false
+	}
 	p.buffer = append(p.buffer, data...)
-	
+
+	if !p.bomChecked {
+		if len(p.buffer) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, p.buffer) {
+			return nil
+		}
+		p.buffer = bytes.TrimPrefix(p.buffer, utf8BOM)
+		p.bomChecked = true
+	}
+
 	var events []SSEEvent
-	lines := string(p.buffer)
-	
-	// Simple SSE parsing (production would need more robust parsing)
-	if len(lines) > 0 {
-		event := SSEEvent{
-			Type: "message",
-			Data: lines,
+	rest := p.buffer
+	for {
+		i := bytes.IndexAny(rest, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		line := string(rest[:i])
+		next := i + 1
+		if rest[i] == '\r' {
+			// A CR ends the line right away; when it is the last byte
+			// the LF of a CRLF may still arrive with the next chunk
+			if next == len(rest) {
+				p.skipLF = true
+			} else if rest[next] == '\n' {
+				next++
+			}
+		}
+		rest = rest[next:]
+
+		if event, ok := p.processLine(line); ok {
+			events = append(events, event)
 		}
-		events = append(events, event)
-		p.buffer = p.buffer[:0] // Clear buffer
 	}
-	
+	p.buffer = append(p.buffer[:0], rest...)
+
 	return events
-}
\ No newline at end of file
+}
+
+// LastID returns the most recent event ID seen by the parser. It survives
+// across Parse calls so a reconnecting client can send it as Last-Event-ID.
+func (p *SSEParser) LastID() string {
+	return p.lastID
+}
+
+// Retry returns the reconnection delay requested by the server, or zero
+func (p *SSEParser) Retry() time.Duration {
+	return p.retry
+}
+
+func (p *SSEParser) processLine(line string) (SSEEvent, bool) {
+	// A blank line dispatches the event
+	if line == "" {
+		if p.data == nil {
+			p.eventType = ""
+			return SSEEvent{}, false
+		}
+		event := SSEEvent{
+			Type: p.eventType,
+			Data: strings.Join(p.data, "\n"),
+			ID:   p.lastID,
+		}
+		if event.Type == "" {
+			event.Type = "message"
+		}
+		p.eventType = ""
+		p.data = nil
+		return event, true
+	}
+
+	// Lines starting with a colon are comments
+	if strings.HasPrefix(line, ":") {
+		return SSEEvent{}, false
+	}
+
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+
+	switch field {
+	case "event":
+		p.eventType = value
+	case "data":
+		p.data = append(p.data, value)
+	case "id":
+		// Per the SSE spec, IDs containing NULL are ignored
+		if !strings.ContainsRune(value, 0) {
+			p.lastID = value
+		}
+	case "retry":
+		// Only plain ASCII digits are a valid delay
+		if value != "" && strings.Trim(value, "0123456789") == "" {
+			if ms, err := strconv.Atoi(value); err == nil {
+				p.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	return SSEEvent{}, false
+}
+
+// reset drops any partially received event, keeping the last ID and the
+// retry delay, so the parser can read a new stream after a reconnect
+func (p *SSEParser) reset() {
+	p.buffer = p.buffer[:0]
+	p.skipLF = false
+	p.bomChecked = false
+	p.eventType = ""
+	p.data = nil
+}
@@ -3,13 +3,19 @@ package test
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/loadbalancer"
 )
 
 func TestLoadBalancing(t *testing.T) {
@@ -43,6 +49,115 @@ func TestLoadBalancing(t *testing.T) {
 	}
 }
 
+func TestWeightedLoadBalancing(t *testing.T) {
+	heavy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("heavy"))
+	}))
+	defer heavy.Close()
+
+	light := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("light"))
+	}))
+	defer light.Close()
+
+	client := httpclient.New().
+		WithRateLimiter(100000).
+		WithWeightedLoadBalancer(map[string]int{heavy.URL: 3, light.URL: 1})
+
+	const requests = 2000
+	responses := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		data, err := client.GET("/")
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		responses[string(data)]++
+	}
+
+	share := float64(responses["heavy"]) / requests
+	if share < 0.70 || share > 0.80 {
+		t.Errorf("Expected about 75%% of requests on the heavy endpoint, got %.1f%% (%v)", share*100, responses)
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if _, err := client.GET("/"); err != nil {
+						t.Errorf("Request failed: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("InvalidWeight", func(t *testing.T) {
+		invalid := httpclient.New().WithWeightedLoadBalancer(map[string]int{heavy.URL: 1, light.URL: 0})
+		if _, err := invalid.GET("/"); !errors.Is(err, httpclient.ErrInvalidWeights) {
+			t.Errorf("Expected ErrInvalidWeights, got %v", err)
+		}
+	})
+}
+
+func TestLeastConnReleasesEndpoints(t *testing.T) {
+	const requests = 10
+	var arrived sync.WaitGroup
+	arrived.Add(requests)
+	unblock := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		arrived.Done()
+		<-unblock
+		w.Write([]byte("ok"))
+	})
+
+	server1 := httptest.NewServer(handler)
+	defer server1.Close()
+	server2 := httptest.NewServer(handler)
+	defer server2.Close()
+
+	client := httpclient.New().
+		WithRetries(0).
+		WithLoadBalancer([]string{server1.URL, server2.URL}, "least-conn")
+
+	var done sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			if _, err := client.GET("/"); err != nil {
+				t.Errorf("Request failed: %v", err)
+			}
+		}()
+	}
+
+	arrived.Wait()
+	connections := client.EndpointConnections()
+	if connections[server1.URL] != requests/2 || connections[server2.URL] != requests/2 {
+		t.Errorf("Expected the in-flight requests split evenly, got %v", connections)
+	}
+
+	close(unblock)
+	done.Wait()
+	if _, err := client.GET("/fail"); err == nil {
+		t.Error("Expected the failing request to fail")
+	}
+
+	for endpoint, count := range client.EndpointConnections() {
+		if count != 0 {
+			t.Errorf("Expected no connections to %s after completion, got %d", endpoint, count)
+		}
+	}
+}
+
 func TestCompression(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Accept-Encoding") == "" {
@@ -120,6 +235,53 @@ func TestResponseInterceptor(t *testing.T) {
 	}
 }
 
+func TestResponseInterceptorFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": "maintenance"}`))
+	}))
+	defer server.Close()
+
+	var afterStatus int
+	var errorBody string
+	client := httpclient.New().
+		WithRetries(0).
+		WithMiddleware(httpclient.MiddlewareFunc(nil, func(resp *http.Response) {
+			afterStatus = resp.StatusCode
+		})).
+		WithResponseInterceptor(func(resp *http.Response) error {
+			if resp.StatusCode != http.StatusServiceUnavailable {
+				return nil
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			errorBody = string(body)
+			resp.StatusCode = http.StatusOK
+			resp.Body = io.NopCloser(strings.NewReader(`{"items": [], "stale": true}`))
+			return nil
+		})
+
+	var result struct {
+		Items []string `json:"items"`
+		Stale bool     `json:"stale"`
+	}
+	if err := client.JSON("GET", server.URL, nil, &result); err != nil {
+		t.Fatalf("expected the interceptor to handle the 503, got %v", err)
+	}
+	if !result.Stale {
+		t.Errorf("expected the fallback value, got %+v", result)
+	}
+	if errorBody != `{"error": "maintenance"}` {
+		t.Errorf("interceptor read body %q", errorBody)
+	}
+	if afterStatus != http.StatusServiceUnavailable {
+		t.Errorf("middleware After saw status %d, want 503", afterStatus)
+	}
+}
+
 func TestBackupEndpoints(t *testing.T) {
 	// Primary server that fails
 	primaryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -291,4 +453,68 @@ func TestContextWithAdvancedFeatures(t *testing.T) {
 	if string(data) != "context response" {
 		t.Errorf("Unexpected context response: %s", data)
 	}
-}
\ No newline at end of file
+}
+func TestConsistentHashLoadBalancer(t *testing.T) {
+	endpoints := []string{"http://a", "http://b", "http://c", "http://d"}
+	lb := loadbalancer.New(endpoints, loadbalancer.ConsistentHash).(loadbalancer.KeyedLoadBalancer)
+
+	const keys = 2000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("/users/%d", i)
+		before[key] = lb.NextEndpointForKey(key)
+		if again := lb.NextEndpointForKey(key); again != before[key] {
+			t.Fatalf("Key %s mapped to %s, then %s", key, before[key], again)
+		}
+	}
+
+	lb.RemoveEndpoint("http://c")
+	moved := 0
+	for key, endpoint := range before {
+		after := lb.NextEndpointForKey(key)
+		if after == "http://c" {
+			t.Fatalf("Key %s still maps to the removed endpoint", key)
+		}
+		if endpoint != "http://c" && after != endpoint {
+			t.Errorf("Key %s moved from %s to %s though its endpoint remains", key, endpoint, after)
+		}
+		if after != endpoint {
+			moved++
+		}
+	}
+	// Only the removed endpoint's share, about a quarter, should move
+	if share := float64(moved) / keys; share < 0.10 || share > 0.40 {
+		t.Errorf("Expected about 25%% of keys to move, got %.1f%%", share*100)
+	}
+
+	t.Run("Client", func(t *testing.T) {
+		var urls []string
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("server%d", i)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(name))
+			}))
+			defer server.Close()
+			urls = append(urls, server.URL)
+		}
+
+		client := httpclient.New().
+			WithLoadBalancer(urls, "consistent-hash").
+			WithLoadBalancerKey(func(req *http.Request) string {
+				// Route by user, ignoring the rest of the path
+				return strings.Join(strings.SplitN(req.URL.Path, "/", 4)[:3], "/")
+			})
+
+		hits := make(map[string]bool)
+		for i := 0; i < 5; i++ {
+			data, err := client.GET(fmt.Sprintf("/users/42/item%d", i))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			hits[string(data)] = true
+		}
+		if len(hits) != 1 {
+			t.Errorf("Expected every request for one user to reach the same server, got %v", hits)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/ai"
+)
+
+// deadlineRecorder records how long each attempt had left before its
+// deadline when it was sent
+type deadlineRecorder struct {
+	remaining *[]time.Duration
+}
+
+func (d deadlineRecorder) Before(req *http.Request) error {
+	var left time.Duration
+	if deadline, ok := req.Context().Deadline(); ok {
+		left = time.Until(deadline)
+	}
+	*d.remaining = append(*d.remaining, left)
+	return nil
+}
+
+func (d deadlineRecorder) After(resp *http.Response) {}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var remaining []time.Duration
+	client := httpclient.New().
+		WithAttemptTimeout(time.Minute).
+		WithAdaptiveTimeout(true).
+		WithMiddleware(deadlineRecorder{&remaining})
+
+	for i := 0; i < 6; i++ {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	// Until the endpoint has answered five times the attempt timeout
+	// applies, then the fast responses bring it down to the 5s floor
+	for i, left := range remaining[:5] {
+		if left < 50*time.Second {
+			t.Errorf("Expected request %d to get the 1m attempt timeout, got %v", i, left)
+		}
+	}
+	if left := remaining[5]; left <= 0 || left > 5*time.Second {
+		t.Errorf("Expected the adaptive timeout to shrink to 5s, got %v", left)
+	}
+
+	// Without it the attempt timeout stays
+	remaining = nil
+	client = client.WithAdaptiveTimeout(false)
+	for i := 0; i < 6; i++ {
+		client.GET(server.URL)
+	}
+	if left := remaining[5]; left < 50*time.Second {
+		t.Errorf("Expected the attempt timeout without adaptive timeouts, got %v", left)
+	}
+}
+
+func TestAIRetry(t *testing.T) {
+	var flaky int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" {
+			atomic.AddInt32(&flaky, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	attempts := func(client httpclient.Client) int32 {
+		atomic.StoreInt32(&flaky, 0)
+		if _, err := client.GET(server.URL + "/flaky"); err == nil {
+			t.Fatal("Expected the request to fail")
+		}
+		return atomic.LoadInt32(&flaky)
+	}
+	client := httpclient.New().
+		WithClock(instantClock{}).
+		WithRateLimiter(0).
+		WithRetries(5)
+
+	if got := attempts(client); got != 6 {
+		t.Fatalf("Expected 6 attempts without AI retry, got %d", got)
+	}
+
+	// A host that only failed so far isn't retried for long
+	fresh := attempts(client.WithAIRetry(true))
+	if fresh >= 6 {
+		t.Errorf("Expected AI retry to give up on a failing host early, got %d attempts", fresh)
+	}
+
+	// After a run of successes the host's failures look transient, and
+	// are retried longer
+	trusted := client.WithAIRetry(true)
+	for i := 0; i < 20; i++ {
+		if _, err := trusted.GET(server.URL + "/ok"); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if got := attempts(trusted); got <= fresh {
+		t.Errorf("Expected more than %d attempts after a history of successes, got %d", fresh, got)
+	}
+}
+
+func TestSmartCaching(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/large" {
+			w.Write(bytes.Repeat([]byte("x"), 2<<20))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	requests := func(client httpclient.Client, path string) int32 {
+		atomic.StoreInt32(&hits, 0)
+		for i := 0; i < 2; i++ {
+			if _, err := client.GET(server.URL + path); err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+		}
+		return atomic.LoadInt32(&hits)
+	}
+	client := httpclient.New().WithCache(time.Minute).WithSmartCaching(false)
+
+	if got := requests(client, "/large"); got != 1 {
+		t.Errorf("Expected the large response to be cached without smart caching, got %d requests", got)
+	}
+	smart := client.WithSmartCaching(true)
+	if got := requests(smart, "/large"); got != 2 {
+		t.Errorf("Expected smart caching to keep a large response of a new URL out, got %d requests", got)
+	}
+	if got := requests(smart, "/small"); got != 1 {
+		t.Errorf("Expected smart caching to store a small response, got %d requests", got)
+	}
+
+	// Only the enabled features keep a history of the requests
+	const large = 2 << 20
+	req := httptest.NewRequest("GET", "http://api.test/report", nil)
+	for _, features := range []ai.Features{{AdaptiveTimeout: true}, {Caching: true}} {
+		manager := ai.NewAIManager()
+		manager.SetFeatures(features)
+		for i := 0; i < 50; i++ {
+			manager.RecordRequest(req, "api.test/report", &http.Response{StatusCode: http.StatusOK}, time.Millisecond, true)
+		}
+		if got := manager.ShouldCache(req.URL.String(), large); got != features.Caching {
+			t.Errorf("Expected ShouldCache %v for a frequent URL with %+v, got %v", features.Caching, features, got)
+		}
+	}
+
+	t.Run("Bounded", func(t *testing.T) {
+		cache := ai.NewSmartCache()
+		for i := 0; i < 10000; i++ {
+			cache.RecordAccess(fmt.Sprintf("http://api.test/page-%d", i))
+		}
+		if got := cache.Len(); got != ai.MaxAccessPatterns {
+			t.Errorf("Expected the access history of %d URLs, got %d", ai.MaxAccessPatterns, got)
+		}
+	})
+}
+
+// okTransport answers every request with an empty 200 without a network
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestAdaptiveTimeoutEndpoints(t *testing.T) {
+	// Each subtest has a client of its own, with statistics of its own
+	base := httpclient.New().
+		WithCustomTransport(okTransport{}).
+		WithRateLimiter(0).
+		WithAttemptTimeout(time.Minute)
+
+	t.Run("NormalizedPath", func(t *testing.T) {
+		client := base.WithAdaptiveTimeout(true)
+		for i := 1; i <= 5; i++ {
+			client.GET(fmt.Sprintf("http://api.test/users/%d/posts", i))
+		}
+		client.GET("http://api.test/users/9b2f0c4e-5d7a-4e1b-8c3d-2f6a7b8c9d0e/posts")
+
+		timeouts := client.AdaptiveTimeouts()
+		if len(timeouts) != 1 || timeouts["api.test/users/{id}/posts"] != 5*time.Second {
+			t.Errorf("Expected one endpoint for every user with a 5s timeout, got %v", timeouts)
+		}
+	})
+
+	t.Run("RouteTemplate", func(t *testing.T) {
+		client := base.WithAdaptiveTimeout(true)
+		for _, id := range []string{"a1", "b2", "c3"} {
+			params := httpclient.WithPathParams(map[string]string{"orderID": id})
+			client.GetContext(context.Background(), "http://api.test/orders/{orderID}", params)
+		}
+
+		timeouts := client.AdaptiveTimeouts()
+		if timeout, ok := timeouts["api.test/orders/{orderID}"]; len(timeouts) != 1 || !ok || timeout != time.Minute {
+			t.Errorf("Expected one endpoint for the template with the attempt timeout, got %v", timeouts)
+		}
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		client := base.WithAdaptiveTimeout(true)
+		for i := 0; i < 10000; i++ {
+			if _, err := client.GET(fmt.Sprintf("http://api.test/page-%d", i)); err != nil {
+				t.Fatalf("Request %d failed: %v", i, err)
+			}
+		}
+
+		timeouts := client.AdaptiveTimeouts()
+		if len(timeouts) != httpclient.MaxAdaptiveEndpoints {
+			t.Errorf("Expected %d endpoints, got %d", httpclient.MaxAdaptiveEndpoints, len(timeouts))
+		}
+		if _, ok := timeouts["api.test/page-9999"]; !ok {
+			t.Error("Expected the latest endpoint to be kept")
+		}
+		if _, ok := timeouts["api.test/page-0"]; ok {
+			t.Error("Expected the oldest endpoint to be dropped")
+		}
+	})
+
+	if timeouts := base.AdaptiveTimeouts(); len(timeouts) != 0 {
+		t.Errorf("Expected no endpoints without adaptive timeouts, got %v", timeouts)
+	}
+}
@@ -0,0 +1,730 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/batch"
+)
+
+func TestBatchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	responses, err := batch.NewBatchRequest(http.DefaultClient).
+		Add("GET", server.URL+"/ok", nil).
+		Add("GET", server.URL+"/fail", nil).
+		Add("GET", server.URL+"/ok", nil).
+		Add("GET", "http://invalid host", nil).
+		Execute()
+
+	var batchErr *batch.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected BatchError, got %v", err)
+	}
+	if batchErr.Failed != 2 || batchErr.Total != 4 {
+		t.Errorf("Expected 2 of 4 failed, got %d of %d", batchErr.Failed, batchErr.Total)
+	}
+	if len(batchErr.Unwrap()) != 2 {
+		t.Errorf("Expected 2 wrapped errors, got %d", len(batchErr.Unwrap()))
+	}
+	if !strings.Contains(err.Error(), "request 1") {
+		t.Errorf("Expected the aggregate to name the failed request, got %v", err)
+	}
+
+	// A BatchError without wrapped errors still describes itself
+	if got := (&batch.BatchError{Failed: 1, Total: 2}).Error(); got != "1 of 2 batch requests failed" {
+		t.Errorf("Expected a message without a first error, got %q", got)
+	}
+
+	if len(responses) != 4 {
+		t.Fatalf("Expected 4 responses, got %d", len(responses))
+	}
+	for i, failed := range []bool{false, true, false, true} {
+		if (responses[i].Error != nil) != failed {
+			t.Errorf("Response %d: expected failed=%v, got error %v", i, failed, responses[i].Error)
+		}
+		if !failed && string(responses[i].Data) != "ok" {
+			t.Errorf("Response %d: expected data ok, got %q", i, responses[i].Data)
+		}
+	}
+	if responses.AllSucceeded() {
+		t.Error("Expected AllSucceeded to be false")
+	}
+
+	responses, err = batch.NewBatchRequest(http.DefaultClient).
+		Add("GET", server.URL+"/ok", nil).
+		Add("GET", server.URL+"/ok", nil).
+		Execute()
+	if err != nil || !responses.AllSucceeded() {
+		t.Errorf("Expected all requests to succeed, got %v", err)
+	}
+}
+
+func TestBatchUsesClientPipeline(t *testing.T) {
+	var flakyAttempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/flaky" && atomic.AddInt32(&flakyAttempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithBaseURL(server.URL).
+		WithAuth("token").
+		WithRetries(1)
+
+	responses, err := client.Batch().
+		Add("GET", "/stable", nil).
+		Add("GET", "/flaky", nil).
+		Execute()
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if string(responses[0].Data) != "ok /stable" || string(responses[1].Data) != "ok /flaky" {
+		t.Errorf("Unexpected responses: %q, %q", responses[0].Data, responses[1].Data)
+	}
+	if got := atomic.LoadInt32(&flakyAttempts); got != 2 {
+		t.Errorf("Expected the flaky item to be retried once, got %d attempts", got)
+	}
+
+	atomic.StoreInt32(&flakyAttempts, 0)
+	pipeline, err := client.Pipeline().
+		Add("GET", "/flaky", nil).
+		Add("GET", "/stable", nil).
+		Execute()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	var results []string
+	for resp := range pipeline {
+		if resp.Error != nil {
+			t.Errorf("Pipeline step %d failed: %v", resp.Index, resp.Error)
+		}
+		results = append(results, string(resp.Data))
+	}
+	if strings.Join(results, ",") != "ok /flaky,ok /stable" {
+		t.Errorf("Unexpected pipeline results: %q", results)
+	}
+}
+
+func TestBatchItemRetryPolicy(t *testing.T) {
+	var downAttempts, missingAttempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Intercepted") != "yes":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/api/down":
+			atomic.AddInt32(&downAttempts, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/api/missing":
+			atomic.AddInt32(&missingAttempts, 1)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("ok " + r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithBaseURL(server.URL + "/api").
+		WithAuth("token").
+		WithClock(instantClock{}).
+		WithRetries(2).
+		WithRequestInterceptor(func(r *http.Request) error {
+			r.Header.Set("X-Intercepted", "yes")
+			return nil
+		})
+
+	responses, err := client.Batch().
+		Add("GET", "/users", nil).
+		Add("GET", "down", nil).
+		Add("GET", "missing", nil).
+		Execute()
+
+	var batchErr *httpclient.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 2 {
+		t.Fatalf("Expected 2 failed items, got %v", err)
+	}
+	if string(responses[0].Data) != "ok /api/users" {
+		t.Errorf("Expected the item path joined to the base URL path, got %q", responses[0].Data)
+	}
+
+	// Server errors are retried up to the client's limit, client errors not
+	if !errors.Is(responses[1].Error, httpclient.ErrMaxRetries) {
+		t.Errorf("Expected ErrMaxRetries for the failing item, got %v", responses[1].Error)
+	}
+	if got := atomic.LoadInt32(&downAttempts); got != 3 {
+		t.Errorf("Expected 3 attempts for the failing item, got %d", got)
+	}
+	var apiErr *httpclient.APIError
+	if !errors.As(responses[2].Error, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 APIError, got %v", responses[2].Error)
+	}
+	if got := atomic.LoadInt32(&missingAttempts); got != 1 {
+		t.Errorf("Expected the 404 not to be retried, got %d attempts", got)
+	}
+}
+
+func TestPipelineDependent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/users":
+			w.Write([]byte(`{"id": 7}`))
+		case r.URL.Path == "/users/7":
+			w.Write([]byte(`{"id": 7, "name": "John"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	idFrom := func(prev []byte) int {
+		var user TestUser
+		json.Unmarshal(prev, &user)
+		return user.ID
+	}
+
+	pipeline, err := httpclient.New().
+		WithBaseURL(server.URL).
+		WithRetries(0).
+		Pipeline().
+		Add("POST", "/users", TestUser{Name: "John"}).
+		AddDependent(func(prev []byte) (string, string, interface{}) {
+			return "GET", fmt.Sprintf("/users/%d", idFrom(prev)), nil
+		}).
+		Add("GET", "/missing", nil).
+		AddDependent(func(prev []byte) (string, string, interface{}) {
+			t.Error("Step after a failed step must not be built")
+			return "GET", "/", nil
+		}).
+		Execute()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	var responses []batch.PipelineResponse
+	for resp := range pipeline {
+		responses = append(responses, resp)
+	}
+	if len(responses) != 4 {
+		t.Fatalf("Expected 4 responses, got %d", len(responses))
+	}
+
+	var user TestUser
+	if err := json.Unmarshal(responses[1].Data, &user); err != nil || user.Name != "John" {
+		t.Errorf("Expected step 2 to fetch the created user, got %q, %v", responses[1].Data, responses[1].Error)
+	}
+	if responses[2].Error == nil {
+		t.Error("Expected step 3 to fail")
+	}
+	if !errors.Is(responses[3].Error, batch.ErrStepSkipped) {
+		t.Errorf("Expected step 4 to be skipped, got %v", responses[3].Error)
+	}
+}
+
+func TestPipelineAddFunc(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/users":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 42}`))
+		case r.URL.Path == "/users/42":
+			w.Write([]byte(`{"id": 42, "name": "John"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithRetries(0)
+	fetchCreated := func(ctx context.Context, prev []httpclient.PipelineResponse) (string, string, interface{}, error) {
+		var created TestUser
+		if err := json.Unmarshal(prev[0].Data, &created); err != nil {
+			return "", "", nil, err
+		}
+		return "GET", fmt.Sprintf("/users/%d", created.ID), nil, nil
+	}
+
+	pipeline, err := client.Pipeline().
+		Add("POST", "/users", TestUser{Name: "John"}).
+		AddFunc(fetchCreated).
+		Execute()
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	var responses []httpclient.PipelineResponse
+	for resp := range pipeline {
+		responses = append(responses, resp)
+	}
+
+	var user TestUser
+	if len(responses) != 2 || json.Unmarshal(responses[1].Data, &user) != nil || user.Name != "John" {
+		t.Fatalf("Expected step 2 to fetch the created user, got %+v", responses)
+	}
+	if strings.Join(paths, ",") != "POST /users,GET /users/42" {
+		t.Errorf("Expected the second URL built from the first response, got %v", paths)
+	}
+
+	t.Run("ContinueOnError", func(t *testing.T) {
+		var seen int
+		pipeline, _ := client.Pipeline().
+			Add("GET", "/missing", nil).
+			AddFunc(func(ctx context.Context, prev []httpclient.PipelineResponse) (string, string, interface{}, error) {
+				seen = len(prev)
+				if prev[0].StatusCode != http.StatusNotFound {
+					t.Errorf("Expected the failed step's status, got %d", prev[0].StatusCode)
+				}
+				return "GET", "/users/42", nil, nil
+			}).
+			Execute()
+		var count int
+		for resp := range pipeline {
+			count++
+			if resp.Index == 1 && resp.Error != nil {
+				t.Errorf("Expected the step after a failure to run, got %v", resp.Error)
+			}
+		}
+		if count != 2 || seen != 1 {
+			t.Errorf("Expected 2 responses with the step seeing 1 earlier result, got %d and %d", count, seen)
+		}
+	})
+
+	t.Run("StopOnError", func(t *testing.T) {
+		pipeline, _ := client.Pipeline().
+			StopOnError().
+			Add("GET", "/missing", nil).
+			AddFunc(func(ctx context.Context, prev []httpclient.PipelineResponse) (string, string, interface{}, error) {
+				t.Error("Step after a failed step must not be built")
+				return "GET", "/", nil, nil
+			}).
+			Execute()
+		var responses []httpclient.PipelineResponse
+		for resp := range pipeline {
+			responses = append(responses, resp)
+		}
+		if len(responses) != 1 || responses[0].Error == nil {
+			t.Errorf("Expected the pipeline to end with the failed step, got %+v", responses)
+		}
+	})
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var slowAttempts, lastCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			// Cancel while this step is in flight and hold it until the
+			// client gives up
+			atomic.AddInt32(&slowAttempts, 1)
+			cancel()
+			<-r.Context().Done()
+		case "/last":
+			atomic.AddInt32(&lastCalls, 1)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	pipeline, err := httpclient.New().
+		WithBaseURL(server.URL).
+		WithRetries(3).
+		Pipeline().
+		Add("GET", "/first", nil).
+		Add("GET", "/slow", nil).
+		Add("GET", "/last", nil).
+		ExecuteContext(ctx)
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range pipeline {
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pipeline didn't stop after cancellation")
+	}
+
+	if got := atomic.LoadInt32(&slowAttempts); got != 1 {
+		t.Errorf("Expected the cancelled step not to be retried, got %d attempts", got)
+	}
+	if got := atomic.LoadInt32(&lastCalls); got != 0 {
+		t.Errorf("Expected the remaining step not to run, got %d calls", got)
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	var inFlight, peak, calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithRetries(0).WithRateLimiter(1000)
+
+	b := client.Batch().WithConcurrency(3)
+	for i := 0; i < 12; i++ {
+		b.Add("GET", fmt.Sprintf("/item/%d", i), nil)
+	}
+	responses, err := b.Execute()
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if len(responses) != 12 || !responses.AllSucceeded() {
+		t.Fatalf("Expected 12 successful responses, got %d", len(responses))
+	}
+	if got := atomic.LoadInt32(&peak); got != 3 {
+		t.Errorf("Expected at most 3 requests in flight and the limit reached, peak was %d", got)
+	}
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		b := client.Batch().WithConcurrency(2)
+		for i := 0; i < 20; i++ {
+			b.Add("GET", fmt.Sprintf("/item/%d", i), nil)
+		}
+		responses, err := b.ExecuteContext(ctx)
+		if err == nil {
+			t.Fatal("Expected an error for the canceled batch")
+		}
+		if got := atomic.LoadInt32(&calls); got > 6 {
+			t.Errorf("Expected no new requests after cancellation, server saw %d", got)
+		}
+		if !errors.Is(responses[19].Error, batch.ErrNotStarted) || !errors.Is(responses[19].Error, context.DeadlineExceeded) {
+			t.Errorf("Expected the last request not to start, got %v", responses[19].Error)
+		}
+	})
+}
+
+func TestBatchFailFast(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/slow":
+			// Held until the failure cancels it
+			<-r.Context().Done()
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithRetries(0)
+
+	b := client.Batch().WithConcurrency(2).FailFast().
+		Add("GET", "/ok", nil).
+		Add("GET", "/slow", nil).
+		Add("GET", "/bad", nil)
+	for i := 0; i < 10; i++ {
+		b.Add("GET", "/ok", nil)
+	}
+
+	done := make(chan struct{})
+	var responses batch.BatchResponses
+	var err error
+	go func() {
+		defer close(done)
+		responses, err = b.Execute()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch didn't stop after the failure")
+	}
+
+	var batchErr *httpclient.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected a BatchError, got %v", err)
+	}
+	if string(responses[0].Data) != "ok" || responses[0].Error != nil {
+		t.Errorf("Expected the first result to be kept, got %+v", responses[0])
+	}
+	var apiErr *httpclient.APIError
+	if !errors.As(responses[2].Error, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the failure itself, got %v", responses[2].Error)
+	}
+	if !errors.Is(responses[1].Error, context.Canceled) {
+		t.Errorf("Expected the request in flight to be canceled, got %v", responses[1].Error)
+	}
+	if !errors.Is(responses[12].Error, batch.ErrNotStarted) {
+		t.Errorf("Expected the remaining requests not to start, got %v", responses[12].Error)
+	}
+	if got := atomic.LoadInt32(&calls); got > 4 {
+		t.Errorf("Expected the batch to stop scheduling after the failure, server saw %d requests", got)
+	}
+}
+
+func TestBatchItemOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"accept":  r.Header.Get("Accept"),
+			"version": r.Header.Get("X-Version"),
+		})
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithRetries(0).WithHeader("X-Version", "1")
+
+	responses, err := client.Batch().
+		Add("GET", "/csv", nil, httpclient.ItemHeader("Accept", "text/csv"), httpclient.ItemHeader("X-Version", "2")).
+		Add("GET", "/plain", nil).
+		Add("GET", "/slow", nil, httpclient.ItemTimeout(50*time.Millisecond)).
+		Add("GET", "/missing", nil, httpclient.ItemHeader("Accept", "application/xml")).
+		Execute()
+
+	var batchErr *httpclient.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 2 {
+		t.Fatalf("Expected the slow and missing items to fail, got %v", err)
+	}
+
+	type echo struct {
+		Accept  string `json:"accept"`
+		Version string `json:"version"`
+	}
+	var csv, plain echo
+	if err := responses.DecodeJSON(0, &csv); err != nil {
+		t.Fatal(err)
+	}
+	if csv.Accept != "text/csv" || csv.Version != "2" {
+		t.Errorf("Expected the item headers, got %+v", csv)
+	}
+	if err := responses.DecodeJSON(1, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain.Accept == "text/csv" || plain.Version != "1" {
+		t.Errorf("Expected another item's headers not to leak, got %+v", plain)
+	}
+
+	if !errors.Is(responses[2].Error, context.DeadlineExceeded) || responses[2].Duration > 500*time.Millisecond {
+		t.Errorf("Expected the item timeout to apply, got %v after %v", responses[2].Error, responses[2].Duration)
+	}
+	if responses[0].StatusCode != http.StatusOK || responses[2].StatusCode != 0 || responses[3].StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status codes %d, %d, %d", responses[0].StatusCode, responses[2].StatusCode, responses[3].StatusCode)
+	}
+	var apiErr *httpclient.APIError
+	if err := responses.DecodeJSON(3, &plain); !errors.As(err, &apiErr) {
+		t.Errorf("Expected DecodeJSON to return the item's error, got %v", err)
+	}
+	if err := responses.DecodeJSON(4, &plain); err == nil {
+		t.Error("Expected an error for an index out of range")
+	}
+}
+
+func TestBatchExecuteStream(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		w.Write([]byte("ok " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var progress []int
+	results, err := httpclient.New().WithBaseURL(server.URL).WithRetries(0).
+		Batch().
+		OnProgress(func(done, total int) {
+			mu.Lock()
+			defer mu.Unlock()
+			if total != 3 {
+				t.Errorf("Expected a total of 3, got %d", total)
+			}
+			progress = append(progress, done)
+		}).
+		Add("GET", "/slow", nil).
+		Add("GET", "/fast", nil).
+		Add("GET", "/fast2", nil).
+		ExecuteStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fast items arrive while the slow one is still held by the server
+	for i := 0; i < 2; i++ {
+		select {
+		case resp := <-results:
+			if resp.Index == 0 {
+				t.Fatal("Slow item arrived before it was released")
+			}
+			if resp.Error != nil || string(resp.Data) != "ok "+[]string{"", "/fast", "/fast2"}[resp.Index] {
+				t.Errorf("Unexpected response %+v", resp)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Fast item didn't arrive before the slow one completed")
+		}
+	}
+	close(release)
+
+	last, ok := <-results
+	if !ok || last.Index != 0 || string(last.Data) != "ok /slow" {
+		t.Errorf("Expected the slow item last, got %+v", last)
+	}
+	if _, ok := <-results; ok {
+		t.Error("Expected the channel to be closed after every item")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(progress) != "[1 2 3]" {
+		t.Errorf("Expected progress 1, 2, 3, got %v", progress)
+	}
+}
+
+func TestBatchRetryFailed(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path]++
+		count := seen[r.URL.Path]
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/down", strings.HasPrefix(r.URL.Path, "/flaky") && count == 1:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte("ok " + r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithRetries(0)
+	paths := []string{"/users", "/flaky/1", "/missing", "/flaky/2", "/orders"}
+	br := client.Batch()
+	for _, path := range paths {
+		br.Add("GET", path, nil)
+	}
+
+	if _, err := br.Execute(); err == nil {
+		t.Fatal("Expected the first execution to fail")
+	}
+	var failed []string
+	for _, item := range br.Failed() {
+		failed = append(failed, item.URL)
+	}
+	// The 404 isn't worth retrying
+	if strings.Join(failed, ",") != "/flaky/1,/flaky/2" {
+		t.Fatalf("Unexpected failed items: %q", failed)
+	}
+
+	mu.Lock()
+	requested = nil
+	mu.Unlock()
+	responses, err := br.RetryFailed(context.Background())
+
+	var batchErr *httpclient.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 1 {
+		t.Fatalf("Expected only the 404 to remain failed, got %v", err)
+	}
+	mu.Lock()
+	retried := append([]string(nil), requested...)
+	mu.Unlock()
+	if len(retried) != 2 || seen["/users"] != 1 || seen["/orders"] != 1 {
+		t.Errorf("Expected only the flaky paths re-requested, got %q", retried)
+	}
+	for i, path := range paths {
+		if path == "/missing" {
+			if responses[i].StatusCode != http.StatusNotFound {
+				t.Errorf("Expected the 404 response kept, got %d", responses[i].StatusCode)
+			}
+			continue
+		}
+		if string(responses[i].Data) != "ok "+path {
+			t.Errorf("Response %d: expected %q, got %q (%v)", i, "ok "+path, responses[i].Data, responses[i].Error)
+		}
+	}
+	if len(br.Failed()) != 0 {
+		t.Errorf("Expected nothing left to retry, got %d items", len(br.Failed()))
+	}
+
+	t.Run("MaxRounds", func(t *testing.T) {
+		br := client.Batch().Add("GET", "/down", nil)
+		br.Execute()
+		if _, err := br.RetryFailed(context.Background(), httpclient.MaxRounds(3)); err == nil {
+			t.Fatal("Expected the item to keep failing")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen["/down"] != 4 {
+			t.Errorf("Expected 1 request and 3 retry rounds, got %d requests", seen["/down"])
+		}
+	})
+
+	t.Run("NotExecuted", func(t *testing.T) {
+		_, err := client.Batch().Add("GET", "/users", nil).RetryFailed(context.Background())
+		if !errors.Is(err, batch.ErrNotExecuted) {
+			t.Errorf("Expected ErrNotExecuted, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,1074 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yourorg/httpclient"
+)
+
+// fakeClock is a manually advanced httpclient.Clock
+type fakeClock struct {
+	now     time.Time
+	waiters []fakeWaiter
+	mu      sync.Mutex
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing any After channels that expire
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.deadline.After(c.now) {
+			w.ch <- c.now
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+}
+
+func TestCacheExpiryWithFakeClock(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte("fresh"))
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	client := httpclient.New().
+		WithClock(clock).
+		WithCache(time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("Expected second request to be served from cache, got %d hits", hits)
+	}
+
+	clock.Advance(59 * time.Second)
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Request before expiry failed: %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected entry to still be fresh, got %d hits", hits)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Request after expiry failed: %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("Expected expired entry to be refetched, got %d hits", hits)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/ok" {
+			w.Write([]byte("ok"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithNegativeCache(time.Minute).
+		WithRetries(0)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GET(server.URL + "/users/42")
+
+		var apiErr *httpclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("Request %d: expected 404 APIError, got %v", i, err)
+		}
+		if string(apiErr.Body) != "missing" {
+			t.Errorf("Request %d: unexpected body %q", i, apiErr.Body)
+		}
+	}
+
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected 1 server hit, got %d", hits)
+	}
+
+	// Success responses aren't cached by the negative cache alone
+	for i := 0; i < 2; i++ {
+		if _, err := client.GET(server.URL + "/ok"); err != nil {
+			t.Fatalf("Request to /ok failed: %v", err)
+		}
+	}
+	if atomic.LoadInt32(&hits) != 3 {
+		t.Errorf("Expected successful responses to bypass the cache, got %d hits", hits)
+	}
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	client := httpclient.New().WithRequestCoalescing(true)
+
+	// fetch sends n concurrent GETs to a server that holds its responses
+	// until the callers have had time to join the first request. It returns
+	// the number of requests the server got and the bodies.
+	fetch := func(n int, get func(url string) ([]byte, error)) (int32, []string) {
+		var hits int32
+		arrived := make(chan struct{}, n)
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			arrived <- struct{}{}
+			<-release
+			w.Write([]byte("popular"))
+		}))
+		defer server.Close()
+
+		bodies := make([]string, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				data, err := get(server.URL)
+				if err != nil {
+					t.Errorf("GET %d failed: %v", i, err)
+				}
+				bodies[i] = string(data)
+			}(i)
+		}
+		<-arrived
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return atomic.LoadInt32(&hits), bodies
+	}
+
+	hits, bodies := fetch(50, func(url string) ([]byte, error) { return client.GET(url) })
+	if hits != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", hits)
+	}
+	for i, body := range bodies {
+		if body != "popular" {
+			t.Errorf("Caller %d got %q", i, body)
+		}
+	}
+
+	t.Run("Bypass", func(t *testing.T) {
+		ctx := httpclient.WithoutCoalescing(context.Background())
+		hits, _ := fetch(2, func(url string) ([]byte, error) { return client.GetContext(ctx, url) })
+		if hits != 2 {
+			t.Errorf("Expected 2 upstream requests, got %d", hits)
+		}
+	})
+
+	t.Run("DifferentHeaders", func(t *testing.T) {
+		var n int32
+		hits, _ := fetch(2, func(url string) ([]byte, error) {
+			accept := "application/json"
+			if atomic.AddInt32(&n, 1) == 2 {
+				accept = "text/plain"
+			}
+			responses, err := client.Batch().Add("GET", url, nil, httpclient.ItemHeader("Accept", accept)).Execute()
+			return responses[0].Data, err
+		})
+		if hits != 2 {
+			t.Errorf("Expected 2 upstream requests, got %d", hits)
+		}
+	})
+}
+
+func TestCacheConditionalRequests(t *testing.T) {
+	var hits, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte("secret"))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("data v1"))
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	client := httpclient.New().WithClock(clock).WithCache(time.Minute)
+
+	get := func(path string) string {
+		t.Helper()
+		data, err := client.GET(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		return string(data)
+	}
+
+	get("/data")
+	clock.Advance(2 * time.Minute)
+
+	// The stale entry is revalidated and its body served on 304
+	if body := get("/data"); body != "data v1" {
+		t.Errorf("Expected the cached body on 304, got %q", body)
+	}
+	if atomic.LoadInt32(&hits) != 2 || atomic.LoadInt32(&notModified) != 1 {
+		t.Fatalf("Expected a conditional request answered with 304, got %d hits and %d 304s", hits, notModified)
+	}
+
+	// The 304 refreshed the entry's TTL
+	clock.Advance(30 * time.Second)
+	if body := get("/data"); body != "data v1" {
+		t.Errorf("Expected the cached body, got %q", body)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("Expected the refreshed entry to be served from cache, got %d hits", hits)
+	}
+
+	// no-store responses are never cached
+	get("/private")
+	get("/private")
+	if atomic.LoadInt32(&hits) != 4 {
+		t.Errorf("Expected no-store responses to bypass the cache, got %d hits", hits)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	notModified := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/max-age-0":
+			w.Header().Set("Cache-Control", "max-age=0")
+		case "/max-age-60":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/s-maxage":
+			w.Header().Set("Cache-Control", "max-age=10, s-maxage=60")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/no-store-max-age":
+			w.Header().Set("Cache-Control", "max-age=60, no-store")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/no-cache":
+			w.Header().Set("Cache-Control", "no-cache, max-age=60")
+		case "/no-cache-etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified[r.URL.Path]++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/must-revalidate":
+			w.Header().Set("Cache-Control", "max-age=60, must-revalidate")
+		case "/expires":
+			w.Header().Set("Date", date.Format(http.TimeFormat))
+			w.Header().Set("Expires", date.Add(30*time.Second).Format(http.TimeFormat))
+		case "/expires-invalid":
+			w.Header().Set("Expires", "0")
+		case "/max-age-over-expires":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Date", date.Format(http.TimeFormat))
+			w.Header().Set("Expires", date.Format(http.TimeFormat))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	override := httpclient.CacheOverride
+	tests := []struct {
+		path    string
+		policy  httpclient.CachePolicy // the default when empty
+		after   time.Duration          // between the two requests
+		want    int                    // requests reaching the server
+		want304 int                    // of which revalidations answered 304
+	}{
+		{path: "/max-age-0", want: 2},
+		{path: "/no-store", want: 2},
+		{path: "/no-store-max-age", want: 2},
+		{path: "/private", want: 2},
+		{path: "/no-cache", want: 2},
+		{path: "/no-cache-etag", want: 2, want304: 1},
+		{path: "/max-age-60", after: 59 * time.Second, want: 1},
+		{path: "/max-age-60", after: 61 * time.Second, want: 2}, // shorter than the client's TTL
+		{path: "/s-maxage", after: 59 * time.Second, want: 1},   // s-maxage wins over max-age
+		{path: "/s-maxage", after: 61 * time.Second, want: 2},
+		{path: "/expires", after: 29 * time.Second, want: 1},
+		{path: "/expires", after: 31 * time.Second, want: 2},
+		{path: "/expires-invalid", want: 2},
+		{path: "/max-age-over-expires", after: 59 * time.Second, want: 1},
+		{path: "/default", after: 9 * time.Minute, want: 1},
+		{path: "/default", after: 11 * time.Minute, want: 2},
+
+		// The client's TTL replaces the server's lifetime, except with
+		// must-revalidate; no-store, private and no-cache still apply
+		{path: "/max-age-60", policy: override, after: 9 * time.Minute, want: 1},
+		{path: "/max-age-0", policy: override, after: 9 * time.Minute, want: 1},
+		{path: "/expires", policy: override, after: 9 * time.Minute, want: 1},
+		{path: "/must-revalidate", policy: override, after: 61 * time.Second, want: 2},
+		{path: "/must-revalidate", policy: override, after: 59 * time.Second, want: 1},
+		{path: "/default", policy: override, after: 11 * time.Minute, want: 2},
+		{path: "/no-store", policy: override, want: 2},
+		{path: "/private", policy: override, want: 2},
+		{path: "/no-cache", policy: override, want: 2},
+		{path: "/no-cache-etag", policy: override, want: 2, want304: 1},
+	}
+	for _, tt := range tests {
+		name := tt.path[1:] + "/" + tt.after.String()
+		if tt.policy != "" {
+			name += "/" + string(tt.policy)
+		}
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			client := httpclient.New().WithClock(clock).WithCache(10 * time.Minute)
+			if tt.policy != "" {
+				client = client.WithCachePolicy(tt.policy)
+			}
+
+			mu.Lock()
+			hits[tt.path] = 0
+			notModified[tt.path] = 0
+			mu.Unlock()
+			for i := 0; i < 2; i++ {
+				data, err := client.GET(server.URL + tt.path)
+				if err != nil {
+					t.Fatalf("Request %d failed: %v", i, err)
+				}
+				if string(data) != "ok" {
+					t.Errorf("Request %d: expected the body, got %q", i, data)
+				}
+				clock.Advance(tt.after)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if hits[tt.path] != tt.want {
+				t.Errorf("Expected %d requests to reach the server, got %d", tt.want, hits[tt.path])
+			}
+			if notModified[tt.path] != tt.want304 {
+				t.Errorf("Expected %d revalidations, got %d", tt.want304, notModified[tt.path])
+			}
+		})
+	}
+}
+
+func TestCacheLimits(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte("body " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	// get requests each path and returns how many of them reached the server
+	get := func(client httpclient.Client, paths ...string) int {
+		t.Helper()
+		mu.Lock()
+		clear(hits)
+		mu.Unlock()
+		for _, path := range paths {
+			if _, err := client.GET(server.URL + path); err != nil {
+				t.Fatalf("GET %s failed: %v", path, err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, n := range hits {
+			total += n
+		}
+		return total
+	}
+
+	t.Run("MaxEntries", func(t *testing.T) {
+		client := httpclient.New().WithCache(time.Minute).WithCacheLimits(2, 0)
+		get(client, "/a", "/b", "/a") // /a is now used more recently than /b
+		if n := get(client, "/c"); n != 1 {
+			t.Fatalf("Expected /c to be fetched, got %d requests", n)
+		}
+		if n := get(client, "/a", "/c"); n != 0 {
+			t.Errorf("Expected /a and /c to stay cached, got %d requests", n)
+		}
+		if n := get(client, "/b"); n != 1 {
+			t.Errorf("Expected the least recently used /b to be evicted, got %d requests", n)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		// Each body is 7 bytes, so two fit
+		client := httpclient.New().WithCache(time.Minute).WithCacheLimits(0, 15)
+		get(client, "/x", "/y", "/z")
+		if n := get(client, "/y", "/z"); n != 0 {
+			t.Errorf("Expected the two most recent bodies to stay cached, got %d requests", n)
+		}
+		if n := get(client, "/x"); n != 1 {
+			t.Errorf("Expected /x to be evicted, got %d requests", n)
+		}
+	})
+
+	t.Run("MaxEntrySize", func(t *testing.T) {
+		// Each body is 7 bytes, one more than the limit
+		client := httpclient.New().WithCache(time.Minute).WithCacheMaxEntrySize(6)
+		for i := 0; i < 2; i++ {
+			data, err := client.GET(server.URL + "/x")
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			if string(data) != "body /x" {
+				t.Errorf("Expected the whole body of an uncached response, got %q", data)
+			}
+		}
+		if n := get(client, "/x"); n != 1 {
+			t.Errorf("Expected a body over the limit not to be cached, got %d requests", n)
+		}
+		if stats := client.CacheStats(); stats.Entries != 0 || stats.Bytes != 0 {
+			t.Errorf("Expected an empty cache, got %+v", stats)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		client := httpclient.New().WithCache(time.Minute).WithCacheLimits(2, 0)
+		get(client, "/a", "/b", "/c") // /a is evicted for /c
+		get(client, "/b", "/c")
+		get(client, "/a") // /b is evicted for /a
+
+		want := httpclient.CacheStats{Hits: 2, Misses: 4, Evictions: 2, Entries: 2, Bytes: 14}
+		if stats := client.CacheStats(); stats != want {
+			t.Errorf("Expected %+v, got %+v", want, stats)
+		}
+		if n := get(client, "/a", "/c"); n != 0 {
+			t.Errorf("Expected the two newest entries to stay cached, got %d requests", n)
+		}
+		if n := get(client, "/b"); n != 1 {
+			t.Errorf("Expected the oldest entry to be gone, got %d requests", n)
+		}
+
+		client.ClearCache()
+		stats := client.CacheStats()
+		if stats.Entries != 0 || stats.Bytes != 0 || stats.Evictions != 3 {
+			t.Errorf("Expected an empty cache after 3 evictions, got %+v", stats)
+		}
+	})
+}
+
+func TestCacheVary(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		header := r.URL.Query().Get("vary")
+		if header != "" {
+			w.Header().Set("Vary", header)
+		} else {
+			header = "Accept"
+		}
+		w.Write([]byte("as " + r.Header.Get(header)))
+	}))
+	defer server.Close()
+
+	// get requests path with a header on the same client, as the header
+	// is set per request
+	get := func(client httpclient.Client, path, header, value string) string {
+		t.Helper()
+		responses, err := client.Batch().
+			Add("GET", server.URL+path, nil, httpclient.ItemHeader(header, value)).
+			Execute()
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		return string(responses[0].Data)
+	}
+
+	tests := []struct {
+		name   string
+		client httpclient.Client
+		path   string
+		header string
+		values []string
+		want   int32 // requests reaching the server
+	}{
+		// Each representation is cached on its own, whether the server
+		// lists the header in Vary or the custom key includes it
+		{"Accept", httpclient.New().WithCache(time.Minute), "/vary?vary=Accept", "Accept",
+			[]string{"application/json", "application/xml"}, 2},
+		{"AcceptEncoding", httpclient.New().WithCache(time.Minute), "/vary?vary=Accept-Encoding", "Accept-Encoding",
+			[]string{"gzip", "br"}, 2},
+		{"KeyFunc", httpclient.New().WithCache(time.Minute).WithCacheKeyFunc(func(r *http.Request) string {
+			return r.URL.String() + " " + r.Header.Get("Accept")
+		}), "/plain", "Accept", []string{"application/json", "application/xml"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+			for i := 0; i < 2; i++ {
+				for _, value := range tt.values {
+					if body := get(tt.client, tt.path, tt.header, value); body != "as "+value {
+						t.Errorf("Expected the %s representation, got %q", value, body)
+					}
+				}
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.want {
+				t.Errorf("Expected %d requests to reach the server, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET":
+			atomic.AddInt32(&gets, 1)
+			w.Write([]byte("item"))
+		case r.URL.Query().Get("fail") != "":
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithCache(time.Minute).WithRetries(0)
+
+	// fetched returns how many of the GETs reached the server
+	fetched := func(urls ...string) int32 {
+		t.Helper()
+		atomic.StoreInt32(&gets, 0)
+		for _, u := range urls {
+			if _, err := client.GET(u); err != nil {
+				t.Fatalf("GET %s failed: %v", u, err)
+			}
+		}
+		return atomic.LoadInt32(&gets)
+	}
+
+	item, other := server.URL+"/items/1", server.URL+"/items/2"
+	fetched(item, item+"?fields=name", other)
+	if n := fetched(item, item+"?fields=name", other); n != 0 {
+		t.Fatalf("Expected the responses to be cached, got %d requests", n)
+	}
+
+	if _, err := client.DELETE(item + "?fail=1"); err == nil {
+		t.Fatal("Expected the failed DELETE to return an error")
+	}
+	if n := fetched(item); n != 0 {
+		t.Errorf("Expected a failed DELETE to keep the cache, got %d requests", n)
+	}
+
+	if _, err := client.DELETE(item); err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	if n := fetched(item, item+"?fields=name"); n != 2 {
+		t.Errorf("Expected the DELETE to invalidate every query of the path, got %d requests", n)
+	}
+	if n := fetched(other); n != 0 {
+		t.Errorf("Expected other paths to stay cached, got %d requests", n)
+	}
+
+	client.InvalidateCache(item + "?fields=name")
+	if n := fetched(item, item+"?fields=name"); n != 1 {
+		t.Errorf("Expected InvalidateCache to drop only that query, got %d requests", n)
+	}
+
+	client.InvalidateCache(server.URL + "/*")
+	if n := fetched(item, other); n != 0 {
+		t.Errorf("Expected * to stay within its path segment, got %d requests", n)
+	}
+	client.InvalidateCache(server.URL + "/items/*")
+	if n := fetched(item, item+"?fields=name", other); n != 3 {
+		t.Errorf("Expected the pattern to drop every item, got %d requests", n)
+	}
+
+	client.ClearCache()
+	if n := fetched(item, other); n != 2 {
+		t.Errorf("Expected ClearCache to drop every response, got %d requests", n)
+	}
+	client.FlushCache()
+	if n := fetched(item, other); n != 2 {
+		t.Errorf("Expected FlushCache to drop every response, got %d requests", n)
+	}
+}
+
+// cacheLookups returns the httpclient_cache_lookups_total counters by result
+func cacheLookups(t *testing.T) map[string]float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "httpclient_cache_lookups_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "result" {
+					counts[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestCacheMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	client := httpclient.New().WithClock(clock).WithCache(time.Minute)
+	before := cacheLookups(t)
+
+	get := func() {
+		t.Helper()
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+	}
+	get() // miss
+	get() // hit
+	clock.Advance(2 * time.Minute)
+	get() // miss, refreshed by a 304
+	get() // hit
+
+	want := httpclient.CacheStats{Hits: 2, Misses: 2, Revalidations: 1, Entries: 1, Bytes: 4}
+	if stats := client.CacheStats(); stats != want {
+		t.Errorf("Expected %+v, got %+v", want, stats)
+	}
+	if snapshot := client.MetricsSnapshot(); snapshot.Cache != want {
+		t.Errorf("Expected the snapshot's cache stats %+v, got %+v", want, snapshot.Cache)
+	}
+
+	after := cacheLookups(t)
+	for result, n := range map[string]float64{"hit": 2, "miss": 2, "revalidated": 1} {
+		if got := after[result] - before[result]; got != n {
+			t.Errorf("Expected httpclient_cache_lookups_total{result=%q} to grow by %v, got %v", result, n, got)
+		}
+	}
+}
+
+func TestCacheRequestModes(t *testing.T) {
+	var version, full, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		fmt.Fprintf(w, "version %d", atomic.LoadInt32(&version))
+	}))
+	defer server.Close()
+
+	// setup returns a client with version 1 cached
+	setup := func(t *testing.T) (httpclient.Client, *fakeClock) {
+		atomic.StoreInt32(&version, 1)
+		clock := newFakeClock()
+		client := httpclient.New().WithClock(clock).WithCache(time.Minute)
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		atomic.StoreInt32(&full, 0)
+		atomic.StoreInt32(&notModified, 0)
+		return client, clock
+	}
+	// step fetches the resource with opts and checks the body and how many
+	// full and 304 responses the server sent since setup
+	step := func(t *testing.T, client httpclient.Client, desc, body string, wantFull, want304 int32, opts ...httpclient.RequestOption) {
+		t.Helper()
+		data, err := client.GET(server.URL, opts...)
+		if err != nil {
+			t.Fatalf("%s: GET failed: %v", desc, err)
+		}
+		if string(data) != body {
+			t.Errorf("%s: expected %q, got %q", desc, body, data)
+		}
+		if f, n := atomic.LoadInt32(&full), atomic.LoadInt32(&notModified); f != wantFull || n != want304 {
+			t.Errorf("%s: expected %d full and %d 304 responses, got %d and %d", desc, wantFull, want304, f, n)
+		}
+	}
+
+	t.Run("NoCache", func(t *testing.T) {
+		client, _ := setup(t)
+		atomic.StoreInt32(&version, 2)
+		step(t, client, "bypass", "version 2", 1, 0, httpclient.NoCache())
+		step(t, client, "cache kept", "version 1", 1, 0)
+		client.ClearCache()
+		step(t, client, "bypass on empty cache", "version 2", 2, 0, httpclient.NoCache())
+		step(t, client, "nothing stored", "version 2", 3, 0)
+	})
+
+	t.Run("ForceRevalidate", func(t *testing.T) {
+		client, _ := setup(t)
+		step(t, client, "unchanged", "version 1", 0, 1, httpclient.ForceRevalidate())
+		atomic.StoreInt32(&version, 2)
+		step(t, client, "changed", "version 2", 1, 1, httpclient.ForceRevalidate())
+		step(t, client, "stored", "version 2", 1, 1)
+	})
+
+	t.Run("OnlyIfCached", func(t *testing.T) {
+		client, clock := setup(t)
+		step(t, client, "cached", "version 1", 0, 0, httpclient.OnlyIfCached())
+
+		missing := server.URL + "/missing"
+		_, err := client.GET(missing, httpclient.OnlyIfCached())
+		if !errors.Is(err, httpclient.ErrCacheMiss) || errors.Is(err, httpclient.ErrMaxRetries) {
+			t.Errorf("Expected ErrCacheMiss without retries for an uncached URL, got %v", err)
+		}
+		clock.Advance(2 * time.Minute)
+		if _, err := client.GET(server.URL, httpclient.OnlyIfCached()); !errors.Is(err, httpclient.ErrCacheMiss) {
+			t.Errorf("Expected ErrCacheMiss for an expired response, got %v", err)
+		}
+		if _, err := httpclient.New().GET(server.URL, httpclient.OnlyIfCached()); !errors.Is(err, httpclient.ErrCacheMiss) {
+			t.Errorf("Expected ErrCacheMiss without a cache, got %v", err)
+		}
+		if f, n := atomic.LoadInt32(&full), atomic.LoadInt32(&notModified); f != 0 || n != 0 {
+			t.Errorf("Expected no requests, got %d full and %d 304 responses", f, n)
+		}
+	})
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		// validate sets the validator of version v on w and reports
+		// whether the conditional headers of r match it
+		validate func(w http.ResponseWriter, r *http.Request, v int) bool
+	}{
+		{"ETag", func(w http.ResponseWriter, r *http.Request, v int) bool {
+			etag := fmt.Sprintf(`"v%d"`, v)
+			w.Header().Set("ETag", etag)
+			return r.Header.Get("If-None-Match") == etag
+		}},
+		{"LastModified", func(w http.ResponseWriter, r *http.Request, v int) bool {
+			modified := time.Date(2024, 1, v, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+			w.Header().Set("Last-Modified", modified)
+			return r.Header.Get("If-Modified-Since") == modified
+		}},
+		{"NoValidator", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var version, full, notModified, conditional int32
+			atomic.StoreInt32(&version, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+					atomic.AddInt32(&conditional, 1)
+				}
+				v := int(atomic.LoadInt32(&version))
+				if tt.validate != nil && tt.validate(w, r, v) {
+					atomic.AddInt32(&notModified, 1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				atomic.AddInt32(&full, 1)
+				fmt.Fprintf(w, "version %d", v)
+			}))
+			defer server.Close()
+
+			clock := newFakeClock()
+			client := httpclient.New().WithClock(clock).WithCache(time.Minute)
+
+			// step fetches the resource and checks the body and the
+			// running totals of full and 304 responses
+			step := func(desc, body string, wantFull, want304 int32) {
+				t.Helper()
+				data, err := client.GET(server.URL)
+				if err != nil {
+					t.Fatalf("%s: GET failed: %v", desc, err)
+				}
+				if string(data) != body {
+					t.Errorf("%s: expected %q, got %q", desc, body, data)
+				}
+				if f, n := atomic.LoadInt32(&full), atomic.LoadInt32(&notModified); f != wantFull || n != want304 {
+					t.Errorf("%s: expected %d full and %d 304 responses, got %d and %d", desc, wantFull, want304, f, n)
+				}
+			}
+
+			step("absent", "version 1", 1, 0)
+			step("fresh", "version 1", 1, 0)
+
+			clock.Advance(2 * time.Minute)
+			if tt.validate == nil {
+				// Without a validator a stale entry is as good as absent
+				step("stale", "version 1", 2, 0)
+				if n := atomic.LoadInt32(&conditional); n != 0 {
+					t.Errorf("Expected no conditional request without a validator, got %d", n)
+				}
+				return
+			}
+			step("stale, unchanged", "version 1", 1, 1)
+			step("refreshed by 304", "version 1", 1, 1)
+
+			atomic.StoreInt32(&version, 2)
+			clock.Advance(2 * time.Minute)
+			step("stale, changed", "version 2", 2, 1)
+			step("replaced by 200", "version 2", 2, 1)
+
+			clock.Advance(2 * time.Minute)
+			step("stale again", "version 2", 2, 2)
+
+			// Long expired entries are dropped rather than revalidated
+			clock.Advance(3 * time.Hour)
+			conditionalBefore := atomic.LoadInt32(&conditional)
+			step("expired", "version 2", 3, 2)
+			if n := atomic.LoadInt32(&conditional); n != conditionalBefore {
+				t.Errorf("Expected an unconditional request for a long expired entry")
+			}
+		})
+	}
+}
+
+// sharedStore is a CacheStore that, like Redis, holds encoded responses and
+// can be shared by several clients
+type sharedStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	down    bool // every call fails
+}
+
+func newSharedStore() *sharedStore {
+	return &sharedStore{entries: make(map[string][]byte)}
+}
+
+func (s *sharedStore) Get(ctx context.Context, key string) (*httpclient.CachedResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, false, errors.New("store down")
+	}
+	data, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	var resp httpclient.CachedResponse
+	if err := resp.UnmarshalBinary(data); err != nil {
+		return nil, false, err
+	}
+	return &resp, true, nil
+}
+
+func (s *sharedStore) Set(ctx context.Context, key string, resp *httpclient.CachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store down")
+	}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.entries[key] = data
+	return nil
+}
+
+func (s *sharedStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("store down")
+	}
+	delete(s.entries, key)
+	return nil
+}
+
+func TestCacheStore(t *testing.T) {
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		if r.URL.Path == "/vary" {
+			w.Header().Set("Vary", "Accept")
+		}
+		w.Header().Set("X-Served", "yes")
+		w.Write([]byte("as " + r.Header.Get("Accept")))
+	}))
+	defer server.Close()
+
+	// get requests path with the Accept header and returns the body
+	get := func(client httpclient.Client, path, accept string) string {
+		t.Helper()
+		responses, err := client.Batch().
+			Add("GET", server.URL+path, nil, httpclient.ItemHeader("Accept", accept)).
+			Execute()
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		if responses[0].Error != nil {
+			t.Fatalf("GET %s failed: %v", path, responses[0].Error)
+		}
+		return string(responses[0].Data)
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		store := newSharedStore()
+		first := httpclient.New().WithCache(time.Minute).WithCacheStore(store)
+		second := httpclient.New().WithCache(time.Minute).WithCacheStore(store)
+		atomic.StoreInt32(&gets, 0)
+
+		get(first, "/data", "text/plain")
+		if body := get(second, "/data", "text/plain"); body != "as text/plain" {
+			t.Errorf("Expected the stored body, got %q", body)
+		}
+		if got := atomic.LoadInt32(&gets); got != 1 {
+			t.Errorf("Expected the second client to use the first one's response, got %d requests", got)
+		}
+		if stats := second.CacheStats(); stats.Hits != 1 || stats.Misses != 0 {
+			t.Errorf("Expected one hit on the second client, got %+v", stats)
+		}
+
+		// A change through one client is seen by the other
+		if _, err := first.POST(server.URL+"/data", nil); err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		get(second, "/data", "text/plain")
+		if got := atomic.LoadInt32(&gets); got != 2 {
+			t.Errorf("Expected the POST to invalidate the shared entry, got %d requests", got)
+		}
+		second.InvalidateCache(server.URL + "/data")
+		get(first, "/data", "text/plain")
+		if got := atomic.LoadInt32(&gets); got != 3 {
+			t.Errorf("Expected InvalidateCache to remove the shared entry, got %d requests", got)
+		}
+	})
+
+	t.Run("Vary", func(t *testing.T) {
+		store := newSharedStore()
+		first := httpclient.New().WithCache(time.Minute).WithCacheStore(store)
+		second := httpclient.New().WithCache(time.Minute).WithCacheStore(store)
+		atomic.StoreInt32(&gets, 0)
+
+		for _, client := range []httpclient.Client{first, second, first, second} {
+			for _, accept := range []string{"application/json", "application/xml"} {
+				if body := get(client, "/vary", accept); body != "as "+accept {
+					t.Errorf("Expected the %s representation, got %q", accept, body)
+				}
+			}
+		}
+		if got := atomic.LoadInt32(&gets); got != 2 {
+			t.Errorf("Expected one request per representation, got %d", got)
+		}
+
+		// Invalidating the resource drops every representation
+		second.InvalidateCache(server.URL + "/vary")
+		get(first, "/vary", "application/json")
+		if body := get(first, "/vary", "application/xml"); body != "as application/xml" {
+			t.Errorf("Expected the xml representation, got %q", body)
+		}
+		if got := atomic.LoadInt32(&gets); got != 4 {
+			t.Errorf("Expected both representations to be fetched again, got %d requests", got)
+		}
+	})
+
+	t.Run("StoreDown", func(t *testing.T) {
+		store := newSharedStore()
+		store.down = true
+		client := httpclient.New().WithCache(time.Minute).WithCacheStore(store)
+		atomic.StoreInt32(&gets, 0)
+
+		for i := 0; i < 2; i++ {
+			if body := get(client, "/data", "text/plain"); body != "as text/plain" {
+				t.Errorf("Expected the response despite the store failing, got %q", body)
+			}
+		}
+		if got := atomic.LoadInt32(&gets); got != 2 {
+			t.Errorf("Expected store errors to be cache misses, got %d requests", got)
+		}
+		if stats := client.CacheStats(); stats.Errors == 0 || stats.Misses != 2 {
+			t.Errorf("Expected the failed store calls to be counted, got %+v", stats)
+		}
+	})
+
+	t.Run("Encoding", func(t *testing.T) {
+		resp := &httpclient.CachedResponse{
+			StatusCode: http.StatusOK,
+			Headers:    http.Header{"Content-Type": {"text/plain"}, "Etag": {`"v1"`}},
+			Body:       []byte("hello"),
+			ExpiresAt:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			URL:        "https://api.example.com/greeting",
+			Vary:       http.Header{"Accept": {"text/plain"}},
+		}
+		data, err := resp.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		var decoded httpclient.CachedResponse
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+		if fmt.Sprint(decoded) != fmt.Sprint(*resp) {
+			t.Errorf("Expected %+v after a round trip, got %+v", *resp, decoded)
+		}
+		if err := decoded.UnmarshalBinary([]byte(`{"v":99}`)); err == nil {
+			t.Error("Expected an unknown encoding version to be rejected")
+		}
+	})
+}
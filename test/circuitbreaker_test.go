@@ -0,0 +1,472 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/middleware"
+)
+
+func TestCircuitBreakerState(t *testing.T) {
+	var failing int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	clk := newFakeClock()
+	var client httpclient.Client
+	var transitions, reported []string
+
+	client = httpclient.New().
+		WithClock(clk).
+		WithCircuitBreaker(2, time.Minute).
+		OnCircuitStateChange(func(old, new string) {
+			transitions = append(transitions, old+"->"+new)
+			// The callback may query the breaker it is reporting on
+			state, _ := client.CircuitBreakerState()
+			reported = append(reported, state)
+		}).
+		WithRetries(0)
+
+	if state, failures := client.CircuitBreakerState(); state != "closed" || failures != 0 {
+		t.Fatalf("Expected closed with 0 failures, got %s with %d", state, failures)
+	}
+
+	client.GET(server.URL)
+	if state, failures := client.CircuitBreakerState(); state != "closed" || failures != 1 {
+		t.Errorf("Expected closed with 1 failure, got %s with %d", state, failures)
+	}
+
+	client.GET(server.URL)
+	if state, failures := client.CircuitBreakerState(); state != "open" || failures != 2 {
+		t.Errorf("Expected open with 2 failures, got %s with %d", state, failures)
+	}
+
+	if _, err := client.GET(server.URL); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	// After the timeout one trial request is let through and closes the
+	// breaker again on success
+	atomic.StoreInt32(&failing, 0)
+	clk.Advance(2 * time.Minute)
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Trial request failed: %v", err)
+	}
+	if state, failures := client.CircuitBreakerState(); state != "closed" || failures != 0 {
+		t.Errorf("Expected closed with 0 failures, got %s with %d", state, failures)
+	}
+
+	expected := "closed->open,open->half-open,half-open->closed"
+	if got := strings.Join(transitions, ","); got != expected {
+		t.Errorf("Expected transitions %s, got %s", expected, got)
+	}
+	if got := strings.Join(reported, ","); got != "open,half-open,closed" {
+		t.Errorf("Expected reported states open,half-open,closed, got %s", got)
+	}
+
+	if state, _ := httpclient.New().CircuitBreakerState(); state != "disabled" {
+		t.Errorf("Expected disabled without a circuit breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerPerHost(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var healthyHits int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyHits, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	client := httpclient.New().
+		WithCircuitBreaker(2, time.Minute).
+		WithRetries(0)
+
+	for i := 0; i < 2; i++ {
+		client.GET(failing.URL)
+	}
+	if _, err := client.GET(failing.URL); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Errorf("Expected failing host circuit to be open, got %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.GET(healthy.URL); err != nil {
+			t.Fatalf("Request %d to healthy host failed: %v", i, err)
+		}
+	}
+	if atomic.LoadInt32(&healthyHits) != 5 {
+		t.Errorf("Expected 5 requests to reach the healthy host, got %d", healthyHits)
+	}
+
+	failingHost := strings.TrimPrefix(failing.URL, "http://")
+	healthyHost := strings.TrimPrefix(healthy.URL, "http://")
+	if state, failures := client.CircuitState(failingHost); state != httpclient.CircuitOpen || failures != 2 {
+		t.Errorf("Expected failing host open with 2 failures, got %s with %d", state, failures)
+	}
+	if state, failures := client.CircuitState(healthyHost); state != httpclient.CircuitClosed || failures != 0 {
+		t.Errorf("Expected healthy host closed with 0 failures, got %s with %d", state, failures)
+	}
+	if state, _ := client.CircuitBreakerState(); state != "open" {
+		t.Errorf("Expected overall state open, got %s", state)
+	}
+}
+
+// serverErrorTransport answers every request with 500 without a network
+type serverErrorTransport struct{}
+
+func (serverErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// circuitStateMetric returns httpclient_circuit_state for host, and false
+// when the host has none
+func circuitStateMetric(t *testing.T, host string) (float64, bool) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "httpclient_circuit_state" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "host" && label.GetValue() == host {
+					return metric.GetGauge().GetValue(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// hostTransport answers requests to the hosts marked failing with 500 and
+// the others with 200, without a network
+type hostTransport struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func (h *hostTransport) setFailing(host string, failing bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failing[host] = failing
+}
+
+func (h *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.mu.Lock()
+	status := http.StatusOK
+	if h.failing[req.URL.Host] {
+		status = http.StatusInternalServerError
+	}
+	h.mu.Unlock()
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func TestCircuitStateMetrics(t *testing.T) {
+	clk := newFakeClock()
+	transport := &hostTransport{failing: map[string]bool{
+		"metrics-a.test": true,
+		"metrics-b.test": true,
+	}}
+	client := httpclient.New().
+		WithClock(clk).
+		WithCustomTransport(transport).
+		WithCircuitBreaker(1, time.Minute).
+		WithRetries(0)
+
+	expectMetric := func(host string, want float64) {
+		t.Helper()
+		got, ok := circuitStateMetric(t, host)
+		if want == 0 && ok {
+			t.Errorf("Expected no httpclient_circuit_state for %s, got %v", host, got)
+		} else if want != 0 && got != want {
+			t.Errorf("Expected httpclient_circuit_state %v for %s, got %v, %v", want, host, got, ok)
+		}
+	}
+
+	client.GET("http://metrics-a.test")
+	client.GET("http://metrics-b.test")
+	client.GET("http://metrics-c.test")
+	expectMetric("metrics-a.test", 1)
+	expectMetric("metrics-b.test", 1)
+	expectMetric("metrics-c.test", 0)
+
+	// Only the host whose circuit closes leaves the gauge
+	transport.setFailing("metrics-b.test", false)
+	clk.Advance(time.Minute + time.Second)
+	if _, err := client.GET("http://metrics-b.test"); err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	expectMetric("metrics-a.test", 1)
+	expectMetric("metrics-b.test", 0)
+}
+
+func TestCircuitBreakerHostLimit(t *testing.T) {
+	clk := newFakeClock()
+	var closed []string
+	var client httpclient.Client
+	client = httpclient.New().
+		WithClock(clk).
+		WithCustomTransport(serverErrorTransport{}).
+		WithRateLimiter(0).
+		WithCircuitBreaker(1, time.Minute).
+		OnCircuitStateChange(func(old, new string) {
+			if new == "closed" {
+				closed = append(closed, old)
+			}
+		}).
+		WithRetries(0)
+
+	host := func(i int) string { return fmt.Sprintf("host-%d.test", i) }
+	for i := 0; i < middleware.MaxCircuits; i++ {
+		client.GET("http://" + host(i))
+		clk.Advance(time.Millisecond)
+	}
+	if state, failures := client.CircuitState(host(0)); state != httpclient.CircuitOpen || failures != 1 {
+		t.Fatalf("Expected the first host open, got %s with %d failures", state, failures)
+	}
+	if state, ok := circuitStateMetric(t, host(0)); !ok || state != 1 {
+		t.Errorf("Expected httpclient_circuit_state 1 for an open host, got %v, %v", state, ok)
+	}
+
+	// One more failing host drops the circuit that failed longest ago
+	client.GET("http://" + host(middleware.MaxCircuits))
+	if state, failures := client.CircuitState(host(0)); state != httpclient.CircuitClosed || failures != 0 {
+		t.Errorf("Expected the oldest circuit to be dropped, got %s with %d failures", state, failures)
+	}
+	if _, ok := circuitStateMetric(t, host(0)); ok {
+		t.Error("Expected the dropped host to leave httpclient_circuit_state")
+	}
+	if len(closed) != 1 || closed[0] != "open" {
+		t.Errorf("Expected one open->closed transition for the dropped host, got %q", closed)
+	}
+	for _, i := range []int{1, middleware.MaxCircuits} {
+		if state, _ := client.CircuitState(host(i)); state != httpclient.CircuitOpen {
+			t.Errorf("Expected %s open, got %s", host(i), state)
+		}
+	}
+	if _, failures := client.CircuitBreakerState(); failures != middleware.MaxCircuits {
+		t.Errorf("Expected %d tracked failures, got %d", middleware.MaxCircuits, failures)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	const (
+		succeed = iota
+		fail
+		block
+	)
+	var mode int32 = fail
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.LoadInt32(&mode) {
+		case fail:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case block:
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	clk := newFakeClock()
+	var mu sync.Mutex
+	var transitions []string
+	client := httpclient.New().
+		WithClock(clk).
+		WithCircuitBreaker(2, time.Minute).
+		WithCircuitBreakerProbes(2, 2).
+		OnStateChange(func(h string, from, to httpclient.CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			transitions = append(transitions, fmt.Sprintf("%s:%s->%s", h, from, to))
+		}).
+		WithRetries(0)
+
+	expectState := func(want httpclient.CircuitState, metric float64) {
+		t.Helper()
+		if state, _ := client.CircuitState(host); state != want {
+			t.Errorf("Expected %s, got %s", want, state)
+		}
+		got, ok := circuitStateMetric(t, host)
+		if metric == 0 && ok {
+			t.Errorf("Expected no httpclient_circuit_state for a closed host, got %v", got)
+		} else if metric != 0 && got != metric {
+			t.Errorf("Expected httpclient_circuit_state %v, got %v, %v", metric, got, ok)
+		}
+	}
+
+	// closed -> open
+	client.GET(server.URL)
+	client.GET(server.URL)
+	expectState(httpclient.CircuitOpen, 1)
+	if _, err := client.GET(server.URL); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	// open -> half-open, with two probes in flight and a third rejected
+	atomic.StoreInt32(&mode, block)
+	clk.Advance(2 * time.Minute)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GET(server.URL)
+			errs <- err
+		}()
+	}
+	<-arrived
+	<-arrived
+	expectState(httpclient.CircuitHalfOpen, 2)
+	if _, err := client.GET(server.URL); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Errorf("Expected a third probe to be rejected, got %v", err)
+	}
+
+	// half-open -> closed once both probes succeed
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Probe failed: %v", err)
+		}
+	}
+	expectState(httpclient.CircuitClosed, 0)
+
+	// A failed probe opens the circuit again
+	atomic.StoreInt32(&mode, fail)
+	client.GET(server.URL)
+	client.GET(server.URL)
+	clk.Advance(2 * time.Minute)
+	client.GET(server.URL)
+	expectState(httpclient.CircuitOpen, 1)
+
+	// One success isn't enough to close it
+	atomic.StoreInt32(&mode, succeed)
+	clk.Advance(2 * time.Minute)
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	expectState(httpclient.CircuitHalfOpen, 2)
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	expectState(httpclient.CircuitClosed, 0)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var expected []string
+	for _, step := range []string{
+		"closed->open", "open->half-open", "half-open->closed",
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	} {
+		expected = append(expected, host+":"+step)
+	}
+	if got := strings.Join(transitions, ","); got != strings.Join(expected, ",") {
+		t.Errorf("Expected transitions %s, got %s", expected, got)
+	}
+}
+
+func TestCircuitBreakerNetworkErrors(t *testing.T) {
+	t.Run("ConnectionRefused", func(t *testing.T) {
+		// The address of a server that is no longer listening
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		client := httpclient.New().
+			WithCircuitBreaker(3, time.Minute).
+			WithRetries(0)
+		for i := 0; i < 3; i++ {
+			if _, err := client.GET(server.URL); err == nil || errors.Is(err, httpclient.ErrCircuitOpen) {
+				t.Fatalf("Expected request %d to fail to connect, got %v", i, err)
+			}
+		}
+		if state, failures := client.CircuitState(host); state != httpclient.CircuitOpen || failures != 3 {
+			t.Errorf("Expected open with 3 failures, got %s with %d", state, failures)
+		}
+		if _, err := client.GET(server.URL); !errors.Is(err, httpclient.ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen, got %v", err)
+		}
+	})
+
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	host := strings.TrimPrefix(slow.URL, "http://")
+
+	t.Run("Timeout", func(t *testing.T) {
+		client := httpclient.New().
+			WithCircuitBreaker(1, time.Minute).
+			WithAttemptTimeout(20 * time.Millisecond).
+			WithRetries(0)
+		if _, err := client.GET(slow.URL); !errors.Is(err, httpclient.ErrAttemptTimeout) {
+			t.Fatalf("Expected ErrAttemptTimeout, got %v", err)
+		}
+		<-arrived
+		if state, failures := client.CircuitState(host); state != httpclient.CircuitOpen || failures != 1 {
+			t.Errorf("Expected a timeout to open the circuit, got %s with %d failures", state, failures)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		client := httpclient.New().
+			WithCircuitBreaker(1, time.Minute).
+			WithRetries(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-arrived
+			cancel()
+		}()
+		if _, err := client.GetContext(ctx, slow.URL); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		if state, failures := client.CircuitState(host); state != httpclient.CircuitClosed || failures != 0 {
+			t.Errorf("Expected a canceled request not to count, got %s with %d failures", state, failures)
+		}
+	})
+}
@@ -1,10 +1,21 @@
 package test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,7 +31,7 @@ func TestBasicHTTPMethods(t *testing.T) {
 	tests := []struct {
 		name   string
 		method string
-		fn     func(string) ([]byte, error)
+		fn     func(string, ...httpclient.RequestOption) ([]byte, error)
 	}{
 		{"GET", "GET", httpclient.GET},
 		{"DELETE", "DELETE", httpclient.DELETE},
@@ -136,6 +147,43 @@ func TestContextAwareMethods(t *testing.T) {
 	}
 }
 
+func TestResponseHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/slow-body" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithRetries(0).
+		WithDialTimeout(time.Second).
+		WithResponseHeaderTimeout(100 * time.Millisecond)
+
+	if _, err := client.GET(server.URL + "/slow-headers"); err == nil {
+		t.Error("Expected a server slow to send headers to trip the response header timeout")
+	}
+
+	data, err := client.GET(server.URL + "/slow-body")
+	if err != nil {
+		t.Fatalf("Expected a slow body within the overall timeout to succeed, got %v", err)
+	}
+	if string(data) != "done" {
+		t.Errorf("Unexpected response: %s", data)
+	}
+
+	// The overall timeout still covers the body
+	if _, err := client.WithTimeout(100 * time.Millisecond).GET(server.URL + "/slow-body"); err == nil {
+		t.Error("Expected a slow body to trip the overall timeout")
+	}
+}
+
 func TestErrorHandling(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -201,4 +249,989 @@ func TestBaseURL(t *testing.T) {
 	if string(data) != `{"users": []}` {
 		t.Errorf("Unexpected response: %s", data)
 	}
-}
\ No newline at end of file
+}
+
+func TestBaseURLJoining(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		base string
+		url  string
+		want string
+	}{
+		{"BasePath", "/v2", "/users", "/v2/users"},
+		{"BasePathRelative", "/v2", "users", "/v2/users"},
+		{"BaseTrailingSlash", "/v2/", "/users", "/v2/users"},
+		{"DuplicateSlashes", "/v2//", "///users/1", "/v2/users/1"},
+		{"NestedBasePath", "/api/v2", "users/1/posts", "/api/v2/users/1/posts"},
+		{"EmptyPath", "/v2", "", "/v2"},
+		{"NoBasePath", "", "/users", "/users"},
+		{"Absolute", "/v2?key=1", server.URL + "/other?x=1", "/other?x=1"},
+		{"QueryOnRequest", "/v2", "/users?page=2", "/v2/users?page=2"},
+		{"QueryOnBase", "/v2?api-version=1", "/users", "/v2/users?api-version=1"},
+		{"QueryMerged", "/v2?api-version=1", "/users?page=2", "/v2/users?api-version=1&page=2"},
+		{"QueryOverride", "/v2?api-version=1&page=1", "/users?page=2", "/v2/users?api-version=1&page=2"},
+		{"QueryOnly", "/v2?api-version=1", "?page=2", "/v2?api-version=1&page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := httpclient.New().WithBaseURL(server.URL + tt.base).GET(tt.url)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, data)
+			}
+		})
+	}
+}
+
+// instantClock is a clock whose timers fire immediately, so backoff delays
+// can be checked without waiting for them
+type instantClock struct{}
+
+func (instantClock) Now() time.Time { return time.Now() }
+
+func (instantClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func TestOnRetry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	type call struct {
+		attempt int
+		status  int
+		delay   time.Duration
+	}
+	var calls []call
+	client := httpclient.New().
+		WithClock(instantClock{}).
+		WithRetries(3).
+		WithOnRetry(func(attempt int, err error, nextDelay time.Duration) {
+			var apiErr *httpclient.APIError
+			if !errors.As(err, &apiErr) {
+				t.Errorf("attempt %d: expected an APIError, got %v", attempt, err)
+				return
+			}
+			calls = append(calls, call{attempt, apiErr.StatusCode, nextDelay})
+		})
+
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("expected the fourth attempt to succeed, got %v", err)
+	}
+
+	// The default backoff starts at 1s and doubles
+	want := []call{
+		{1, http.StatusServiceUnavailable, time.Second},
+		{2, http.StatusServiceUnavailable, 2 * time.Second},
+		{3, http.StatusServiceUnavailable, 4 * time.Second},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got retries %+v, want %+v", calls, want)
+	}
+}
+
+// advancingClock jumps forward by each delay it is asked to wait, so time
+// spent in backoff shows up in Now without actually waiting
+type advancingClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *advancingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *advancingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestRetryBudget(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Backoff waits 1s, 2s, 4s, ...: after the first retry the next wait
+	// would end 3s in, past the budget
+	client := httpclient.New().
+		WithClock(&advancingClock{now: time.Now()}).
+		WithRetries(5).
+		WithRetryBudget(2500 * time.Millisecond)
+
+	_, err := client.GET(server.URL)
+	if !errors.Is(err, httpclient.ErrRetryBudgetExceeded) {
+		t.Fatalf("Expected ErrRetryBudgetExceeded, got %v", err)
+	}
+	var apiErr *httpclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the last attempt's error to be kept, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 of the 6 allowed attempts, got %d", attempts)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithClock(instantClock{}).WithRetries(2)
+
+	tests := []struct {
+		name   string
+		client httpclient.Client
+		method string
+		want   int
+	}{
+		{"POST", client, "POST", 1},
+		{"PATCH", client, "PATCH", 1},
+		{"PUT", client, "PUT", 3},
+		{"OptedIn", client.WithRetryNonIdempotent(true), "POST", 3},
+		{"IdempotencyKey", client.WithHeader("Idempotency-Key", "order-42"), "POST", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts = 0
+			if err := tt.client.JSON(tt.method, server.URL, TestUser{Name: "John"}, nil); err == nil {
+				t.Fatal("Expected the request to fail")
+			}
+			if attempts != tt.want {
+				t.Errorf("Expected %d attempts, got %d", tt.want, attempts)
+			}
+		})
+	}
+
+	t.Run("NotSent", func(t *testing.T) {
+		// A POST that couldn't connect had no effect, so it is retried
+		retries := 0
+		_, err := client.
+			WithOnRetry(func(int, error, time.Duration) { retries++ }).
+			POST("http://127.0.0.1:1/orders", TestUser{Name: "John"})
+		if err == nil {
+			t.Fatal("Expected a connection error")
+		}
+		if retries != 2 {
+			t.Errorf("Expected 2 retries, got %d", retries)
+		}
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		attempt := len(keys)
+		mu.Unlock()
+		// Every call fails twice before succeeding
+		if attempt%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithClock(instantClock{}).WithRetries(3).WithIdempotencyKey()
+	for i := 0; i < 2; i++ {
+		if _, err := client.POST(server.URL, TestUser{Name: "John"}); err != nil {
+			t.Fatalf("POST %d failed: %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	got := append([]string(nil), keys...)
+	keys = nil
+	mu.Unlock()
+	if len(got) != 6 {
+		t.Fatalf("Expected 6 attempts, got %d", len(got))
+	}
+	for _, call := range [][]string{got[:3], got[3:]} {
+		if call[0] == "" || call[1] != call[0] || call[2] != call[0] {
+			t.Errorf("Expected every attempt of a call to carry the same key, got %q", call)
+		}
+	}
+	if got[0] == got[3] {
+		t.Errorf("Expected distinct calls to get distinct keys, both got %q", got[0])
+	}
+
+	t.Run("CallerKey", func(t *testing.T) {
+		if _, err := client.WithHeader("Idempotency-Key", "order-42").POST(server.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if keys[0] != "order-42" {
+			t.Errorf("Expected the caller's key kept, got %q", keys[0])
+		}
+	})
+}
+
+func TestTransportSharedAcrossWith(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := httpclient.New()
+	derived := []httpclient.Client{
+		client,
+		client.WithHeader("X-Tenant", "a"),
+		client.WithRetries(5).WithTimeout(time.Minute),
+		httpclient.New().WithAuth("token"),
+	}
+	for i, c := range derived {
+		if _, err := c.GET(server.URL); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("Expected clients with the same transport settings to share 1 connection, got %d", got)
+	}
+
+	// Different transport settings mean a separate pool
+	if _, err := client.WithDialTimeout(5 * time.Second).GET(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&conns); got != 2 {
+		t.Errorf("Expected a new connection for different settings, got %d connections", got)
+	}
+}
+
+func TestPooledResponseBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		w.Write(bytes.Repeat([]byte{byte('a' + n%26)}, 100+n*997))
+	}))
+	defer server.Close()
+
+	client := httpclient.New()
+	want := func(n int) []byte {
+		return bytes.Repeat([]byte{byte('a' + n%26)}, 100+n*997)
+	}
+
+	// Bodies kept from earlier requests must not change while later ones
+	// reuse the read buffers
+	kept := make([][]byte, 40)
+	var wg sync.WaitGroup
+	for n := range kept {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			data, err := client.GET(fmt.Sprintf("%s?n=%d", server.URL, n))
+			if err != nil {
+				t.Errorf("Request %d failed: %v", n, err)
+				return
+			}
+			kept[n] = data
+		}(n)
+	}
+	wg.Wait()
+
+	for n := len(kept) - 1; n >= 0; n-- {
+		if _, err := client.GET(fmt.Sprintf("%s?n=%d", server.URL, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n, data := range kept {
+		if !bytes.Equal(data, want(n)) {
+			t.Errorf("Body %d corrupted: got %d bytes starting %q", n, len(data), data[:min(len(data), 8)])
+		}
+	}
+}
+
+func BenchmarkGETSmallBody(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1,"name":"John Doe","email":"john@example.com"}`))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithRetries(0).WithRateLimiter(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Configuring a client per request is common in handlers
+		if _, err := client.WithHeader("X-Request", "1").GET(server.URL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBatch100(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithRetries(0).WithRateLimiter(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch := client.Batch()
+		for j := 0; j < 100; j++ {
+			batch.Add("GET", server.URL, nil)
+		}
+		if _, err := batch.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newSilentListener accepts connections and reads from them but never
+// writes, like a server that hangs before responding
+func newSilentListener(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	const limit = 64 << 10
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		body := bytes.Repeat([]byte("x"), size)
+		switch r.URL.Path {
+		case "/chunked":
+			// Flushed in pieces, without a Content-Length
+			for len(body) > 0 {
+				n := min(len(body), 4096)
+				w.Write(body[:n])
+				w.(http.Flusher).Flush()
+				body = body[n:]
+			}
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write(body)
+			gz.Close()
+		default:
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithMaxResponseSize(limit).WithCompression(true)
+	tests := []struct {
+		name     string
+		client   httpclient.Client
+		path     string
+		size     int
+		wantErr  bool
+		requests int32
+	}{
+		{"AtLimit", client, "/", limit, false, 1},
+		{"OverLimit", client, "/", 10 << 20, true, 1},
+		{"Chunked", client, "/chunked", 10 << 20, true, 1},
+		// A few kilobytes that inflate past the limit
+		{"Decompressed", client, "/gzip", 10 << 20, true, 1},
+		// Not stored either, so the second request goes to the server too
+		{"Cached", client.WithCache(time.Minute), "/", limit + 1, true, 2},
+		{"NoLimit", httpclient.New(), "/", 10 << 20, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			url := fmt.Sprintf("%s%s?size=%d", server.URL, tt.path, tt.size)
+			for i := int32(0); i < tt.requests; i++ {
+				data, err := tt.client.GET(url)
+				if !tt.wantErr {
+					if err != nil || len(data) != tt.size {
+						t.Fatalf("Expected %d bytes, got %d and %v", tt.size, len(data), err)
+					}
+					continue
+				}
+				if !errors.Is(err, httpclient.ErrResponseTooLarge) {
+					t.Fatalf("Expected ErrResponseTooLarge, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "response too large") {
+					t.Errorf("Expected a clear error message, got %q", err)
+				}
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.requests {
+				t.Errorf("Expected %d requests without retries, got %d", tt.requests, got)
+			}
+		})
+	}
+}
+
+func TestMaxResponseSizeCoalesced(t *testing.T) {
+	const total = 256 << 20
+	var written int64
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		chunk := bytes.Repeat([]byte("x"), 4096)
+		for written < total {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			written += int64(len(chunk))
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithMaxResponseSize(64 << 10).WithRequestCoalescing(true)
+	if _, err := client.GET(server.URL); !errors.Is(err, httpclient.ErrResponseTooLarge) {
+		t.Fatalf("Expected ErrResponseTooLarge, got %v", err)
+	}
+
+	// The shared response is buffered for every caller, but only up to
+	// the limit: the connection is dropped long before the server is done
+	<-done
+	if written >= total {
+		t.Errorf("Expected the client to stop reading at the limit, the server wrote all %d bytes", written)
+	}
+}
+
+func TestTransportTimeouts(t *testing.T) {
+	addr := newSilentListener(t)
+	client := httpclient.New().
+		WithTimeout(5 * time.Second).
+		WithRetries(0).
+		WithCompression(true)
+
+	tests := []struct {
+		name   string
+		client httpclient.Client
+		url    string
+		want   string
+	}{
+		{"ResponseHeader", client.WithResponseHeaderTimeout(100 * time.Millisecond), "http://" + addr, "timeout awaiting response headers"},
+		{"TLSHandshake", client.WithTLSHandshakeTimeout(100 * time.Millisecond), "https://" + addr, "TLS handshake timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			_, err := tt.client.GET(tt.url)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Expected %q, got %v", tt.want, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Expected the phase timeout to fire before the overall one, took %s", elapsed)
+			}
+		})
+	}
+
+	t.Run("ExpectContinue", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Expect") != "100-continue" {
+				w.WriteHeader(http.StatusExpectationFailed)
+				return
+			}
+			io.Copy(w, r.Body)
+		}))
+		defer server.Close()
+
+		data, err := client.WithExpectContinue(true, time.Second).POST(server.URL, TestUser{Name: "John"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "John") {
+			t.Errorf("Expected the body sent after 100 Continue, got %q", data)
+		}
+	})
+}
+
+func TestAttemptAndOverallTimeout(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithClock(instantClock{}).
+		WithRetries(5).
+		WithAttemptTimeout(100 * time.Millisecond)
+
+	t.Run("Attempt", func(t *testing.T) {
+		atomic.StoreInt32(&attempts, 0)
+		start := time.Now()
+		_, err := client.GET(server.URL)
+		elapsed := time.Since(start)
+
+		if !errors.Is(err, httpclient.ErrAttemptTimeout) || !errors.Is(err, httpclient.ErrMaxRetries) {
+			t.Fatalf("Expected every attempt to time out, got %v", err)
+		}
+		if got := atomic.LoadInt32(&attempts); got != 6 {
+			t.Errorf("Expected 6 attempts, got %d", got)
+		}
+		if elapsed < 600*time.Millisecond || elapsed > 1500*time.Millisecond {
+			t.Errorf("Expected about 6 attempt timeouts of elapsed time, took %s", elapsed)
+		}
+	})
+
+	t.Run("Overall", func(t *testing.T) {
+		atomic.StoreInt32(&attempts, 0)
+		start := time.Now()
+		_, err := client.WithOverallTimeout(450 * time.Millisecond).GET(server.URL)
+		elapsed := time.Since(start)
+
+		if !errors.Is(err, httpclient.ErrOverallTimeout) {
+			t.Fatalf("Expected the overall timeout, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "overall timeout exceeded") {
+			t.Errorf("Expected the error to name the overall timeout, got %q", err)
+		}
+		if got := atomic.LoadInt32(&attempts); got != 5 {
+			t.Errorf("Expected the 5th attempt to be cut short, got %d attempts", got)
+		}
+		if elapsed < 450*time.Millisecond || elapsed > time.Second {
+			t.Errorf("Expected the call to end at the overall timeout, took %s", elapsed)
+		}
+	})
+}
+
+func TestAuthProvider(t *testing.T) {
+	var mu sync.Mutex
+	valid := "token-1"
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Header.Get("Authorization")+" "+string(body))
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// The provider caches its token and only fetches the next one when asked
+	// to refresh
+	var calls, refreshes int
+	current := 1
+	client := httpclient.New().WithRetries(0).WithAuthRefreshOn401(true).WithAuthProvider(func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if httpclient.IsAuthRefresh(ctx) {
+			refreshes++
+			current++
+		}
+		return fmt.Sprintf("token-%d", current), nil
+	})
+
+	// requests returns what the server received since the last call
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := seen
+		seen = nil
+		return got
+	}
+
+	if _, err := client.POST(server.URL, "first"); err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	if got := requests(); len(got) != 1 || got[0] != `Bearer token-1 "first"` {
+		t.Fatalf("Expected one request with the first token, got %q", got)
+	}
+
+	// The server rotates the token: the next request is rejected, refreshed
+	// and repeated with its body
+	mu.Lock()
+	valid = "token-2"
+	mu.Unlock()
+	if _, err := client.POST(server.URL, "second"); err != nil {
+		t.Fatalf("Expected the request to succeed after a refresh, got %v", err)
+	}
+	want := []string{`Bearer token-1 "second"`, `Bearer token-2 "second"`}
+	if got := requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if calls != 3 || refreshes != 1 {
+		t.Errorf("Expected 3 provider calls with 1 refresh, got %d and %d", calls, refreshes)
+	}
+
+	// A token the server never accepts is refreshed once, then the 401 is
+	// returned
+	mu.Lock()
+	valid = "none"
+	mu.Unlock()
+	_, err := client.GET(server.URL)
+	var apiErr *httpclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a 401 error, got %v", err)
+	}
+	if got := requests(); len(got) != 2 {
+		t.Errorf("Expected a single repeat after the refresh, got %q", got)
+	}
+
+	t.Run("ProviderError", func(t *testing.T) {
+		failing := httpclient.New().WithAuthProvider(func(ctx context.Context) (string, error) {
+			return "", errors.New("identity service down")
+		})
+		if _, err := failing.GET(server.URL); err == nil || !strings.Contains(err.Error(), "identity service down") {
+			t.Fatalf("Expected the provider error, got %v", err)
+		}
+		if got := requests(); len(got) != 0 {
+			t.Errorf("Expected no request without a token, got %q", got)
+		}
+	})
+}
+
+func TestAuthRefreshOn401(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// The first token has just expired, a refresh yields one the server takes
+	provider := func(ctx context.Context) (string, error) {
+		if httpclient.IsAuthRefresh(ctx) {
+			return "fresh", nil
+		}
+		return "expired", nil
+	}
+	// The server never takes the token, however often it is refreshed
+	rejected := func(ctx context.Context) (string, error) {
+		if httpclient.IsAuthRefresh(ctx) {
+			return "also-rejected", nil
+		}
+		return "rejected", nil
+	}
+
+	tests := []struct {
+		name     string
+		client   httpclient.Client
+		wantErr  bool
+		requests int32
+	}{
+		{"Refreshed", httpclient.New().WithAuthProvider(provider).WithAuthRefreshOn401(true), false, 2},
+		{"NotOptedIn", httpclient.New().WithAuthProvider(provider), true, 1},
+		{"SingleRetry", httpclient.New().WithAuthProvider(rejected).WithAuthRefreshOn401(true).WithRetries(3).WithClock(instantClock{}), true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			_, err := tt.client.GET(server.URL)
+			if tt.wantErr {
+				var apiErr *httpclient.APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+					t.Errorf("Expected a 401 error, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected the refreshed request to succeed, got %v", err)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.requests {
+				t.Errorf("Expected %d requests, got %d", tt.requests, got)
+			}
+		})
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	var mu sync.Mutex
+	var grants []string // grant and credentials of each token request
+	issued, valid := 0, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api" {
+			if r.Header.Get("Authorization") != "Bearer "+valid {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ok"))
+			return
+		}
+
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		grant := r.PostForm.Get("grant_type")
+		switch grant {
+		case "authorization_code":
+			grant += " " + r.PostForm.Get("code") + " " + r.PostForm.Get("redirect_uri")
+		case "refresh_token":
+			grant += " " + r.PostForm.Get("refresh_token")
+		case "client_credentials":
+			grant += " " + r.PostForm.Get("scope")
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		grants = append(grants, grant+" as "+id+":"+secret)
+		if strings.HasSuffix(grant, "revoked") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"refresh token revoked"}`))
+			return
+		}
+
+		issued++
+		valid = fmt.Sprintf("access-%d", issued)
+		token := map[string]interface{}{"access_token": valid, "token_type": "Bearer", "expires_in": 60}
+		// The third token comes without a refresh token, so the last one stays
+		if issued < 3 {
+			token["refresh_token"] = fmt.Sprintf("refresh-%d", issued)
+		}
+		json.NewEncoder(w).Encode(token)
+	}))
+	defer server.Close()
+
+	// sent returns the token requests since the last call
+	sent := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := grants
+		grants = nil
+		return got
+	}
+	get := func(client httpclient.Client) {
+		t.Helper()
+		if _, err := client.GET(server.URL + "/api"); err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+	}
+
+	t.Run("AuthCodeAndRefresh", func(t *testing.T) {
+		var stored []string
+		clock := newFakeClock()
+		client := httpclient.New().WithClock(clock).WithOAuth2(httpclient.OAuth2Config{
+			ClientID:     "app",
+			ClientSecret: "s3cret",
+			TokenURL:     server.URL + "/token",
+			AuthCode:     "code-1",
+			RedirectURL:  "https://app.example.com/callback",
+			OnToken: func(token httpclient.OAuth2Token) {
+				stored = append(stored, token.AccessToken+" "+token.RefreshToken)
+			},
+		})
+
+		get(client)
+		get(client)
+		want := []string{"authorization_code code-1 https://app.example.com/callback as app:s3cret"}
+		if got := sent(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected the code to be exchanged once, got %q", got)
+		}
+
+		// Shortly before it expires the token is refreshed
+		clock.Advance(55 * time.Second)
+		get(client)
+		if got, want := sent(), []string{"refresh_token refresh-1 as app:s3cret"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected a refresh before the expiry, got %q", got)
+		}
+
+		// A token the server stops taking is refreshed too
+		mu.Lock()
+		valid = "revoked"
+		mu.Unlock()
+		get(client)
+		if got, want := sent(), []string{"refresh_token refresh-2 as app:s3cret"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected a refresh after a 401, got %q", got)
+		}
+
+		wantStored := []string{"access-1 refresh-1", "access-2 refresh-2", "access-3 refresh-2"}
+		if !reflect.DeepEqual(stored, wantStored) {
+			t.Errorf("Expected OnToken with %q, got %q", wantStored, stored)
+		}
+	})
+
+	t.Run("SharedByClones", func(t *testing.T) {
+		client := httpclient.New().WithOAuth2(httpclient.OAuth2Config{
+			ClientID: "app",
+			TokenURL: server.URL + "/token",
+			AuthCode: "code-2",
+		})
+		before := client.WithHeader("X-Before", "1")
+
+		get(client)
+		get(before)
+		get(client.WithTimeout(time.Minute))
+		if got, want := sent(), []string{"authorization_code code-2  as app:"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected the code to be exchanged once for all clones, got %q", got)
+		}
+
+		// A new config starts a session of its own
+		get(client.WithOAuth2(httpclient.OAuth2Config{
+			ClientID:  "other",
+			TokenURL:  server.URL + "/token",
+			AuthStyle: httpclient.OAuth2AuthParams,
+		}))
+		if got, want := sent(), []string{"client_credentials  as other:"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected a token for the new config, got %q", got)
+		}
+	})
+
+	t.Run("ClientCredentials", func(t *testing.T) {
+		client := httpclient.New().WithOAuth2(httpclient.OAuth2Config{
+			ClientID:     "service",
+			ClientSecret: "s3cret",
+			TokenURL:     server.URL + "/token",
+			Scopes:       []string{"read", "write"},
+			AuthStyle:    httpclient.OAuth2AuthParams,
+		})
+		get(client)
+		if got, want := sent(), []string{"client_credentials read write as service:s3cret"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("InvalidGrant", func(t *testing.T) {
+		client := httpclient.New().WithOAuth2(httpclient.OAuth2Config{
+			ClientID:     "app",
+			TokenURL:     server.URL + "/token",
+			RefreshToken: "revoked",
+		})
+		_, err := client.GET(server.URL + "/api")
+		var oauthErr *httpclient.OAuth2Error
+		if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" || oauthErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected an invalid_grant error, got %v", err)
+		}
+		sent()
+	})
+}
+
+func TestPathParams(t *testing.T) {
+	var requests int32
+	var mu sync.Mutex
+	var routes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithBaseURL(server.URL + "/v2").
+		WithMiddleware(httpclient.MiddlewareFunc(func(r *http.Request) error {
+			mu.Lock()
+			routes = append(routes, httpclient.RequestRoute(r))
+			mu.Unlock()
+			return nil
+		}, nil))
+
+	tests := []struct {
+		name     string
+		template string
+		params   map[string]string
+		want     string
+	}{
+		{"Plain", "/users/{id}/posts/{slug}", map[string]string{"id": "42", "slug": "hello world"}, "/v2/users/42/posts/hello%20world"},
+		{"Slash", "/files/{name}", map[string]string{"name": "a/../b"}, "/v2/files/a%2F..%2Fb"},
+		{"Unicode", "/users/{name}", map[string]string{"name": "zoë/日本"}, "/v2/users/zo%C3%AB%2F%E6%97%A5%E6%9C%AC"},
+		{"Reserved", "/search/{q}", map[string]string{"q": "50%?x#y"}, "/v2/search/50%25%3Fx%23y"},
+		{"PartialSegment", "/reports/{year}-{month}.csv", map[string]string{"year": "2024", "month": "01"}, "/v2/reports/2024-01.csv"},
+		{"Dots", "/files/{name}", map[string]string{"name": "..."}, "/v2/files/..."},
+		{"QueryKept", "/users/{id}?fields={a}", map[string]string{"id": "7"}, "/v2/users/7?fields={a}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			routes = nil
+			mu.Unlock()
+
+			data, err := client.GET(tt.template, httpclient.WithPathParams(tt.params))
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, data)
+			}
+			route := strings.SplitN(tt.template, "?", 2)[0]
+			mu.Lock()
+			if len(routes) != 1 || routes[0] != route {
+				t.Errorf("Expected the route %q, got %q", route, routes)
+			}
+			mu.Unlock()
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		invalid := []map[string]string{
+			{},                                     // missing both
+			{"id": "1"},                            // missing slug
+			{"id": "1", "slug": "x", "extra": "y"}, // extra
+			{"id": "..", "slug": "x"},              // parent segment
+			{"id": ".", "slug": "x"},               // current segment
+			{"id": "1", "slug": ""},                // empty segment
+		}
+		for _, params := range invalid {
+			_, err := client.GET("/users/{id}/posts/{slug}", httpclient.WithPathParams(params))
+			if !errors.Is(err, httpclient.ErrPathParams) {
+				t.Errorf("Expected ErrPathParams for %v, got %v", params, err)
+			}
+		}
+		if got := atomic.LoadInt32(&requests); got != 0 {
+			t.Errorf("Expected no request to be sent, got %d", got)
+		}
+	})
+
+	t.Run("GetAs", func(t *testing.T) {
+		got, err := httpclient.GetAs[string](context.Background(), httpclient.New().WithBaseURL(server.URL),
+			"/echo/{id}", httpclient.WithPathParams(map[string]string{"id": "x y"}))
+		var decodeErr *httpclient.DecodeError
+		if !errors.As(err, &decodeErr) || !strings.Contains(decodeErr.Snippet, "/echo/x%20y") {
+			t.Errorf("Expected the expanded path in the body, got %q, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+//go:build brotli
+
+package test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestBrotliDecompression(t *testing.T) {
+	runEncodingTests(t, []encodingTest{
+		{"Brotli", "br", func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }},
+	})
+}
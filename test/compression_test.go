@@ -0,0 +1,266 @@
+package test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+// encodingTest describes a response body compressed with encoding
+type encodingTest struct {
+	name     string
+	encoding string
+	encode   func(w io.Writer) io.WriteCloser
+}
+
+var payload = strings.Repeat(`{"id": 1, "name": "John Doe"}`, 100)
+
+func gzipWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func zlibWriter(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+
+func flateWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return fw
+}
+
+// runEncodingTests serves payload compressed per test and checks that the
+// client decodes it
+func runEncodingTests(t *testing.T, tests []encodingTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var buf bytes.Buffer
+				encoder := tt.encode(&buf)
+				encoder.Write([]byte(payload))
+				encoder.Close()
+
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write(buf.Bytes())
+			}))
+			defer server.Close()
+
+			var headers http.Header
+			client := httpclient.New().
+				WithCompression(true).
+				WithResponseInterceptor(func(resp *http.Response) error {
+					headers = resp.Header
+					return nil
+				})
+
+			data, err := client.GET(server.URL)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if string(data) != payload {
+				t.Errorf("Decoded body mismatch: got %d bytes", len(data))
+			}
+			if headers.Get("Content-Encoding") != "" || headers.Get("Content-Length") != "" {
+				t.Errorf("Expected encoding headers to be stripped, got %v", headers)
+			}
+		})
+	}
+}
+
+func TestResponseDecompression(t *testing.T) {
+	runEncodingTests(t, []encodingTest{
+		{"Gzip", "gzip", gzipWriter},
+		{"Deflate", "deflate", zlibWriter},
+		{"RawDeflate", "deflate", flateWriter},
+		{"Stacked", "deflate, gzip", func(w io.Writer) io.WriteCloser {
+			gz := gzip.NewWriter(w)
+			return &stackedWriter{zlib.NewWriter(gz), gz}
+		}},
+	})
+}
+
+// stackedWriter closes an encoder and then the encoder it writes into
+type stackedWriter struct {
+	io.WriteCloser
+	inner io.WriteCloser
+}
+
+func (s *stackedWriter) Close() error {
+	s.WriteCloser.Close()
+	return s.inner.Close()
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "compress")
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	_, err := httpclient.New().WithCompression(true).WithRetries(0).GET(server.URL)
+	if !errors.Is(err, httpclient.ErrUnsupportedEncoding) {
+		t.Errorf("Expected ErrUnsupportedEncoding, got %v", err)
+	}
+
+	for _, encoding := range strings.Split(acceptEncoding, ", ") {
+		switch encoding {
+		case "gzip", "deflate", "br", "zstd":
+		default:
+			t.Errorf("Unexpected encoding %q advertised in %q", encoding, acceptEncoding)
+		}
+	}
+}
+
+func TestGzipConnectionReuse(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(payload))
+	gz.Close()
+	compressed := buf.Bytes()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		// Every tenth response is corrupt, so decoding stops before the
+		// body has been read
+		if atomic.AddInt32(&requests, 1)%10 == 0 {
+			w.Write(bytes.Repeat([]byte("not gzip"), 8<<10))
+			return
+		}
+		w.Write(compressed)
+	}))
+	defer server.Close()
+
+	var reused, fresh int32
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt32(&reused, 1)
+			} else {
+				atomic.AddInt32(&fresh, 1)
+			}
+		},
+	})
+
+	client := httpclient.New().WithCompression(true).WithRetries(0)
+	for i := 1; i <= 50; i++ {
+		data, err := client.GetContext(ctx, server.URL)
+		if i%10 == 0 {
+			if err == nil {
+				t.Errorf("Request %d: expected error for corrupt gzip body", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if string(data) != payload {
+			t.Fatalf("Request %d: decoded body mismatch", i)
+		}
+	}
+
+	if fresh != 1 || reused != 49 {
+		t.Errorf("Expected 1 new and 49 reused connections, got %d new and %d reused", fresh, reused)
+	}
+}
+
+// readRequestBody returns the decoded request body and its encoding
+func readRequestBody(r *http.Request) (string, string) {
+	var body io.Reader = r.Body
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return "", encoding
+		}
+		body = gz
+	}
+	data, _ := io.ReadAll(body)
+	return string(data), encoding
+}
+
+func TestRequestCompressionThreshold(t *testing.T) {
+	type received struct{ body, encoding string }
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, encoding := readRequestBody(r)
+		requests <- received{body, encoding}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithCompression(true).WithCompressionMinSize(256)
+
+	tests := []struct {
+		name     string
+		body     interface{}
+		encoding string
+	}{
+		{"Small", TestUser{Name: "John"}, ""},
+		{"Large", TestUser{Name: strings.Repeat("John", 100)}, "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.POST(server.URL, tt.body); err != nil {
+				t.Fatalf("POST failed: %v", err)
+			}
+			got := <-requests
+			if got.encoding != tt.encoding {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.encoding, got.encoding)
+			}
+			if !strings.Contains(got.body, `"name"`) {
+				t.Errorf("Server received unexpected body: %s", got.body)
+			}
+		})
+	}
+}
+
+func TestRequestCompressionRetry(t *testing.T) {
+	var attempts int32
+	bodies := make(chan string, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, encoding := readRequestBody(r)
+		if encoding != "gzip" {
+			t.Errorf("Attempt %d: expected gzip body, got %q", atomic.LoadInt32(&attempts)+1, encoding)
+		}
+		bodies <- body
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	user := TestUser{Name: strings.Repeat("John", 500)}
+	data, err := httpclient.New().
+		WithCompression(true).
+		WithRetries(3).
+		WithRetryNonIdempotent(true).
+		POST(server.URL, user)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	if string(data) != "ok" {
+		t.Errorf("Unexpected response: %s", data)
+	}
+
+	close(bodies)
+	var first string
+	for body := range bodies {
+		if first == "" {
+			first = body
+		}
+		if body == "" || body != first {
+			t.Errorf("Expected every attempt to carry the full body, got %d bytes", len(body))
+		}
+	}
+}
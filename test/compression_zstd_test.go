@@ -0,0 +1,19 @@
+//go:build zstd
+
+package test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdDecompression(t *testing.T) {
+	runEncodingTests(t, []encodingTest{
+		{"Zstd", "zstd", func(w io.Writer) io.WriteCloser {
+			encoder, _ := zstd.NewWriter(w)
+			return encoder
+		}},
+	})
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestTRACE(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "message/http")
+		w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	data, err := httpclient.New().TRACE(server.URL + "/diagnostics")
+	if err != nil {
+		t.Fatalf("TRACE failed: %v", err)
+	}
+	if string(data) != "TRACE /diagnostics" {
+		t.Errorf("Unexpected response: %s", data)
+	}
+}
+
+// newEchoServer starts a TCP server that echoes everything it reads
+func newEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	target := newEchoServer(t)
+
+	var tunnels int32
+	proxy := newConnectProxy(t, "user", "secret", &tunnels)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	t.Run("ViaProxy", func(t *testing.T) {
+		conn, err := httpclient.New().WithProxy(proxyURL.String()).Connect(target)
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Errorf("Expected echo of ping, got %q", buf)
+		}
+		if atomic.LoadInt32(&tunnels) != 1 {
+			t.Errorf("Expected 1 tunnel through proxy, got %d", tunnels)
+		}
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		badURL := *proxyURL
+		badURL.User = url.UserPassword("user", "wrong")
+
+		if _, err := httpclient.New().WithProxy(badURL.String()).Connect(target); err == nil {
+			t.Error("Expected error when the proxy refuses the tunnel")
+		}
+	})
+
+	t.Run("Direct", func(t *testing.T) {
+		conn, err := httpclient.New().WithoutProxy().Connect(target)
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		conn.Close()
+	})
+}
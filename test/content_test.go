@@ -0,0 +1,234 @@
+package test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	htmlPage := "<!DOCTYPE html><html><body><h1>502 Bad Gateway</h1>" + strings.Repeat("<p>padding</p>", 100) + "</body></html>"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(htmlPage))
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<user><id>1</id><name>John</name></user>`))
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.Write([]byte(`{"id": 2, "name": "Jane"}`))
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		case "/msgpack":
+			w.Header().Set("Content-Type", "application/msgpack")
+			w.Write([]byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa4, 'J', 'o', 'h', 'n'})
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL)
+
+	t.Run("HTMLForJSON", func(t *testing.T) {
+		var user TestUser
+		err := client.JSON("GET", "/html", nil, &user)
+		if !errors.Is(err, httpclient.ErrUnexpectedContentType) {
+			t.Fatalf("Expected ErrUnexpectedContentType, got %v", err)
+		}
+		var ctErr *httpclient.ContentTypeError
+		if !errors.As(err, &ctErr) {
+			t.Fatalf("Expected ContentTypeError, got %T", err)
+		}
+		if ctErr.ContentType != "text/html; charset=utf-8" {
+			t.Errorf("Unexpected content type %q", ctErr.ContentType)
+		}
+		if len(ctErr.Body) != 512 || !strings.HasPrefix(string(ctErr.Body), "<!DOCTYPE html>") {
+			t.Errorf("Expected the first 512 bytes of the body, got %d bytes", len(ctErr.Body))
+		}
+		if !strings.Contains(err.Error(), "502 Bad Gateway") {
+			t.Errorf("Expected the body in the error message, got %v", err)
+		}
+	})
+
+	t.Run("XML", func(t *testing.T) {
+		var user struct {
+			XMLName xml.Name `xml:"user"`
+			ID      int      `xml:"id"`
+			Name    string   `xml:"name"`
+		}
+		if err := client.JSON("GET", "/xml", nil, &user); err != nil {
+			t.Fatalf("XML decode failed: %v", err)
+		}
+		if user.ID != 1 || user.Name != "John" {
+			t.Errorf("Unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("JSONSuffix", func(t *testing.T) {
+		var user TestUser
+		if err := client.JSON("GET", "/problem", nil, &user); err != nil {
+			t.Fatalf("JSON decode failed: %v", err)
+		}
+		if user.Name != "Jane" {
+			t.Errorf("Unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		var text string
+		if err := client.JSON("GET", "/text", nil, &text); err != nil || text != "hello" {
+			t.Errorf("Expected hello, got %q, %v", text, err)
+		}
+
+		var user TestUser
+		if err := client.JSON("GET", "/text", nil, &user); !errors.Is(err, httpclient.ErrUnexpectedContentType) {
+			t.Errorf("Expected ErrUnexpectedContentType for a struct target, got %v", err)
+		}
+	})
+
+	t.Run("RegisteredDecoder", func(t *testing.T) {
+		var user TestUser
+		if err := client.JSON("GET", "/msgpack", nil, &user); !errors.Is(err, httpclient.ErrUnexpectedContentType) {
+			t.Fatalf("Expected ErrUnexpectedContentType before registering, got %v", err)
+		}
+
+		var calls int
+		httpclient.RegisterDecoder("application/msgpack", func(data []byte, result interface{}) error {
+			calls++
+			// Just enough msgpack for a one-entry map of short strings
+			key := string(data[2 : 2+int(data[1]&0x1f)])
+			rest := data[2+len(key):]
+			if key == "name" {
+				result.(*TestUser).Name = string(rest[1 : 1+int(rest[0]&0x1f)])
+			}
+			return nil
+		})
+		defer httpclient.RegisterDecoder("application/msgpack", nil)
+
+		if err := client.JSON("GET", "/msgpack", nil, &user); err != nil {
+			t.Fatalf("Registered decoder failed: %v", err)
+		}
+		if calls != 1 || user.Name != "John" {
+			t.Errorf("Expected the registered decoder to be invoked once, got %d calls and %+v", calls, user)
+		}
+	})
+}
+
+// recordingCodec is encoding/json that records what it encodes and decodes
+type recordingCodec struct {
+	marshaled   []interface{}
+	unmarshaled []string
+}
+
+func (c *recordingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.marshaled = append(c.marshaled, v)
+	return json.Marshal(v)
+}
+
+func (c *recordingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshaled = append(c.unmarshaled, string(data))
+	return json.Unmarshal(data, v)
+}
+
+func TestCodec(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 2, "name": "Jane"}`))
+	}))
+	defer server.Close()
+
+	codec := &recordingCodec{}
+	client := httpclient.New().WithBaseURL(server.URL).WithCodec(codec)
+
+	var user TestUser
+	if err := client.JSON("POST", "/users", TestUser{ID: 1, Name: "John"}, &user); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if len(codec.marshaled) != 1 || codec.marshaled[0] != (TestUser{ID: 1, Name: "John"}) {
+		t.Errorf("Expected the codec to encode the request body, got %v", codec.marshaled)
+	}
+	if string(received) != `{"id":1,"name":"John"}` {
+		t.Errorf("Expected the encoded body to be sent, got %s", received)
+	}
+	if len(codec.unmarshaled) != 1 || user != (TestUser{ID: 2, Name: "Jane"}) {
+		t.Errorf("Expected the codec to decode the response, got %v into %+v", codec.unmarshaled, user)
+	}
+
+	// A nil codec restores encoding/json
+	if err := client.WithCodec(nil).JSON("POST", "/users", TestUser{ID: 1}, &user); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if len(codec.marshaled) != 1 || len(codec.unmarshaled) != 1 {
+		t.Errorf("Expected the codec to be unused after WithCodec(nil), got %d and %d calls", len(codec.marshaled), len(codec.unmarshaled))
+	}
+}
+
+func TestAccept(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Header.Get("Accept"))
+	}))
+	defer server.Close()
+	client := httpclient.New().WithBaseURL(server.URL)
+
+	many := make([]string, 11)
+	for i := range many {
+		many[i] = "application/vnd.v" + string(rune('a'+i)) + "+json"
+	}
+
+	tests := []struct {
+		name   string
+		client httpclient.Client
+		want   string
+	}{
+		{"JSONDefault", client, "application/json"},
+		{"Weighted", client.WithAccept("application/json", "application/xml", "*/*"), "application/json, application/xml;q=0.9, */*;q=0.8"},
+		{"ExplicitQ", client.WithAccept("text/html", "text/plain;q=0.2"), "text/html, text/plain;q=0.2"},
+		{"Single", client.WithAccept("application/cbor"), "application/cbor"},
+		{"Cleared", client.WithAccept("text/html").WithAccept(), "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accept string
+			if err := tt.client.JSON("GET", "/", nil, &accept); err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if accept != tt.want {
+				t.Errorf("Expected Accept %q, got %q", tt.want, accept)
+			}
+		})
+	}
+
+	t.Run("ManyTypes", func(t *testing.T) {
+		var accept string
+		if err := client.WithAccept(many...).JSON("GET", "/", nil, &accept); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		parts := strings.Split(accept, ", ")
+		if len(parts) != 11 || parts[1] != many[1]+";q=0.91" || parts[10] != many[10]+";q=0.1" {
+			t.Errorf("Expected 11 types weighted down to q=0.1, got %q", accept)
+		}
+	})
+
+	t.Run("NotJSON", func(t *testing.T) {
+		data, err := client.GET("/")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if string(data) != "\"\"\n" {
+			t.Errorf("Expected no Accept header outside the JSON methods, got %s", data)
+		}
+	})
+}
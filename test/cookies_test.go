@@ -0,0 +1,62 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestPersistentCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/", Expires: time.Now().Add(time.Hour)})
+			http.SetCookie(w, &http.Cookie{Name: "flash", Value: "gone", Path: "/", MaxAge: 1})
+			http.SetCookie(w, &http.Cookie{Name: "stale", Value: "old", Path: "/", Expires: time.Now().Add(-time.Hour)})
+		case "/me":
+			if c, err := r.Cookie("session"); err != nil || c.Value != "abc123" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	jar, err := httpclient.PersistentCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := httpclient.New().WithRetries(0).WithCookieJar(jar).GET(server.URL + "/login"); err != nil {
+		t.Fatal(err)
+	}
+	if err := jar.Err(); err != nil {
+		t.Fatalf("Saving cookies failed: %v", err)
+	}
+
+	// Let the short-lived cookie expire before the "restart"
+	time.Sleep(1100 * time.Millisecond)
+
+	restored, err := httpclient.PersistentCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL)
+	got := map[string]string{}
+	for _, c := range restored.Cookies(u) {
+		got[c.Name] = c.Value
+	}
+	if len(got) != 1 || got["session"] != "abc123" {
+		t.Errorf("Expected only the unexpired session cookie to survive, got %v", got)
+	}
+
+	if _, err := httpclient.New().WithRetries(0).WithCookieJar(restored).GET(server.URL + "/me"); err != nil {
+		t.Errorf("Expected the restored session to be sent: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/dns"
+)
+
+// countingResolver resolves every host to loopback and counts lookups
+type countingResolver struct {
+	lookups int32
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	if host == "missing.test" {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+// newDNSTestServer returns a server and the URL of it under a fake hostname
+func newDNSTestServer(t testing.TB) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	u, _ := url.Parse(server.URL)
+	return server, "http://counting.test:" + u.Port()
+}
+
+func TestCustomResolver(t *testing.T) {
+	server, baseURL := newDNSTestServer(t)
+	defer server.Close()
+
+	resolver := &countingResolver{}
+	client := httpclient.New().
+		WithResolver(resolver).
+		WithHeaders(map[string]string{"Connection": "close"}).
+		WithRetries(0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GET(baseURL + "/"); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	// Without a cache every new connection resolves again
+	if got := atomic.LoadInt32(&resolver.lookups); got != 3 {
+		t.Errorf("Expected 3 lookups, got %d", got)
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	server, baseURL := newDNSTestServer(t)
+	defer server.Close()
+
+	resolver := &countingResolver{}
+	client := httpclient.New().
+		WithResolver(resolver).
+		WithDNSCache(time.Minute, time.Minute, 100).
+		WithHeaders(map[string]string{"Connection": "close"}).
+		WithRetries(0)
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.GET(baseURL + "/"); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&resolver.lookups); got != 1 {
+		t.Errorf("Expected 1 lookup with cache, got %d", got)
+	}
+
+	// Backup clients share the cache of their parent
+	backup := client.
+		WithLoadBalancer([]string{"http://missing.test"}, "round-robin").
+		WithBackupEndpoints([]string{baseURL})
+	for i := 0; i < 2; i++ {
+		if _, err := backup.GET("/"); err != nil {
+			t.Fatalf("Request via backup endpoint failed: %v", err)
+		}
+	}
+	// One lookup for the missing host, which is then negatively cached
+	if got := atomic.LoadInt32(&resolver.lookups); got != 2 {
+		t.Errorf("Expected 2 lookups after backup requests, got %d", got)
+	}
+}
+
+func TestDNSCacheExpiryAndSize(t *testing.T) {
+	clk := newFakeClock()
+	resolver := &countingResolver{}
+	cache := dns.NewCache(time.Minute, 10*time.Second, 2, clk)
+	ctx := context.Background()
+
+	cache.Lookup(ctx, resolver, "a.test")
+	cache.Lookup(ctx, resolver, "a.test")
+	if got := atomic.LoadInt32(&resolver.lookups); got != 1 {
+		t.Fatalf("Expected 1 lookup, got %d", got)
+	}
+
+	if _, err := cache.Lookup(ctx, resolver, "missing.test"); err == nil {
+		t.Fatal("Expected error for missing host")
+	}
+	clk.Advance(11 * time.Second)
+	cache.Lookup(ctx, resolver, "missing.test")
+	if got := atomic.LoadInt32(&resolver.lookups); got != 3 {
+		t.Errorf("Expected negative entry to expire, got %d lookups", got)
+	}
+
+	cache.Lookup(ctx, resolver, "b.test")
+	cache.Lookup(ctx, resolver, "c.test")
+	if cache.Len() != 2 {
+		t.Errorf("Expected cache capped at 2 entries, got %d", cache.Len())
+	}
+
+	clk.Advance(2 * time.Minute)
+	before := atomic.LoadInt32(&resolver.lookups)
+	cache.Lookup(ctx, resolver, "c.test")
+	if got := atomic.LoadInt32(&resolver.lookups); got != before+1 {
+		t.Errorf("Expected positive entry to expire")
+	}
+}
+
+func TestDNSCacheContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := dns.ResolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		<-release
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	})
+
+	cache := dns.NewCache(time.Minute, 0, 10, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := cache.Lookup(ctx, slow, "slow.test"); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func BenchmarkDNSCache(b *testing.B) {
+	server, baseURL := newDNSTestServer(b)
+	defer server.Close()
+
+	for _, bc := range []struct {
+		name   string
+		cached bool
+	}{{"NoCache", false}, {"Cache", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			resolver := &countingResolver{}
+			client := httpclient.New().
+				WithResolver(resolver).
+				WithHeaders(map[string]string{"Connection": "close"}).
+				WithRetries(0)
+			if bc.cached {
+				client = client.WithDNSCache(time.Minute, time.Minute, 100)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.GET(baseURL + "/"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt32(&resolver.lookups))/float64(b.N), "lookups/op")
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-ID", "abc123")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code": "invalid_name", "message": "name is required"}`))
+	}))
+	defer server.Close()
+
+	_, err := httpclient.New().POST(server.URL, TestUser{})
+	if err == nil {
+		t.Fatal("Expected error for 422 response")
+	}
+
+	var apiErr *httpclient.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %T: %v", err, err)
+	}
+
+	if apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", apiErr.StatusCode)
+	}
+	if apiErr.Headers.Get("X-Request-ID") != "abc123" {
+		t.Errorf("Expected response headers on error, got %v", apiErr.Headers)
+	}
+
+	var payload struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := apiErr.Unmarshal(&payload); err != nil {
+		t.Fatalf("Unmarshal error body failed: %v", err)
+	}
+	if payload.Code != "invalid_name" || payload.Message != "name is required" {
+		t.Errorf("Unexpected error payload: %+v", payload)
+	}
+
+	expected := `HTTP 422: {"code": "invalid_name", "message": "name is required"}`
+	if err.Error() != expected {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	t.Run("CircuitOpen", func(t *testing.T) {
+		client := httpclient.New().
+			WithCircuitBreaker(2, time.Minute).
+			WithRetries(0)
+
+		for i := 0; i < 2; i++ {
+			if _, err := client.GET(failing.URL); errors.Is(err, httpclient.ErrCircuitOpen) {
+				t.Fatalf("Circuit opened before threshold on request %d", i)
+			}
+		}
+
+		_, err := client.GET(failing.URL)
+		if !errors.Is(err, httpclient.ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen, got %v", err)
+		}
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		client := httpclient.New().WithRateLimiter(1)
+
+		if _, err := client.GET(healthy.URL); err != nil {
+			t.Fatalf("First request failed: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := client.GetContext(ctx, healthy.URL)
+		if !errors.Is(err, httpclient.ErrRateLimited) {
+			t.Errorf("Expected ErrRateLimited, got %v", err)
+		}
+	})
+
+	t.Run("NotWhitelisted", func(t *testing.T) {
+		client := httpclient.New().WithIPWhitelist([]string{"10.0.0.1"})
+
+		_, err := client.GET(healthy.URL)
+		if !errors.Is(err, httpclient.ErrNotWhitelisted) {
+			t.Errorf("Expected ErrNotWhitelisted, got %v", err)
+		}
+	})
+
+	t.Run("MaxRetries", func(t *testing.T) {
+		client := httpclient.New().WithRetries(1)
+
+		_, err := client.GET(failing.URL)
+		if !errors.Is(err, httpclient.ErrMaxRetries) {
+			t.Errorf("Expected ErrMaxRetries, got %v", err)
+		}
+
+		var apiErr *httpclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Expected wrapped APIError with status 500, got %v", err)
+		}
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusRequestTimeout)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	client := httpclient.New().
+		WithClock(instantClock{}).
+		WithRetries(3).
+		WithOnRetry(func(attempt int, err error, nextDelay time.Duration) {
+			delays = append(delays, nextDelay)
+		})
+
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+
+	// The 429 waits as long as Retry-After asks, the 408 backs off as usual
+	want := []time.Duration{7 * time.Second, 2 * time.Second}
+	if len(delays) != len(want) || delays[0] != want[0] || delays[1] != want[1] {
+		t.Errorf("Expected delays %v, got %v", want, delays)
+	}
+}
+
+func TestRetryAfterCappedAtMaxDelay(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	client := httpclient.New().
+		WithClock(instantClock{}).
+		WithRetries(1).
+		WithOnRetry(func(attempt int, err error, nextDelay time.Duration) {
+			delays = append(delays, nextDelay)
+		})
+
+	if _, err := client.GET(server.URL); err != nil {
+		t.Fatalf("Expected the second attempt to succeed, got %v", err)
+	}
+
+	// A day-long Retry-After waits no longer than the 30s maximum delay
+	if len(delays) != 1 || delays[0] != 30*time.Second {
+		t.Errorf("Expected a single 30s delay, got %v", delays)
+	}
+}
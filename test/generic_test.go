@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestGetAs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"id": 1, "name": "John", "email": "john@example.com"}`))
+		case "/users":
+			w.Write([]byte(`[{"id": 1, "name": "John"}, {"id": 2, "name": "Jane"}]`))
+		case "/counts":
+			w.Write([]byte(`{"a": 1, "b": 2}`))
+		case "/broken":
+			w.Write([]byte(`{"id": 1, "name": "John", "tags": [1, 2,, 3]}`))
+		case "/wrongtype":
+			w.Write([]byte(`{"id": "one", "name": "John"}`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := httpclient.New().WithBaseURL(server.URL)
+
+	t.Run("Struct", func(t *testing.T) {
+		user, err := httpclient.GetAs[TestUser](ctx, client, "/user")
+		if err != nil {
+			t.Fatalf("GetAs failed: %v", err)
+		}
+		if user.ID != 1 || user.Name != "John" {
+			t.Errorf("Unexpected user: %+v", user)
+		}
+	})
+
+	t.Run("Slice", func(t *testing.T) {
+		users, err := httpclient.GetAs[[]TestUser](ctx, client, "/users")
+		if err != nil {
+			t.Fatalf("GetAs failed: %v", err)
+		}
+		if len(users) != 2 || users[1].Name != "Jane" {
+			t.Errorf("Unexpected users: %+v", users)
+		}
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		counts, err := httpclient.GetAs[map[string]int](ctx, client, "/counts")
+		if err != nil {
+			t.Fatalf("GetAs failed: %v", err)
+		}
+		if len(counts) != 2 || counts["b"] != 2 {
+			t.Errorf("Unexpected counts: %v", counts)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		user, err := httpclient.GetAs[*TestUser](ctx, client, "/empty")
+		if err != nil || user != nil {
+			t.Errorf("Expected nil user and no error, got %+v, %v", user, err)
+		}
+	})
+
+	t.Run("DisallowUnknownFields", func(t *testing.T) {
+		_, err := httpclient.GetAs[TestUser](ctx, client, "/user", httpclient.DisallowUnknownFields())
+		var decodeErr *httpclient.DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("Expected DecodeError, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "email") {
+			t.Errorf("Expected error to name the unknown field, got %v", err)
+		}
+	})
+
+	t.Run("SyntaxError", func(t *testing.T) {
+		_, err := httpclient.GetAs[TestUser](ctx, client, "/broken")
+		var decodeErr *httpclient.DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("Expected DecodeError, got %v", err)
+		}
+		if !strings.Contains(decodeErr.Snippet, "2,, 3") {
+			t.Errorf("Expected snippet around the offending JSON, got %q", decodeErr.Snippet)
+		}
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Expected wrapped json.SyntaxError, got %v", err)
+		}
+	})
+
+	t.Run("TypeError", func(t *testing.T) {
+		_, err := httpclient.GetAs[TestUser](ctx, client, "/wrongtype")
+		var decodeErr *httpclient.DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("Expected DecodeError, got %v", err)
+		}
+		if !strings.Contains(decodeErr.Snippet, `"one"`) {
+			t.Errorf("Expected snippet around the offending JSON, got %q", decodeErr.Snippet)
+		}
+	})
+}
+
+func TestPostAs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user TestUser
+		json.NewDecoder(r.Body).Decode(&user)
+		user.ID = 42
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}))
+	defer server.Close()
+
+	user, err := httpclient.PostAs[TestUser](context.Background(), httpclient.New(), server.URL, TestUser{Name: "John"})
+	if err != nil {
+		t.Fatalf("PostAs failed: %v", err)
+	}
+	if user.ID != 42 || user.Name != "John" {
+		t.Errorf("Unexpected user: %+v", user)
+	}
+}
@@ -0,0 +1,915 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/graphql"
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+const testGraphQLSchema = `{"data": {"__schema": {
+	"queryType": {"name": "Query"},
+	"mutationType": null,
+	"subscriptionType": null,
+	"types": [
+		{"kind": "OBJECT", "name": "Query", "fields": [
+			{"name": "user", "args": [
+				{"name": "id", "defaultValue": null, "type": {"kind": "NON_NULL", "name": null, "ofType": {"kind": "SCALAR", "name": "ID", "ofType": null}}}
+			], "type": {"kind": "OBJECT", "name": "User", "ofType": null}}
+		]},
+		{"kind": "OBJECT", "name": "User", "fields": [
+			{"name": "id", "args": [], "type": {"kind": "NON_NULL", "name": null, "ofType": {"kind": "SCALAR", "name": "ID", "ofType": null}}},
+			{"name": "name", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}}
+		]},
+		{"kind": "SCALAR", "name": "ID"},
+		{"kind": "SCALAR", "name": "String"}
+	]
+}}}`
+
+func TestGraphQLValidation(t *testing.T) {
+	var introspections, queries int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.GraphQLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.Query, "__schema") {
+			atomic.AddInt32(&introspections, 1)
+			w.Write([]byte(testGraphQLSchema))
+			return
+		}
+		atomic.AddInt32(&queries, 1)
+		w.Write([]byte(`{"data": {"user": {"id": 1, "name": "John"}}}`))
+	}))
+	defer server.Close()
+
+	gc := graphql.NewGraphQLClient(server.URL, http.DefaultClient).WithValidation()
+
+	var result struct {
+		User TestUser `json:"user"`
+	}
+	query := `query GetUser($id: ID!) { user(id: $id) { id name } }`
+	if err := gc.Query(query, map[string]interface{}{"id": 1}, &result); err != nil {
+		t.Fatalf("Valid query failed: %v", err)
+	}
+	if result.User.Name != "John" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		expected  string
+	}{
+		{
+			name:      "UnknownField",
+			query:     `query GetUser($id: ID!) { user(id: $id) { id nmae } }`,
+			variables: map[string]interface{}{"id": "1"},
+			expected:  `cannot query field "nmae" on type "User"`,
+		},
+		{
+			name:     "MissingVariable",
+			query:    `query GetUser($id: ID!) { user(id: $id) { id } }`,
+			expected: "variable $id of required type ID! was not provided",
+		},
+		{
+			name:      "UndefinedVariable",
+			query:     `query { user(id: $id) { id } }`,
+			variables: map[string]interface{}{"id": "1"},
+			expected:  "variable $id is not defined",
+		},
+		{
+			name:      "WrongVariableType",
+			query:     `query GetUser($id: ID!) { user(id: $id) { id } }`,
+			variables: map[string]interface{}{"id": true},
+			expected:  "$id: expected ID, got boolean",
+		},
+		{
+			name:     "MissingSubfields",
+			query:    `{ user(id: "1") }`,
+			expected: `field "user" of type User must have a selection of subfields`,
+		},
+		{
+			name:     "SyntaxError",
+			query:    `{ user(id: "1") { id }`,
+			expected: "syntax error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := gc.Query(tt.query, tt.variables, nil)
+			var validationErr *graphql.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// Invalid queries never reach the server and the schema is fetched once
+	if got := atomic.LoadInt32(&queries); got != 1 {
+		t.Errorf("Expected 1 query to be sent, got %d", got)
+	}
+	if got := atomic.LoadInt32(&introspections); got != 1 {
+		t.Errorf("Expected the schema to be introspected once, got %d", got)
+	}
+
+	t.Run("Client", func(t *testing.T) {
+		atomic.StoreInt32(&introspections, 0)
+		atomic.StoreInt32(&queries, 0)
+		client := httpclient.New().WithBaseURL(server.URL).WithGraphQLValidation(true)
+
+		if err := client.GraphQL(query, map[string]interface{}{"id": "1"}, &result); err != nil {
+			t.Fatalf("Valid query failed: %v", err)
+		}
+		err := client.GraphQL(`query GetUser($id: ID!) { user(id: $id) { id nmae } }`, map[string]interface{}{"id": "1"}, nil)
+		var validationErr *httpclient.GraphQLValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Expected GraphQLValidationError, got %v", err)
+		}
+		if got := atomic.LoadInt32(&queries); got != 1 {
+			t.Errorf("Expected 1 query to be sent, got %d", got)
+		}
+		if got := atomic.LoadInt32(&introspections); got != 1 {
+			t.Errorf("Expected the schema to be introspected once, got %d", got)
+		}
+	})
+}
+
+func TestGraphQLPersistedQueries(t *testing.T) {
+	var mu sync.Mutex
+	persisted := make(map[string]string)
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.GraphQLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		pq, _ := req.Extensions["persistedQuery"].(map[string]interface{})
+		hash, _ := pq["sha256Hash"].(string)
+		if req.Query == "" {
+			requests = append(requests, "hash")
+			if _, ok := persisted[hash]; !ok {
+				w.Write([]byte(`{"errors": [{"message": "PersistedQueryNotFound", "extensions": {"code": "PERSISTED_QUERY_NOT_FOUND"}}]}`))
+				return
+			}
+		} else {
+			requests = append(requests, "full")
+			sum := sha256.Sum256([]byte(req.Query))
+			if hash != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": [{"message": "provided sha does not match query"}]}`))
+				return
+			}
+			persisted[hash] = req.Query
+		}
+		w.Write([]byte(`{"data": {"user": {"id": 1, "name": "John"}}}`))
+	}))
+	defer server.Close()
+
+	gc := graphql.NewGraphQLClient(server.URL, http.DefaultClient).WithPersistedQueries(true)
+	query := `query GetUser($id: ID!) { user(id: $id) { id name } }`
+
+	for i := 0; i < 2; i++ {
+		var result struct {
+			User TestUser `json:"user"`
+		}
+		if err := gc.Query(query, map[string]interface{}{"id": "1"}, &result); err != nil {
+			t.Fatalf("Query %d failed: %v", i, err)
+		}
+		if result.User.Name != "John" {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	}
+
+	// The first query registers itself, the second is sent by hash only
+	if got := strings.Join(requests, ","); got != "hash,full,hash" {
+		t.Errorf("Expected requests hash,full,hash, got %s", got)
+	}
+
+	t.Run("Client", func(t *testing.T) {
+		mu.Lock()
+		persisted = make(map[string]string)
+		requests = nil
+		mu.Unlock()
+
+		client := httpclient.New().WithBaseURL(server.URL).WithGraphQLPersistedQueries(true)
+		for i := 0; i < 2; i++ {
+			var result struct {
+				User TestUser `json:"user"`
+			}
+			if err := client.GraphQL(query, map[string]interface{}{"id": "1"}, &result); err != nil {
+				t.Fatalf("GraphQL %d failed: %v", i, err)
+			}
+			if result.User.Name != "John" {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if got := strings.Join(requests, ","); got != "hash,full,hash" {
+			t.Errorf("Expected requests hash,full,hash, got %s", got)
+		}
+	})
+}
+
+func TestGraphQLPersistedQueriesNotSupported(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.GraphQLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if req.Extensions != nil {
+			requests = append(requests, "persisted")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": [{"message": "PersistedQueryNotSupported"}]}`))
+			return
+		}
+		requests = append(requests, "plain")
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer server.Close()
+
+	gc := graphql.NewGraphQLClient(server.URL, http.DefaultClient).WithPersistedQueries(true)
+	for i := 0; i < 2; i++ {
+		if err := gc.Query(`{ user(id: "1") { id } }`, nil, nil); err != nil {
+			t.Fatalf("Query %d failed: %v", i, err)
+		}
+	}
+
+	if got := strings.Join(requests, ","); got != "persisted,plain,plain" {
+		t.Errorf("Expected requests persisted,plain,plain, got %s", got)
+	}
+}
+
+func TestGraphQLBatch(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		var batch []graphql.GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]json.RawMessage, len(batch))
+		for i, req := range batch {
+			switch {
+			case strings.Contains(req.Query, "user"):
+				responses[i] = json.RawMessage(`{"data": {"user": {"id": 1, "name": "John"}}}`)
+			case strings.Contains(req.Query, "posts"):
+				responses[i] = json.RawMessage(`{"data": {"posts": [{"title": "Hello"}, {"title": "World"}]}}`)
+			default:
+				responses[i] = json.RawMessage(`{"errors": [{"message": "unknown operation"}]}`)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	gc := graphql.NewGraphQLClient(server.URL, http.DefaultClient)
+
+	var userResult struct {
+		User TestUser `json:"user"`
+	}
+	var postsResult struct {
+		Posts []struct {
+			Title string `json:"title"`
+		} `json:"posts"`
+	}
+
+	err := gc.QueryBatch([]graphql.GraphQLRequest{
+		{Query: `query GetUser($id: ID!) { user(id: $id) { id name } }`, Variables: map[string]interface{}{"id": "1"}},
+		{Query: `{ posts { title } }`},
+	}, []interface{}{&userResult, &postsResult})
+	if err != nil {
+		t.Fatalf("QueryBatch failed: %v", err)
+	}
+
+	if userResult.User.Name != "John" {
+		t.Errorf("Unexpected user result: %+v", userResult)
+	}
+	if len(postsResult.Posts) != 2 || postsResult.Posts[1].Title != "World" {
+		t.Errorf("Unexpected posts result: %+v", postsResult)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Expected both queries in 1 request, got %d", got)
+	}
+
+	t.Run("OperationError", func(t *testing.T) {
+		var user struct {
+			User TestUser `json:"user"`
+		}
+		err := gc.QueryBatch([]graphql.GraphQLRequest{
+			{Query: `{ unknown }`},
+			{Query: `{ user(id: "1") { name } }`},
+		}, []interface{}{nil, &user})
+
+		var batchErr *graphql.BatchError
+		if !errors.As(err, &batchErr) {
+			t.Fatalf("Expected BatchError, got %v", err)
+		}
+		var gqlErrs *graphql.GraphQLErrors
+		if !errors.As(batchErr.Errors[0], &gqlErrs) || gqlErrs.Errors[0].Message != "unknown operation" {
+			t.Errorf("Expected the first operation to fail, got %v", batchErr.Errors[0])
+		}
+		if batchErr.Errors[1] != nil || user.User.Name != "John" {
+			t.Errorf("Expected the second operation to succeed, got %v and %+v", batchErr.Errors[1], user)
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		client := httpclient.New().WithBaseURL(server.URL).WithGraphQLEndpoint("/graphql")
+
+		var user struct {
+			User TestUser `json:"user"`
+		}
+		var posts struct {
+			Posts []struct {
+				Title string `json:"title"`
+			} `json:"posts"`
+		}
+		err := client.GraphQLBatch(context.Background(), []httpclient.GraphQLRequest{
+			{Query: `{ user(id: "1") { name } }`},
+			{Query: `{ posts { title } }`},
+		}, []interface{}{&user, &posts})
+		if err != nil {
+			t.Fatalf("GraphQLBatch failed: %v", err)
+		}
+		if user.User.Name != "John" || len(posts.Posts) != 2 {
+			t.Errorf("Unexpected results %+v and %+v", user, posts)
+		}
+		if got := atomic.LoadInt32(&requests); got != 1 {
+			t.Errorf("Expected both queries in 1 request, got %d", got)
+		}
+
+		err = client.GraphQLBatch(context.Background(), []httpclient.GraphQLRequest{
+			{Query: `{ unknown }`},
+		}, []interface{}{nil})
+		var batchErr *httpclient.GraphQLBatchError
+		if !errors.As(err, &batchErr) || len(batchErr.Errors) != 1 {
+			t.Errorf("Expected a GraphQLBatchError, got %v", err)
+		}
+	})
+}
+
+func TestClientGraphQL(t *testing.T) {
+	var flaky int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphql" || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Intercepted") != "yes" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req graphql.GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch req.OperationName {
+		case "GetUser":
+			if req.Variables["id"] != "1" {
+				w.Write([]byte(`{"errors": [{"message": "unexpected variables"}]}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"user": {"id": "1", "name": "Alice"}}}`))
+		case "Partial":
+			w.Write([]byte(`{
+				"data": {"user": {"id": "1", "name": null}},
+				"errors": [{"message": "name hidden", "path": ["user", "name"], "extensions": {"code": "FORBIDDEN"}}]
+			}`))
+		case "Invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": [{"message": "Cannot query field \"nope\"", "extensions": {"code": "GRAPHQL_VALIDATION_FAILED"}}]}`))
+		case "Flaky":
+			if atomic.AddInt32(&flaky, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"data": {"user": {"id": "2", "name": "Bob"}}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithBaseURL(server.URL).
+		WithGraphQLEndpoint("/graphql").
+		WithAuth("token").
+		WithRetries(0).
+		WithRequestInterceptor(func(r *http.Request) error {
+			r.Header.Set("X-Intercepted", "yes")
+			return nil
+		})
+
+	const query = `query GetUser($id: ID!) { user(id: $id) { id name } }
+query Partial { user(id: "1") { id name } }`
+
+	type userResult struct {
+		User struct {
+			ID   string  `json:"id"`
+			Name *string `json:"name"`
+		} `json:"user"`
+	}
+
+	t.Run("Data", func(t *testing.T) {
+		var result userResult
+		err := client.GraphQLOp(context.Background(), "GetUser", query, map[string]interface{}{"id": "1"}, &result)
+		if err != nil {
+			t.Fatalf("GraphQLOp failed: %v", err)
+		}
+		if result.User.ID != "1" || result.User.Name == nil || *result.User.Name != "Alice" {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	})
+
+	t.Run("PartialErrors", func(t *testing.T) {
+		var result userResult
+		err := client.GraphQLOp(context.Background(), "Partial", query, nil, &result)
+		var gqlErrs *httpclient.GraphQLErrors
+		if !errors.As(err, &gqlErrs) {
+			t.Fatalf("Expected *GraphQLErrors, got %v", err)
+		}
+		if len(gqlErrs.Errors) != 1 || gqlErrs.Errors[0].Extensions["code"] != "FORBIDDEN" {
+			t.Fatalf("Unexpected errors %+v", gqlErrs.Errors)
+		}
+		if path := gqlErrs.Errors[0].Path; len(path) != 2 || path[0] != "user" || path[1] != "name" {
+			t.Errorf("Unexpected path %v", path)
+		}
+		// The data that came with the errors is still decoded
+		if result.User.ID != "1" || result.User.Name != nil {
+			t.Errorf("Expected the partial data, got %+v", result)
+		}
+	})
+
+	t.Run("ErrorStatus", func(t *testing.T) {
+		err := client.GraphQLOp(context.Background(), "Invalid", `query Invalid { nope }`, nil, nil)
+		var gqlErrs *httpclient.GraphQLErrors
+		if !errors.As(err, &gqlErrs) {
+			t.Fatalf("Expected *GraphQLErrors, got %v", err)
+		}
+		if gqlErrs.Errors[0].Extensions["code"] != "GRAPHQL_VALIDATION_FAILED" {
+			t.Errorf("Unexpected errors %+v", gqlErrs.Errors)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		var result userResult
+		if err := client.WithRetries(1).GraphQLOp(context.Background(), "Flaky", `query Flaky { user(id: "2") { id name } }`, nil, &result); err != nil {
+			t.Fatalf("GraphQLOp failed: %v", err)
+		}
+		if result.User.ID != "2" || atomic.LoadInt32(&flaky) != 2 {
+			t.Errorf("Expected the retried request to succeed, got %+v after %d attempts", result, flaky)
+		}
+	})
+
+	t.Run("TransportErrors", func(t *testing.T) {
+		err := client.GraphQL(`{ boom }`, nil, nil)
+		var apiErr *httpclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Expected an *APIError with status 500, got %v", err)
+		}
+
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+		err = client.WithBaseURL(unreachable.URL).GraphQL(`{ user }`, nil, nil)
+		var gqlErrs *httpclient.GraphQLErrors
+		if err == nil || errors.As(err, &gqlErrs) {
+			t.Errorf("Expected a transport error, got %v", err)
+		}
+	})
+}
+
+// newGraphQLWSServer speaks enough graphql-transport-ws for the tests: it
+// requires a token in connection_init, pings the client once acknowledged
+// and runs the subscriptions Ticks (three results, then complete), Forever
+// (one result, then waits for the client to complete it) and Fail (an
+// error). It reports connections, pongs, client completions, the close
+// code of each socket and the Authorization header of the last handshake.
+type graphQLWSServer struct {
+	*httptest.Server
+	connections   int32
+	pongs         int32
+	completed     chan string
+	closeCodes    chan int
+	authorization atomic.Value
+}
+
+func newGraphQLWSServer(t *testing.T) *graphQLWSServer {
+	s := &graphQLWSServer{completed: make(chan string, 10), closeCodes: make(chan int, 10)}
+	upgrader := websocket.Upgrader{Subprotocols: []string{"graphql-transport-ws"}}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&s.connections, 1)
+		s.authorization.Store(r.Header.Get("Authorization"))
+
+		var writeMu sync.Mutex
+		write := func(msg map[string]interface{}) {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.WriteJSON(msg)
+		}
+
+		for {
+			var msg struct {
+				ID      string          `json:"id"`
+				Type    string          `json:"type"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				code := websocket.CloseAbnormalClosure
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					code = closeErr.Code
+				}
+				s.closeCodes <- code
+				return
+			}
+
+			switch msg.Type {
+			case "connection_init":
+				var payload map[string]string
+				json.Unmarshal(msg.Payload, &payload)
+				if payload["token"] != "secret" {
+					writeMu.Lock()
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4403, "Forbidden"))
+					writeMu.Unlock()
+					continue
+				}
+				write(map[string]interface{}{"type": "connection_ack"})
+				write(map[string]interface{}{"type": "ping"})
+			case "pong":
+				atomic.AddInt32(&s.pongs, 1)
+			case "complete":
+				s.completed <- msg.ID
+			case "subscribe":
+				var req graphql.GraphQLRequest
+				json.Unmarshal(msg.Payload, &req)
+				switch req.OperationName {
+				case "Ticks":
+					go func(id string) {
+						for i := 1; i <= 3; i++ {
+							write(map[string]interface{}{"id": id, "type": "next", "payload": map[string]interface{}{"data": map[string]int{"tick": i}}})
+						}
+						write(map[string]interface{}{"id": id, "type": "complete"})
+					}(msg.ID)
+				case "Forever":
+					write(map[string]interface{}{"id": msg.ID, "type": "next", "payload": map[string]interface{}{"data": map[string]int{"tick": 0}}})
+				case "Fail":
+					write(map[string]interface{}{"id": msg.ID, "type": "error", "payload": []map[string]interface{}{
+						{"message": "not allowed", "extensions": map[string]string{"code": "FORBIDDEN"}},
+					}})
+				}
+			}
+		}
+	}))
+	return s
+}
+
+func TestGraphQLUpload(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Intercepted") != "yes" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The spec requires operations, then map, then the files
+		var names []string
+		parts := make(map[string]string)
+		filenames := make(map[string]string)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			names = append(names, part.FormName())
+			parts[part.FormName()] = string(data)
+			filenames[part.FormName()] = part.FileName()
+		}
+
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if strings.Join(names, ",") != "operations,map,0,1" {
+			http.Error(w, "unexpected parts "+strings.Join(names, ","), http.StatusBadRequest)
+			return
+		}
+
+		var ops graphql.GraphQLRequest
+		if err := json.Unmarshal([]byte(parts["operations"]), &ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files := ops.Variables["input"].(map[string]interface{})["files"].([]interface{})
+		if v, ok := ops.Variables["avatar"]; !ok || v != nil || files[0] != "keep" || files[1] != nil {
+			http.Error(w, "files not nulled: "+parts["operations"], http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"map":        parts["map"],
+			"avatar":     parts["0"],
+			"avatarAs":   filenames["0"],
+			"document":   parts["1"],
+			"documentAs": filenames["1"],
+		}})
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithBaseURL(server.URL).
+		WithAuth("token").
+		WithRetries(1).
+		WithRetryNonIdempotent(true).
+		WithRequestInterceptor(func(r *http.Request) error {
+			r.Header.Set("X-Intercepted", "yes")
+			return nil
+		})
+
+	const query = `mutation ($avatar: Upload!, $input: DocumentInput!) { upload(avatar: $avatar, input: $input) }`
+	variables := func() map[string]interface{} {
+		return map[string]interface{}{
+			"avatar": "placeholder",
+			"input":  map[string]interface{}{"files": []interface{}{"keep", "placeholder"}},
+		}
+	}
+
+	var result struct {
+		Map        string `json:"map"`
+		Avatar     string `json:"avatar"`
+		AvatarAs   string `json:"avatarAs"`
+		Document   string `json:"document"`
+		DocumentAs string `json:"documentAs"`
+	}
+
+	t.Run("Seekable", func(t *testing.T) {
+		atomic.StoreInt32(&attempts, 0)
+		vars := variables()
+		err := client.GraphQLUpload(context.Background(), query, vars, map[string]httpclient.Upload{
+			"avatar":        {Reader: strings.NewReader("png bytes"), Filename: "me.png", ContentType: "image/png"},
+			"input.files.1": {Reader: bytes.NewReader([]byte("pdf bytes")), Filename: `a "b".pdf`},
+		}, &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Errorf("got %d attempts, want 2", attempts)
+		}
+		if result.Map != `{"0":["variables.avatar"],"1":["variables.input.files.1"]}` {
+			t.Errorf("got map %s", result.Map)
+		}
+		if result.Avatar != "png bytes" || result.AvatarAs != "me.png" || result.Document != "pdf bytes" || result.DocumentAs != `a "b".pdf` {
+			t.Errorf("got files %+v", result)
+		}
+		if vars["avatar"] != "placeholder" {
+			t.Error("caller's variables were modified")
+		}
+	})
+
+	t.Run("NotSeekable", func(t *testing.T) {
+		atomic.StoreInt32(&attempts, 0)
+		err := client.GraphQLUpload(context.Background(), query, variables(), map[string]httpclient.Upload{
+			"avatar":        {Reader: io.MultiReader(strings.NewReader("png bytes")), Filename: "me.png"},
+			"input.files.1": {Reader: strings.NewReader("pdf bytes"), Filename: "doc.pdf"},
+		}, &result)
+		if err == nil {
+			t.Fatal("expected the failed upload not to be retried")
+		}
+		if attempts != 1 {
+			t.Errorf("got %d attempts, want 1", attempts)
+		}
+	})
+
+	t.Run("NotSent", func(t *testing.T) {
+		rejecting := client.WithRetries(0).WithMiddleware(httpclient.MiddlewareFunc(func(*http.Request) error {
+			return errors.New("rejected")
+		}, nil))
+		before := runtime.NumGoroutine()
+		for i := 0; i < 20; i++ {
+			err := rejecting.GraphQLUpload(context.Background(), query, variables(), map[string]httpclient.Upload{
+				"avatar": {Reader: strings.NewReader("png bytes"), Filename: "me.png"},
+			}, &result)
+			if err == nil {
+				t.Fatal("expected the middleware to fail the upload")
+			}
+		}
+
+		// Closing the body of a request that is never sent stops the
+		// goroutine writing it
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before+5 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before+5 {
+			t.Errorf("got %d goroutines after the uploads, want about %d", n, before)
+		}
+	})
+
+	t.Run("BadPath", func(t *testing.T) {
+		err := client.GraphQLUpload(context.Background(), query, variables(), map[string]httpclient.Upload{
+			"input.missing.0": {Reader: strings.NewReader("x")},
+		}, &result)
+		if err == nil {
+			t.Fatal("expected an error for a path outside the variables")
+		}
+	})
+}
+
+func TestGraphQLSubscriptions(t *testing.T) {
+	type tick struct {
+		Tick int `json:"tick"`
+	}
+	const query = `subscription Ticks { tick }
+subscription Forever { tick }
+subscription Fail { tick }`
+
+	newClient := func(url string) *graphql.GraphQLClient {
+		return graphql.NewGraphQLClient(url, http.DefaultClient).
+			WithConnectionInitPayload(map[string]interface{}{"token": "secret"})
+	}
+
+	t.Run("Multiplexed", func(t *testing.T) {
+		server := newGraphQLWSServer(t)
+		defer server.Close()
+		client := newClient(server.URL)
+
+		forever, err := client.SubscribeContext(context.Background(), "Forever", query, nil)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		ticks, err := client.SubscribeContext(context.Background(), "Ticks", query, nil)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		var got []int
+		for resp := range ticks.Events() {
+			var v tick
+			if err := resp.Decode(&v); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			got = append(got, v.Tick)
+		}
+		if ticks.Err() != nil || len(got) != 3 || got[0] != 1 || got[2] != 3 {
+			t.Errorf("Expected ticks 1 to 3 and completion, got %v, %v", got, ticks.Err())
+		}
+
+		resp, ok := <-forever.Events()
+		if !ok {
+			t.Fatalf("Expected a result, subscription ended with %v", forever.Err())
+		}
+		var v tick
+		if err := resp.Decode(&v); err != nil || v.Tick != 0 {
+			t.Errorf("Unexpected result %+v, %v", v, err)
+		}
+		if n := atomic.LoadInt32(&server.connections); n != 1 {
+			t.Errorf("Expected both subscriptions on one socket, got %d connections", n)
+		}
+
+		// Closing the last subscription completes it on the server and
+		// closes the socket
+		if err := forever.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if _, ok := <-forever.Events(); ok {
+			t.Error("Expected Events to be closed")
+		}
+		if id := <-server.completed; id != "1" {
+			t.Errorf("Expected the server to see subscription 1 completed, got %q", id)
+		}
+		if code := <-server.closeCodes; code != websocket.CloseNormalClosure {
+			t.Errorf("Expected the socket to be closed normally, got %d", code)
+		}
+		if atomic.LoadInt32(&server.pongs) != 1 {
+			t.Errorf("Expected the server's ping to be answered")
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		server := newGraphQLWSServer(t)
+		defer server.Close()
+
+		sub, err := newClient(server.URL).SubscribeContext(context.Background(), "Fail", query, nil)
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		for range sub.Events() {
+			t.Error("Expected no results")
+		}
+
+		var gqlErrs *graphql.GraphQLErrors
+		if !errors.As(sub.Err(), &gqlErrs) || gqlErrs.Errors[0].Extensions["code"] != "FORBIDDEN" {
+			t.Fatalf("Expected a FORBIDDEN *GraphQLErrors, got %v", sub.Err())
+		}
+		if code := <-server.closeCodes; code != websocket.CloseNormalClosure {
+			t.Errorf("Expected the socket to be closed with its last subscription, got %d", code)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		server := newGraphQLWSServer(t)
+		defer server.Close()
+
+		_, err := graphql.NewGraphQLClient(server.URL, http.DefaultClient).Subscribe(`subscription { tick }`, nil)
+		var closeErr *streaming.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != 4403 {
+			t.Errorf("Expected the server's 4403 close, got %v", err)
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		server := newGraphQLWSServer(t)
+		defer server.Close()
+		client := httpclient.New().
+			WithBaseURL(server.URL).
+			WithGraphQLEndpoint("/graphql").
+			WithAuth("token").
+			WithGraphQLInitPayload(map[string]interface{}{"token": "secret"})
+
+		sub, err := client.GraphQLSubscribe(context.Background(), "Ticks", query, nil)
+		if err != nil {
+			t.Fatalf("GraphQLSubscribe failed: %v", err)
+		}
+		var got []int
+		for resp := range sub.Events() {
+			var v tick
+			if err := resp.Decode(&v); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			got = append(got, v.Tick)
+		}
+		if sub.Err() != nil || len(got) != 3 {
+			t.Errorf("Expected ticks 1 to 3 and completion, got %v, %v", got, sub.Err())
+		}
+		if auth := server.authorization.Load(); auth != "Bearer token" {
+			t.Errorf("Expected the handshake to carry the client's auth, got %v", auth)
+		}
+
+		sub, err = client.GraphQLSubscribe(context.Background(), "Fail", query, nil)
+		if err != nil {
+			t.Fatalf("GraphQLSubscribe failed: %v", err)
+		}
+		for range sub.Events() {
+		}
+		var gqlErrs *httpclient.GraphQLErrors
+		if !errors.As(sub.Err(), &gqlErrs) {
+			t.Errorf("Expected *GraphQLErrors, got %v", sub.Err())
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHTTP2(t *testing.T) {
+	protocols := make(chan string, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto := r.Proto
+		if r.TLS != nil {
+			proto += " " + r.TLS.NegotiatedProtocol
+		}
+		protocols <- proto
+		w.Write([]byte("ok"))
+	})
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	h2cServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cServer.Close()
+
+	newClient := func() httpclient.Client {
+		cfg := config.Default()
+		cfg.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		cfg.Retries = 0
+		return httpclient.NewWithConfig(cfg)
+	}
+
+	tests := []struct {
+		name     string
+		client   httpclient.Client
+		url      string
+		expected string
+	}{
+		{"ALPN", newClient().WithHTTP2(true), tlsServer.URL, "HTTP/2.0 h2"},
+		{"ALPNDisabled", newClient().WithHTTP2(false), tlsServer.URL, "HTTP/1.1 "},
+		{"PriorKnowledge", newClient().WithHTTP2(true), h2cServer.URL, "HTTP/2.0"},
+		{"CleartextDisabled", newClient().WithHTTP2(false), h2cServer.URL, "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.client.GET(tt.url)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			if string(data) != "ok" {
+				t.Errorf("Unexpected response: %s", data)
+			}
+			if proto := <-protocols; proto != tt.expected {
+				t.Errorf("Expected the server to see %q, got %q", tt.expected, proto)
+			}
+		})
+	}
+}
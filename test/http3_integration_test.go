@@ -0,0 +1,85 @@
+//go:build http3
+
+package test
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/yourorg/httpclient"
+)
+
+// newHTTP3TestServer serves handler over HTTP/2 on TCP and HTTP/3 on the
+// UDP port with the same number
+func newHTTP3TestServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	conn, err := net.ListenPacket("udp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Listen UDP failed: %v", err)
+	}
+	h3 := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: server.TLS.Certificates}),
+	}
+	go h3.Serve(conn)
+	t.Cleanup(func() {
+		h3.Close()
+		conn.Close()
+	})
+
+	return server
+}
+
+func TestHTTP3(t *testing.T) {
+	protocols := make(chan string, 1)
+	server := newHTTP3TestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols <- r.Proto
+		w.Write([]byte("ok"))
+	}))
+
+	client := httpclient.NewWithConfig(newHTTP3TestConfig()).WithHTTP3(true)
+
+	data, err := client.GET(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if string(data) != "ok" {
+		t.Errorf("Unexpected response: %s", data)
+	}
+	if proto := <-protocols; proto != "HTTP/3.0" {
+		t.Errorf("Expected HTTP/3.0, got %s", proto)
+	}
+}
+
+func TestHTTP3AltSvc(t *testing.T) {
+	protocols := make(chan string, 3)
+	var port string
+	server := newHTTP3TestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols <- r.Proto
+		w.Header().Set("Alt-Svc", `h3=":`+port+`"; ma=60`)
+		w.Write([]byte("ok"))
+	}))
+	_, port, _ = net.SplitHostPort(server.Listener.Addr().String())
+
+	client := httpclient.NewWithConfig(newHTTP3TestConfig()).WithHTTP3AltSvc(true)
+
+	// The first request discovers HTTP/3 through Alt-Svc
+	expected := []string{"HTTP/2.0", "HTTP/3.0", "HTTP/3.0"}
+	for i, want := range expected {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if proto := <-protocols; proto != want {
+			t.Errorf("Request %d: expected %s, got %s", i, want, proto)
+		}
+	}
+}
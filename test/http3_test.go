@@ -0,0 +1,50 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/config"
+)
+
+// newHTTP3TestConfig returns a config that trusts the test server
+// certificates and gives up on QUIC handshakes quickly
+func newHTTP3TestConfig() *config.Config {
+	cfg := config.Default()
+	cfg.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	cfg.TLSHandshakeTimeout = time.Second
+	cfg.Retries = 0
+	return cfg
+}
+
+func TestHTTP3Fallback(t *testing.T) {
+	protocols := make(chan string, 2)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols <- r.Proto
+		w.Write([]byte("ok"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// Nothing listens on the UDP side of the port, so the QUIC handshake
+	// fails and the request has to go over TCP
+	client := httpclient.NewWithConfig(newHTTP3TestConfig()).WithHTTP3(true)
+
+	for i := 0; i < 2; i++ {
+		data, err := client.GET(server.URL)
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if string(data) != "ok" {
+			t.Errorf("Unexpected response: %s", data)
+		}
+		if proto := <-protocols; proto != "HTTP/2.0" {
+			t.Errorf("Expected fallback to HTTP/2.0, got %s", proto)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+)
+
+// newStaleKeepAliveServer answers the first request on every connection
+// and closes the connection without responding when a second request
+// arrives on it, like a server that timed out an idle keep-alive connection.
+func newStaleKeepAliveServer(t *testing.T, conns *int32) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(conns, 1)
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for served := 0; ; served++ {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					io.Copy(io.Discard, req.Body)
+					if served > 0 {
+						return
+					}
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}(conn)
+		}
+	}()
+
+	return "http://" + ln.Addr().String()
+}
+
+func TestKeepAliveEOFRetry(t *testing.T) {
+	t.Run("Idempotent", func(t *testing.T) {
+		var conns int32
+		url := newStaleKeepAliveServer(t, &conns)
+
+		// A threshold of one opens the breaker on any counted failure
+		client := httpclient.New().
+			WithCircuitBreaker(1, time.Minute).
+			WithRetries(0)
+
+		for i := 0; i < 3; i++ {
+			if _, err := client.PUT(url, TestUser{Name: "John"}); err != nil {
+				t.Fatalf("PUT %d failed: %v", i, err)
+			}
+		}
+		if _, err := client.DELETE(url); err != nil {
+			t.Fatalf("DELETE failed: %v", err)
+		}
+
+		if got := atomic.LoadInt32(&conns); got != 4 {
+			t.Errorf("Expected every request on a fresh connection, got %d connections", got)
+		}
+	})
+
+	t.Run("SharedTransport", func(t *testing.T) {
+		var staleConns int32
+		staleURL := newStaleKeepAliveServer(t, &staleConns)
+
+		var conns int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		// Both clients have the same transport settings and so share
+		// their idle connections
+		client := httpclient.New()
+		other := httpclient.New().WithHeader("X-Tenant", "a")
+		if _, err := other.GET(server.URL); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := client.DELETE(staleURL); err != nil {
+				t.Fatalf("DELETE %d failed: %v", i, err)
+			}
+		}
+
+		// The retry of the second DELETE must leave the other client's
+		// idle connection alone
+		if _, err := other.GET(server.URL); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(&conns); got != 1 {
+			t.Errorf("Expected the other client to keep its connection, got %d connections", got)
+		}
+	})
+
+	t.Run("NonIdempotent", func(t *testing.T) {
+		var conns int32
+		url := newStaleKeepAliveServer(t, &conns)
+
+		client := httpclient.New().
+			WithCircuitBreaker(1, time.Minute).
+			WithRetries(0)
+
+		if _, err := client.POST(url, TestUser{Name: "John"}); err != nil {
+			t.Fatalf("First POST failed: %v", err)
+		}
+
+		_, err := client.POST(url, TestUser{Name: "John"})
+		if err == nil {
+			t.Fatal("Expected POST on a closed keep-alive connection to fail")
+		}
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+
+		// The failure is real for a POST and counts toward the breaker
+		if _, err := client.POST(url, TestUser{Name: "John"}); !errors.Is(err, httpclient.ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+// capturingLogger records log lines prefixed with their level
+type capturingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *capturingLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) { l.log("DEBUG", format, args...) }
+func (l *capturingLogger) Infof(format string, args ...interface{})  { l.log("INFO", format, args...) }
+func (l *capturingLogger) Errorf(format string, args ...interface{}) { l.log("ERROR", format, args...) }
+
+func (l *capturingLogger) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines
+	l.lines = nil
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	logger := &capturingLogger{}
+
+	t.Run("Debug", func(t *testing.T) {
+		client := httpclient.New().WithRetries(0).WithDebug(true).WithLogger(logger).WithAuth("secret")
+		if _, err := client.GET(server.URL + "/users"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GET("http://127.0.0.1:1/users"); err == nil {
+			t.Fatal("expected a connection error")
+		}
+
+		lines := strings.Join(logger.take(), "\n")
+		patterns := []string{
+			`DEBUG request method=GET url=` + regexp.QuoteMeta(server.URL) + `/users`,
+			`DEBUG header Authorization: \[REDACTED\]`,
+			`DEBUG response method=GET url=` + regexp.QuoteMeta(server.URL) + `/users status=200 duration=\S+`,
+			`ERROR request failed method=GET url=http://127.0.0.1:1/users duration=\S+ error=".+"`,
+		}
+		for _, pattern := range patterns {
+			if !regexp.MustCompile(`(?m)^` + pattern + `$`).MatchString(lines) {
+				t.Errorf("no line matching %q in:\n%s", pattern, lines)
+			}
+		}
+		if strings.Contains(lines, "secret") {
+			t.Error("credentials were logged")
+		}
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		client := httpclient.New().
+			WithBaseURL(server.URL + "/down").
+			WithRetries(0).
+			WithBackupEndpoints([]string{server.URL}).
+			WithLogger(logger)
+		if _, err := client.GET(""); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := logger.take()
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "INFO failing over method=GET url= backup="+server.URL+" error=") {
+			t.Errorf("got lines %q, want one failover warning", lines)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+// recordingMiddleware appends its calls to a shared log
+type recordingMiddleware struct {
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+}
+
+func (m recordingMiddleware) record(call string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.log = append(*m.log, m.name+"."+call)
+}
+
+func (m recordingMiddleware) Before(req *http.Request) error {
+	m.record("Before")
+	req.Header.Add("X-Middleware", m.name)
+	return nil
+}
+
+func (m recordingMiddleware) After(resp *http.Response) {
+	m.record("After")
+}
+
+func (m recordingMiddleware) OnError(req *http.Request, err error) {
+	m.record("OnError")
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Values("X-Middleware")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var log []string
+	first := recordingMiddleware{name: "first", mu: &mu, log: &log}
+	second := recordingMiddleware{name: "second", mu: &mu, log: &log}
+
+	client := httpclient.New().WithRetries(0).WithMiddleware(first).WithMiddleware(second)
+
+	t.Run("BeforeAndAfter", func(t *testing.T) {
+		log = nil
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"first.Before", "second.Before", "second.After", "first.After"}
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("got calls %v, want %v", log, want)
+		}
+		if !reflect.DeepEqual(seen, []string{"first", "second"}) {
+			t.Errorf("server saw middleware headers %v", seen)
+		}
+	})
+
+	t.Run("OnError", func(t *testing.T) {
+		log = nil
+		if _, err := client.GET("http://127.0.0.1:1/"); err == nil {
+			t.Fatal("expected a connection error")
+		}
+		want := []string{"first.Before", "second.Before", "second.OnError", "first.OnError"}
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("got calls %v, want %v", log, want)
+		}
+	})
+
+	t.Run("BeforeError", func(t *testing.T) {
+		log = nil
+		errRejected := errors.New("rejected")
+		reject := httpclient.MiddlewareFunc(func(*http.Request) error { return errRejected }, nil)
+		_, err := client.WithMiddleware(reject).WithMiddleware(first).GET(server.URL)
+		if !errors.Is(err, errRejected) {
+			t.Fatalf("got %v, want the Before error", err)
+		}
+		want := []string{"first.Before", "second.Before"}
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("got calls %v, want %v", log, want)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+)
+
+func TestPreflight(t *testing.T) {
+	var origin string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			t.Errorf("Expected OPTIONS request, got %s", r.Method)
+		}
+		origin = r.Header.Get("Origin")
+
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, post,PUT")
+		w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Add("Access-Control-Allow-Headers", "X-Request-ID")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithBaseURL(server.URL).WithHeader("Origin", "https://app.example.com")
+
+	methods, headers, err := client.Preflight("/users")
+	if err != nil {
+		t.Fatalf("Preflight failed: %v", err)
+	}
+	if origin != "https://app.example.com" {
+		t.Errorf("Expected Origin header to be sent, got %q", origin)
+	}
+
+	expectedMethods := []string{"GET", "POST", "PUT", "HEAD", "OPTIONS"}
+	if !reflect.DeepEqual(methods, expectedMethods) {
+		t.Errorf("Expected methods %v, got %v", expectedMethods, methods)
+	}
+	expectedHeaders := []string{"Content-Type", "Authorization", "X-Request-ID"}
+	if !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("Expected headers %v, got %v", expectedHeaders, headers)
+	}
+}
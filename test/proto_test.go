@@ -0,0 +1,96 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// protoMessage adapts a google.golang.org/protobuf message to
+// httpclient.ProtoMessage
+type protoMessage struct {
+	proto.Message
+}
+
+func (m protoMessage) Marshal() ([]byte, error)    { return proto.Marshal(m.Message) }
+func (m protoMessage) Unmarshal(data []byte) error { return proto.Unmarshal(data, m.Message) }
+
+func TestProto(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			// Fail the first attempt to check the body is replayed on retry
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/error":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing field: name"))
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Accept") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	in, err := structpb.NewStruct(map[string]interface{}{
+		"name": "John",
+		"age":  42,
+		"tags": []interface{}{"admin", "ops"},
+	})
+	if err != nil {
+		t.Fatalf("NewStruct failed: %v", err)
+	}
+
+	client := httpclient.New().WithBaseURL(server.URL)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		out := &structpb.Struct{}
+		if err := client.Proto("POST", "/echo", protoMessage{in}, protoMessage{out}); err != nil {
+			t.Fatalf("Proto failed: %v", err)
+		}
+		if !proto.Equal(in, out) {
+			t.Errorf("Expected %v, got %v", in, out)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		out := &structpb.Struct{}
+		if err := client.WithRetries(1).WithRetryNonIdempotent(true).Proto("POST", "/flaky", protoMessage{in}, protoMessage{out}); err != nil {
+			t.Fatalf("Proto failed: %v", err)
+		}
+		if !proto.Equal(in, out) {
+			t.Errorf("Expected %v, got %v", in, out)
+		}
+		if atomic.LoadInt32(&attempts) != 2 {
+			t.Errorf("Expected 2 attempts, got %d", attempts)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		err := client.WithRetries(0).Proto("POST", "/error", protoMessage{in}, protoMessage{&structpb.Struct{}})
+		var apiErr *httpclient.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("Expected APIError, got %v", err)
+		}
+		if apiErr.StatusCode != http.StatusBadRequest || string(apiErr.Body) != "missing field: name" {
+			t.Errorf("Expected the error body, got %d %q", apiErr.StatusCode, apiErr.Body)
+		}
+	})
+}
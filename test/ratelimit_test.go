@@ -0,0 +1,135 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/ratelimit"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithRateLimiterBurst(10, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("Burst request %d failed: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected burst of 3 to pass without waiting, took %v", elapsed)
+	}
+
+	// The burst is spent; the next two requests wait ~100ms each at 10 rps
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("Throttled request %d failed: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected requests beyond the burst to be throttled, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterNonBlocking(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := httpclient.New().
+		WithRateLimiterBurst(1, 5).
+		WithRateLimiterNonBlocking(true)
+
+	var succeeded, limited int32
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GET(server.URL)
+			switch {
+			case err == nil:
+				atomic.AddInt32(&succeeded, 1)
+			case errors.Is(err, httpclient.ErrRateLimited):
+				atomic.AddInt32(&limited, 1)
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Non-blocking limiter should not wait, took %v", elapsed)
+	}
+	if succeeded != 5 || limited != 15 {
+		t.Errorf("Expected 5 succeeded and 15 limited, got %d and %d", succeeded, limited)
+	}
+}
+
+func TestPerHostRateLimiter(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	serverA := httptest.NewServer(handler)
+	defer serverA.Close()
+	serverB := httptest.NewServer(handler)
+	defer serverB.Close()
+
+	client := httpclient.New().
+		WithRateLimiter(0).
+		WithPerHostRateLimiter(5)
+
+	// Each host gets its own burst of 5
+	start := time.Now()
+	for _, url := range []string{serverA.URL, serverB.URL} {
+		for i := 0; i < 5; i++ {
+			if _, err := client.GET(url); err != nil {
+				t.Fatalf("Request to %s failed: %v", url, err)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected hosts to be throttled independently, took %v", elapsed)
+	}
+
+	// Host A's burst is exhausted, so the next request waits ~200ms
+	start = time.Now()
+	if _, err := client.GET(serverA.URL); err != nil {
+		t.Fatalf("Throttled request failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected host A to be throttled, took %v", elapsed)
+	}
+}
+
+func TestPerHostRateLimiterCleanup(t *testing.T) {
+	limiter := ratelimit.NewPerHost(10, 10, 20*time.Millisecond)
+
+	limiter.Limiter("a.example.com")
+	limiter.Limiter("b.example.com")
+	if limiter.Len() != 2 {
+		t.Fatalf("Expected 2 tracked hosts, got %d", limiter.Len())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	limiter.Limiter("c.example.com")
+
+	if limiter.Len() != 1 {
+		t.Errorf("Expected idle hosts to be cleaned up, got %d tracked hosts", limiter.Len())
+	}
+}
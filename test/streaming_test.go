@@ -0,0 +1,692 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+func TestSSEParserLastID(t *testing.T) {
+	parser := streaming.NewSSEParser()
+
+	chunks := []string{
+		"id: 1\ndata: first\n\n",
+		"id: 2\ndata: sec",
+		"ond\n\ni",
+		"d: 3\ndata: third\n\n",
+		"data: no id\n\n",
+	}
+	expected := []string{"1", "2", "2", "3", "3"}
+
+	for i, chunk := range chunks {
+		parser.Parse([]byte(chunk))
+		if parser.LastID() != expected[i] {
+			t.Errorf("After chunk %d expected LastID %q, got %q", i, expected[i], parser.LastID())
+		}
+	}
+}
+
+func TestSSEParser(t *testing.T) {
+	type event = streaming.SSEEvent
+
+	tests := []struct {
+		name   string
+		input  string
+		events []event
+		lastID string
+		retry  time.Duration
+	}{
+		{
+			name:   "SingleEvent",
+			input:  "data: hello\n\n",
+			events: []event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name:   "NamedEventWithID",
+			input:  "event: update\nid: 42\ndata: {\"id\": 1}\n\n",
+			events: []event{{Type: "update", Data: `{"id": 1}`, ID: "42"}},
+			lastID: "42",
+		},
+		{
+			name:   "MultiLineData",
+			input:  "data: first\ndata: second\ndata:\ndata: fourth\n\n",
+			events: []event{{Type: "message", Data: "first\nsecond\n\nfourth"}},
+		},
+		{
+			name:   "Comments",
+			input:  ": keep-alive\n:\ndata: a\n: ignored\n\n",
+			events: []event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "CRLF",
+			input:  "event: ping\r\ndata: a\r\ndata: b\r\n\r\n",
+			events: []event{{Type: "ping", Data: "a\nb"}},
+		},
+		{
+			name:   "CR",
+			input:  "data: a\rdata: b\r\rdata: c\r\r",
+			events: []event{{Type: "message", Data: "a\nb"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "MixedLineEndings",
+			input:  "data: a\r\n\ndata: b\n\r\ndata: c\r\n\r",
+			events: []event{{Type: "message", Data: "a"}, {Type: "message", Data: "b"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "OnlyOneLeadingSpaceStripped",
+			input:  "data:  two spaces\ndata:none\n\n",
+			events: []event{{Type: "message", Data: " two spaces\nnone"}},
+		},
+		{
+			name:   "FieldWithoutColon",
+			input:  "data\n\n",
+			events: []event{{Type: "message", Data: ""}},
+		},
+		{
+			name:  "NoDataNoEvent",
+			input: "event: update\nid: 7\n\n",
+			// The ID is kept even though no event was dispatched
+			lastID: "7",
+		},
+		{
+			name:   "EventTypeResetAfterDispatch",
+			input:  "event: update\ndata: a\n\ndata: b\n\n",
+			events: []event{{Type: "update", Data: "a"}, {Type: "message", Data: "b"}},
+		},
+		{
+			name:   "IDPersistsAcrossEvents",
+			input:  "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			events: []event{{Type: "message", Data: "a", ID: "1"}, {Type: "message", Data: "b", ID: "1"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "IDWithNullIgnored",
+			input:  "id: 1\nid: 2\x003\ndata: a\n\n",
+			events: []event{{Type: "message", Data: "a", ID: "1"}},
+			lastID: "1",
+		},
+		{
+			name:  "Retry",
+			input: "retry: 2500\nretry: 1.5\nretry: +7\nretry: -1\nretry\n",
+			retry: 2500 * time.Millisecond,
+		},
+		{
+			name:   "UnknownFieldsIgnored",
+			input:  "foo: bar\ndata: a\nDATA: b\n\n",
+			events: []event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "IncompleteEventNotDispatched",
+			input:  "data: a\n\ndata: b\n",
+			events: []event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "ByteOrderMark",
+			input:  "\xEF\xBB\xBFdata: a\n\n",
+			events: []event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "UTF8Data",
+			input:  "data: héllo wörld 👋\n\n",
+			events: []event{{Type: "message", Data: "héllo wörld 👋"}},
+		},
+	}
+
+	parse := func(chunks ...string) (*streaming.SSEParser, []event) {
+		parser := streaming.NewSSEParser()
+		var events []event
+		for _, chunk := range chunks {
+			events = append(events, parser.Parse([]byte(chunk))...)
+		}
+		return parser, events
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(how string, chunks ...string) {
+				parser, events := parse(chunks...)
+				if fmt.Sprint(events) != fmt.Sprint(tt.events) {
+					t.Errorf("%s: expected events %q, got %q", how, tt.events, events)
+				}
+				if parser.LastID() != tt.lastID {
+					t.Errorf("%s: expected LastID %q, got %q", how, tt.lastID, parser.LastID())
+				}
+				if parser.Retry() != tt.retry {
+					t.Errorf("%s: expected retry %v, got %v", how, tt.retry, parser.Retry())
+				}
+			}
+
+			check("whole", tt.input)
+
+			// Every split into two chunks
+			for i := 0; i <= len(tt.input); i++ {
+				check(fmt.Sprintf("split at %d", i), tt.input[:i], tt.input[i:])
+			}
+
+			// One byte at a time, including empty chunks
+			var bytewise []string
+			for i := 0; i < len(tt.input); i++ {
+				bytewise = append(bytewise, tt.input[i:i+1], "")
+			}
+			check("bytewise", bytewise...)
+		})
+	}
+}
+
+func TestSubscribeSSE(t *testing.T) {
+	var connections int32
+	lastEventIDs := make(chan string, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			lastEventIDs <- r.Header.Get("Last-Event-ID")
+			// Drop the connection after two events to force a reconnect
+			fmt.Fprint(w, "retry: 10\n\n")
+			fmt.Fprint(w, "event: update\nid: 1\ndata: {\"id\": 1}\n\n")
+			flusher.Flush()
+			fmt.Fprint(w, "event: delete\nid: 2\ndata: 7\n\n")
+			fmt.Fprint(w, "event: update\ndata: incomplete")
+			flusher.Flush()
+			return
+		}
+
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: line one\ndata: line two\n\n")
+		fmt.Fprint(w, "event: update\nid: 3\ndata: {\"id\": 2}\n\n")
+		flusher.Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) func(string) {
+		return func(data string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name+":"+data)
+			if len(calls) == 4 {
+				cancel()
+			}
+		}
+	}
+
+	err := httpclient.New().SubscribeSSE(ctx, server.URL, map[string]func(data string){
+		"update": record("update"),
+		"delete": record("delete"),
+		"":       record("default"),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	expected := []string{
+		`update:{"id": 1}`,
+		"delete:7",
+		"default:line one\nline two",
+		`update:{"id": 2}`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(calls, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected handler calls %q, got %q", expected, calls)
+	}
+
+	if id := <-lastEventIDs; id != "" {
+		t.Errorf("Expected no Last-Event-ID on first connection, got %q", id)
+	}
+	if id := <-lastEventIDs; id != "2" {
+		t.Errorf("Expected Last-Event-ID 2 on reconnect, got %q", id)
+	}
+}
+
+// newChunkedJSONServer writes payload in chunks of size bytes, flushing
+// after each so records arrive split at arbitrary points
+func newChunkedJSONServer(payload string, size int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher := w.(http.Flusher)
+		for i := 0; i < len(payload); i += size {
+			end := i + size
+			if end > len(payload) {
+				end = len(payload)
+			}
+			fmt.Fprint(w, payload[i:end])
+			flusher.Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+}
+
+func TestStreamJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"NewlineDelimited", "{\"id\": 1, \"name\": \"John\"}\n{\"id\": 2, \"name\": \"Jane\"}\r\n\n{\"id\": 3, \"name\": \"Bob\"}\n"},
+		{"Concatenated", `{"id": 1, "name": "John"}{"id": 2, "name": "Jane"} {"id": 3, "name": "Bob"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newChunkedJSONServer(tt.payload, 7)
+			defer server.Close()
+
+			var names []string
+			err := httpclient.New().StreamJSON(context.Background(), "GET", server.URL, nil, func(record json.RawMessage) error {
+				var user TestUser
+				if err := json.Unmarshal(record, &user); err != nil {
+					return err
+				}
+				names = append(names, user.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("StreamJSON failed: %v", err)
+			}
+			if got := strings.Join(names, ","); got != "John,Jane,Bob" {
+				t.Errorf("Expected John,Jane,Bob, got %s", got)
+			}
+		})
+	}
+
+	t.Run("PartialRecord", func(t *testing.T) {
+		server := newChunkedJSONServer("{\"id\": 1}\n{\"id\": 2, \"na", 5)
+		defer server.Close()
+
+		var records int
+		err := httpclient.New().StreamJSON(context.Background(), "GET", server.URL, nil, func(json.RawMessage) error {
+			records++
+			return nil
+		})
+		if !errors.Is(err, httpclient.ErrPartialRecord) {
+			t.Fatalf("Expected ErrPartialRecord, got %v", err)
+		}
+		if records != 1 {
+			t.Errorf("Expected 1 complete record, got %d", records)
+		}
+	})
+
+	t.Run("StopOnHandlerError", func(t *testing.T) {
+		server := newChunkedJSONServer(strings.Repeat("{\"id\": 1}\n", 10), 4)
+		defer server.Close()
+
+		stop := errors.New("stop")
+		var records int
+		err := httpclient.New().StreamJSON(context.Background(), "GET", server.URL, nil, func(json.RawMessage) error {
+			if records++; records == 3 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) || records != 3 {
+			t.Errorf("Expected handler error after 3 records, got %v after %d", err, records)
+		}
+	})
+}
+
+func TestStreamUsesClientConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Tenant") != "acme" || r.Header.Get("X-Intercepted") != "yes" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		// The event comes after the client timeout, which only bounds the
+		// wait for the response headers
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "data: ok\n\n")
+	}))
+	defer server.Close()
+
+	var intercepted int32
+	client := httpclient.New().
+		WithBaseURL(server.URL).
+		WithTimeout(100*time.Millisecond).
+		WithAuth("token").
+		WithHeader("X-Tenant", "acme").
+		WithRequestInterceptor(func(req *http.Request) error {
+			atomic.AddInt32(&intercepted, 1)
+			req.Header.Set("X-Intercepted", "yes")
+			return nil
+		})
+
+	t.Run("Stream", func(t *testing.T) {
+		atomic.StoreInt32(&intercepted, 0)
+		ch, err := client.Stream("GET", "/events", nil)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		var data []byte
+		for chunk := range ch {
+			data = append(data, chunk...)
+		}
+		if string(data) != "data: ok\n\n" {
+			t.Errorf("Expected the event stream, got %q", data)
+		}
+		if atomic.LoadInt32(&intercepted) != 1 {
+			t.Errorf("Expected the interceptor to run once, got %d", intercepted)
+		}
+	})
+
+	t.Run("SubscribeSSE", func(t *testing.T) {
+		atomic.StoreInt32(&intercepted, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var got string
+		err := client.SubscribeSSE(ctx, "/events", map[string]func(string){
+			"": func(data string) {
+				got = data
+				cancel()
+			},
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			t.Fatalf("SubscribeSSE failed: %v", err)
+		}
+		if got != "ok" {
+			t.Errorf("Expected event data ok, got %q", got)
+		}
+		if atomic.LoadInt32(&intercepted) == 0 {
+			t.Error("Expected the interceptor to run")
+		}
+	})
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{\"id\": 1, \"name\": \"John\"}\n")
+		w.(http.Flusher).Flush()
+		// Keep the connection open without sending anything
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithStreamIdleTimeout(100 * time.Millisecond)
+
+	t.Run("StreamJSON", func(t *testing.T) {
+		var records int
+		start := time.Now()
+		err := client.StreamJSON(context.Background(), "GET", server.URL, nil, func(json.RawMessage) error {
+			records++
+			return nil
+		})
+		if !errors.Is(err, httpclient.ErrStreamIdleTimeout) {
+			t.Fatalf("Expected ErrStreamIdleTimeout, got %v", err)
+		}
+		if records != 1 {
+			t.Errorf("Expected 1 record before the timeout, got %d", records)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the idle timeout to end the stream, took %v", elapsed)
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		ch, err := client.Stream("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+
+		var data []byte
+		timeout := time.After(2 * time.Second)
+		for done := false; !done; {
+			select {
+			case chunk, ok := <-ch:
+				data = append(data, chunk...)
+				done = !ok
+			case <-timeout:
+				t.Fatal("Expected the idle timeout to close the stream")
+			}
+		}
+		if !strings.Contains(string(data), "John") {
+			t.Errorf("Expected the chunk sent before the timeout, got %q", data)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+
+		err := httpclient.New().StreamJSON(ctx, "GET", server.URL, nil, func(json.RawMessage) error { return nil })
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the stream to wait for ctx without an idle timeout, got %v", err)
+		}
+	})
+}
+
+func TestStreamSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		option  httpclient.StreamOption
+		want    []string
+	}{
+		{"Lines", "first line\r\nsecond\n\nfourth", httpclient.SplitLines(), []string{"first line", "second", "", "fourth"}},
+		{"Delimiter", "a,bb,,ccc,", httpclient.SplitDelimiter(','), []string{"a", "bb", "", "ccc"}},
+		{"SSE", "\nevent: update\ndata: 1\n\nid: 2\r\ndata: 2\r\n\r\ndata: 3\r\rdata: partial", httpclient.SplitSSE(), []string{"event: update\ndata: 1", "id: 2\r\ndata: 2", "data: 3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Small writes make records straddle read boundaries
+			server := newChunkedJSONServer(tt.payload, 3)
+			defer server.Close()
+
+			var streamErr error
+			ch, err := httpclient.New().Stream("GET", server.URL, nil, tt.option, httpclient.OnStreamError(func(err error) {
+				streamErr = err
+			}))
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+
+			var records [][]byte
+			for record := range ch {
+				records = append(records, record)
+			}
+			if streamErr != nil {
+				t.Errorf("Unexpected stream error: %v", streamErr)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("Expected %q, got %q", tt.want, records)
+			}
+			for i, want := range tt.want {
+				// Records must stay intact after later reads
+				if string(records[i]) != want {
+					t.Errorf("Record %d: expected %q, got %q", i, want, records[i])
+				}
+			}
+		})
+	}
+
+	t.Run("TooLong", func(t *testing.T) {
+		server := newChunkedJSONServer("short\n"+strings.Repeat("x", 100)+"\nafter\n", 16)
+		defer server.Close()
+
+		var streamErr error
+		ch, err := httpclient.New().Stream("GET", server.URL, nil,
+			httpclient.SplitLines(),
+			httpclient.MaxRecordSize(64),
+			httpclient.OnStreamError(func(err error) { streamErr = err }))
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+
+		var records []string
+		for record := range ch {
+			records = append(records, string(record))
+		}
+		if len(records) != 1 || records[0] != "short" {
+			t.Errorf("Expected only the record before the long line, got %q", records)
+		}
+		if !errors.Is(streamErr, httpclient.ErrRecordTooLong) {
+			t.Errorf("Expected ErrRecordTooLong, got %v", streamErr)
+		}
+	})
+}
+
+func TestStreamOutlivesClientTimeout(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			// No response headers before the timeout
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+		case "/ws":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			time.Sleep(3 * timeout)
+			conn.WriteMessage(websocket.TextMessage, []byte("late"))
+			conn.ReadMessage()
+			return
+		}
+		// A line every 100ms, for three times the client timeout
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	client := httpclient.New().WithTimeout(timeout).WithRetries(0)
+
+	t.Run("Stream", func(t *testing.T) {
+		start := time.Now()
+		lines, err := client.Stream("GET", server.URL+"/events", nil, httpclient.SplitLines())
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		var got int
+		for range lines {
+			got++
+		}
+		if got != 10 {
+			t.Errorf("Expected all 10 lines, the stream was cut after %d", got)
+		}
+		if elapsed := time.Since(start); elapsed < 3*timeout {
+			t.Errorf("Expected the stream to run past the client timeout, took %v", elapsed)
+		}
+	})
+
+	t.Run("HeaderTimeout", func(t *testing.T) {
+		start := time.Now()
+		_, err := client.Stream("GET", server.URL+"/slow", nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the client timeout to bound the wait for headers, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the stream to fail at the client timeout, took %v", elapsed)
+		}
+	})
+
+	t.Run("WebSocket", func(t *testing.T) {
+		conn, err := client.WebSocket("ws" + strings.TrimPrefix(server.URL, "http") + "/ws")
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+		data, err := conn.Receive()
+		if err != nil || string(data) != "late" {
+			t.Errorf("Expected a message sent after the client timeout, got %q, %v", data, err)
+		}
+	})
+}
+
+// countingBody counts the bytes read from a response body
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	const chunk, chunks = 4096, 256
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < chunks; i++ {
+			if _, err := w.Write(bytes.Repeat([]byte{byte(i)}, chunk)); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	for _, size := range []int{0, 4} {
+		t.Run(fmt.Sprintf("Buffer%d", size), func(t *testing.T) {
+			var read int64
+			client := httpclient.New().WithMiddleware(httpclient.MiddlewareFunc(nil, func(resp *http.Response) {
+				resp.Body = &countingBody{ReadCloser: resp.Body, n: &read}
+			}))
+
+			stream, err := client.Stream("GET", server.URL, nil, httpclient.StreamBufferSize(size))
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			if cap(stream) != size {
+				t.Errorf("Expected a channel of %d values, got %d", size, cap(stream))
+			}
+
+			// While the consumer stalls, the stream reads no further than
+			// the buffered values and the one waiting to be sent
+			first := <-stream
+			time.Sleep(200 * time.Millisecond)
+			if ahead := atomic.LoadInt64(&read) - int64(len(first)); ahead > int64((size+1)*chunk) {
+				t.Errorf("Expected at most %d bytes read ahead of a stalled consumer, got %d", (size+1)*chunk, ahead)
+			}
+
+			// A slow consumer still gets every byte, in order
+			var received bytes.Buffer
+			received.Write(first)
+			for data := range stream {
+				received.Write(data)
+				time.Sleep(time.Millisecond)
+			}
+			if received.Len() != chunk*chunks {
+				t.Fatalf("Expected %d bytes, got %d", chunk*chunks, received.Len())
+			}
+			for i, b := range received.Bytes() {
+				if b != byte(i/chunk) {
+					t.Fatalf("Byte %d is from chunk %d, expected chunk %d", i, b, i/chunk)
+				}
+			}
+		})
+	}
+}
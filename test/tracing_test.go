@@ -0,0 +1,86 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yourorg/httpclient"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mockPropagator sends the trace ID in a header of its own
+type mockPropagator struct{}
+
+func (mockPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		carrier.Set("X-Mock-Trace", sc.TraceID().String())
+	}
+}
+
+func (mockPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return ctx
+}
+
+func (mockPropagator) Fields() []string { return []string{"X-Mock-Trace"} }
+
+func TestTracePropagation(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	state, _ := trace.ParseTraceState("vendor=value")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: state,
+	}))
+
+	client := httpclient.New().WithTracing(true)
+
+	t.Run("TraceContext", func(t *testing.T) {
+		if _, err := client.GetContext(ctx, server.URL); err != nil {
+			t.Fatalf("GetContext failed: %v", err)
+		}
+		h := <-headers
+
+		// Without an SDK the request span carries on the active span
+		// context, so the header identifies it exactly
+		if got, want := h.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+			t.Errorf("Expected traceparent %q, got %q", want, got)
+		}
+		if got := h.Get("tracestate"); got != "vendor=value" {
+			t.Errorf("Expected tracestate vendor=value, got %q", got)
+		}
+	})
+
+	t.Run("NoActiveSpan", func(t *testing.T) {
+		if _, err := client.GET(server.URL); err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		if h := <-headers; h.Get("traceparent") != "" {
+			t.Errorf("Expected no traceparent without an active span, got %q", h.Get("traceparent"))
+		}
+	})
+
+	t.Run("CustomPropagator", func(t *testing.T) {
+		if _, err := client.WithTracePropagator(mockPropagator{}).GetContext(ctx, server.URL); err != nil {
+			t.Fatalf("GetContext failed: %v", err)
+		}
+		h := <-headers
+		if got := h.Get("X-Mock-Trace"); got != traceID.String() {
+			t.Errorf("Expected X-Mock-Trace %q, got %q", traceID, got)
+		}
+		if h.Get("traceparent") != "" {
+			t.Errorf("Expected only the custom propagator's headers, got traceparent %q", h.Get("traceparent"))
+		}
+	})
+}
@@ -0,0 +1,956 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourorg/httpclient"
+	"github.com/yourorg/httpclient/internal/streaming"
+)
+
+var upgrader = websocket.Upgrader{}
+
+func TestWebSocketWriteBufferPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   streaming.WritePolicy
+		expected []string
+	}{
+		{"DropNewest", streaming.WriteDropNewest, []string{"1", "2", "3"}},
+		{"DropOldest", streaming.WriteDropOldest, []string{"3", "4", "5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			received := make(chan []string, 1)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Errorf("Upgrade failed: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				// Stall until the client has filled its buffer
+				<-release
+
+				var messages []string
+				for {
+					_, data, err := conn.ReadMessage()
+					if err != nil {
+						break
+					}
+					if len(data) > 1024 {
+						continue // the message that stalled the writer
+					}
+					if string(data) == "end" {
+						break
+					}
+					messages = append(messages, string(data))
+				}
+				received <- messages
+			}))
+			defer server.Close()
+
+			conn, err := streaming.NewWebSocketDialer().
+				WithWriteBuffer(3).
+				WithWritePolicy(tt.policy).
+				Dial(server.URL)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			// A message larger than the socket buffers blocks the writer
+			// until the server starts reading
+			if err := conn.Send(strings.Repeat("x", 32<<20)); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for conn.Buffered() > 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			for _, msg := range []string{"1", "2", "3", "4", "5"} {
+				if err := conn.Send(msg); err != nil {
+					t.Fatalf("Send %s failed: %v", msg, err)
+				}
+			}
+
+			if conn.Dropped() != 2 {
+				t.Errorf("Expected 2 dropped messages, got %d", conn.Dropped())
+			}
+
+			close(release)
+			for conn.Buffered() > 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := conn.Send("end"); err != nil {
+				t.Fatalf("Send end failed: %v", err)
+			}
+
+			select {
+			case messages := <-received:
+				if strings.Join(messages, ",") != strings.Join(tt.expected, ",") {
+					t.Errorf("Expected messages %v, got %v", tt.expected, messages)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for server to receive messages")
+			}
+		})
+	}
+}
+
+func TestWebSocketInheritsClientConfig(t *testing.T) {
+	handshakes := make(chan http.Header, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, data)
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(serverURL, []*http.Cookie{{Name: "session", Value: "abc"}})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	client := httpclient.New().
+		WithBaseURL(server.URL).
+		WithAuth("token").
+		WithHeader("X-Tenant", "acme").
+		WithCookieJar(jar).
+		WithTLSConfig(&tls.Config{RootCAs: pool})
+
+	conn, err := client.WebSocket("/ws")
+	if err != nil {
+		t.Fatalf("WebSocket failed: %v", err)
+	}
+	defer conn.Close()
+
+	headers := <-handshakes
+	if got := headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Expected the Authorization header, got %q", got)
+	}
+	if got := headers.Get("X-Tenant"); got != "acme" {
+		t.Errorf("Expected the custom header, got %q", got)
+	}
+	if got := headers.Get("Cookie"); got != "session=abc" {
+		t.Errorf("Expected the session cookie, got %q", got)
+	}
+
+	if err := conn.Send("ping"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	data, err := conn.Receive()
+	if err != nil || string(data) != "ping" {
+		t.Errorf("Expected the echo, got %q, %v", data, err)
+	}
+
+	// The test server's certificate isn't trusted without the TLS config
+	if conn, err := httpclient.New().WebSocket(server.URL); err == nil {
+		conn.Close()
+		t.Error("Expected the handshake to fail without the TLS config")
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	const perConnection = 3
+	var connections int32
+	resubscribed := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		n := atomic.AddInt32(&connections, 1)
+		if n > 1 {
+			// Reconnected clients resubscribe before anything is sent
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			resubscribed <- string(data)
+		}
+		for i := 0; i < perConnection; i++ {
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("c%d-%d", n, i)))
+		}
+		if n == 3 {
+			// Stay up until the client closes
+			conn.ReadMessage()
+		}
+		// Other connections drop after their messages
+	}))
+	defer server.Close()
+
+	var callbacks int32
+	conn, err := httpclient.New().WebSocket(server.URL,
+		httpclient.WithReconnect(httpclient.ReconnectPolicy{
+			InitialDelay: 10 * time.Millisecond,
+			MaxDelay:     50 * time.Millisecond,
+			Jitter:       0.5,
+		}),
+		httpclient.OnReconnect(func(conn httpclient.WebSocketConn) error {
+			atomic.AddInt32(&callbacks, 1)
+			return conn.Send("resubscribe")
+		}))
+	if err != nil {
+		t.Fatalf("WebSocket failed: %v", err)
+	}
+
+	var messages []string
+	for i := 0; i < 3*perConnection; i++ {
+		data, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Receive %d failed: %v", i, err)
+		}
+		messages = append(messages, string(data))
+	}
+	if got := strings.Join(messages, ","); got != "c1-0,c1-1,c1-2,c2-0,c2-1,c2-2,c3-0,c3-1,c3-2" {
+		t.Errorf("Expected messages to continue across reconnects, got %s", got)
+	}
+	if got := atomic.LoadInt32(&callbacks); got != 2 {
+		t.Errorf("Expected the reconnect callback twice, got %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		if msg := <-resubscribed; msg != "resubscribe" {
+			t.Errorf("Expected the server to get the resubscription, got %q", msg)
+		}
+	}
+
+	// Close stops reconnecting for good
+	conn.Close()
+	if _, err := conn.Receive(); !errors.Is(err, httpclient.ErrWebSocketClosed) {
+		t.Errorf("Expected ErrWebSocketClosed after Close, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&connections); got != 3 {
+		t.Errorf("Expected no connections after Close, got %d in total", got)
+	}
+}
+
+func TestWebSocketReconnectBlockedSend(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Never read, so the client's writes back up
+		time.Sleep(200 * time.Millisecond)
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+		<-release
+	}))
+	defer server.Close()
+
+	conn, err := httpclient.New().WebSocket(server.URL, httpclient.WithReconnect(httpclient.ReconnectPolicy{
+		MaxAttempts:           1,
+		DropWhileDisconnected: true,
+	}))
+	if err != nil {
+		t.Fatalf("WebSocket failed: %v", err)
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		payload := bytes.Repeat([]byte("x"), 1<<20)
+		for i := 0; i < 256; i++ {
+			if conn.Send(payload) != nil {
+				return
+			}
+		}
+	}()
+
+	// A Send stuck on a full connection doesn't hold up Receive
+	time.Sleep(100 * time.Millisecond)
+	received := make(chan string, 1)
+	go func() {
+		data, _ := conn.Receive()
+		received <- string(data)
+	}()
+	select {
+	case data := <-received:
+		if data != "hello" {
+			t.Errorf("Expected hello, got %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected Receive to return while Send is blocked")
+	}
+
+	close(release)
+	<-sent
+	conn.Close()
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	newServer := func(answerPings bool, pongs chan<- struct{}) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			if !answerPings {
+				conn.SetPingHandler(func(string) error { return nil })
+			}
+			conn.SetPongHandler(func(string) error {
+				pongs <- struct{}{}
+				return nil
+			})
+
+			// Control frames are only handled while reading
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			time.Sleep(400 * time.Millisecond)
+			conn.WriteMessage(websocket.TextMessage, []byte("late"))
+			time.Sleep(time.Second)
+		}))
+	}
+
+	t.Run("Alive", func(t *testing.T) {
+		pongs := make(chan struct{}, 1)
+		server := newServer(true, pongs)
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL, httpclient.WithPingKeepalive(50*time.Millisecond, 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		// The message arrives well after the ping interval plus timeout,
+		// so only pongs keep the connection open until then
+		data, err := conn.Receive()
+		if err != nil || string(data) != "late" {
+			t.Fatalf("Expected the late message, got %q, %v", data, err)
+		}
+		select {
+		case <-pongs:
+		default:
+			t.Error("Expected the client to answer the server's ping")
+		}
+	})
+
+	t.Run("Dead", func(t *testing.T) {
+		server := newServer(false, make(chan struct{}, 1))
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL, httpclient.WithPingKeepalive(50*time.Millisecond, 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		start := time.Now()
+		if _, err := conn.Receive(); !errors.Is(err, httpclient.ErrKeepaliveTimeout) {
+			t.Fatalf("Expected ErrKeepaliveTimeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+			t.Errorf("Expected the dead connection to be detected quickly, took %v", elapsed)
+		}
+		if err := conn.Send("ping"); !errors.Is(err, httpclient.ErrKeepaliveTimeout) {
+			t.Errorf("Expected Send on the dead connection to fail with ErrKeepaliveTimeout, got %v", err)
+		}
+	})
+}
+
+func TestWebSocketKeepaliveReconnect(t *testing.T) {
+	t.Run("ServerExpectsPings", func(t *testing.T) {
+		var pings int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			// Like an idle-timeout proxy, drop the connection unless a
+			// ping arrives every 150ms
+			conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+			conn.SetPingHandler(func(data string) error {
+				atomic.AddInt32(&pings, 1)
+				conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+				return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+			})
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				conn.WriteMessage(websocket.TextMessage, []byte("still here"))
+			}()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL, httpclient.WithPingKeepalive(50*time.Millisecond, 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		data, err := conn.Receive()
+		if err != nil || string(data) != "still here" {
+			t.Fatalf("Expected pings to keep the connection open, got %q, %v", data, err)
+		}
+		if got := atomic.LoadInt32(&pings); got < 3 {
+			t.Errorf("Expected the client to ping regularly, the server got %d pings", got)
+		}
+	})
+
+	t.Run("DeadConnectionRedialed", func(t *testing.T) {
+		var connections int32
+		release := make(chan struct{})
+		defer close(release)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			if atomic.AddInt32(&connections, 1) == 1 {
+				// A silently dead peer: the socket stays open but nothing
+				// is read, so pings go unanswered
+				<-release
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte("back"))
+			conn.ReadMessage()
+		}))
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL,
+			httpclient.WithPingKeepalive(50*time.Millisecond, 100*time.Millisecond),
+			httpclient.WithReconnect(httpclient.ReconnectPolicy{InitialDelay: 10 * time.Millisecond}))
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		start := time.Now()
+		data, err := conn.Receive()
+		if err != nil || string(data) != "back" {
+			t.Fatalf("Expected a message on the new connection, got %q, %v", data, err)
+		}
+		if got := atomic.LoadInt32(&connections); got != 2 {
+			t.Errorf("Expected one reconnect, got %d connections", got)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the dead connection to be replaced quickly, took %v", elapsed)
+		}
+	})
+}
+
+type wsEvent struct {
+	ID   int    `json:"id"`
+	From string `json:"from"`
+}
+
+// newJSONEchoServer echoes every JSON message back with From set to
+// "echo" while pushing pushes messages of its own concurrently
+func newJSONEchoServer(t *testing.T, pushes int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var writeMu sync.Mutex
+		write := func(event wsEvent) error {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			return conn.WriteJSON(event)
+		}
+
+		go func() {
+			for i := 0; i < pushes; i++ {
+				if write(wsEvent{ID: i, From: "server"}) != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			var event wsEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			event.From = "echo"
+			if write(event) != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestWebSocketListen(t *testing.T) {
+	const senders, perSender, pushes = 4, 25, 50
+
+	t.Run("ConcurrentJSON", func(t *testing.T) {
+		server := newJSONEchoServer(t, pushes)
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL)
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		messages, errs := conn.Listen(ctx)
+
+		var wg sync.WaitGroup
+		for s := 0; s < senders; s++ {
+			wg.Add(1)
+			go func(s int) {
+				defer wg.Done()
+				for i := 0; i < perSender; i++ {
+					if err := conn.SendJSON(wsEvent{ID: s*perSender + i, From: "client"}); err != nil {
+						t.Errorf("SendJSON failed: %v", err)
+						return
+					}
+				}
+			}(s)
+		}
+
+		echoes := make(map[int]bool)
+		pushed := 0
+		timeout := time.After(5 * time.Second)
+		for len(echoes) < senders*perSender || pushed < pushes {
+			select {
+			case msg := <-messages:
+				if msg.Type != httpclient.TextMessage || msg.Received.IsZero() {
+					t.Fatalf("Unexpected message metadata: %v, %v", msg.Type, msg.Received)
+				}
+				var event wsEvent
+				if err := json.Unmarshal(msg.Data, &event); err != nil {
+					t.Fatalf("Corrupt message %q: %v", msg.Data, err)
+				}
+				switch event.From {
+				case "echo":
+					echoes[event.ID] = true
+				case "server":
+					pushed++
+				default:
+					t.Fatalf("Unexpected message %+v", event)
+				}
+			case err := <-errs:
+				t.Fatalf("Listen failed: %v", err)
+			case <-timeout:
+				t.Fatalf("Timed out with %d echoes and %d pushes", len(echoes), pushed)
+			}
+		}
+		wg.Wait()
+
+		// Cancelling the context closes both channels without an error
+		cancel()
+		for range messages {
+		}
+		if err, ok := <-errs; ok {
+			t.Errorf("Expected no error after cancel, got %v", err)
+		}
+	})
+
+	t.Run("ConcurrentReceiveAs", func(t *testing.T) {
+		server := newJSONEchoServer(t, pushes)
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL)
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		var received int64
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for atomic.LoadInt64(&received) < pushes {
+					event, err := httpclient.ReceiveAs[wsEvent](conn)
+					if err != nil {
+						// The connection is closed once every push arrived
+						if atomic.LoadInt64(&received) < pushes {
+							t.Errorf("ReceiveAs failed: %v", err)
+						}
+						return
+					}
+					if event.From != "server" {
+						t.Errorf("Unexpected message %+v", event)
+					}
+					if atomic.AddInt64(&received, 1) == pushes {
+						conn.Close()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if received != pushes {
+			t.Errorf("Expected %d messages, got %d", pushes, received)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		server := newJSONEchoServer(t, 0)
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL)
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+
+		messages, errs := conn.Listen(context.Background())
+		conn.Close()
+
+		select {
+		case _, ok := <-messages:
+			if ok {
+				t.Error("Expected no messages")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Close to close the message channel")
+		}
+		if err, ok := <-errs; ok {
+			t.Errorf("Expected no error after Close, got %v", err)
+		}
+		if _, err := conn.Receive(); !errors.Is(err, httpclient.ErrWebSocketClosed) {
+			t.Errorf("Expected ErrWebSocketClosed, got %v", err)
+		}
+	})
+}
+
+func TestWebSocketReadChannel(t *testing.T) {
+	const consumers, pushes = 4, 200
+
+	cases := []struct {
+		name string
+		opts []httpclient.WebSocketOption
+	}{
+		{"Conn", nil},
+		{"Reconnecting", []httpclient.WebSocketOption{httpclient.WithReconnect(httpclient.ReconnectPolicy{})}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newJSONEchoServer(t, pushes)
+			defer server.Close()
+
+			conn, err := httpclient.New().WebSocket(server.URL, tc.opts...)
+			if err != nil {
+				t.Fatalf("WebSocket failed: %v", err)
+			}
+			defer conn.Close()
+
+			if conn.ReadChannel() != conn.ReadChannel() {
+				t.Fatal("Expected ReadChannel to return the same channel every time")
+			}
+
+			var mu sync.Mutex
+			seen := make(map[int]int)
+			var received int64
+			var wg sync.WaitGroup
+			for c := 0; c < consumers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for msg := range conn.ReadChannel() {
+						var event wsEvent
+						if err := json.Unmarshal(msg.Data, &event); err != nil {
+							t.Errorf("Corrupt message %q: %v", msg.Data, err)
+							continue
+						}
+						mu.Lock()
+						seen[event.ID]++
+						mu.Unlock()
+						if atomic.AddInt64(&received, 1) == pushes {
+							conn.Close()
+						}
+					}
+				}()
+			}
+
+			// Every consumer returns once Close ends the channel
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out with %d of %d messages", atomic.LoadInt64(&received), pushes)
+			}
+
+			if len(seen) != pushes {
+				t.Errorf("Expected %d distinct messages, got %d", pushes, len(seen))
+			}
+			for id, n := range seen {
+				if n != 1 {
+					t.Errorf("Message %d delivered %d times", id, n)
+				}
+			}
+		})
+	}
+
+	t.Run("ReconnectFailed", func(t *testing.T) {
+		var connections int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&connections, 1) > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte("only"))
+			conn.Close()
+		}))
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL, httpclient.WithReconnect(httpclient.ReconnectPolicy{
+			MaxAttempts:  2,
+			InitialDelay: 10 * time.Millisecond,
+		}))
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		var received int
+		for range conn.ReadChannel() {
+			received++
+		}
+		if received != 1 {
+			t.Errorf("Expected 1 message, got %d", received)
+		}
+
+		// Receive reports why the channel was closed, without dialing again
+		dialed := atomic.LoadInt32(&connections)
+		if _, err := conn.Receive(); !errors.Is(err, httpclient.ErrReconnectFailed) {
+			t.Errorf("Expected ErrReconnectFailed, got %v", err)
+		}
+		if got := atomic.LoadInt32(&connections); got != dialed {
+			t.Errorf("Expected no reconnects after the channel was closed, got %d", got-dialed)
+		}
+	})
+}
+
+func TestWebSocketCloseHandshake(t *testing.T) {
+	// newServer echoes messages and reports the close code it receives
+	newServer := func(closes chan<- *websocket.CloseError) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			for {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					var closeErr *websocket.CloseError
+					if !errors.As(err, &closeErr) {
+						closeErr = &websocket.CloseError{Code: websocket.CloseAbnormalClosure}
+					}
+					closes <- closeErr
+					return
+				}
+				if err := conn.WriteMessage(messageType, data); err != nil {
+					return
+				}
+			}
+		}))
+	}
+
+	tests := []struct {
+		name   string
+		close  func(httpclient.WebSocketConn) error
+		code   int
+		reason string
+	}{
+		{"Close", func(conn httpclient.WebSocketConn) error { return conn.Close() }, websocket.CloseNormalClosure, ""},
+		{"CloseWithStatus", func(conn httpclient.WebSocketConn) error {
+			return conn.CloseWithStatus(httpclient.CloseGoingAway, "shutting down")
+		}, websocket.CloseGoingAway, "shutting down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closes := make(chan *websocket.CloseError, 1)
+			server := newServer(closes)
+			defer server.Close()
+
+			conn, err := httpclient.New().WebSocket(server.URL)
+			if err != nil {
+				t.Fatalf("WebSocket failed: %v", err)
+			}
+			if err := conn.Send("hello"); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if data, err := conn.Receive(); err != nil || string(data) != "hello" {
+				t.Fatalf("Expected the echo, got %q, %v", data, err)
+			}
+
+			start := time.Now()
+			if err := tt.close(conn); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+			// The server answers the close frame right away
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected the close handshake to finish quickly, took %v", elapsed)
+			}
+
+			select {
+			case closeErr := <-closes:
+				if closeErr.Code != tt.code || closeErr.Text != tt.reason {
+					t.Errorf("Expected the server to see %d %q, got %d %q", tt.code, tt.reason, closeErr.Code, closeErr.Text)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Server never saw the connection close")
+			}
+			if _, err := conn.Receive(); !errors.Is(err, httpclient.ErrWebSocketClosed) {
+				t.Errorf("Expected ErrWebSocketClosed after Close, got %v", err)
+			}
+		})
+	}
+
+	t.Run("ServerClose", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Upgrade failed: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "maintenance"))
+			conn.ReadMessage()
+		}))
+		defer server.Close()
+
+		conn, err := httpclient.New().WebSocket(server.URL)
+		if err != nil {
+			t.Fatalf("WebSocket failed: %v", err)
+		}
+		defer conn.Close()
+
+		_, err = conn.Receive()
+		var closeErr *httpclient.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("Expected a CloseError, got %v", err)
+		}
+		if closeErr.Code != 4000 || closeErr.Reason != "maintenance" {
+			t.Errorf("Expected 4000 maintenance, got %d %q", closeErr.Code, closeErr.Reason)
+		}
+	})
+}
+
+func TestWebSocketReceiveTyped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+		conn.WriteMessage(websocket.BinaryMessage, []byte{0x00, 0xff, 0x10})
+		conn.WriteControl(websocket.PingMessage, []byte("are you there"), time.Now().Add(time.Second))
+		conn.WriteMessage(websocket.TextMessage, []byte("compat"))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "done"))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	conn, err := httpclient.New().WebSocket(server.URL)
+	if err != nil {
+		t.Fatalf("WebSocket failed: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		wantType httpclient.MessageType
+		wantData []byte
+	}{
+		{httpclient.TextMessage, []byte("hello")},
+		{httpclient.BinaryMessage, []byte{0x00, 0xff, 0x10}},
+	}
+	for i, tt := range tests {
+		messageType, data, err := conn.ReceiveTyped()
+		if err != nil {
+			t.Fatalf("ReceiveTyped %d failed: %v", i, err)
+		}
+		if messageType != tt.wantType || !bytes.Equal(data, tt.wantData) {
+			t.Errorf("Expected a %s message %q, got a %s message %q", tt.wantType, tt.wantData, messageType, data)
+		}
+	}
+
+	// The ping is answered, not returned, and Receive still works
+	if data, err := conn.Receive(); err != nil || string(data) != "compat" {
+		t.Errorf("Expected Receive to return the next message, got %q, %v", data, err)
+	}
+
+	messageType, _, err := conn.ReceiveTyped()
+	var closeErr *httpclient.CloseError
+	if messageType != httpclient.CloseMessage || !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Reason != "done" {
+		t.Errorf("Expected the close frame with code 4000, got a %s message, %v", messageType, err)
+	}
+}